@@ -0,0 +1,32 @@
+package procycmd
+
+import (
+	"net"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// resolveAdminURL returns the URL of the admin API of the running proxy: adminURL if set, else the one of admin.address
+func resolveAdminURL(adminURL string) string {
+	if adminURL != "" {
+		return strings.TrimSuffix(adminURL, "/")
+	}
+	cfg := loadConfig(configPath)
+	if cfg.Admin.Address == "" {
+		logrus.Fatalf("The admin API is disabled: set admin.address, or pass --admin")
+	}
+	return "http://" + localAddress(cfg.Admin.Address)
+}
+
+// localAddress returns the address to connect to a server listening on address, e.g. 127.0.0.1:8081 for ":8081"
+func localAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
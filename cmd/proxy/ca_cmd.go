@@ -0,0 +1,126 @@
+package procycmd
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/ca"
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newCACommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ca",
+		Short: "Manage the CA used for TLS interception",
+	}
+	cmd.AddCommand(newCAExportCommand())
+	cmd.AddCommand(newCAInstallCommand(false))
+	cmd.AddCommand(newCAInstallCommand(true))
+	return cmd
+}
+
+func newCAExportCommand() *cobra.Command {
+	var output string
+	var der bool
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the CA certificate, to add it to the trust stores of clients",
+		Long:  "Export the CA certificate configured in server.https.ca_cert_file (never its key), to add it to the trust stores of clients. Without one, exports the per-user CA, generating it if needed",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			cert := loadCACertificate()
+			out := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+			if der {
+				out = cert.Raw
+			}
+			if output == "" {
+				_, _ = os.Stdout.Write(out)
+				return
+			}
+			if err := os.WriteFile(output, out, 0644); err != nil {
+				logrus.Fatalf("Failed to write CA certificate: %v", err)
+			}
+			fmt.Fprintf(os.Stderr, "Exported %s (expires %s) to %s\n", cert.Subject.CommonName, cert.NotAfter.Format("2006-01-02"), output)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output file. Default is standard output")
+	cmd.Flags().BoolVar(&der, "der", false, "Export in DER format (e.g. for Android or Windows) instead of PEM")
+	return cmd
+}
+
+func newCAInstallCommand(uninstall bool) *cobra.Command {
+	use, short := "install", "Install the CA certificate into the trust stores of the OS and browsers found on this machine"
+	if uninstall {
+		use, short = "uninstall", "Remove the CA certificate from the trust stores of the OS and browsers found on this machine"
+	}
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Long:  short + ": macOS login keychain, Linux system store, Windows user root store, and NSS databases of Firefox and Chrome (with certutil). The Linux system store requires root",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			cert := loadCACertificate()
+			home, err := os.UserHomeDir()
+			if err != nil {
+				logrus.Fatalf("Failed to find the home directory: %v", err)
+			}
+			stores := ca.TrustStores(runtime.GOOS, home)
+			if len(stores) == 0 {
+				logrus.Fatalf("No supported trust store found, add the certificate manually (see 'ca export')")
+			}
+
+			failed := false
+			for _, store := range stores {
+				if uninstall {
+					err = store.Uninstall(cert)
+				} else {
+					err = store.Install(cert)
+				}
+				if err != nil {
+					failed = true
+					logrus.Errorf("%s: %v", store.Name(), err)
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "%s: done\n", store.Name())
+			}
+			if failed {
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+// loadCACertificate loads the CA certificate of the configuration, or the per-user one, exiting on error
+func loadCACertificate() *x509.Certificate {
+	cfg := loadConfig(configPath)
+	useDefaultCA(cfg)
+	cert, err := ca.LoadCertificate(cfg.Server.HTTPS.CACertFile)
+	if err != nil {
+		logrus.Fatalf("Invalid CA certificate: %v", err)
+	}
+	return cert
+}
+
+// useDefaultCA sets the per-user CA in the configuration if no CA is configured, generating it on first use
+func useDefaultCA(cfg *config.Config) {
+	https := &cfg.Server.HTTPS
+	if https.CACertFile != "" || https.CAKeyFile != "" {
+		return
+	}
+	certPath, keyPath, generated, err := ca.EnsureDefault()
+	if err != nil {
+		logrus.Fatalf("Failed to set up the default CA: %v", err)
+	}
+	if generated {
+		logrus.Infof("No CA configured, generated %s for TLS interception. Trust it in your system or tools (see 'ca export')", certPath)
+	} else {
+		logrus.Infof("No CA configured, using %s for TLS interception", certPath)
+	}
+	https.CACertFile, https.CAKeyFile = certPath, keyPath
+}
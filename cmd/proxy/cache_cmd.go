@@ -0,0 +1,480 @@
+package procycmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+	"github.com/iTrooz/caching-dev-proxy/internal/proxy"
+	"github.com/iTrooz/caching-dev-proxy/internal/repro"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newCacheCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect and manage cached entries",
+	}
+	cmd.AddCommand(newCacheLsCommand(), newCacheInspectCommand(), newCachePurgeCommand(), newCacheBumpNamespaceCommand(),
+		newCacheExportCommand(), newCacheImportCommand(), newCacheImportHARCommand(), newCacheMigrateCommand(), newCacheVerifyCommand())
+	return cmd
+}
+
+// parseURLArg parses a URL given as argument, exiting if it is not absolute
+func parseURLArg(arg string) *url.URL {
+	u, err := url.Parse(arg)
+	if err != nil || !u.IsAbs() {
+		logrus.Fatalf("Invalid URL: %s", arg)
+	}
+	return u
+}
+
+// openCache opens the cache described by the configuration, exiting on error
+func openCache(cfg *config.Config) *httpcache.HTTPCache {
+	cacheManager, err := proxy.NewCacheManager(cfg)
+	if err != nil {
+		logrus.Fatalf("Failed to open cache: %v", err)
+	}
+	return cacheManager
+}
+
+func newCacheLsCommand() *cobra.Command {
+	var client, minAge, maxAge, minSize, maxSize string
+	var filter httpcache.EntryFilter
+	cmd := &cobra.Command{
+		Use:   "ls [url-prefix]",
+		Short: "List cached entries with their size and expiry, optionally filtered",
+		Long:  "List cached entries (including expired ones kept to be served stale) with their size, storage time and expiry.\nEntries can be filtered by URL prefix, host, path prefix, method, age and size",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg := loadValidConfig(configPath)
+			ttl, err := cfg.GetCacheTTL()
+			if err != nil {
+				logrus.Fatalf("Invalid cache TTL: %v", err)
+			}
+			if filter.MinAge, err = config.ParseOptionalDuration(minAge); err != nil {
+				logrus.Fatalf("Invalid --min-age: %v", err)
+			}
+			if filter.MaxAge, err = config.ParseOptionalDuration(maxAge); err != nil {
+				logrus.Fatalf("Invalid --max-age: %v", err)
+			}
+			if filter.MinSize, err = config.ParseSize(minSize); err != nil {
+				logrus.Fatalf("Invalid --min-size: %v", err)
+			}
+			if filter.MaxSize, err = config.ParseSize(maxSize); err != nil {
+				logrus.Fatalf("Invalid --max-size: %v", err)
+			}
+			cacheManager := openCache(cfg)
+
+			prefix := proxy.KeyNamespace(cfg, client)
+			if len(args) == 1 {
+				prefix = filepath.Join(prefix, httpcache.KeyDir(parseURLArg(args[0])))
+			}
+
+			now := time.Now()
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "KEY\tMETHOD\tSTATUS\tSIZE\tSTORED\tEXPIRES")
+			err = cacheManager.List(prefix, filter, func(info cache.EntryInfo, resp *http.Response) error {
+				if resp == nil {
+					var err error
+					if resp, err = cacheManager.GetStaleKey(info.Key); err != nil || resp == nil {
+						return err
+					}
+				}
+				_ = resp.Body.Close()
+				details := proxy.InspectEntry(resp)
+				if details.Stored.IsZero() {
+					details.Stored = info.ModTime
+				}
+				method := "-"
+				if resp.Request != nil {
+					method = resp.Request.Method
+				}
+				expires := "never"
+				if at := details.ExpiresAt(ttl); !at.IsZero() {
+					expires = at.Local().Format(time.DateTime)
+					if at.Before(now) {
+						expires += " (expired)"
+					}
+				}
+				_, err := fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", info.Key, method, resp.StatusCode, info.Size,
+					details.Stored.Local().Format(time.DateTime), expires)
+				return err
+			})
+			if err != nil {
+				logrus.Fatalf("Failed to list cache: %v", err)
+			}
+			_ = w.Flush()
+		},
+	}
+	cmd.Flags().StringVar(&client, "client", "", "Client certificate CN whose cache to list (see server.https.client_cert_namespace)")
+	cmd.Flags().StringVar(&filter.Host, "host", "", "Only list entries of this host")
+	cmd.Flags().StringVar(&filter.PathPrefix, "path", "", "Only list entries of URL paths starting with this prefix")
+	cmd.Flags().StringVar(&filter.Method, "method", "", "Only list entries of this request method")
+	cmd.Flags().StringVar(&minAge, "min-age", "", "Only list entries stored at least this long ago (e.g. 24h)")
+	cmd.Flags().StringVar(&maxAge, "max-age", "", "Only list entries stored at most this long ago (e.g. 30m)")
+	cmd.Flags().StringVar(&minSize, "min-size", "", "Only list entries at least this large (e.g. 1MB)")
+	cmd.Flags().StringVar(&maxSize, "max-size", "", "Only list entries at most this large (e.g. 10KiB)")
+	return cmd
+}
+
+func newCacheInspectCommand() *cobra.Command {
+	var client string
+	var showRequest, showBody, asCurl, asHTTPie bool
+	cmd := &cobra.Command{
+		Use:     "inspect <url|cache-file>",
+		Aliases: []string{"show"},
+		Short:   "Show the cached entries of a URL: status, headers, age and optionally body",
+		Long: "Show the cached entries of a URL (all methods and header variations), or of a cache file (path, or key relative to cache.folder): " +
+			"status, headers, storage time and expiry, and optionally the body and the stored request, e.g. to reproduce it against upstream",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg := loadValidConfig(configPath)
+			ttl, err := cfg.GetCacheTTL()
+			if err != nil {
+				logrus.Fatalf("Invalid cache TTL: %v", err)
+			}
+			cacheManager := openCache(cfg)
+
+			entries := map[string]*http.Response{}
+			if u, err := url.Parse(args[0]); err == nil && u.IsAbs() {
+				keys, err := cacheManager.FindURL(proxy.KeyNamespace(cfg, client), u)
+				if err != nil {
+					logrus.Fatalf("Failed to search cache: %v", err)
+				}
+				for _, key := range keys {
+					if entries[key], err = cacheManager.GetStaleKey(key); err != nil {
+						logrus.Fatalf("Failed to read cache entry %s: %v", key, err)
+					}
+				}
+			} else if data, err := os.ReadFile(args[0]); err == nil {
+				key := args[0]
+				if rel, err := filepath.Rel(cfg.Cache.Folder, args[0]); err == nil && !strings.HasPrefix(rel, "..") {
+					key = rel
+				}
+				if entries[key], err = httpcache.Deserialize(data); err != nil {
+					logrus.Fatalf("Failed to decode cache file %s: %v", args[0], err)
+				}
+			} else if entries[args[0]], err = cacheManager.GetStaleKey(args[0]); err != nil {
+				logrus.Fatalf("Failed to read cache entry %s: %v", args[0], err)
+			}
+
+			keys := slices.Sorted(maps.Keys(entries))
+			found := false
+			for _, key := range keys {
+				resp := entries[key]
+				if resp == nil {
+					continue // removed in the meantime
+				}
+				found = true
+				switch {
+				case asCurl || asHTTPie:
+					printStoredRequestCommand(key, resp, asHTTPie)
+				default:
+					printEntry(key, resp, ttl, showRequest, showBody)
+				}
+			}
+			if !found {
+				logrus.Fatalf("No cached entry found for %s", args[0])
+			}
+		},
+	}
+	cmd.Flags().StringVar(&client, "client", "", "Client certificate CN whose cache to search (see server.https.client_cert_namespace)")
+	cmd.Flags().BoolVar(&showRequest, "request", false, "Also print the stored request")
+	cmd.Flags().BoolVar(&showBody, "body", false, "Also print the response body (gzip-decoded if needed)")
+	cmd.Flags().BoolVar(&asCurl, "as-curl", false, "Only print stored requests, as curl commands")
+	cmd.Flags().BoolVar(&asHTTPie, "as-httpie", false, "Only print stored requests, as HTTPie commands")
+	cmd.MarkFlagsMutuallyExclusive("as-curl", "as-httpie")
+	return cmd
+}
+
+// printEntry prints a cached entry: its metadata, response status and headers, and optionally its request and body
+func printEntry(key string, resp *http.Response, ttl time.Duration, showRequest bool, showBody bool) {
+	details := proxy.InspectEntry(resp)
+	now := time.Now()
+
+	fmt.Printf("# %s\n", key)
+	if resp.Request != nil {
+		fmt.Printf("Request: %s %s\n", resp.Request.Method, resp.Request.URL.String())
+	}
+	if details.Stored.IsZero() {
+		fmt.Printf("Stored:  unknown\n")
+	} else {
+		fmt.Printf("Stored:  %s (%v ago)\n", details.Stored.Local().Format(time.DateTime), now.Sub(details.Stored).Round(time.Second))
+	}
+	expires := details.ExpiresAt(ttl)
+	switch {
+	case expires.IsZero() && ttl == 0:
+		fmt.Printf("Expires: never\n")
+	case expires.IsZero():
+		fmt.Printf("Expires: unknown\n")
+	case expires.Before(now):
+		fmt.Printf("Expires: %s (expired, kept to be served stale)\n", expires.Local().Format(time.DateTime))
+	default:
+		fmt.Printf("Expires: %s (in %v)\n", expires.Local().Format(time.DateTime), expires.Sub(now).Round(time.Second))
+	}
+	for _, name := range slices.Sorted(maps.Keys(details.ServeHeaders)) {
+		fmt.Printf("Served with: %s: %s\n", name, strings.Join(details.ServeHeaders[name], ", "))
+	}
+
+	if showRequest && resp.Request != nil {
+		dump, err := httputil.DumpRequest(resp.Request, true)
+		if err != nil {
+			logrus.Fatalf("Failed to dump stored request of %s: %v", key, err)
+		}
+		fmt.Printf("\n%s", dump)
+	}
+
+	fmt.Printf("\n%s %s\n", resp.Proto, resp.Status)
+	_ = resp.Header.Write(os.Stdout)
+	if showBody {
+		body, err := decodedBody(resp)
+		if err != nil {
+			logrus.Fatalf("Failed to read body of %s: %v", key, err)
+		}
+		fmt.Printf("\n%s\n", body)
+	}
+	fmt.Println()
+}
+
+// decodedBody reads the body of a response, decompressing it if gzip-encoded
+func decodedBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return body, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip body: %w", err)
+	}
+	return io.ReadAll(reader)
+}
+
+// printStoredRequestCommand prints the request stored with an entry as a curl or HTTPie command
+func printStoredRequestCommand(key string, resp *http.Response, httpie bool) {
+	req := resp.Request
+	if req == nil {
+		fmt.Printf("# %s: stored without its request\n", key)
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		logrus.Fatalf("Failed to read stored request body of %s: %v", key, err)
+	}
+	fmt.Printf("# %s (%s)\n", key, resp.Status)
+	if httpie {
+		fmt.Println(repro.HTTPie(req, body))
+	} else {
+		fmt.Println(repro.Curl(req, body))
+	}
+}
+
+func newCachePurgeCommand() *cobra.Command {
+	var client string
+	var prefix, regex bool
+	cmd := &cobra.Command{
+		Use:   "purge <url|pattern>",
+		Short: "Remove the cached entries of a URL, or of all URLs matching a pattern",
+		Long: "Remove the cached entries of a URL, or with --prefix, of all URLs starting with it.\n" +
+			"A URL containing \"*\" is a glob matching whole URLs, \"*\" matching any characters (e.g. 'https://api.example.com/v1/*'). " +
+			"With --regex, remove the entries of URLs containing a match of a regular expression",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg := loadValidConfig(configPath)
+			namespace := proxy.KeyNamespace(cfg, client)
+
+			var purge func(cacheManager *httpcache.HTTPCache) (int, error)
+			switch {
+			case regex:
+				pattern, err := httpcache.NewRegexPattern(args[0])
+				if err != nil {
+					logrus.Fatalf("Invalid pattern: %v", err)
+				}
+				purge = func(cacheManager *httpcache.HTTPCache) (int, error) { return cacheManager.PurgePattern(namespace, pattern) }
+			case httpcache.IsGlob(args[0]):
+				if prefix {
+					logrus.Fatalf("--prefix cannot be used with a glob pattern, end it with \"*\" instead")
+				}
+				pattern := httpcache.NewGlobPattern(args[0])
+				purge = func(cacheManager *httpcache.HTTPCache) (int, error) { return cacheManager.PurgePattern(namespace, pattern) }
+			default:
+				u := parseURLArg(args[0])
+				purge = func(cacheManager *httpcache.HTTPCache) (int, error) { return cacheManager.Purge(namespace, u, prefix) }
+			}
+			cacheManager := openCache(cfg)
+
+			removed, err := purge(cacheManager)
+			if err != nil {
+				logrus.Fatalf("Failed to purge cache: %v", err)
+			}
+			if err := cacheManager.Close(); err != nil {
+				logrus.Fatalf("Failed to close cache: %v", err)
+			}
+			fmt.Printf("Removed %d entries\n", removed)
+		},
+	}
+	cmd.Flags().StringVar(&client, "client", "", "Client certificate CN whose cache to purge (see server.https.client_cert_namespace)")
+	cmd.Flags().BoolVar(&prefix, "prefix", false, "Remove the entries of all URLs starting with the URL (ignoring scheme and query string)")
+	cmd.Flags().BoolVar(&regex, "regex", false, "Treat the argument as a regular expression matched against entry URLs")
+	cmd.MarkFlagsMutuallyExclusive("prefix", "regex")
+	return cmd
+}
+
+func newCacheBumpNamespaceCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "bump-namespace",
+		Short: "Switch the config to a new cache namespace, starting from an empty cache",
+		Long:  "Set cache.namespace to its next value in the config file (e.g. v2 -> v3).\nEntries of the previous namespace are kept on disk, but not served anymore",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			configPath := resolveConfigPath(configPath)
+			namespace, err := config.BumpNamespace(configPath)
+			if err != nil {
+				logrus.Fatalf("Failed to bump cache namespace: %v", err)
+			}
+			fmt.Printf("Cache namespace is now '%s' in %s. Restart the proxy to apply it\n", namespace, configPath)
+		},
+	}
+}
+
+func newCacheExportCommand() *cobra.Command {
+	var client string
+	cmd := &cobra.Command{
+		Use:   "export <out" + httpcache.ArchiveExtension + "|-> [url-prefix]",
+		Short: "Write cached entries to an archive, to share them or seed another cache",
+		Long:  "Write all cached entries, or those of URLs starting with a prefix, to a zstd-compressed tar archive (- for stdout).\nEntries keep their cache namespace, so the importing proxy must use the same cache.namespace to serve them",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg := loadValidConfig(configPath)
+			keyPrefix := ""
+			if len(args) == 2 {
+				keyPrefix = httpcache.KeyPrefix(proxy.KeyNamespace(cfg, client), parseURLArg(args[1]))
+			}
+			cacheManager := openCache(cfg)
+
+			out := os.Stdout
+			if args[0] != "-" {
+				var err error
+				if out, err = os.Create(args[0]); err != nil {
+					logrus.Fatalf("Failed to create archive: %v", err)
+				}
+			}
+			exported, err := cacheManager.Export(out, keyPrefix)
+			if err != nil {
+				logrus.Fatalf("Failed to export cache: %v", err)
+			}
+			if err := out.Close(); err != nil {
+				logrus.Fatalf("Failed to write archive: %v", err)
+			}
+			logrus.Infof("Exported %d entries", exported)
+		},
+	}
+	cmd.Flags().StringVar(&client, "client", "", "Client certificate CN whose cache to export (see server.https.client_cert_namespace)")
+	return cmd
+}
+
+func newCacheImportCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "import <in" + httpcache.ArchiveExtension + "|->",
+		Short: "Store the entries of an archive written by cache export",
+		Long:  "Store the entries of an archive written by cache export (- for stdin), replacing existing entries of the same requests.\nImported entries keep the storage time they had when exported: their TTL goes on.\nWhile the proxy runs with the memory backend, import through the admin API instead (POST /api/cache/import)",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg := loadValidConfig(configPath)
+			var in io.Reader = os.Stdin
+			if args[0] != "-" {
+				file, err := os.Open(args[0])
+				if err != nil {
+					logrus.Fatalf("Failed to open archive: %v", err)
+				}
+				defer func() { _ = file.Close() }()
+				in = file
+			}
+			cacheManager := openCache(cfg)
+
+			imported, err := cacheManager.Import(in)
+			if err != nil {
+				logrus.Fatalf("Failed to import cache (%d entries imported): %v", imported, err)
+			}
+			if err := cacheManager.Close(); err != nil {
+				logrus.Fatalf("Failed to close cache: %v", err)
+			}
+			fmt.Printf("Imported %d entries\n", imported)
+		},
+	}
+}
+
+func newCacheMigrateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "migrate",
+		Short: "Convert cached entries to the entry format of this version",
+		Long: fmt.Sprintf("Convert the cached entries written by older versions to the current entry format (version %d), keeping their storage time.\n", httpcache.FormatVersion) +
+			"Entries of older formats are still read, converted on each read: migrating avoids it, so later versions can drop support for old formats.\n" +
+			"Stop the proxy first",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg := loadValidConfig(configPath)
+			cacheManager := openCache(cfg)
+
+			migrated, upToDate, err := cacheManager.MigrateEntries()
+			if err != nil {
+				logrus.Fatalf("Failed to migrate cache (%d entries migrated): %v", migrated, err)
+			}
+			if err := cacheManager.Close(); err != nil {
+				logrus.Fatalf("Failed to close cache: %v", err)
+			}
+			fmt.Printf("Migrated %d entries to format version %d (%d already were)\n", migrated, httpcache.FormatVersion, upToDate)
+		},
+	}
+}
+
+func newCacheImportHARCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "import-har <file.har|->",
+		Short: "Store the entries of a HAR file (e.g. saved from browser devtools), to replay the session through the proxy",
+		Long: "Store the responses of a HAR file (- for stdin) under the cache keys of their requests, regardless of the rules.\n" +
+			"Replaying the same requests through the proxy (same method, URL, body and key headers) then serves them from cache. " +
+			"When a request appears several times, its last response is kept",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg := loadValidConfig(configPath)
+			in := os.Stdin
+			if args[0] != "-" {
+				var err error
+				if in, err = os.Open(args[0]); err != nil {
+					logrus.Fatalf("Failed to open HAR file: %v", err)
+				}
+				defer func() { _ = in.Close() }()
+			}
+			cacheManager := openCache(cfg)
+
+			imported, err := proxy.ImportHAR(cfg, cacheManager, in)
+			if err != nil {
+				logrus.Fatalf("Failed to import HAR file (%d entries imported): %v", imported, err)
+			}
+			if err := cacheManager.Close(); err != nil {
+				logrus.Fatalf("Failed to close cache: %v", err)
+			}
+			fmt.Printf("Imported %d entries\n", imported)
+		},
+	}
+}
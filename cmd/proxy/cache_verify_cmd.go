@@ -0,0 +1,116 @@
+package procycmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/admin"
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+	"github.com/iTrooz/caching-dev-proxy/internal/proxy"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newCacheVerifyCommand() *cobra.Command {
+	var adminURL, client string
+	var sample int
+	var jsonOutput bool
+	filters := map[string]*string{}
+	cmd := &cobra.Command{
+		Use:   "verify [url-prefix]",
+		Short: "Revalidate cached entries with upstream, and report those that changed",
+		Long: "Revalidate cached entries (all, or a random sample) with upstream through the running proxy, using conditional requests when entries have validators (ETag, Last-Modified), " +
+			"and report which ones changed upstream, e.g. to decide what to purge after a backend release. Cached entries are left as they are",
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			query := url.Values{}
+			prefix := proxy.KeyNamespace(loadConfig(configPath), client)
+			if len(args) == 1 {
+				prefix = filepath.Join(prefix, httpcache.KeyDir(parseURLArg(args[0])))
+			}
+			if prefix != "" {
+				query.Set("prefix", prefix)
+			}
+			if sample > 0 {
+				query.Set("sample", strconv.Itoa(sample))
+			}
+			for name, value := range filters {
+				if *value != "" {
+					query.Set(name, *value)
+				}
+			}
+			verifyCache(resolveAdminURL(adminURL), query, jsonOutput)
+		},
+	}
+	cmd.Flags().StringVar(&adminURL, "admin", "", "URL of the admin API (e.g. http://127.0.0.1:8081). Default is built from admin.address")
+	cmd.Flags().StringVar(&client, "client", "", "Client certificate CN whose cache to verify (see server.https.client_cert_namespace)")
+	cmd.Flags().IntVar(&sample, "sample", 0, "Only verify this many entries, drawn at random (0 for all)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the results as JSON lines")
+	for _, flag := range []struct{ name, param, usage string }{
+		{"host", "host", "Only verify entries of this host"},
+		{"path", "path", "Only verify entries of URL paths starting with this prefix"},
+		{"method", "method", "Only verify entries of this request method"},
+		{"min-age", "min_age", "Only verify entries stored at least this long ago (e.g. 24h)"},
+		{"max-age", "max_age", "Only verify entries stored at most this long ago (e.g. 30m)"},
+	} {
+		filters[flag.param] = cmd.Flags().String(flag.name, "", flag.usage)
+	}
+	return cmd
+}
+
+func verifyCache(adminURL string, query url.Values, jsonOutput bool) {
+	resp, err := http.Post(adminURL+"/api/cache/verify?"+query.Encode(), "", nil)
+	if err != nil {
+		logrus.Fatalf("Failed to connect to the admin API: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		logrus.Fatalf("Admin API answered %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	verified, changed, failed := 0, 0, 0
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 4<<20) // differences quote values, lines may be long
+	for scanner.Scan() {
+		if jsonOutput {
+			fmt.Println(scanner.Text())
+			continue
+		}
+		var entryDiff admin.EntryDiff
+		if err := json.Unmarshal(scanner.Bytes(), &entryDiff); err != nil {
+			logrus.Warnf("Invalid result: %v", err)
+			continue
+		}
+		verified++
+		switch {
+		case entryDiff.Error != "":
+			failed++
+			fmt.Printf("ERROR      %s: %s\n", entryDiff.Key, entryDiff.Error)
+		case entryDiff.NotModified:
+			fmt.Printf("unchanged  %s (304 Not Modified)\n", entryDiff.URL)
+		case !entryDiff.Changed:
+			fmt.Printf("unchanged  %s\n", entryDiff.URL)
+		default:
+			changed++
+			fmt.Printf("CHANGED    %s %s\n", entryDiff.Method, entryDiff.URL)
+			for _, difference := range entryDiff.Differences {
+				fmt.Printf("             %s\n", difference)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logrus.Fatalf("Failed to read the results: %v", err)
+	}
+	if !jsonOutput {
+		fmt.Printf("\n%d entries verified: %d changed upstream, %d could not be verified\n", verified, changed, failed)
+	}
+}
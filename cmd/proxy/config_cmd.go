@@ -0,0 +1,138 @@
+package procycmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/ca"
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Create and check configuration files",
+	}
+	cmd.AddCommand(newConfigInitCommand(), newConfigValidateCommand())
+	return cmd
+}
+
+func newConfigValidateCommand() *cobra.Command {
+	var strict, printConfig bool
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the configuration for errors and likely mistakes",
+		Long:  "Check the configuration and the files it refers to (e.g. certificates) for errors, and warn about likely mistakes (e.g. unreachable rules)",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			configValidate(resolveConfigPath(configPath), strict, printConfig)
+		},
+	}
+	cmd.Flags().BoolVar(&strict, "strict", false, "Exit with an error on warnings too")
+	cmd.Flags().BoolVar(&printConfig, "print", false, "Print the effective configuration (defaults and included files merged) once valid")
+	return cmd
+}
+
+func configValidate(configPath string, strict bool, printConfig bool) {
+	cfg := loadConfig(configPath)
+	if err := cfg.Validate(); err != nil {
+		fmt.Printf("%s: error: %v\n", configPath, err)
+		os.Exit(1)
+	}
+	if errs := cfg.CheckFiles(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Printf("%s: error: %v\n", configPath, err)
+		}
+		os.Exit(1)
+	}
+
+	warnings := cfg.Lint()
+	for _, warning := range warnings {
+		fmt.Printf("%s: warning: %s\n", configPath, warning)
+	}
+	if len(warnings) == 0 {
+		fmt.Printf("%s: ok\n", configPath)
+	} else if strict {
+		os.Exit(1)
+	}
+
+	if printConfig {
+		fmt.Println()
+		effective, err := cfg.ToMap()
+		if err == nil {
+			encoder := yaml.NewEncoder(os.Stdout)
+			encoder.SetIndent(2)
+			err = encoder.Encode(effective)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print the configuration: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+func newConfigInitCommand() *cobra.Command {
+	var generateCA, force bool
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Write a commented default configuration, optionally with a new CA",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			configInit(resolveConfigPath(configPath), generateCA, force)
+		},
+	}
+	cmd.Flags().BoolVar(&generateCA, "ca", false, "Also generate a CA key and certificate next to the configuration file, and use them for TLS interception")
+	cmd.Flags().BoolVar(&force, "force", false, "Overwrite existing files")
+	return cmd
+}
+
+func configInit(configPath string, generateCA bool, force bool) {
+	if len(DefaultConfigFile) == 0 {
+		logrus.Fatalf("No default configuration embedded in this build")
+	}
+
+	if _, err := os.Stat(configPath); err == nil && !force {
+		logrus.Fatalf("%s already exists, use -force to overwrite it", configPath)
+	}
+	dir := filepath.Dir(configPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logrus.Fatalf("Failed to create config directory: %v", err)
+	}
+
+	data := DefaultConfigFile
+	if generateCA {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			logrus.Fatalf("Failed to resolve config directory: %v", err)
+		}
+		certPath, keyPath := filepath.Join(absDir, "ca.crt"), filepath.Join(absDir, "ca.key")
+		if _, err := os.Stat(keyPath); err == nil && !force {
+			fmt.Printf("Keeping the existing CA %s\n", certPath)
+		} else {
+			if err := ca.Generate(certPath, keyPath, "caching-dev-proxy CA"); err != nil {
+				logrus.Fatalf("%v", err)
+			}
+			fmt.Printf("Generated CA certificate %s and key %s\n", certPath, keyPath)
+		}
+		for key, value := range map[string]string{"ca_cert_file": certPath, "ca_key_file": keyPath} {
+			if data, err = config.SetYAMLValue(data, value, "server", "https", key); err != nil {
+				logrus.Fatalf("Failed to set CA in configuration: %v", err)
+			}
+		}
+	}
+
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		logrus.Fatalf("Failed to write config file: %v", err)
+	}
+	fmt.Printf("Wrote %s\n", configPath)
+	if generateCA {
+		fmt.Printf("Trust the CA certificate in your system or tools to use TLS interception (see the client-config admin endpoint)\n")
+	} else {
+		fmt.Printf("TLS interception needs a CA: set server.https.ca_cert_file and ca_key_file, or run again with -ca\n")
+	}
+}
@@ -0,0 +1,83 @@
+package procycmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/admin"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newDiffCommand() *cobra.Command {
+	var adminURL, client string
+	var jsonOutput bool
+	cmd := &cobra.Command{
+		Use:   "diff <url>",
+		Short: "Compare the cached entries of a URL with what upstream answers now",
+		Long: "Compare the cached entries of a URL (all methods and header variations) with what upstream answers now: " +
+			"the running proxy sends their stored requests again, bypassing the cache, and lists the differences of status, headers and body. " +
+			"Cached entries are left as they are",
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			diffCached(resolveAdminURL(adminURL), args[0], client, jsonOutput)
+		},
+	}
+	cmd.Flags().StringVar(&adminURL, "admin", "", "URL of the admin API (e.g. http://127.0.0.1:8081). Default is built from admin.address")
+	cmd.Flags().StringVar(&client, "client", "", "Client certificate CN whose cache to compare (see server.https.client_cert_namespace)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the differences as JSON")
+	return cmd
+}
+
+func diffCached(adminURL string, rawURL string, client string, jsonOutput bool) {
+	query := url.Values{"url": {rawURL}}
+	if client != "" {
+		query.Set("client", client)
+	}
+	resp, err := http.Get(adminURL + "/api/cache/diff?" + query.Encode())
+	if err != nil {
+		logrus.Fatalf("Failed to connect to the admin API: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		logrus.Fatalf("Admin API answered %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	if jsonOutput {
+		_, _ = io.Copy(os.Stdout, resp.Body)
+		return
+	}
+
+	var diffs []admin.EntryDiff
+	if err := json.NewDecoder(resp.Body).Decode(&diffs); err != nil {
+		logrus.Fatalf("Invalid admin API response: %v", err)
+	}
+	for _, entryDiff := range diffs {
+		fmt.Printf("# %s\n", entryDiff.Key)
+		if entryDiff.URL != "" {
+			fmt.Printf("Request: %s %s\n", entryDiff.Method, entryDiff.URL)
+		}
+		if !entryDiff.Stored.IsZero() {
+			fmt.Printf("Stored:  %s (%v ago)\n", entryDiff.Stored.Local().Format(time.DateTime), time.Since(entryDiff.Stored).Round(time.Second))
+		}
+		switch {
+		case entryDiff.Error != "":
+			fmt.Printf("Not compared: %s\n", entryDiff.Error)
+		case len(entryDiff.Differences) == 0:
+			fmt.Printf("Up to date: upstream answers the same\n")
+		default:
+			fmt.Printf("%d differences from cached to live:\n", len(entryDiff.Differences))
+			for _, difference := range entryDiff.Differences {
+				fmt.Printf("  %s\n", difference)
+			}
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,162 @@
+package procycmd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/history"
+	"github.com/iTrooz/caching-dev-proxy/internal/loadprofile"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newLoadCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "load",
+		Short: "Build load profiles from the request history, and replay them",
+	}
+	cmd.AddCommand(newLoadProfileCommand(), newLoadReplayCommand())
+	return cmd
+}
+
+func newLoadProfileCommand() *cobra.Command {
+	var since time.Duration
+	var output string
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Build a load profile (request mix and timing) from the request history",
+		Long:  "Build a load profile from the request history (see history.enabled)",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			loadProfile(since, output)
+		},
+	}
+	cmd.Flags().DurationVar(&since, "since", 0, "Only use requests recorded in this duration before now (e.g. 24h). 0 uses the whole history")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output file. Default is standard output")
+	return cmd
+}
+
+func loadProfile(since time.Duration, outputPath string) {
+	cfg := loadConfig(configPath)
+	// No retention: building a profile must not prune the history
+	db, err := history.Open(cfg.History.Path, 0, 0)
+	if err != nil {
+		logrus.Fatalf("Failed to open history: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	var from time.Time
+	if since != 0 {
+		from = time.Now().Add(-since)
+	}
+	profile, err := loadprofile.Build(db, from, time.Time{})
+	if err != nil {
+		logrus.Fatalf("Failed to build load profile: %v", err)
+	}
+
+	output := os.Stdout
+	if outputPath != "" {
+		output, err = os.Create(outputPath)
+		if err != nil {
+			logrus.Fatalf("Failed to create output file: %v", err)
+		}
+		defer func() { _ = output.Close() }()
+	}
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(profile); err != nil {
+		logrus.Fatalf("Failed to write load profile: %v", err)
+	}
+}
+
+func newLoadReplayCommand() *cobra.Command {
+	var opts replayFlags
+	cmd := &cobra.Command{
+		Use:   "replay <profile.json>",
+		Short: "Send requests following a load profile",
+		Long:  "Send requests following a load profile, and print a summary.\nRequests are sent without the headers and bodies of the recorded ones",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			loadReplay(args[0], opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.proxy, "proxy", "", "Proxy to send requests through (e.g. http://127.0.0.1:8080). Default sends them directly")
+	cmd.Flags().StringVar(&opts.upstream, "upstream", "", "Replace the scheme and host of recorded URLs (e.g. https://staging.example.com)")
+	cmd.Flags().Float64Var(&opts.speed, "speed", 1, "Time multiplier: 2 sends requests twice as fast as recorded")
+	cmd.Flags().IntVarP(&opts.requests, "requests", "n", 0, "Number of requests to send. 0 sends as many as recorded")
+	cmd.Flags().Uint64Var(&opts.seed, "seed", 0, "Random seed, to replay the same sequence of requests. 0 picks one")
+	cmd.Flags().BoolVar(&opts.insecure, "insecure", false, "Do not verify TLS certificates (e.g. when not trusting the proxy CA)")
+	return cmd
+}
+
+// replayFlags holds the flags of load replay
+type replayFlags struct {
+	proxy    string
+	upstream string
+	speed    float64
+	requests int
+	seed     uint64
+	insecure bool
+}
+
+func loadReplay(profilePath string, flags replayFlags) {
+	data, err := os.ReadFile(profilePath)
+	if err != nil {
+		logrus.Fatalf("Failed to read load profile: %v", err)
+	}
+	var profile loadprofile.Profile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		logrus.Fatalf("Invalid load profile: %v", err)
+	}
+
+	transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: flags.insecure}}
+	if flags.proxy != "" {
+		proxyURL, err := url.Parse(flags.proxy)
+		if err != nil {
+			logrus.Fatalf("Invalid proxy URL: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	opts := loadprofile.ReplayOptions{
+		Client:   &http.Client{Transport: transport, Timeout: time.Minute},
+		Speed:    flags.speed,
+		Requests: flags.requests,
+		Seed:     flags.seed,
+	}
+	if opts.Seed == 0 {
+		opts.Seed = uint64(time.Now().UnixNano())
+	}
+	if flags.upstream != "" {
+		opts.Upstream, err = url.Parse(flags.upstream)
+		if err != nil || opts.Upstream.Host == "" {
+			logrus.Fatalf("Invalid upstream URL: %s", flags.upstream)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if opts.Requests == 0 {
+		opts.Requests = profile.Requests
+	}
+	logrus.Infof("Replaying %d requests (seed %d)", opts.Requests, opts.Seed)
+	result, err := loadprofile.Replay(ctx, &profile, opts)
+	if err != nil {
+		logrus.Fatalf("Replay failed: %v", err)
+	}
+
+	fmt.Printf("Sent %d requests in %v (%d errors)\n", result.Requests, result.Duration.Round(time.Millisecond), result.Errors)
+	for _, status := range slices.Sorted(maps.Keys(result.ByStatus)) {
+		fmt.Printf("  %d: %d\n", status, result.ByStatus[status])
+	}
+	fmt.Printf("Latency: p50 %v, p90 %v, p99 %v, max %v\n", result.Latency.P50, result.Latency.P90, result.Latency.P99, result.Latency.Max)
+}
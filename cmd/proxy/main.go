@@ -1,21 +1,36 @@
 package procycmd
 
 import (
-	"flag"
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"syscall"
+	"time"
 
 	"github.com/iTrooz/caching-dev-proxy/internal/config"
 	"github.com/iTrooz/caching-dev-proxy/internal/proxy"
 
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
 )
 
-func setupLogrus(level string) {
-	logrus.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp: true,
-	})
+// DefaultConfigFile is the commented default configuration written by "config init"
+var DefaultConfigFile []byte
+
+// configPath is the --config flag, shared by all commands
+var configPath string
+
+func setupLogrus(level string, format string) {
+	if format == "json" {
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logrus.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp: true,
+		})
+	}
 
 	lvl, err := logrus.ParseLevel(level)
 	if err != nil {
@@ -25,42 +40,108 @@ func setupLogrus(level string) {
 }
 
 func Main() {
-	// Parse CLI flags
-	configPathPtr := flag.String("config", "", "Configuration file path")
-	addressPtr := flag.String("a", "", "Address to listen on (example: :8080)")
-	verbosePtr := flag.Bool("v", false, "Enable verbose (debug) logging, overrides config")
-	flag.Parse()
-
-	// Set log level from CLI for setup logging (will be overriden later)
-	if *verbosePtr {
-		logrus.SetLevel(logrus.DebugLevel)
+	root := newServeCommand()
+	root.Use = "caching-dev-proxy"
+	root.Short = "Caching HTTP(S) proxy for development"
+	root.Long = "Caching HTTP(S) proxy for development. Without a command, starts the proxy (see serve)"
+	root.PersistentFlags().StringVar(&configPath, "config", "", "Configuration file path (default: $APP_CONFIG, or caching-dev-proxy/config.yaml in the XDG config directory)")
+	root.AddCommand(newServeCommand(), newCacheCommand(), newConfigCommand(), newCACommand(), newLoadCommand(), newTailCommand(), newDiffCommand())
+
+	root.SetArgs(normalizeArgs(os.Args[1:]))
+	if err := root.Execute(); err != nil {
+		os.Exit(2)
 	}
+}
 
-	// Load config
-	configPath := resolveConfigPath(*configPathPtr)
-	cfg, err := config.Load(configPath)
-	if err != nil {
-		logrus.Fatalf("Failed to load config: %v", err)
+// singleDashFlag matches long flags written with a single dash (e.g. -config), as accepted before subcommands used cobra
+var singleDashFlag = regexp.MustCompile(`^-[a-z][a-z-]+(=.*)?$`)
+
+// normalizeArgs rewrites single-dash long flags to double-dash ones, so existing scripts keep working.
+// Shorthand flags are single letters, so they are not affected
+func normalizeArgs(args []string) []string {
+	normalized := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			copy(normalized[i:], args[i:])
+			break
+		}
+		if singleDashFlag.MatchString(arg) {
+			arg = "-" + arg
+		}
+		normalized[i] = arg
 	}
+	return normalized
+}
 
-	// Handle CLI overrides
-	if *addressPtr != "" {
-		cfg.Server.HTTP.Address = *addressPtr
+func newServeCommand() *cobra.Command {
+	var address, mode string
+	var verbose bool
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Start the proxy",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Set log level from CLI for setup logging (will be overriden later)
+			if verbose {
+				logrus.SetLevel(logrus.DebugLevel)
+			}
+
+			// Load config
+			cfg := loadConfig(configPath)
+
+			// Handle CLI overrides
+			if address != "" {
+				cfg.Server.HTTP.Address = address
+			}
+			if mode != "" {
+				cfg.Cache.Mode = config.CacheMode(mode)
+			}
+			if verbose {
+				cfg.Log.Level = "debug"
+			}
+
+			// Validate config
+			if err := cfg.Validate(); err != nil {
+				logrus.Fatalf("Invalid configuration: %v", err)
+			}
+
+			// Setup logging
+			setupLogrus(cfg.Log.Level, cfg.Log.Format)
+
+			for _, warning := range cfg.Lint() {
+				logrus.Warnf("Configuration: %s", warning)
+			}
+			if cfg.Server.HTTPS.Enabled {
+				useDefaultCA(cfg)
+			}
+
+			// Launch proxy
+			launchProxy(cfg)
+		},
 	}
-	if *verbosePtr {
-		cfg.Log.Level = "debug"
+	cmd.Flags().StringVarP(&address, "address", "a", "", "Address to listen on (example: :8080)")
+	cmd.Flags().StringVar(&mode, "mode", "", "Cache mode: normal, record (always fetch upstream and store) or replay (only serve from cache, fail on miss). Overrides config")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose (debug) logging, overrides config")
+	return cmd
+}
+
+// loadConfig loads the configuration from the CLI, env or default path, exiting on error
+func loadConfig(cliPath string) *config.Config {
+	configPath := resolveConfigPath(cliPath)
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		logrus.Fatalf("Failed to load config: %v", err)
 	}
+	return cfg
+}
 
-	// Validate config
+// loadValidConfig loads the configuration like loadConfig, and exits if it is invalid
+func loadValidConfig(cliPath string) *config.Config {
+	cfg := loadConfig(cliPath)
 	if err := cfg.Validate(); err != nil {
 		logrus.Fatalf("Invalid configuration: %v", err)
 	}
-
-	// Setup logging
-	setupLogrus(cfg.Log.Level)
-
-	// Launch proxy
-	launchProxy(cfg)
+	return cfg
 }
 
 func resolveConfigPath(cliPath string) string {
@@ -91,7 +172,27 @@ func launchProxy(cfg *config.Config) {
 		logrus.Fatalf("Failed to create proxy server: %v", err)
 	}
 
+	// Stop accepting requests on shutdown, so Start returns and state (e.g. memory cache snapshot) is saved below
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		logrus.Infof("Received %v, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logrus.Errorf("Failed to stop cleanly: %v", err)
+		}
+	}()
+
 	if err := server.Start(); err != nil {
+		if err := server.Close(); err != nil {
+			logrus.Errorf("Failed to shut down cleanly: %v", err)
+		}
 		logrus.Fatalf("Server failed: %v", err)
 	}
+	// Stopped by a signal, or after server.exit_when_idle
+	if err := server.Close(); err != nil {
+		logrus.Fatalf("Failed to shut down cleanly: %v", err)
+	}
 }
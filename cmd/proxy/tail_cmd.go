@@ -0,0 +1,67 @@
+package procycmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/admin"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newTailCommand() *cobra.Command {
+	var adminURL string
+	var jsonOutput bool
+	cmd := &cobra.Command{
+		Use:   "tail",
+		Short: "Print the requests handled by a running proxy, as they happen",
+		Long:  "Print the requests handled by a running proxy, as they happen, from the event stream of its admin API (see admin.address)",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			tail(adminURL, jsonOutput)
+		},
+	}
+	cmd.Flags().StringVar(&adminURL, "admin", "", "URL of the admin API (e.g. http://127.0.0.1:8081). Default is built from admin.address")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print events as JSON lines")
+	return cmd
+}
+
+func tail(adminURL string, jsonOutput bool) {
+	resp, err := http.Get(resolveAdminURL(adminURL) + "/api/events")
+	if err != nil {
+		logrus.Fatalf("Failed to connect to the admin API: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		logrus.Fatalf("Admin API answered %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if jsonOutput {
+			fmt.Println(data)
+			continue
+		}
+		var event admin.RequestEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			logrus.Warnf("Invalid event: %v", err)
+			continue
+		}
+		fmt.Printf("%s %d %s <- %s %s (%v)\n", event.Time.Local().Format(time.TimeOnly), event.Status, event.Decision,
+			event.Method, event.URL, time.Duration(event.LatencyMS*float64(time.Millisecond)).Round(time.Millisecond))
+	}
+	if err := scanner.Err(); err != nil {
+		logrus.Fatalf("Event stream failed: %v", err)
+	}
+	fmt.Fprintln(os.Stderr, "The proxy closed the event stream")
+}
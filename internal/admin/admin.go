@@ -0,0 +1,118 @@
+// HTTP API to inspect and manage a running proxy
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+	"github.com/iTrooz/caching-dev-proxy/internal/history"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Options holds what the admin API exposes. nil fields disable the related endpoints
+type Options struct {
+	History *history.DB
+	Cache   *httpcache.HTTPCache
+	Config  *config.Config
+	// Returns the request counters of the proxy
+	Stats func() RequestStats
+	// Returns the key namespace of requests made by a client identity (may be empty)
+	KeyNamespace func(clientIdentity string) string
+	// Explains the caching decision for a request and its response
+	Explain func(requ *http.Request, resp *http.Response) Explanation
+	// Sets the headers injected when serving the cached entries of a URL. Returns the number of updated entries
+	SetServeHeaders func(namespace string, u *url.URL, headers http.Header) (int, error)
+	// Returns the last sample of stored entries, nil if none was taken
+	StorageSample func() *StorageSample
+	// Returns when a stored entry expires, zero if it never does
+	EntryExpiry func(resp *http.Response) time.Time
+	// Subscribes to the requests handled by the proxy, until unsubscribe is called
+	Events func() (events <-chan RequestEvent, unsubscribe func())
+	// Compares the cached entries of a URL with the responses upstream gives now
+	DiffCached func(namespace string, u *url.URL) ([]EntryDiff, error)
+	// Revalidates with upstream the entries whose key starts with prefix and matching filter, or n of them at random if n > 0
+	VerifyCached func(prefix string, filter httpcache.EntryFilter, n int, fn func(EntryDiff) error) error
+}
+
+// API serves the admin endpoints
+type API struct {
+	history       *history.DB
+	cache         *httpcache.HTTPCache
+	config        *config.Config
+	stats         func() RequestStats
+	keyNamespace  func(clientIdentity string) string
+	explain       func(requ *http.Request, resp *http.Response) Explanation
+	serveHeaders  func(namespace string, u *url.URL, headers http.Header) (int, error)
+	storageSample func() *StorageSample
+	entryExpiry   func(resp *http.Response) time.Time
+	events        func() (<-chan RequestEvent, func())
+	diffCached    func(namespace string, u *url.URL) ([]EntryDiff, error)
+	verifyCached  func(prefix string, filter httpcache.EntryFilter, n int, fn func(EntryDiff) error) error
+	mux           *http.ServeMux
+}
+
+func New(opts Options) *API {
+	a := &API{
+		history:       opts.History,
+		cache:         opts.Cache,
+		config:        opts.Config,
+		stats:         opts.Stats,
+		keyNamespace:  opts.KeyNamespace,
+		explain:       opts.Explain,
+		serveHeaders:  opts.SetServeHeaders,
+		storageSample: opts.StorageSample,
+		entryExpiry:   opts.EntryExpiry,
+		events:        opts.Events,
+		diffCached:    opts.DiffCached,
+		verifyCached:  opts.VerifyCached,
+		mux:           http.NewServeMux(),
+	}
+	if a.keyNamespace == nil {
+		a.keyNamespace = func(string) string { return "" }
+	}
+	a.mux.HandleFunc("GET /api/stats", a.handleStats)
+	a.mux.HandleFunc("GET /api/cache/entries", a.handleCacheEntries)
+	a.mux.HandleFunc("GET /api/cache/diff", a.handleCacheDiff)
+	a.mux.HandleFunc("POST /api/cache/verify", a.handleCacheVerify)
+	a.mux.HandleFunc("GET /api/rules", a.handleRules)
+	a.mux.HandleFunc("GET /api/rules/explain", a.handleExplain)
+	a.mux.HandleFunc("GET /api/config", a.handleConfig)
+	a.mux.HandleFunc("GET /api/history/export", a.handleHistoryExport)
+	a.mux.HandleFunc("GET /api/events", a.handleEvents)
+	a.mux.HandleFunc("GET /api/client-config", a.handleClientConfig)
+	a.mux.HandleFunc("PUT /api/cache/headers", a.handleServeHeaders)
+	a.mux.HandleFunc("DELETE /api/cache", a.handleCachePurge)
+	a.mux.HandleFunc("PURGE /api/cache", a.handleCachePurge)
+	a.mux.HandleFunc("GET /api/cache/export", a.handleCacheExport)
+	a.mux.HandleFunc("POST /api/cache/import", a.handleCacheImport)
+	return a
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// parseTimeParam parses an absolute (RFC 3339) or relative (duration before now, e.g. "24h") time.
+// Empty means unbounded
+func parseTimeParam(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Errorf("Failed to write admin API response: %v", err)
+	}
+}
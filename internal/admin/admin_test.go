@@ -0,0 +1,155 @@
+package admin
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+	"github.com/iTrooz/caching-dev-proxy/internal/history"
+)
+
+func fixtureHistory(t *testing.T, records ...history.Record) *history.DB {
+	db, err := history.Open(filepath.Join(t.TempDir(), "history.db"), 0, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	for _, r := range records {
+		if err := db.Insert(r); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	}
+	return db
+}
+
+// fixtureCache returns a cache holding a GET response for each URL
+func fixtureCache(t *testing.T, urls ...string) *httpcache.HTTPCache {
+	httpCache := httpcache.NewHTTP(cache.NewGenericDisk(t.TempDir(), 0))
+	for _, rawURL := range urls {
+		req := httptest.NewRequest(http.MethodGet, rawURL, nil)
+		key, err := httpCache.GenerateKey(req, httpcache.KeyOptions{})
+		if err != nil {
+			t.Fatalf("GenerateKey() error = %v", err)
+		}
+		resp := &http.Response{StatusCode: 200, ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("ok")), Request: req}
+		if err := httpCache.SetKey(key, resp); err != nil {
+			t.Fatalf("SetKey() error = %v", err)
+		}
+	}
+	return httpCache
+}
+
+// get performs a GET request on the API and decodes the JSON response into v
+func get(t *testing.T, api *API, target string, v any) int {
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("GET %s returned invalid JSON %q: %v", target, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestParseTimeParam(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", time.Time{}, false},
+		{"24h", now.Add(-24 * time.Hour), false},
+		{"2025-01-01T00:00:00Z", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"yesterday", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeParam(tt.value, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimeParam(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseTimeParam(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestHistoryExport(t *testing.T) {
+	now := time.Now()
+	db := fixtureHistory(t,
+		history.Record{Time: now.Add(-2 * time.Hour), Method: "GET", URL: "http://example.com/old", Status: 200, CacheStatus: "MISS"},
+		history.Record{Time: now.Add(-time.Minute), Method: "GET", URL: "http://example.com/a", Status: 200, CacheStatus: "HIT", ResponseSize: 12},
+		history.Record{Time: now.Add(-time.Second), Method: "POST", URL: "http://example.com/b", Status: 201, CacheStatus: "DISABLED", ResponseSize: -1},
+	)
+	api := New(Options{History: db})
+
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/export?from=1h", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export status = %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Content-Type") != "application/gzip" {
+		t.Errorf("export Content-Type = %s", rec.Header().Get("Content-Type"))
+	}
+
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("export is not gzip: %v", err)
+	}
+	records := []exportRecord{}
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var r exportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 2 {
+		t.Fatalf("export returned %d records, want 2", len(records))
+	}
+	if records[0].URL != "http://example.com/a" || records[0].CacheStatus != "HIT" || records[1].Method != "POST" {
+		t.Errorf("export returned unexpected records: %+v", records)
+	}
+
+	// Plain access log
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/export?format=access&gzip=false", nil))
+	body, _ := io.ReadAll(rec.Body)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[2], `"POST http://example.com/b" 201 - "DISABLED"`) {
+		t.Errorf("unexpected access log export:\n%s", body)
+	}
+}
+
+func TestHistoryExportErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		api    *API
+		query  string
+		status int
+	}{
+		{"history disabled", New(Options{}), "", http.StatusNotFound},
+		{"invalid from", New(Options{History: fixtureHistory(t)}), "?from=yesterday", http.StatusBadRequest},
+		{"unknown format", New(Options{History: fixtureHistory(t)}), "?format=xml", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/export"+tt.query, nil))
+			if rec.Code != tt.status {
+				t.Errorf("export status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestStats(t *testing.T) {
+	api := New(Options{
+		Cache: fixtureCache(t, "http://example.com/a", "http://example.com/b"),
+		Stats: func() RequestStats {
+			return RequestStats{
+				Since:         time.Now(),
+				Requests:      4,
+				ByCacheStatus: map[string]int64{"HIT": 3, "MISS": 1},
+				ByHost:        map[string]HostStats{"example.com": {Requests: 4, Hits: 3, Misses: 1, TimeSaved: 1500 * time.Millisecond}},
+			}
+		},
+	})
+
+	var stats statsResponse
+	if status := get(t, api, "/api/stats", &stats); status != http.StatusOK {
+		t.Fatalf("GET /api/stats status = %d", status)
+	}
+	if stats.Requests != 4 || stats.HitRatio != 0.75 {
+		t.Errorf("unexpected request stats: %+v", stats)
+	}
+	if host := stats.ByHost["example.com"]; host.Hits != 3 || host.TimeSavedSeconds != 1.5 {
+		t.Errorf("unexpected host stats: %+v", stats.ByHost)
+	}
+	if stats.Cache == nil || stats.Cache.Entries != 2 || stats.Cache.Size == 0 {
+		t.Errorf("unexpected cache stats: %+v", stats.Cache)
+	}
+}
+
+func TestCacheEntries(t *testing.T) {
+	api := New(Options{Cache: fixtureCache(t, "http://a.example.com/1", "http://a.example.com/2", "http://a.example.com/3", "http://b.example.com/")})
+
+	var page entriesResponse
+	if status := get(t, api, "/api/cache/entries?limit=2", &page); status != http.StatusOK {
+		t.Fatalf("GET /api/cache/entries status = %d", status)
+	}
+	if page.Total != 4 || len(page.Entries) != 2 {
+		t.Fatalf("first page: total = %d, entries = %d, want 4 and 2", page.Total, len(page.Entries))
+	}
+	if page.Entries[0].URL != "http://a.example.com/1" || page.Entries[0].Method != "GET" || page.Entries[0].Status != 200 {
+		t.Errorf("unexpected first entry: %+v", page.Entries[0])
+	}
+
+	if get(t, api, "/api/cache/entries?limit=2&offset=2", &page); len(page.Entries) != 2 || page.Entries[1].URL != "http://b.example.com/" {
+		t.Errorf("unexpected second page: %+v", page.Entries)
+	}
+
+	if get(t, api, "/api/cache/entries?prefix=b.example.com", &page); page.Total != 1 {
+		t.Errorf("prefix filter returned %d entries, want 1", page.Total)
+	}
+
+	if get(t, api, "/api/cache/entries?host=b.example.com&method=GET", &page); page.Total != 1 || page.Entries[0].URL != "http://b.example.com/" {
+		t.Errorf("host filter returned %+v, want the b.example.com entry", page.Entries)
+	}
+	if get(t, api, "/api/cache/entries?min_age=1h", &page); page.Total != 0 {
+		t.Errorf("min_age filter returned %d entries, want 0", page.Total)
+	}
+	if status := get(t, api, "/api/cache/entries?max_size=big", &page); status != http.StatusBadRequest {
+		t.Errorf("invalid max_size status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if status := get(t, api, "/api/cache/entries?limit=-1", &page); status != http.StatusBadRequest {
+		t.Errorf("invalid limit status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestRulesAndConfig(t *testing.T) {
+	cfg := config.DefaultConfig
+	cfg.Rules = *config.NewRulesConfig(config.RulesModeWhitelist, config.NewCacheRule("https://example.com", "GET"))
+	api := New(Options{Config: &cfg})
+
+	var rules struct {
+		Mode  string           `json:"mode"`
+		Rules []map[string]any `json:"rules"`
+	}
+	if status := get(t, api, "/api/rules", &rules); status != http.StatusOK {
+		t.Fatalf("GET /api/rules status = %d", status)
+	}
+	if rules.Mode != "whitelist" || len(rules.Rules) != 1 || rules.Rules[0]["base_uri"] != "https://example.com" {
+		t.Errorf("unexpected rules: %+v", rules)
+	}
+
+	var dump map[string]map[string]any
+	if status := get(t, api, "/api/config", &dump); status != http.StatusOK {
+		t.Fatalf("GET /api/config status = %d", status)
+	}
+	if dump["cache"]["folder"] != "./cache" {
+		t.Errorf("unexpected config dump: %+v", dump)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	var received *http.Request
+	var receivedStatus int
+	api := New(Options{Explain: func(requ *http.Request, resp *http.Response) Explanation {
+		received, receivedStatus = requ, resp.StatusCode
+		return Explanation{Mode: "blacklist", Cache: true, Reason: "no rule matched in blacklist mode"}
+	}})
+
+	var explanation Explanation
+	if status := get(t, api, "/api/rules/explain?url=https://example.com/a&method=post&status=404", &explanation); status != http.StatusOK {
+		t.Fatalf("GET /api/rules/explain status = %d", status)
+	}
+	if received.Method != "POST" || received.URL.String() != "https://example.com/a" || receivedStatus != 404 {
+		t.Errorf("explained %s %s (status %d), want POST https://example.com/a (status 404)", received.Method, received.URL, receivedStatus)
+	}
+	if !explanation.Cache || explanation.Mode != "blacklist" {
+		t.Errorf("unexpected explanation: %+v", explanation)
+	}
+
+	if status := get(t, api, "/api/rules/explain?url=/relative", &explanation); status != http.StatusBadRequest {
+		t.Errorf("relative URL status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestServeHeaders(t *testing.T) {
+	var received http.Header
+	api := New(Options{SetServeHeaders: func(namespace string, u *url.URL, headers http.Header) (int, error) {
+		received = headers
+		return 2, nil
+	}})
+
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/cache/headers?url=https://example.com/a", strings.NewReader(`{"content-type": "application/pdf"}`)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"updated":2`) {
+		t.Fatalf("PUT /api/cache/headers = %d %q", rec.Code, rec.Body.String())
+	}
+	if received.Get("Content-Type") != "application/pdf" {
+		t.Errorf("received headers %v, want Content-Type: application/pdf", received)
+	}
+
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/cache/headers?url=https://example.com/a", strings.NewReader(`["invalid"]`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid body status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,61 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handleCacheExport streams the cached entries as an archive (see httpcache.HTTPCache.Export).
+// Query parameters: url (optional, only export the entries of URLs starting with it), client
+func (a *API) handleCacheExport(w http.ResponseWriter, r *http.Request) {
+	if a.cache == nil {
+		http.Error(w, "cache is not available", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	keyPrefix := ""
+	if rawURL := query.Get("url"); rawURL != "" {
+		u, err := url.Parse(rawURL)
+		if err != nil || !u.IsAbs() {
+			http.Error(w, fmt.Sprintf("'url' parameter must be an absolute URL, got '%s'", rawURL), http.StatusBadRequest)
+			return
+		}
+		keyPrefix = httpcache.KeyPrefix(a.keyNamespace(query.Get("client")), u)
+	}
+
+	filename := fmt.Sprintf("cache-%s%s", time.Now().Format("20060102-150405"), httpcache.ArchiveExtension)
+	w.Header().Set("Content-Type", "application/zstd")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	exported, err := a.cache.Export(w, keyPrefix)
+	if err != nil {
+		logrus.Errorf("Failed to export cache: %v", err)
+		// Headers may already be sent: abort the connection so the client notices the truncated export
+		panic(http.ErrAbortHandler)
+	}
+	logrus.Infof("Exported %d cache entries", exported)
+}
+
+// handleCacheImport stores the entries of the archive sent as request body (see httpcache.HTTPCache.Import)
+func (a *API) handleCacheImport(w http.ResponseWriter, r *http.Request) {
+	if a.cache == nil {
+		http.Error(w, "cache is not available", http.StatusNotFound)
+		return
+	}
+
+	imported, err := a.cache.Import(r.Body)
+	if err != nil {
+		logrus.Errorf("Failed to import cache archive (%d entries imported): %v", imported, err)
+		http.Error(w, fmt.Sprintf("failed to import archive after %d entries: %v", imported, err), http.StatusBadRequest)
+		return
+	}
+	logrus.Infof("Imported %d cache entries", imported)
+
+	writeJSON(w, map[string]int{"imported": imported})
+}
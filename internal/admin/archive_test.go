@@ -0,0 +1,39 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCacheExportImport(t *testing.T) {
+	source := New(Options{Cache: fixtureCache(t, "http://example.com/a", "http://example.com/b", "http://other.com/c")})
+	target := New(Options{Cache: fixtureCache(t)})
+
+	rec := httptest.NewRecorder()
+	source.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/cache/export?url=http://example.com/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/cache/export status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec2 := httptest.NewRecorder()
+	target.ServeHTTP(rec2, httptest.NewRequest(http.MethodPost, "/api/cache/import", bytes.NewReader(rec.Body.Bytes())))
+	if rec2.Code != http.StatusOK {
+		t.Fatalf("POST /api/cache/import status = %d: %s", rec2.Code, rec2.Body.String())
+	}
+	var body map[string]int
+	if err := json.Unmarshal(rec2.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", rec2.Body.String(), err)
+	}
+	if body["imported"] != 2 {
+		t.Errorf("imported %d entries, want 2", body["imported"])
+	}
+
+	rec3 := httptest.NewRecorder()
+	target.ServeHTTP(rec3, httptest.NewRequest(http.MethodPost, "/api/cache/import", bytes.NewReader([]byte("not an archive"))))
+	if rec3.Code != http.StatusBadRequest {
+		t.Errorf("importing an invalid archive status = %d, want %d", rec3.Code, http.StatusBadRequest)
+	}
+}
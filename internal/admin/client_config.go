@@ -0,0 +1,190 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/ca"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Platforms client configuration can be generated for
+var clientPlatforms = []string{"linux", "darwin", "windows"}
+
+// clientConfigResponse holds ready-to-use configuration for tools sending requests through the proxy
+type clientConfigResponse struct {
+	Platform string `json:"platform"`
+	ProxyURL string `json:"proxy_url"`
+	// CA certificate to trust for TLS decryption. Empty if not configured
+	CACertFile string `json:"ca_cert_file,omitempty"`
+	// System roots and the CA certificate, for tools whose CA setting replaces the system roots. Empty if it could not be written
+	CABundleFile string `json:"ca_bundle_file,omitempty"`
+	// Environment variables, also as a script for the platform shell
+	Env   map[string]string `json:"env"`
+	Shell string            `json:"shell"`
+	// Configuration file fragments, and where to put them
+	Files map[string]clientConfigFile `json:"files"`
+}
+
+type clientConfigFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// guessPlatform returns the platform of a client from its User-Agent, defaulting to linux
+func guessPlatform(userAgent string) string {
+	userAgent = strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(userAgent, "windows"):
+		return "windows"
+	case strings.Contains(userAgent, "darwin"), strings.Contains(userAgent, "mac os"):
+		return "darwin"
+	default:
+		return "linux"
+	}
+}
+
+// proxyURL returns the URL clients reach the proxy at: the host they reached the admin API with, and the proxy port
+func proxyURL(proxyAddress string, r *http.Request) string {
+	host, port, err := net.SplitHostPort(proxyAddress)
+	if err != nil {
+		host, port = proxyAddress, "8080"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// buildClientConfig generates the configuration of a platform.
+// caBundleFile is used by the tools whose CA setting replaces the system roots, that are left unconfigured without it
+func buildClientConfig(platform string, proxy string, caCertFile string, caBundleFile string) clientConfigResponse {
+	env := map[string]string{
+		"HTTP_PROXY":  proxy,
+		"HTTPS_PROXY": proxy,
+		"http_proxy":  proxy,
+		"https_proxy": proxy,
+		"NO_PROXY":    "localhost,127.0.0.1,::1",
+		"no_proxy":    "localhost,127.0.0.1,::1",
+	}
+	if caCertFile != "" {
+		// Only added to the system roots
+		env["NODE_EXTRA_CA_CERTS"] = caCertFile
+	}
+	if caBundleFile != "" {
+		env["SSL_CERT_FILE"] = caBundleFile
+		env["REQUESTS_CA_BUNDLE"] = caBundleFile
+		env["PIP_CERT"] = caBundleFile
+	}
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var shell strings.Builder
+	for _, name := range names {
+		if platform == "windows" {
+			fmt.Fprintf(&shell, "$env:%s = \"%s\"\n", name, env[name])
+		} else {
+			fmt.Fprintf(&shell, "export %s=%q\n", name, env[name])
+		}
+	}
+
+	npmrc := fmt.Sprintf("proxy=%s\nhttps-proxy=%s\n", proxy, proxy)
+	pipConf := fmt.Sprintf("[global]\nproxy = %s\n", proxy)
+	if caBundleFile != "" {
+		npmrc += fmt.Sprintf("cafile=%s\n", caBundleFile)
+		pipConf += fmt.Sprintf("cert = %s\n", caBundleFile)
+	}
+	dockerConf, _ := json.MarshalIndent(map[string]any{
+		"proxies": map[string]any{
+			"default": map[string]string{"httpProxy": proxy, "httpsProxy": proxy, "noProxy": env["NO_PROXY"]},
+		},
+	}, "", "  ")
+
+	files := map[string]clientConfigFile{
+		"npm":    {Path: "~/.npmrc", Content: npmrc},
+		"pip":    {Path: "~/.config/pip/pip.conf", Content: pipConf},
+		"docker": {Path: "~/.docker/config.json", Content: string(dockerConf) + "\n"},
+	}
+	switch platform {
+	case "darwin":
+		files["pip"] = clientConfigFile{Path: "~/Library/Application Support/pip/pip.conf", Content: pipConf}
+	case "windows":
+		files["npm"] = clientConfigFile{Path: `%USERPROFILE%\.npmrc`, Content: npmrc}
+		files["pip"] = clientConfigFile{Path: `%APPDATA%\pip\pip.ini`, Content: pipConf}
+		files["docker"] = clientConfigFile{Path: `%USERPROFILE%\.docker\config.json`, Content: string(dockerConf) + "\n"}
+	}
+
+	return clientConfigResponse{
+		Platform:     platform,
+		ProxyURL:     proxy,
+		CACertFile:   caCertFile,
+		CABundleFile: caBundleFile,
+		Env:          env,
+		Shell:        shell.String(),
+		Files:        files,
+	}
+}
+
+// handleClientConfig returns configuration snippets for tools to use the proxy.
+// Parameters: platform (default guessed from the User-Agent), snippet ("shell", "npm", "pip" or "docker") to get a single snippet as plain text
+func (a *API) handleClientConfig(w http.ResponseWriter, r *http.Request) {
+	if a.config == nil {
+		http.Error(w, "config is not available", http.StatusNotFound)
+		return
+	}
+
+	platform := r.URL.Query().Get("platform")
+	if platform == "" {
+		platform = guessPlatform(r.UserAgent())
+	} else if !slices.Contains(clientPlatforms, platform) {
+		http.Error(w, fmt.Sprintf("invalid platform: must be one of %s", strings.Join(clientPlatforms, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	caCertFile := ""
+	if a.config.Server.HTTPS.Enabled && a.config.Server.HTTPS.CACertFile != "" {
+		if abs, err := filepath.Abs(a.config.Server.HTTPS.CACertFile); err == nil {
+			caCertFile = abs
+		} else {
+			caCertFile = a.config.Server.HTTPS.CACertFile
+		}
+	}
+	caBundleFile := ""
+	if caCertFile != "" {
+		if bundle, err := ca.WriteBundle(caCertFile); err == nil {
+			caBundleFile = bundle
+		} else {
+			logrus.Warnf("Failed to write the CA bundle, client config only sets NODE_EXTRA_CA_CERTS: %v", err)
+		}
+	}
+	clientConfig := buildClientConfig(platform, proxyURL(a.config.Server.HTTP.Address, r), caCertFile, caBundleFile)
+
+	snippet := r.URL.Query().Get("snippet")
+	if snippet == "" {
+		writeJSON(w, clientConfig)
+		return
+	}
+	content := clientConfig.Shell
+	if snippet != "shell" {
+		file, ok := clientConfig.Files[snippet]
+		if !ok {
+			http.Error(w, "invalid snippet: must be shell, npm, pip or docker", http.StatusBadRequest)
+			return
+		}
+		content = file.Content
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(content))
+}
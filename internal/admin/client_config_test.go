@@ -0,0 +1,95 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/ca"
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestGuessPlatform(t *testing.T) {
+	tests := map[string]string{
+		"curl/8.5.0": "linux",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64)":       "windows",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7)": "darwin",
+		"Python-urllib/3.12":                              "linux",
+		"Go-http-client/1.1 (darwin)":                     "darwin",
+	}
+	for userAgent, expected := range tests {
+		if got := guessPlatform(userAgent); got != expected {
+			t.Errorf("guessPlatform(%q) = %q, want %q", userAgent, got, expected)
+		}
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	tempDir := t.TempDir()
+	caCertFile, systemBundle := filepath.Join(tempDir, "ca.crt.pem"), filepath.Join(tempDir, "system.pem")
+	if err := ca.Generate(caCertFile, filepath.Join(tempDir, "ca.key"), "Test CA"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.Generate(systemBundle, filepath.Join(tempDir, "system.key"), "System CA"); err != nil {
+		t.Fatal(err)
+	}
+	previous := ca.SystemBundles
+	t.Cleanup(func() { ca.SystemBundles = previous })
+	ca.SystemBundles = []string{systemBundle}
+
+	cfg := config.DefaultConfig
+	cfg.Server.HTTP.Address = ":3128"
+	cfg.Server.HTTPS.Enabled = true
+	cfg.Server.HTTPS.CACertFile = caCertFile
+	api := New(Options{Config: &cfg})
+
+	var clientConfig clientConfigResponse
+	if status := get(t, api, "/api/client-config?platform=linux", &clientConfig); status != http.StatusOK {
+		t.Fatalf("GET /api/client-config status = %d", status)
+	}
+	// httptest requests are made to example.com
+	if clientConfig.ProxyURL != "http://example.com:3128" {
+		t.Errorf("proxy URL = %q, want http://example.com:3128", clientConfig.ProxyURL)
+	}
+	if clientConfig.Env["HTTPS_PROXY"] != clientConfig.ProxyURL || clientConfig.Env["NODE_EXTRA_CA_CERTS"] != caCertFile {
+		t.Errorf("unexpected env: %v", clientConfig.Env)
+	}
+	// Variables replacing the system roots point to the bundle holding both
+	bundle, err := os.ReadFile(clientConfig.CABundleFile)
+	if err != nil {
+		t.Fatalf("CA bundle: %v", err)
+	}
+	system, _ := os.ReadFile(systemBundle)
+	caCert, _ := os.ReadFile(caCertFile)
+	if !strings.Contains(string(bundle), string(system)) || !strings.Contains(string(bundle), string(caCert)) {
+		t.Errorf("CA bundle does not hold the system roots and the CA certificate: %q", bundle)
+	}
+	for _, name := range []string{"SSL_CERT_FILE", "REQUESTS_CA_BUNDLE", "PIP_CERT"} {
+		if clientConfig.Env[name] != clientConfig.CABundleFile {
+			t.Errorf("%s = %q, want the CA bundle %q", name, clientConfig.Env[name], clientConfig.CABundleFile)
+		}
+	}
+	if !strings.Contains(clientConfig.Shell, `export HTTPS_PROXY="http://example.com:3128"`) {
+		t.Errorf("unexpected shell script: %q", clientConfig.Shell)
+	}
+	if !strings.Contains(clientConfig.Files["npm"].Content, "cafile="+clientConfig.CABundleFile) {
+		t.Errorf("unexpected npm config: %q", clientConfig.Files["npm"].Content)
+	}
+
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/client-config?platform=windows&snippet=shell", nil))
+	if !strings.Contains(rec.Body.String(), `$env:HTTP_PROXY = "http://example.com:3128"`) {
+		t.Errorf("unexpected windows shell snippet: %q", rec.Body.String())
+	}
+
+	if status := get(t, api, "/api/client-config?platform=amiga", &clientConfig); status != http.StatusBadRequest {
+		t.Errorf("invalid platform status = %d, want %d", status, http.StatusBadRequest)
+	}
+	if status := get(t, api, "/api/client-config?snippet=maven", &clientConfig); status != http.StatusBadRequest {
+		t.Errorf("invalid snippet status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,34 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// handleRules returns the caching rules, as written in the configuration file
+func (a *API) handleRules(w http.ResponseWriter, r *http.Request) {
+	if a.config == nil {
+		http.Error(w, "config is not available", http.StatusNotFound)
+		return
+	}
+	configMap, err := a.config.ToMap()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read rules: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, configMap["rules"])
+}
+
+// handleConfig returns the effective configuration (file merged with defaults and CLI overrides)
+func (a *API) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if a.config == nil {
+		http.Error(w, "config is not available", http.StatusNotFound)
+		return
+	}
+	configMap, err := a.config.ToMap()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read config: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, configMap)
+}
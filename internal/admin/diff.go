@@ -0,0 +1,101 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EntryDiff compares a cached entry with the response upstream gives now to its request
+type EntryDiff struct {
+	Key    string `json:"key"`
+	Method string `json:"method,omitempty"`
+	URL    string `json:"url,omitempty"`
+	// Storage time of the entry, zero if unknown
+	Stored time.Time `json:"stored"`
+	// Status of the cached and of the live response. LiveStatus is 0 if upstream could not be reached
+	CachedStatus int `json:"cached_status"`
+	LiveStatus   int `json:"live_status,omitempty"`
+	// Whether the status or the body of the live response differs from the cached ones
+	Changed bool `json:"changed"`
+	// Whether upstream answered a conditional request with 304 Not Modified, the entry being up to date
+	NotModified bool `json:"not_modified,omitempty"`
+	// Differences from the cached response to the live one, one human readable line each (see diff.Responses)
+	Differences []string `json:"differences"`
+	// Why the entry could not be compared, e.g. upstream failed
+	Error string `json:"error,omitempty"`
+}
+
+// handleCacheDiff fetches the requests of the cached entries of a URL again, bypassing the cache, and lists the differences of the live responses.
+// Query parameters: url, client (client certificate CN whose cache to compare)
+func (a *API) handleCacheDiff(w http.ResponseWriter, r *http.Request) {
+	if a.diffCached == nil {
+		http.Error(w, "cache is not available", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	u, err := url.Parse(query.Get("url"))
+	if err != nil || !u.IsAbs() {
+		http.Error(w, fmt.Sprintf("'url' parameter must be an absolute URL, got '%s'", query.Get("url")), http.StatusBadRequest)
+		return
+	}
+	diffs, err := a.diffCached(a.keyNamespace(query.Get("client")), u)
+	if err != nil {
+		logrus.Errorf("Failed to compare cache entries of %s: %v", u, err)
+		http.Error(w, fmt.Sprintf("failed to compare cache entries: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(diffs) == 0 {
+		http.Error(w, fmt.Sprintf("no cached entry found for %s", u), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, diffs)
+}
+
+// handleCacheVerify revalidates cached entries with upstream, and streams the result of each as a JSON line, as soon as it is known.
+// Query parameters: prefix (key prefix, e.g. a host), sample (number of entries drawn at random, 0 for all), and filters (see entryFilterParams)
+func (a *API) handleCacheVerify(w http.ResponseWriter, r *http.Request) {
+	if a.verifyCached == nil {
+		http.Error(w, "cache is not available", http.StatusNotFound)
+		return
+	}
+	sample, err := intParam(r, "sample", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := entryFilterParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	verified, changed := 0, 0
+	err = a.verifyCached(r.URL.Query().Get("prefix"), filter, sample, func(entryDiff EntryDiff) error {
+		verified++
+		if entryDiff.Changed {
+			changed++
+		}
+		if err := encoder.Encode(entryDiff); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// The status was already sent with the first results
+		logrus.Errorf("Failed to verify cache entries: %v", err)
+		return
+	}
+	logrus.Infof("Verified %d cache entries: %d changed upstream", verified, changed)
+}
@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+)
+
+func TestCacheDiff(t *testing.T) {
+	api := New(Options{DiffCached: func(namespace string, u *url.URL) ([]EntryDiff, error) {
+		if u.Path == "/missing" {
+			return []EntryDiff{}, nil
+		}
+		return []EntryDiff{{Key: "example.com/a/GET.bin", URL: u.String(), Differences: []string{"status: 200 -> 404"}}}, nil
+	}})
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"http://example.com/a", http.StatusOK},
+		{"http://example.com/missing", http.StatusNotFound},
+		{"/relative", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/cache/diff?url="+url.QueryEscape(tt.url), nil))
+		if rec.Code != tt.status {
+			t.Errorf("GET diff %s status = %d, want %d", tt.url, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var diffs []EntryDiff
+		if err := json.Unmarshal(rec.Body.Bytes(), &diffs); err != nil || len(diffs) != 1 || diffs[0].URL != tt.url {
+			t.Errorf("GET diff %s = %s, want the diff of the entry", tt.url, rec.Body.String())
+		}
+	}
+}
+
+func TestCacheVerify(t *testing.T) {
+	api := New(Options{VerifyCached: func(prefix string, filter httpcache.EntryFilter, n int, fn func(EntryDiff) error) error {
+		if prefix != "example.com" || filter.Method != "GET" || n != 2 {
+			t.Errorf("VerifyCached(%q, %+v, %d), want the query parameters", prefix, filter, n)
+		}
+		for _, key := range []string{"example.com/a/GET.bin", "example.com/b/GET.bin"} {
+			if err := fn(EntryDiff{Key: key, Changed: key == "example.com/b/GET.bin"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}})
+
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/cache/verify?prefix=example.com&method=GET&sample=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST verify status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	var last EntryDiff
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[1]), &last) != nil || !last.Changed {
+		t.Errorf("POST verify = %s, want one JSON line per entry", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/cache/verify?sample=some", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST verify with invalid sample status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
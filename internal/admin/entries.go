@@ -0,0 +1,138 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+const (
+	defaultEntriesLimit = 100
+	maxEntriesLimit     = 1000
+)
+
+type entry struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Details read from the entry. Empty if it could not be read (e.g. removed since listed)
+	Method string `json:"method,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Status int    `json:"status,omitempty"`
+	// Expiry of the entry. Omitted if it never expires
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+type entriesResponse struct {
+	Total   int     `json:"total"`
+	Offset  int     `json:"offset"`
+	Limit   int     `json:"limit"`
+	Entries []entry `json:"entries"`
+}
+
+// intParam parses an optional non-negative integer query parameter
+func intParam(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("'%s' parameter must be a non-negative integer, got '%s'", name, value)
+	}
+	return n, nil
+}
+
+// entryFilterParams parses the optional entry filter query parameters
+func entryFilterParams(r *http.Request) (httpcache.EntryFilter, error) {
+	query := r.URL.Query()
+	filter := httpcache.EntryFilter{
+		Host:       query.Get("host"),
+		PathPrefix: query.Get("path"),
+		Method:     query.Get("method"),
+	}
+	for name, target := range map[string]*time.Duration{"min_age": &filter.MinAge, "max_age": &filter.MaxAge} {
+		value, err := config.ParseOptionalDuration(query.Get(name))
+		if err != nil {
+			return filter, fmt.Errorf("invalid '%s' parameter: %w", name, err)
+		}
+		*target = value
+	}
+	for name, target := range map[string]*int64{"min_size": &filter.MinSize, "max_size": &filter.MaxSize} {
+		value, err := config.ParseSize(query.Get(name))
+		if err != nil {
+			return filter, fmt.Errorf("invalid '%s' parameter: %w", name, err)
+		}
+		*target = value
+	}
+	return filter, nil
+}
+
+// handleCacheEntries lists cached entries, in key order.
+// Query parameters: prefix (key prefix, e.g. a host), offset, limit, and filters (see entryFilterParams)
+func (a *API) handleCacheEntries(w http.ResponseWriter, r *http.Request) {
+	if a.cache == nil {
+		http.Error(w, "cache is not available", http.StatusNotFound)
+		return
+	}
+
+	offset, err := intParam(r, "offset", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := intParam(r, "limit", defaultEntriesLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit = min(limit, maxEntriesLimit)
+	filter, err := entryFilterParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only keep the requested page in memory
+	resp := entriesResponse{Offset: offset, Limit: limit, Entries: []entry{}}
+	err = a.cache.List(r.URL.Query().Get("prefix"), filter, func(info cache.EntryInfo, stored *http.Response) error {
+		if stored != nil {
+			_ = stored.Body.Close()
+		}
+		if resp.Total >= offset && len(resp.Entries) < limit {
+			resp.Entries = append(resp.Entries, entry{Key: info.Key, Size: info.Size, ModTime: info.ModTime})
+		}
+		resp.Total++
+		return nil
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to list cache: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	for i := range resp.Entries {
+		e := &resp.Entries[i]
+		stored, err := a.cache.GetStaleKey(e.Key)
+		if err != nil || stored == nil {
+			continue
+		}
+		_ = stored.Body.Close()
+		e.Status = stored.StatusCode
+		if a.entryExpiry != nil {
+			if expires := a.entryExpiry(stored); !expires.IsZero() {
+				e.Expires = &expires
+			}
+		}
+		if stored.Request != nil {
+			e.Method = stored.Request.Method
+			e.URL = stored.Request.URL.String()
+		}
+	}
+
+	writeJSON(w, resp)
+}
@@ -0,0 +1,61 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RequestEvent describes a request handled by the proxy, as streamed by /api/events and written to log.events_file
+type RequestEvent struct {
+	Time   time.Time `json:"ts"`
+	Method string    `json:"method"`
+	URL    string    `json:"url"`
+	Status int       `json:"status"`
+	// X-Cache status of the response
+	Decision string `json:"decision"`
+	// index of the rule matching the response from upstream. Absent if none did, or if the response did not come from upstream
+	Rule      *int    `json:"rule,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+	// response body size, -1 if unknown
+	Size int64 `json:"size"`
+}
+
+// handleEvents streams the requests handled by the proxy as Server-Sent Events, one JSON RequestEvent per event, until the client disconnects
+func (a *API) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if a.events == nil {
+		http.Error(w, "event stream is not available", http.StatusNotFound)
+		return
+	}
+	events, unsubscribe := a.events()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				logrus.Errorf("Failed to encode request event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
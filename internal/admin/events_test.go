@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	events := make(chan RequestEvent, 1)
+	unsubscribed := make(chan struct{})
+	api := New(Options{Events: func() (<-chan RequestEvent, func()) {
+		return events, func() { close(unsubscribed) }
+	}})
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/events")
+	if err != nil {
+		t.Fatalf("GET /api/events error = %v", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %s, want text/event-stream", ct)
+	}
+
+	rule := 1
+	events <- RequestEvent{Method: http.MethodGet, URL: "http://example.com/a", Status: http.StatusOK, Decision: "HIT", Rule: &rule}
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("ReadString() error = %v", err)
+	}
+	data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: ")
+	if !ok {
+		t.Fatalf("line = %q, want an event", line)
+	}
+	var event RequestEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		t.Fatalf("invalid event %q: %v", data, err)
+	}
+	if event.URL != "http://example.com/a" || event.Decision != "HIT" || event.Rule == nil || *event.Rule != 1 {
+		t.Errorf("event = %+v, want the sent one", event)
+	}
+
+	// Disconnecting unsubscribes
+	_ = resp.Body.Close()
+	<-unsubscribed
+
+	rec := httptest.NewRecorder()
+	New(Options{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/events", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status without events = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Explanation details how the caching rules decide whether to cache a response
+type Explanation struct {
+	Mode  string      `json:"mode"`
+	Rules []RuleTrace `json:"rules"`
+	Cache bool        `json:"cache"`
+	// Why the response is cached or not
+	Reason string `json:"reason"`
+}
+
+// RuleTrace is the evaluation of a rule
+type RuleTrace struct {
+	Index   int    `json:"index"`
+	BaseURI string `json:"base_uri"`
+	// false if an earlier rule already matched
+	Evaluated bool   `json:"evaluated"`
+	Matched   bool   `json:"matched"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// String returns the explanation on a single line, e.g. for a response header
+func (e Explanation) String() string {
+	parts := []string{"mode=" + e.Mode}
+	for _, rule := range e.Rules {
+		if !rule.Evaluated {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("rule %d (%s): %s", rule.Index, rule.BaseURI, rule.Reason))
+	}
+	decision := "bypass"
+	if e.Cache {
+		decision = "cache"
+	}
+	parts = append(parts, fmt.Sprintf("decision=%s (%s)", decision, e.Reason))
+	return strings.Join(parts, "; ")
+}
+
+// handleExplain explains the caching decision for a request, without sending it.
+// Parameters: url, method (default GET), status of the response (default 200)
+func (a *API) handleExplain(w http.ResponseWriter, r *http.Request) {
+	if a.explain == nil {
+		http.Error(w, "rule explanation is not available", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	u, err := url.Parse(query.Get("url"))
+	if err != nil || !u.IsAbs() {
+		http.Error(w, "url parameter must be an absolute URL", http.StatusBadRequest)
+		return
+	}
+	method := query.Get("method")
+	if method == "" {
+		method = http.MethodGet
+	}
+	status := http.StatusOK
+	if value := query.Get("status"); value != "" {
+		status, err = strconv.Atoi(value)
+		if err != nil || status < 100 || status > 999 {
+			http.Error(w, "invalid status", http.StatusBadRequest)
+			return
+		}
+	}
+
+	requ, err := http.NewRequest(strings.ToUpper(method), u.String(), nil)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, a.explain(requ, &http.Response{StatusCode: status, Header: http.Header{}, Request: requ}))
+}
@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/history"
+
+	"github.com/sirupsen/logrus"
+)
+
+// number of exported records between two flushes to the client
+const exportFlushInterval = 1000
+
+// exportRecord is the JSON representation of a history record
+type exportRecord struct {
+	Time         time.Time `json:"time"`
+	Source       string    `json:"source"`
+	Method       string    `json:"method"`
+	URL          string    `json:"url"`
+	Status       int       `json:"status"`
+	CacheStatus  string    `json:"cache_status"`
+	DurationMs   int64     `json:"duration_ms"`
+	RequestSize  int64     `json:"request_size"`
+	ResponseSize int64     `json:"response_size"`
+}
+
+// exportFormats maps format names to the file extension and writer of each record
+var exportFormats = map[string]struct {
+	extension string
+	write     func(w io.Writer, r history.Record) error
+}{
+	"jsonl":  {"jsonl", writeJSONLine},
+	"access": {"log", writeAccessLine},
+}
+
+func writeJSONLine(w io.Writer, r history.Record) error {
+	return json.NewEncoder(w).Encode(exportRecord{
+		Time:         r.Time,
+		Source:       r.Source,
+		Method:       r.Method,
+		URL:          r.URL,
+		Status:       r.Status,
+		CacheStatus:  r.CacheStatus,
+		DurationMs:   r.Duration.Milliseconds(),
+		RequestSize:  r.RequestSize,
+		ResponseSize: r.ResponseSize,
+	})
+}
+
+// writeAccessLine writes a record in a format close to the Combined Log Format, with the cache status and duration appended
+func writeAccessLine(w io.Writer, r history.Record) error {
+	size := "-"
+	if r.ResponseSize >= 0 {
+		size = strconv.FormatInt(r.ResponseSize, 10)
+	}
+	_, err := fmt.Fprintf(w, "%s - - [%s] \"%s %s\" %d %s \"%s\" %dms\n",
+		r.Source, r.Time.Format("02/Jan/2006:15:04:05 -0700"), r.Method, r.URL, r.Status, size, r.CacheStatus, r.Duration.Milliseconds())
+	return err
+}
+
+// handleHistoryExport streams the history records of a time range, gzip-compressed unless gzip=false.
+// Query parameters: from, to (RFC 3339 or duration before now), format (jsonl or access)
+func (a *API) handleHistoryExport(w http.ResponseWriter, r *http.Request) {
+	if a.history == nil {
+		http.Error(w, "history is disabled", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	query := r.URL.Query()
+	from, err := parseTimeParam(query.Get("from"), now)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid 'from' parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(query.Get("to"), now)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid 'to' parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	formatName := query.Get("format")
+	if formatName == "" {
+		formatName = "jsonl"
+	}
+	format, ok := exportFormats[formatName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown format '%s'", formatName), http.StatusBadRequest)
+		return
+	}
+	compress := query.Get("gzip") != "false"
+
+	filename := fmt.Sprintf("history-%s.%s", now.Format("20060102-150405"), format.extension)
+	var out io.Writer = w
+	if compress {
+		filename += ".gz"
+		w.Header().Set("Content-Type", "application/gzip")
+		gz := gzip.NewWriter(w)
+		defer func() { _ = gz.Close() }()
+		out = gz
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// Records are written as they are read, so memory usage does not depend on the time range
+	flusher, _ := w.(http.Flusher)
+	exported := 0
+	err = a.history.Query(from, to, func(record history.Record) error {
+		if err := format.write(out, record); err != nil {
+			return err
+		}
+		exported++
+		if exported%exportFlushInterval == 0 && flusher != nil {
+			if gz, ok := out.(*gzip.Writer); ok {
+				if err := gz.Flush(); err != nil {
+					return err
+				}
+			}
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Failed to export history: %v", err)
+		// Headers may already be sent: abort the connection so the client notices the truncated export
+		panic(http.ErrAbortHandler)
+	}
+	logrus.Debugf("Exported %d history records", exported)
+}
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Media type of serialized cache entries
+const entryContentType = "application/vnd.caching-dev-proxy.entry"
+
+// NewPeerHandler returns the handler of the peer endpoint, serving cache entries to peer proxies.
+// It is read-only: GET /api/cache/entry is its only route. If token is set, requests must send it as a bearer token
+func NewPeerHandler(httpCache *httpcache.HTTPCache, token string) http.Handler {
+	a := &API{cache: httpCache}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/cache/entry", a.handleCacheEntry)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "invalid peer token", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// handleCacheEntry returns a serialized cache entry, for peer proxies missing it. 404 if it is not cached.
+// Query parameters: key
+func (a *API) handleCacheEntry(w http.ResponseWriter, r *http.Request) {
+	if a.cache == nil {
+		http.Error(w, "cache is not available", http.StatusNotFound)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if !cache.ValidKey(key) {
+		http.Error(w, fmt.Sprintf("'key' parameter must be a cache key, got '%s'", key), http.StatusBadRequest)
+		return
+	}
+
+	data, err := a.cache.GetRaw(key)
+	if err != nil {
+		logrus.Errorf("Failed to get cache entry %s: %v", key, err)
+		http.Error(w, fmt.Sprintf("failed to get cache entry: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if data == nil {
+		http.Error(w, "not cached", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", entryContentType)
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+)
+
+func TestCacheEntry(t *testing.T) {
+	httpCache := fixtureCache(t, "http://example.com/a")
+	handler := NewPeerHandler(httpCache, "")
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/a", nil)
+	key, err := httpCache.GenerateKey(req, httpcache.KeyOptions{})
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	tests := []struct {
+		key    string
+		status int
+	}{
+		{key, http.StatusOK},
+		{"example.com/missing/GET.bin", http.StatusNotFound},
+		{"../outside", http.StatusBadRequest},
+		{".index", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/cache/entry?key="+url.QueryEscape(tt.key), nil))
+		if rec.Code != tt.status {
+			t.Errorf("GET entry %s status = %d, want %d", tt.key, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if _, err := httpcache.Deserialize(rec.Body.Bytes()); err != nil {
+			t.Errorf("GET entry %s returned an invalid entry: %v", tt.key, err)
+		}
+	}
+}
+
+func TestPeerHandlerRestricted(t *testing.T) {
+	httpCache := fixtureCache(t, "http://example.com/a")
+	handler := NewPeerHandler(httpCache, "secret")
+	key, err := httpCache.GenerateKey(httptest.NewRequest(http.MethodGet, "http://example.com/a", nil), httpcache.KeyOptions{})
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	entryPath := "/api/cache/entry?key=" + url.QueryEscape(key)
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		status        int
+	}{
+		{"valid token", http.MethodGet, entryPath, "Bearer secret", http.StatusOK},
+		{"missing token", http.MethodGet, entryPath, "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, entryPath, "Bearer other", http.StatusUnauthorized},
+		{"write method", http.MethodPost, entryPath, "Bearer secret", http.StatusMethodNotAllowed},
+		{"admin route", http.MethodDelete, "/api/cache", "Bearer secret", http.StatusNotFound},
+		{"admin read route", http.MethodGet, "/api/config", "Bearer secret", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: %s %s status = %d, want %d", tt.name, tt.method, tt.path, rec.Code, tt.status)
+		}
+	}
+
+	// The admin API does not serve entries to peers
+	rec := httptest.NewRecorder()
+	New(Options{Cache: httpCache}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, entryPath, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("admin API GET entry status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
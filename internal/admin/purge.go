@@ -0,0 +1,36 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handleCachePurge removes the cached entries of a URL.
+// Query parameters: url, prefix (true to remove all URLs starting with url), client (client certificate CN whose cache to purge)
+func (a *API) handleCachePurge(w http.ResponseWriter, r *http.Request) {
+	if a.cache == nil {
+		http.Error(w, "cache is not available", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	u, err := url.Parse(query.Get("url"))
+	if err != nil || !u.IsAbs() {
+		http.Error(w, fmt.Sprintf("'url' parameter must be an absolute URL, got '%s'", query.Get("url")), http.StatusBadRequest)
+		return
+	}
+	prefix := query.Get("prefix") == "true"
+
+	purged, err := a.cache.Purge(a.keyNamespace(query.Get("client")), u, prefix)
+	if err != nil {
+		logrus.Errorf("Failed to purge cache for %s: %v", u, err)
+		http.Error(w, fmt.Sprintf("failed to purge cache: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logrus.Infof("Purged %d cache entries for %s (prefix=%v)", purged, u, prefix)
+
+	writeJSON(w, map[string]int{"purged": purged})
+}
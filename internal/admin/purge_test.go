@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCachePurge(t *testing.T) {
+	httpCache := fixtureCache(t, "http://example.com/a", "http://example.com/b")
+	api := New(Options{Cache: httpCache})
+
+	tests := []struct {
+		method string
+		query  string
+		status int
+		purged int
+	}{
+		{http.MethodDelete, "?url=http://example.com/a", http.StatusOK, 1},
+		{http.MethodDelete, "?url=http://example.com/a", http.StatusOK, 0},
+		{"PURGE", "?url=http://example.com/&prefix=true", http.StatusOK, 1},
+		{http.MethodDelete, "?url=/relative", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(tt.method, "/api/cache"+tt.query, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.query, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var body map[string]int
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid JSON response %q: %v", rec.Body.String(), err)
+		}
+		if body["purged"] != tt.purged {
+			t.Errorf("%s %s purged %d entries, want %d", tt.method, tt.query, body["purged"], tt.purged)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+// handleServeHeaders sets headers injected when serving the cached entries of a URL, e.g. to fix a wrong Content-Type.
+// The body is a JSON object of header names to values, {} removing the injected headers.
+// Query parameters: url, client (client certificate CN whose cache to update)
+func (a *API) handleServeHeaders(w http.ResponseWriter, r *http.Request) {
+	if a.serveHeaders == nil {
+		http.Error(w, "cache is not available", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	u, err := url.Parse(query.Get("url"))
+	if err != nil || !u.IsAbs() {
+		http.Error(w, fmt.Sprintf("'url' parameter must be an absolute URL, got '%s'", query.Get("url")), http.StatusBadRequest)
+		return
+	}
+	var values map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		http.Error(w, fmt.Sprintf("body must be a JSON object of header names to values: %v", err), http.StatusBadRequest)
+		return
+	}
+	headers := http.Header{}
+	for name, value := range values {
+		headers.Set(name, value)
+	}
+
+	updated, err := a.serveHeaders(a.keyNamespace(query.Get("client")), u, headers)
+	if err != nil {
+		logrus.Errorf("Failed to set serve headers for %s: %v", u, err)
+		http.Error(w, fmt.Sprintf("failed to update cache entries: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logrus.Infof("Set serve headers of %d cache entries for %s: %v", updated, u, values)
+
+	writeJSON(w, map[string]int{"updated": updated})
+}
@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+)
+
+// RequestStats holds the request counters of the proxy
+type RequestStats struct {
+	Since    time.Time
+	Requests int64
+	// Requests by X-Cache status (HIT, MISS, ...)
+	ByCacheStatus map[string]int64
+	ByHost        map[string]HostStats
+}
+
+// HostStats holds the request counters of a host
+type HostStats struct {
+	Requests int64 `json:"requests"`
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+	Bypasses int64 `json:"bypasses"`
+	// Size of the bodies served from cache, when known
+	BytesSaved int64 `json:"bytes_saved"`
+	// Estimated from the average duration of misses
+	TimeSaved time.Duration `json:"-"`
+}
+
+// StorageSample describes a random sample of stored entries
+type StorageSample struct {
+	Time time.Time `json:"time"`
+	// Number of sampled entries, and of stored entries they were drawn from
+	Entries int                          `json:"entries"`
+	Total   int64                        `json:"total"`
+	ByHost  map[string]HostStorageSample `json:"by_host"`
+	// Storage settings worth enabling, e.g. compression for a host with compressible bodies
+	Recommendations []string `json:"recommendations"`
+}
+
+// HostStorageSample describes the sampled entries of a host
+type HostStorageSample struct {
+	Entries int `json:"entries"`
+	// Size of the bodies, and once gzip-compressed
+	Bytes           int64 `json:"bytes"`
+	CompressedBytes int64 `json:"compressed_bytes"`
+	// Compressed size / size: lower is more compressible
+	CompressionRatio float64 `json:"compression_ratio"`
+	// Entries whose body is identical to the body of another sampled entry
+	DuplicateBodies int     `json:"duplicate_bodies"`
+	DuplicateRate   float64 `json:"duplicate_rate"`
+}
+
+type hostStatsResponse struct {
+	HostStats
+	TimeSavedSeconds float64 `json:"time_saved_seconds"`
+}
+
+type statsResponse struct {
+	Since         time.Time                    `json:"since"`
+	UptimeSeconds int64                        `json:"uptime_seconds"`
+	Requests      int64                        `json:"requests"`
+	ByCacheStatus map[string]int64             `json:"by_cache_status"`
+	HitRatio      float64                      `json:"hit_ratio"`
+	ByHost        map[string]hostStatsResponse `json:"by_host"`
+	Cache         *cacheStats                  `json:"cache,omitempty"`
+	StorageSample *StorageSample               `json:"storage_sample,omitempty"`
+}
+
+type cacheStats struct {
+	Entries int64 `json:"entries"`
+	Size    int64 `json:"size"`
+}
+
+// handleStats returns request counters and the size of the cache
+func (a *API) handleStats(w http.ResponseWriter, r *http.Request) {
+	resp := statsResponse{ByCacheStatus: map[string]int64{}, ByHost: map[string]hostStatsResponse{}}
+	if a.stats != nil {
+		stats := a.stats()
+		resp.Since = stats.Since
+		resp.UptimeSeconds = int64(time.Since(stats.Since).Seconds())
+		resp.Requests = stats.Requests
+		resp.ByCacheStatus = stats.ByCacheStatus
+		for host, hostStats := range stats.ByHost {
+			resp.ByHost[host] = hostStatsResponse{HostStats: hostStats, TimeSavedSeconds: hostStats.TimeSaved.Seconds()}
+		}
+		if stats.Requests > 0 {
+			resp.HitRatio = float64(stats.ByCacheStatus["HIT"]) / float64(stats.Requests)
+		}
+	}
+
+	if a.storageSample != nil {
+		resp.StorageSample = a.storageSample()
+	}
+
+	if a.cache != nil {
+		resp.Cache = &cacheStats{}
+		err := a.cache.Walk("", func(info cache.EntryInfo) error {
+			resp.Cache.Entries++
+			resp.Cache.Size += info.Size
+			return nil
+		})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to read cache: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeJSON(w, resp)
+}
@@ -0,0 +1,70 @@
+package ca
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SystemBundles are the usual locations of the PEM bundle of the system roots, the first one found is used
+var SystemBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",                // Debian, Ubuntu, Arch, Alpine
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem", // Fedora, RHEL
+	"/etc/pki/tls/certs/ca-bundle.crt",                  // older Fedora, RHEL
+	"/etc/ssl/ca-bundle.pem",                            // openSUSE
+	"/etc/ssl/cert.pem",                                 // macOS, Alpine
+}
+
+// WriteBundle writes the system roots followed by the CA certificate as a PEM bundle in the per-user CA directory, and returns its path.
+// Tools whose CA setting replaces the system roots (SSL_CERT_FILE, REQUESTS_CA_BUNDLE...) need it to keep trusting other hosts
+func WriteBundle(certPath string) (string, error) {
+	var system []byte
+	for _, path := range SystemBundles {
+		data, err := os.ReadFile(path)
+		if err == nil && len(bytes.TrimSpace(data)) > 0 {
+			system = data
+			break
+		}
+	}
+	if system == nil {
+		return "", fmt.Errorf("no system CA bundle found in %v", SystemBundles)
+	}
+	cert, err := os.ReadFile(certPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+
+	dir, err := DefaultDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create CA directory: %w", err)
+	}
+	bundle := append(bytes.TrimRight(system, "\n"), '\n')
+	bundle = append(bundle, cert...)
+
+	// Written aside then renamed, so tools never read a partial bundle
+	bundlePath := filepath.Join(dir, "ca-bundle.pem")
+	tmp, err := os.CreateTemp(dir, ".ca-bundle-*.pem")
+	if err != nil {
+		return "", fmt.Errorf("failed to write CA bundle: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(bundle); err != nil {
+		_ = tmp.Close()
+		return "", fmt.Errorf("failed to write CA bundle: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return "", fmt.Errorf("failed to write CA bundle: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write CA bundle: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), bundlePath); err != nil {
+		return "", fmt.Errorf("failed to write CA bundle: %w", err)
+	}
+	return bundlePath, nil
+}
@@ -0,0 +1,52 @@
+package ca
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteBundle(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	tempDir := t.TempDir()
+	systemPath, systemKey := filepath.Join(tempDir, "system.crt"), filepath.Join(tempDir, "system.key")
+	certPath, keyPath := filepath.Join(tempDir, "ca.crt"), filepath.Join(tempDir, "ca.key")
+	if err := Generate(systemPath, systemKey, "System CA"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Generate(certPath, keyPath, "Proxy CA"); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := SystemBundles
+	t.Cleanup(func() { SystemBundles = previous })
+	SystemBundles = []string{filepath.Join(tempDir, "missing.crt"), systemPath}
+
+	bundlePath, err := WriteBundle(certPath)
+	if err != nil {
+		t.Fatalf("WriteBundle() error = %v", err)
+	}
+	data, err := os.ReadFile(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		t.Fatal("bundle holds no certificate")
+	}
+	for _, path := range []string{systemPath, certPath} {
+		cert, err := LoadCertificate(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+			t.Errorf("bundle does not trust %s: %v", cert.Subject.CommonName, err)
+		}
+	}
+
+	SystemBundles = []string{filepath.Join(tempDir, "missing.crt")}
+	if _, err := WriteBundle(certPath); err == nil {
+		t.Error("WriteBundle() without system roots error = nil, want an error")
+	}
+}
@@ -0,0 +1,106 @@
+// Generates the CA certificate signing the certificates of intercepted HTTPS hosts
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Validity of generated CA certificates
+const Validity = 10 * 365 * 24 * time.Hour
+
+// Generate creates a CA key pair, and writes the certificate and the private key (readable by the owner only) as PEM files
+func Generate(certPath string, keyPath string, commonName string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate CA serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour), // tolerate clock skew of clients
+		NotAfter:              now.Add(Validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode CA key: %w", err)
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("failed to write CA key: %w", err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("failed to write CA certificate: %w", err)
+	}
+	return nil
+}
+
+// DefaultDir returns the directory of the per-user CA used when none is configured: caching-dev-proxy in the XDG data directory
+func DefaultDir() (string, error) {
+	if xdg := os.Getenv("XDG_DATA_HOME"); xdg != "" {
+		return filepath.Join(xdg, "caching-dev-proxy"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the data directory: %w", err)
+	}
+	return filepath.Join(home, ".local", "share", "caching-dev-proxy"), nil
+}
+
+// EnsureDefault returns the certificate and key paths of the per-user CA, generating it if it does not exist yet
+func EnsureDefault() (certPath string, keyPath string, generated bool, err error) {
+	dir, err := DefaultDir()
+	if err != nil {
+		return "", "", false, err
+	}
+	certPath, keyPath = filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	if _, err := os.Stat(keyPath); err == nil {
+		return certPath, keyPath, false, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", false, fmt.Errorf("failed to create CA directory: %w", err)
+	}
+	if err := Generate(certPath, keyPath, "caching-dev-proxy CA"); err != nil {
+		return "", "", false, err
+	}
+	return certPath, keyPath, true, nil
+}
+
+// LoadCertificate loads a PEM certificate
+func LoadCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM certificate found in %s", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate in %s: %w", path, err)
+	}
+	return cert, nil
+}
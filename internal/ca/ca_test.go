@@ -0,0 +1,60 @@
+package ca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	tempDir := t.TempDir()
+	certPath, keyPath := filepath.Join(tempDir, "ca.crt"), filepath.Join(tempDir, "ca.key")
+	if err := Generate(certPath, keyPath, "Test CA"); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("generated files do not load: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.IsCA || cert.Subject.CommonName != "Test CA" || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		t.Errorf("unexpected certificate: CA=%v CN=%s usage=%v", cert.IsCA, cert.Subject.CommonName, cert.KeyUsage)
+	}
+
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key permissions = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestEnsureDefault(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	certPath, keyPath, generated, err := EnsureDefault()
+	if err != nil {
+		t.Fatalf("EnsureDefault() error = %v", err)
+	}
+	if !generated {
+		t.Errorf("EnsureDefault() did not generate a CA on first use")
+	}
+	if _, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+		t.Fatalf("generated files do not load: %v", err)
+	}
+
+	againCert, againKey, generated, err := EnsureDefault()
+	if err != nil {
+		t.Fatalf("EnsureDefault() error = %v", err)
+	}
+	if generated || againCert != certPath || againKey != keyPath {
+		t.Errorf("EnsureDefault() = %s, %s, generated %v, want the existing CA", againCert, againKey, generated)
+	}
+}
@@ -0,0 +1,205 @@
+package ca
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// TrustStore is a certificate store of the OS or of browsers the CA can be installed into
+type TrustStore interface {
+	// Name describes the store to users
+	Name() string
+	// Install adds the CA certificate to the store, trusted to identify websites
+	Install(cert *x509.Certificate) error
+	// Uninstall removes the CA certificate from the store
+	Uninstall(cert *x509.Certificate) error
+}
+
+// run runs a command, failing with its output. Replaced in tests
+var run = func(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// lookPath reports whether a command is available. Replaced in tests
+var lookPath = func(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// TrustStores returns the trust stores found on this machine, for the OS goos (see runtime.GOOS)
+func TrustStores(goos string, home string) []TrustStore {
+	stores := []TrustStore{}
+	switch goos {
+	case "darwin":
+		stores = append(stores, &keychainStore{keychain: filepath.Join(home, "Library", "Keychains", "login.keychain-db")})
+	case "windows":
+		stores = append(stores, &windowsStore{})
+	case "linux":
+		if store := linuxSystemStore(); store != nil {
+			stores = append(stores, store)
+		}
+	}
+
+	// Firefox, and Chrome on Linux, have their own NSS stores
+	if goos == "windows" || !lookPath("certutil") {
+		return stores
+	}
+	dirs := []string{filepath.Join(home, ".pki", "nssdb")}
+	for _, profiles := range []string{
+		filepath.Join(home, ".mozilla", "firefox"),
+		filepath.Join(home, "snap", "firefox", "common", ".mozilla", "firefox"),
+		filepath.Join(home, "Library", "Application Support", "Firefox", "Profiles"),
+	} {
+		matches, _ := filepath.Glob(filepath.Join(profiles, "*"))
+		dirs = append(dirs, matches...)
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, "cert9.db")); err == nil {
+			stores = append(stores, &nssStore{dir: dir})
+		}
+	}
+	return stores
+}
+
+// linuxSystemStore returns the system store of the distribution, or nil if it is not known
+func linuxSystemStore() TrustStore {
+	for _, store := range []*systemStore{
+		{dir: "/usr/local/share/ca-certificates", update: []string{"update-ca-certificates"}},           // Debian, Ubuntu, Alpine
+		{dir: "/etc/pki/ca-trust/source/anchors", update: []string{"update-ca-trust", "extract"}},       // Fedora, RHEL
+		{dir: "/etc/ca-certificates/trust-source/anchors", update: []string{"trust", "extract-compat"}}, // Arch
+		{dir: "/usr/share/pki/trust/anchors", update: []string{"update-ca-certificates"}},               // openSUSE
+	} {
+		if info, err := os.Stat(store.dir); err == nil && info.IsDir() && lookPath(store.update[0]) {
+			return store
+		}
+	}
+	return nil
+}
+
+// systemStore is the store of a Linux distribution, built from the certificates of an anchors directory
+type systemStore struct {
+	dir string
+	// command rebuilding the store from the anchors directory
+	update []string
+}
+
+func (s *systemStore) Name() string {
+	return "system store (" + s.dir + ")"
+}
+
+func (s *systemStore) path() string {
+	return filepath.Join(s.dir, "caching-dev-proxy.crt")
+}
+
+func (s *systemStore) Install(cert *x509.Certificate) error {
+	if err := os.WriteFile(s.path(), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644); err != nil {
+		return fmt.Errorf("failed to write certificate (run as root?): %w", err)
+	}
+	return run(s.update[0], s.update[1:]...)
+}
+
+func (s *systemStore) Uninstall(*x509.Certificate) error {
+	if err := os.Remove(s.path()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove certificate (run as root?): %w", err)
+	}
+	return run(s.update[0], s.update[1:]...)
+}
+
+// keychainStore is a macOS keychain
+type keychainStore struct {
+	keychain string
+}
+
+func (s *keychainStore) Name() string {
+	return "macOS keychain (" + s.keychain + ")"
+}
+
+func (s *keychainStore) Install(cert *x509.Certificate) error {
+	path, cleanup, err := tempCertFile(cert)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return run("security", "add-trusted-cert", "-r", "trustRoot", "-k", s.keychain, path)
+}
+
+func (s *keychainStore) Uninstall(cert *x509.Certificate) error {
+	return run("security", "delete-certificate", "-Z", fmt.Sprintf("%X", sha1.Sum(cert.Raw)), s.keychain)
+}
+
+// windowsStore is the root store of the current Windows user
+type windowsStore struct{}
+
+func (s *windowsStore) Name() string {
+	return "Windows user root store"
+}
+
+func (s *windowsStore) Install(cert *x509.Certificate) error {
+	path, cleanup, err := tempCertFile(cert)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return run("certutil", "-user", "-addstore", "-f", "Root", path)
+}
+
+func (s *windowsStore) Uninstall(cert *x509.Certificate) error {
+	return run("certutil", "-user", "-delstore", "Root", cert.SerialNumber.Text(16))
+}
+
+// nssStore is the NSS database of Firefox profiles, or of Chrome on Linux
+type nssStore struct {
+	dir string
+}
+
+func (s *nssStore) Name() string {
+	return "NSS database (" + s.dir + ")"
+}
+
+func (s *nssStore) Install(cert *x509.Certificate) error {
+	path, cleanup, err := tempCertFile(cert)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return run("certutil", "-d", "sql:"+s.dir, "-A", "-t", "C,,", "-n", nickname(cert), "-i", path)
+}
+
+func (s *nssStore) Uninstall(cert *x509.Certificate) error {
+	return run("certutil", "-d", "sql:"+s.dir, "-D", "-n", nickname(cert))
+}
+
+// nickname identifies the certificate in NSS databases, so regenerated CAs do not collide
+func nickname(cert *x509.Certificate) string {
+	fingerprint := sha256.Sum256(cert.Raw)
+	return fmt.Sprintf("%s %X", cert.Subject.CommonName, fingerprint[:4])
+}
+
+// tempCertFile writes the certificate to a temporary PEM file, for tools reading it from a file
+func tempCertFile(cert *x509.Certificate) (string, func(), error) {
+	file, err := os.CreateTemp("", "caching-dev-proxy-ca-*.crt")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to write certificate: %w", err)
+	}
+	cleanup := func() { _ = os.Remove(file.Name()) }
+	_, err = file.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to write certificate: %w", err)
+	}
+	return file.Name(), cleanup, nil
+}
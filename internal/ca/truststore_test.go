@@ -0,0 +1,97 @@
+package ca
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// stubCommands records the commands run by trust stores instead of running them
+func stubCommands(t *testing.T) *[]string {
+	commands := []string{}
+	oldRun, oldLookPath := run, lookPath
+	run = func(name string, args ...string) error {
+		commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+		return nil
+	}
+	lookPath = func(string) bool { return true }
+	t.Cleanup(func() { run, lookPath = oldRun, oldLookPath })
+	return &commands
+}
+
+func testCertificate(t *testing.T) *x509.Certificate {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "ca.crt")
+	if err := Generate(certPath, filepath.Join(dir, "ca.key"), "Test CA"); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := LoadCertificate(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestNSSStores(t *testing.T) {
+	commands := stubCommands(t)
+	cert := testCertificate(t)
+	home := t.TempDir()
+	for _, dir := range []string{".pki/nssdb", ".mozilla/firefox/abc.default", ".mozilla/firefox/empty"} {
+		if err := os.MkdirAll(filepath.Join(home, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, db := range []string{".pki/nssdb/cert9.db", ".mozilla/firefox/abc.default/cert9.db"} {
+		if err := os.WriteFile(filepath.Join(home, db), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stores := TrustStores("darwin", home)
+	names := []string{}
+	for _, store := range stores {
+		names = append(names, store.Name())
+	}
+	if len(stores) != 3 || !strings.HasPrefix(names[0], "macOS keychain") {
+		t.Fatalf("TrustStores() = %v, want the keychain and 2 NSS databases", names)
+	}
+
+	if err := stores[2].Install(cert); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	if err := stores[2].Uninstall(cert); err != nil {
+		t.Fatalf("Uninstall() error = %v", err)
+	}
+	dir := filepath.Join(home, ".mozilla/firefox/abc.default")
+	if len(*commands) != 2 ||
+		!strings.HasPrefix((*commands)[0], "certutil -d sql:"+dir+" -A -t C,, -n Test CA ") ||
+		(*commands)[1] != "certutil -d sql:"+dir+" -D -n "+nickname(cert) {
+		t.Errorf("commands = %q", *commands)
+	}
+}
+
+func TestSystemStore(t *testing.T) {
+	commands := stubCommands(t)
+	cert := testCertificate(t)
+	store := &systemStore{dir: t.TempDir(), update: []string{"update-ca-trust", "extract"}}
+
+	if err := store.Install(cert); err != nil {
+		t.Fatalf("Install() error = %v", err)
+	}
+	installed, err := LoadCertificate(store.path())
+	if err != nil || !installed.Equal(cert) {
+		t.Fatalf("installed certificate = %v, %v", installed, err)
+	}
+	if err := store.Uninstall(cert); err != nil {
+		t.Fatalf("Uninstall() error = %v", err)
+	}
+	if _, err := os.Stat(store.path()); !os.IsNotExist(err) {
+		t.Errorf("certificate still installed: %v", err)
+	}
+	if want := []string{"update-ca-trust extract", "update-ca-trust extract"}; !slices.Equal(*commands, want) {
+		t.Errorf("commands = %q, want %q", *commands, want)
+	}
+}
@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ChainCache implements Cache interface over an ordered chain of backends (e.g. memory then disk).
+// Reads fall through the chain, writes go to all backends.
+// A failing backend is skipped for a while, then retried, so an outage degrades the cache instead of erroring
+type ChainCache struct {
+	backends      []*chainBackend
+	retryInterval time.Duration
+}
+
+// chainBackend is a backend of the chain along with its health
+type chainBackend struct {
+	name  string
+	cache GenericCache
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// NamedCache is a cache backend with a name, for logs
+type NamedCache struct {
+	Name  string
+	Cache GenericCache
+}
+
+// NewChain creates a chain of backends, in read order.
+// A failing backend is skipped during retryInterval
+func NewChain(backends []NamedCache, retryInterval time.Duration) *ChainCache {
+	c := &ChainCache{retryInterval: retryInterval}
+	for _, backend := range backends {
+		c.backends = append(c.backends, &chainBackend{name: backend.Name, cache: backend.Cache})
+	}
+	return c
+}
+
+// available reports whether the backend should be used
+func (b *chainBackend) available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Now().After(b.downUntil)
+}
+
+// report updates the backend health from the result of an operation
+func (b *chainBackend) report(err error, retryInterval time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		if b.downUntil.IsZero() {
+			logrus.Warnf("Cache backend %s failed, skipping it for %v: %v", b.name, retryInterval, err)
+		}
+		b.downUntil = time.Now().Add(retryInterval)
+	} else if !b.downUntil.IsZero() {
+		logrus.Infof("Cache backend %s recovered", b.name)
+		b.downUntil = time.Time{}
+	}
+}
+
+// read tries get on each available backend in order, until one has the entry.
+// If backfill is set, backends before it are filled with the entry (see backfill)
+func (c *ChainCache) read(key string, get func(GenericCache) ([]byte, error), backfill bool) ([]byte, error) {
+	var errs []error
+	missed := []*chainBackend{}
+	for _, backend := range c.backends {
+		if !backend.available() {
+			continue
+		}
+		data, err := get(backend.cache)
+		backend.report(err, c.retryInterval)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", backend.name, err))
+			continue
+		}
+		if data == nil {
+			missed = append(missed, backend)
+			continue
+		}
+
+		if backfill && len(missed) > 0 {
+			c.backfill(key, data, backend, missed)
+		}
+		return data, nil
+	}
+
+	if len(missed) == 0 && len(errs) > 0 {
+		return nil, fmt.Errorf("all cache backends failed: %w", errors.Join(errs...))
+	}
+	return nil, nil
+}
+
+// backfill copies a fresh entry found in source to the backends which missed it.
+// The copies keep the storage time of the entry, so they expire with it: backends which cannot keep it are not filled
+func (c *ChainCache) backfill(key string, data []byte, source *chainBackend, missed []*chainBackend) {
+	var storedAt time.Time
+	err := source.cache.Walk(key, func(info EntryInfo) error {
+		if info.Key == key {
+			storedAt = info.ModTime
+		}
+		return nil
+	})
+	if err != nil || storedAt.IsZero() {
+		return
+	}
+	for _, previous := range missed {
+		setter, ok := previous.cache.(ModTimeSetter)
+		if !ok {
+			continue
+		}
+		err := previous.cache.Set(key, data)
+		if err == nil {
+			err = setter.SetModTime(key, storedAt)
+		}
+		if err != nil {
+			previous.report(err, c.retryInterval)
+		}
+	}
+}
+
+func (c *ChainCache) Get(key string) ([]byte, error) {
+	return c.read(key, func(backend GenericCache) ([]byte, error) { return backend.Get(key) }, true)
+}
+
+// GetStale does not fill the backends before the one having the entry, as it may have expired
+func (c *ChainCache) GetStale(key string) ([]byte, error) {
+	return c.read(key, func(backend GenericCache) ([]byte, error) { return backend.GetStale(key) }, false)
+}
+
+// write runs op on all available backends. Fails only if no backend succeeded
+func (c *ChainCache) write(op func(GenericCache) error) error {
+	var errs []error
+	succeeded := 0
+	for _, backend := range c.backends {
+		if !backend.available() {
+			continue
+		}
+		err := op(backend.cache)
+		backend.report(err, c.retryInterval)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", backend.name, err))
+			continue
+		}
+		succeeded++
+	}
+
+	if succeeded == 0 {
+		if len(errs) == 0 {
+			return fmt.Errorf("no cache backend available")
+		}
+		return fmt.Errorf("all cache backends failed: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Set stores the entry in all available backends
+func (c *ChainCache) Set(key string, value []byte) error {
+	return c.write(func(backend GenericCache) error { return backend.Set(key, value) })
+}
+
+// Delete removes the entry from all available backends.
+// Entries of unavailable backends are not removed
+func (c *ChainCache) Delete(key string) error {
+	return c.write(func(backend GenericCache) error { return backend.Delete(key) })
+}
+
+// SetModTime sets the storage time of the entry in all available backends supporting it
+func (c *ChainCache) SetModTime(key string, modTime time.Time) error {
+	return c.write(func(backend GenericCache) error {
+		if setter, ok := backend.(ModTimeSetter); ok {
+			return setter.SetModTime(key, modTime)
+		}
+		return nil
+	})
+}
+
+// Walk walks the entries of the first available backend
+func (c *ChainCache) Walk(prefix string, fn func(info EntryInfo) error) error {
+	for _, backend := range c.backends {
+		if backend.available() {
+			return backend.cache.Walk(prefix, fn)
+		}
+	}
+	return fmt.Errorf("no cache backend available")
+}
+
+// Init initializes all backends. Backends failing to initialize are skipped until they recover
+func (c *ChainCache) Init() error {
+	return c.write(func(backend GenericCache) error { return backend.Init() })
+}
+
+// Close closes the backends needing it
+func (c *ChainCache) Close() error {
+	var errs []error
+	for _, backend := range c.backends {
+		if closer, ok := backend.cache.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", backend.name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyCache wraps a cache, failing all operations while down is set
+type flakyCache struct {
+	GenericCache
+	down bool
+}
+
+var errDown = errors.New("backend down")
+
+func (f *flakyCache) Get(key string) ([]byte, error) {
+	if f.down {
+		return nil, errDown
+	}
+	return f.GenericCache.Get(key)
+}
+
+func (f *flakyCache) Set(key string, value []byte) error {
+	if f.down {
+		return errDown
+	}
+	return f.GenericCache.Set(key, value)
+}
+
+func TestChainReadFallThrough(t *testing.T) {
+	first := NewGenericMemory(MemoryOptions{})
+	second := NewGenericDisk(t.TempDir(), 0)
+	chain := NewChain([]NamedCache{{"memory", first}, {"disk", second}}, time.Minute)
+	if err := chain.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	if err := second.Set("a.bin", []byte("a")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	data, err := chain.Get("a.bin")
+	if err != nil || string(data) != "a" {
+		t.Fatalf("Get() = %q, %v, want a from the second backend", data, err)
+	}
+	// The first backend was filled on the way back
+	if data, _ := first.Get("a.bin"); string(data) != "a" {
+		t.Errorf("first backend was not filled, got %q", data)
+	}
+
+	if err := chain.Set("b.bin", []byte("b")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	for _, backend := range []GenericCache{first, second} {
+		if data, _ := backend.Get("b.bin"); string(data) != "b" {
+			t.Errorf("Set() did not write to all backends")
+		}
+	}
+}
+
+// Backfilled entries keep their storage time, and stale entries are not backfilled
+func TestChainBackfillKeepsStorageTime(t *testing.T) {
+	first := NewGenericMemory(MemoryOptions{TTL: time.Hour})
+	second := NewGenericDiskWithOptions(t.TempDir(), DiskOptions{TTL: time.Hour, StaleTTL: time.Hour})
+	chain := NewChain([]NamedCache{{"memory", first}, {"disk", second}}, time.Minute)
+	if err := chain.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	store := func(key string, age time.Duration) {
+		if err := second.Set(key, []byte(key)); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+		if err := second.(ModTimeSetter).SetModTime(key, time.Now().Add(-age)); err != nil {
+			t.Fatalf("SetModTime() error = %v", err)
+		}
+	}
+
+	store("fresh.bin", 30*time.Minute)
+	if data, err := chain.Get("fresh.bin"); err != nil || string(data) != "fresh.bin" {
+		t.Fatalf("Get() = %q, %v", data, err)
+	}
+	_ = first.Walk("fresh.bin", func(info EntryInfo) error {
+		if age := time.Since(info.ModTime); age < 29*time.Minute {
+			t.Errorf("backfilled entry is %v old, want the age of the original entry", age)
+		}
+		return nil
+	})
+
+	store("stale.bin", 90*time.Minute)
+	if data, err := chain.GetStale("stale.bin"); err != nil || string(data) != "stale.bin" {
+		t.Fatalf("GetStale() = %q, %v", data, err)
+	}
+	if data, _ := first.GetStale("stale.bin"); data != nil {
+		t.Errorf("stale entry was backfilled")
+	}
+	if data, _ := chain.Get("stale.bin"); data != nil {
+		t.Errorf("Get() of a stale entry = %q, want a miss", data)
+	}
+}
+
+func TestChainFailover(t *testing.T) {
+	flaky := &flakyCache{GenericCache: NewGenericMemory(MemoryOptions{})}
+	disk := NewGenericDisk(t.TempDir(), 0)
+	chain := NewChain([]NamedCache{{"flaky", flaky}, {"disk", disk}}, 100*time.Millisecond)
+	if err := chain.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	flaky.down = true
+	if err := chain.Set("a.bin", []byte("a")); err != nil {
+		t.Fatalf("Set() should succeed with a backend down, got %v", err)
+	}
+	if data, err := chain.Get("a.bin"); err != nil || string(data) != "a" {
+		t.Fatalf("Get() = %q, %v, want a from the healthy backend", data, err)
+	}
+
+	// Skipped backend does not see writes until the retry interval elapsed
+	flaky.down = false
+	if err := chain.Set("b.bin", []byte("b")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if data, _ := flaky.Get("b.bin"); data != nil {
+		t.Errorf("unavailable backend should be skipped")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if err := chain.Set("c.bin", []byte("c")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if data, _ := flaky.Get("c.bin"); string(data) != "c" {
+		t.Errorf("recovered backend should be used again")
+	}
+
+	// All backends down
+	flaky.down = true
+	chain = NewChain([]NamedCache{{"flaky", flaky}}, time.Minute)
+	if err := chain.Set("d.bin", []byte("d")); err == nil {
+		t.Errorf("Set() should fail when all backends fail")
+	}
+}
@@ -2,8 +2,10 @@ package cache
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -13,25 +15,73 @@ import (
 type DiskCache struct {
 	cacheDir string
 	ttl      time.Duration
+	staleTTL time.Duration
+	// whether the directory is on a network filesystem, possibly shared between machines
+	networkFS bool
+	// keys of the files of the hashed layout. nil with the mirror layout
+	index *hashedIndex
+}
+
+// DiskOptions configures a disk cache
+type DiskOptions struct {
+	// Time after which entries expire. 0 means infinity
+	TTL time.Duration
+	// Time expired entries are kept to be served stale, before being removed
+	StaleTTL time.Duration
+	// Tune for a directory on a network filesystem (NFS, SMB) shared between machines:
+	// writes synced before being renamed in place, retries on stale file handles and eviction lock file taken over when abandoned
+	NetworkFS bool
+	// File layout: LayoutMirror (default) or LayoutHashed, for directories holding millions of entries
+	Layout string
 }
 
 // NewGenericDisk creates a new disk cache
 func NewGenericDisk(cacheDir string, ttl time.Duration) GenericCache {
-	return &DiskCache{
-		cacheDir: cacheDir,
-		ttl:      ttl,
+	return NewGenericDiskWithOptions(cacheDir, DiskOptions{TTL: ttl})
+}
+
+// NewGenericDiskWithOptions creates a new disk cache with the given options
+func NewGenericDiskWithOptions(cacheDir string, opts DiskOptions) GenericCache {
+	d := &DiskCache{
+		cacheDir:  cacheDir,
+		ttl:       opts.TTL,
+		staleTTL:  opts.StaleTTL,
+		networkFS: opts.NetworkFS,
+	}
+	if opts.Layout == LayoutHashed {
+		d.index = &hashedIndex{path: filepath.Join(cacheDir, hashedIndexFile)}
 	}
+	return d
+}
+
+// checkKey returns an error if a key cannot be the key of an entry: empty, or leading outside of the cache directory
+func checkKey(cacheKey string) error {
+	if cacheKey == "" {
+		return fmt.Errorf("cache path cannot be empty")
+	}
+	if !filepath.IsLocal(cacheKey) {
+		return fmt.Errorf("cache path '%s' is not inside the cache directory", cacheKey)
+	}
+	return nil
+}
+
+// file returns the file of an entry, relative to the cache directory
+func (d *DiskCache) file(cacheKey string) string {
+	if d.index != nil {
+		return hashedPath(cacheKey)
+	}
+	return cacheKey
 }
 
 func (d *DiskCache) Get(cacheKey string) ([]byte, error) {
 	logrus.Debugf("DiskCache::Get(file=%s)", cacheKey)
-	if cacheKey == "" {
-		return nil, fmt.Errorf("cache path cannot be empty")
+	if err := checkKey(cacheKey); err != nil {
+		return nil, err
 	}
-	fullPath := filepath.Join(d.cacheDir, cacheKey)
+	fullPath := filepath.Join(d.cacheDir, d.file(cacheKey))
 
 	// Check if cache file exists and is not expired
-	info, err := os.Stat(fullPath)
+	info, err := d.stat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// Cache file does not exist: this is a cache miss, not an error
@@ -44,9 +94,13 @@ func (d *DiskCache) Get(cacheKey string) ([]byte, error) {
 
 	// check TTL (0 means infinity)
 	if d.ttl != 0 && time.Since(info.ModTime()) > d.ttl {
+		if time.Since(info.ModTime()) <= d.ttl+d.staleTTL {
+			logrus.Debugf("Cache expired for %s (ttl was %s), keeping it for stale serving", cacheKey, d.ttl)
+			return nil, nil
+		}
 		logrus.Debugf("Cache expired for %s (ttl was %s), removing", cacheKey, d.ttl)
 		// Cache expired, remove it
-		if err := os.Remove(fullPath); err != nil {
+		if err := d.remove(cacheKey); err != nil {
 			// Do not return error because removing an expired cache file is not critical for Get()
 			logrus.Warnf("Failed to remove expired cache file %s: %v", fullPath, err)
 		}
@@ -54,7 +108,11 @@ func (d *DiskCache) Get(cacheKey string) ([]byte, error) {
 	}
 
 	// Read cached response
-	data, err := os.ReadFile(fullPath)
+	data, err := d.readFile(fullPath)
+	if data == nil && err == nil {
+		logrus.Debugf("DiskCache::Get(file=%s): Removed meanwhile", cacheKey)
+		return nil, nil
+	}
 	if err != nil {
 		logrus.Debugf("DiskCache::Get(file=%s): Failed to read cache file: %v", cacheKey, err)
 		return nil, fmt.Errorf("failed to read cache file '%s': %w", fullPath, err)
@@ -64,33 +122,192 @@ func (d *DiskCache) Get(cacheKey string) ([]byte, error) {
 	return data, nil
 }
 
+func (d *DiskCache) GetStale(cacheKey string) ([]byte, error) {
+	logrus.Debugf("DiskCache::GetStale(file=%s)", cacheKey)
+	if err := checkKey(cacheKey); err != nil {
+		return nil, err
+	}
+	fullPath := filepath.Join(d.cacheDir, d.file(cacheKey))
+
+	info, err := d.stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cache file stat error for %s: %w", fullPath, err)
+	}
+
+	// Entries past the stale TTL are only waiting to be removed
+	if d.ttl != 0 && time.Since(info.ModTime()) > d.ttl+d.staleTTL {
+		return nil, nil
+	}
+
+	data, err := d.readFile(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache file '%s': %w", fullPath, err)
+	}
+	return data, nil
+}
+
 // Set stores a response in the cache
 func (d *DiskCache) Set(cacheKey string, data []byte) error {
 	logrus.Debugf("DiskCache::Set(file=%s)", cacheKey)
-	if cacheKey == "" {
-		return fmt.Errorf("cache path cannot be empty")
+	if err := checkKey(cacheKey); err != nil {
+		return err
 	}
 
 	// Ensure directory exists
-	fullpath := filepath.Join(d.cacheDir, cacheKey)
+	fullpath := filepath.Join(d.cacheDir, d.file(cacheKey))
 	dir := filepath.Dir(fullpath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
-	// Write to cache
-	if err := os.WriteFile(fullpath, data, 0644); err != nil {
+	// Write to cache, atomically as other processes may be reading the entry
+	write := func() error { return writeFileAtomic(fullpath, data, d.networkFS) }
+	var err error
+	if d.networkFS {
+		err = retryStale(write)
+	} else {
+		err = write()
+	}
+	if err != nil {
 		return fmt.Errorf("failed to write cache file: %w", err)
 	}
+	if d.index != nil {
+		if err := d.index.add(d.file(cacheKey), cacheKey); err != nil {
+			return err
+		}
+	}
 
 	logrus.Debugf("DiskCache::Set(file=%s): Ok", cacheKey)
 	return nil
 }
 
-// Init ensures the cache directory exists
+// Delete removes an entry from the cache
+func (d *DiskCache) Delete(cacheKey string) error {
+	logrus.Debugf("DiskCache::Delete(file=%s)", cacheKey)
+	if err := checkKey(cacheKey); err != nil {
+		return err
+	}
+
+	return d.remove(cacheKey)
+}
+
+// SetModTime sets the modification time of the file of an entry
+func (d *DiskCache) SetModTime(cacheKey string, modTime time.Time) error {
+	if err := checkKey(cacheKey); err != nil {
+		return err
+	}
+	fullPath := filepath.Join(d.cacheDir, d.file(cacheKey))
+	if err := os.Chtimes(fullPath, modTime, modTime); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to set modification time of cache file '%s': %w", fullPath, err)
+	}
+	return nil
+}
+
+// remove removes the file of an entry. Removing a missing entry is not an error
+func (d *DiskCache) remove(cacheKey string) error {
+	fullPath := filepath.Join(d.cacheDir, d.file(cacheKey))
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cache file '%s': %w", fullPath, err)
+	}
+	if d.index != nil {
+		return d.index.remove(d.file(cacheKey))
+	}
+	return nil
+}
+
+// Walk calls fn for each entry whose key starts with prefix. The prefix cannot lead outside of the cache directory
+func (d *DiskCache) Walk(prefix string, fn func(info EntryInfo) error) error {
+	if prefix != "" && !filepath.IsLocal(prefix) {
+		return fmt.Errorf("cache prefix '%s' is not inside the cache directory", prefix)
+	}
+	if d.index != nil {
+		return d.walkHashed(prefix, fn)
+	}
+	// Only walk the directory that can contain the prefix
+	root := d.cacheDir
+	if dir := filepath.Dir(prefix); dir != "." {
+		root = filepath.Join(d.cacheDir, dir)
+	}
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || isInternalFile(entry.Name()) {
+			return nil
+		}
+
+		key, err := filepath.Rel(d.cacheDir, path)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Removed while walking
+				return nil
+			}
+			return err
+		}
+		return fn(EntryInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk cache directory: %w", err)
+	}
+	return nil
+}
+
+// stat returns the file info of a cache file, retrying on stale file handles on network filesystems
+func (d *DiskCache) stat(path string) (os.FileInfo, error) {
+	if !d.networkFS {
+		return os.Stat(path)
+	}
+	var info os.FileInfo
+	err := retryStale(func() error {
+		var err error
+		info, err = os.Stat(path)
+		return err
+	})
+	return info, err
+}
+
+// readFile reads a cache file. Returns nil, nil if it was removed since it was looked up (e.g. by another process)
+func (d *DiskCache) readFile(path string) ([]byte, error) {
+	var data []byte
+	read := func() error {
+		var err error
+		data, err = os.ReadFile(path)
+		return err
+	}
+	var err error
+	if d.networkFS {
+		err = retryStale(read)
+	} else {
+		err = read()
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Init ensures the cache directory exists, and loads the index of the hashed layout
 func (d *DiskCache) Init() error {
 	if err := os.MkdirAll(d.cacheDir, 0755); err != nil {
 		return fmt.Errorf("failed to create cache directory: %w", err)
 	}
+	if d.index != nil {
+		return d.index.load()
+	}
 	return nil
 }
@@ -0,0 +1,232 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Layouts of the files of disk caches
+const (
+	// files mirror the keys, which mirror URLs (host/path/GET.bin)
+	LayoutMirror = "mirror"
+	// files are named after the hash of their key, sharded in aa/bb/ directories. Their keys are kept in an index file
+	LayoutHashed = "hashed"
+)
+
+// Name of the index file of the hashed layout, in the cache directory.
+// Each line maps a file to its key ("aa/bb/<hash>\t<key>"), or records its removal ("aa/bb/<hash>\t")
+const hashedIndexFile = ".index"
+
+// hashedPath returns the file of a key in the hashed layout, relative to the cache directory
+func hashedPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+	return filepath.Join(hash[0:2], hash[2:4], hash)
+}
+
+// hashedIndex maps the files of the hashed layout to their keys. It is loaded from the index file,
+// appended to when entries are added or removed, and compacted on load.
+// Processes sharing the directory append under a shared lock of the index lock file, and compact it under an exclusive one
+type hashedIndex struct {
+	path string
+
+	mu sync.Mutex
+	// keys by file path relative to the cache directory
+	keys map[string]string
+	// index file already read, and its size read. Other processes sharing the directory may append after it, or replace it when compacting
+	file   os.FileInfo
+	offset int64
+}
+
+// lockPath returns the path of the lock file of the index
+func (x *hashedIndex) lockPath() string {
+	return x.path + ".lock"
+}
+
+// load reads the index file, and rewrites it without the lines of removed or replaced entries,
+// unless another process is writing it
+func (x *hashedIndex) load() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.keys = make(map[string]string)
+	x.file = nil
+	x.offset = 0
+	lines, err := x.sync()
+	if err != nil {
+		return err
+	}
+	if lines <= len(x.keys) {
+		return nil
+	}
+
+	unlock, ok, err := lockFile(x.lockPath(), true, false)
+	if err != nil {
+		return fmt.Errorf("failed to lock cache index: %w", err)
+	}
+	if !ok {
+		return nil
+	}
+	defer unlock()
+	// Lines appended since read
+	if _, err := x.sync(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for file, key := range x.keys {
+		fmt.Fprintf(&buf, "%s\t%s\n", file, key)
+	}
+	if err := writeFileAtomic(x.path, buf.Bytes(), true); err != nil {
+		return fmt.Errorf("failed to compact cache index: %w", err)
+	}
+	if x.file, err = os.Stat(x.path); err != nil {
+		return fmt.Errorf("failed to compact cache index: %w", err)
+	}
+	x.offset = int64(buf.Len())
+	return nil
+}
+
+// sync reads the lines appended to the index file since the last read. Returns the number of lines read. Must be called with mu held
+func (x *hashedIndex) sync() (int, error) {
+	f, err := os.Open(x.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open cache index: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cache index: %w", err)
+	}
+	// Compacted by another process: read it again
+	if x.file != nil && !os.SameFile(x.file, info) {
+		x.keys = make(map[string]string)
+		x.offset = 0
+	}
+	x.file = info
+	if _, err := f.Seek(x.offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to read cache index: %w", err)
+	}
+
+	lines := 0
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		// A line without newline is still being written
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return lines, fmt.Errorf("failed to read cache index: %w", err)
+		}
+		x.offset += int64(len(line))
+		lines++
+		file, key, _ := strings.Cut(strings.TrimSuffix(line, "\n"), "\t")
+		if key == "" {
+			delete(x.keys, file)
+		} else {
+			x.keys[file] = key
+		}
+	}
+}
+
+// append records the key of a file, or its removal if key is empty. Must be called with mu held
+func (x *hashedIndex) append(file string, key string) error {
+	// Not while another process compacts the index, which would drop the line
+	unlock, _, err := lockFile(x.lockPath(), false, true)
+	if err != nil {
+		return fmt.Errorf("failed to lock cache index: %w", err)
+	}
+	defer unlock()
+	f, err := os.OpenFile(x.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open cache index: %w", err)
+	}
+	_, err = fmt.Fprintf(f, "%s\t%s\n", file, key)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write cache index: %w", err)
+	}
+	return nil
+}
+
+// add records the key of a stored file, unless already known
+func (x *hashedIndex) add(file string, key string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, err := x.sync(); err != nil {
+		return err
+	}
+	if x.keys[file] == key {
+		return nil
+	}
+	x.keys[file] = key
+	return x.append(file, key)
+}
+
+// remove records the removal of a file
+func (x *hashedIndex) remove(file string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, err := x.sync(); err != nil {
+		return err
+	}
+	if _, ok := x.keys[file]; !ok {
+		return nil
+	}
+	delete(x.keys, file)
+	return x.append(file, "")
+}
+
+// entries returns the files whose key starts with prefix, in key order
+func (x *hashedIndex) entries(prefix string) ([]string, map[string]string, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, err := x.sync(); err != nil {
+		return nil, nil, err
+	}
+	files := []string{}
+	keys := map[string]string{}
+	for file, key := range x.keys {
+		if strings.HasPrefix(key, prefix) {
+			files = append(files, file)
+			keys[file] = key
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return keys[files[i]] < keys[files[j]] })
+	return files, keys, nil
+}
+
+// walkHashed calls fn for the indexed entries whose key starts with prefix, in key order
+func (d *DiskCache) walkHashed(prefix string, fn func(info EntryInfo) error) error {
+	files, keys, err := d.index.entries(prefix)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		info, err := d.stat(filepath.Join(d.cacheDir, file))
+		if os.IsNotExist(err) {
+			// Removed without the index knowing, e.g. by hand
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to walk cache directory: %w", err)
+		}
+		if err := fn(EntryInfo{Key: keys[file], Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
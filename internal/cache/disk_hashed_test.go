@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashedDiskLayout(t *testing.T) {
+	tempDir := t.TempDir()
+	cache := NewGenericDiskWithOptions(tempDir, DiskOptions{TTL: time.Hour, Layout: LayoutHashed})
+	if err := cache.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	keys := []string{"example.com/b/GET.bin", "example.com/a/GET.bin", "other.com/GET.bin"}
+	for _, key := range keys {
+		if err := cache.Set(key, []byte(key)); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	file := hashedPath("example.com/a/GET.bin")
+	if parts := strings.Split(file, string(filepath.Separator)); len(parts) != 3 || len(parts[0]) != 2 || len(parts[1]) != 2 || !strings.HasPrefix(parts[2], parts[0]+parts[1]) {
+		t.Errorf("hashedPath() = %s, want aa/bb/aabb...", file)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, file)); err != nil {
+		t.Errorf("entry file not found: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "example.com")); !os.IsNotExist(err) {
+		t.Errorf("URL directories should not be created, got: %v", err)
+	}
+	if data, err := cache.Get("example.com/a/GET.bin"); err != nil || string(data) != "example.com/a/GET.bin" {
+		t.Errorf("Get() = %q, %v", data, err)
+	}
+
+	walk := func(cache GenericCache, prefix string) []string {
+		found := []string{}
+		err := cache.Walk(prefix, func(info EntryInfo) error {
+			found = append(found, info.Key)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Walk() error = %v", err)
+		}
+		return found
+	}
+	if got := walk(cache, "example.com/"); strings.Join(got, ",") != "example.com/a/GET.bin,example.com/b/GET.bin" {
+		t.Errorf("Walk() = %v, want the example.com keys in order", got)
+	}
+
+	// Another process sharing the directory sees the entries, and the ones added later
+	other := NewGenericDiskWithOptions(tempDir, DiskOptions{TTL: time.Hour, Layout: LayoutHashed})
+	if err := other.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := cache.Delete("other.com/GET.bin"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := cache.Set("example.com/c/GET.bin", []byte("c")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got := walk(other, ""); strings.Join(got, ",") != "example.com/a/GET.bin,example.com/b/GET.bin,example.com/c/GET.bin" {
+		t.Errorf("Walk() from another process = %v", got)
+	}
+
+	// Loading the index drops the lines of removed entries
+	if err := NewGenericDiskWithOptions(tempDir, DiskOptions{Layout: LayoutHashed}).Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	index, err := os.ReadFile(filepath.Join(tempDir, hashedIndexFile))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if lines := strings.Count(string(index), "\n"); lines != 3 || !strings.Contains(string(index), file+"\texample.com/a/GET.bin\n") {
+		t.Errorf("compacted index = %q, want the 3 remaining entries", index)
+	}
+
+	// The processes which read the index before it was compacted read it again
+	if err := cache.Set("example.com/d/GET.bin", []byte("d")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if got := walk(other, ""); strings.Join(got, ",") != "example.com/a/GET.bin,example.com/b/GET.bin,example.com/c/GET.bin,example.com/d/GET.bin" {
+		t.Errorf("Walk() after compaction by another process = %v", got)
+	}
+
+	// Compaction is left to the process holding the index lock
+	if err := cache.Delete("example.com/d/GET.bin"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	unlock, ok, err := lockFile(filepath.Join(tempDir, hashedIndexFile+".lock"), false, false)
+	if err != nil || !ok {
+		t.Fatalf("lockFile() = %v, %v", ok, err)
+	}
+	if err := NewGenericDiskWithOptions(tempDir, DiskOptions{Layout: LayoutHashed}).Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	unlock()
+	index, err = os.ReadFile(filepath.Join(tempDir, hashedIndexFile))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if lines := strings.Count(string(index), "\n"); lines != 5 {
+		t.Errorf("index = %q, want it not compacted while locked", index)
+	}
+}
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Attempts of an operation failing with a stale NFS file handle
+const staleHandleAttempts = 3
+
+// Name of the lock file held while evicting entries, in the cache directory
+const evictionLockFile = ".eviction.lock"
+
+// Age after which an eviction lock is considered abandoned (e.g. its holder crashed)
+const evictionLockTimeout = time.Minute
+
+// EvictionLocker is implemented by caches shared between processes, which must not evict entries concurrently
+type EvictionLocker interface {
+	// LockEviction acquires the eviction lock. false if another process holds it
+	LockEviction() (unlock func(), ok bool)
+}
+
+// retryStale runs fn again when it fails with a stale file handle, which network filesystems return
+// when a file was replaced by another client since it was looked up
+func retryStale(fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= staleHandleAttempts; attempt++ {
+		if err = fn(); err == nil || !errors.Is(err, syscall.ESTALE) {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * 10 * time.Millisecond)
+	}
+	return err
+}
+
+// writeFileAtomic writes a file through an exclusively created temporary file renamed over it,
+// so concurrent readers (possibly in other processes or on other machines) never see a partially written file.
+// With sync, the data is flushed to storage before the file is renamed
+func writeFileAtomic(path string, data []byte, sync bool) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	// On network filesystems, data must reach the server before the file becomes visible under its name
+	if sync {
+		if err := tmp.Sync(); err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// isInternalFile reports whether a file of the cache directory is not an entry:
+// temporary and lock files, or files renamed by NFS clients while open (.nfsXXXX)
+func isInternalFile(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// LockEviction acquires the eviction lock file, so processes sharing the cache directory do not evict entries concurrently.
+// On network filesystems, where file locks are unreliable, the lock is the existence of the file, and abandoned locks are taken over
+func (d *DiskCache) LockEviction() (func(), bool) {
+	path := filepath.Join(d.cacheDir, evictionLockFile)
+	if !d.networkFS {
+		unlock, ok, err := lockFile(path, true, false)
+		if err != nil {
+			logrus.Warnf("Failed to acquire eviction lock %s: %v", path, err)
+		}
+		return unlock, ok
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			hostname, _ := os.Hostname()
+			_, _ = fmt.Fprintf(file, "%s %d\n", hostname, os.Getpid())
+			_ = file.Close()
+			return func() {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					logrus.Warnf("Failed to release eviction lock %s: %v", path, err)
+				}
+			}, true
+		}
+		if !os.IsExist(err) {
+			logrus.Warnf("Failed to acquire eviction lock %s: %v", path, err)
+			return nil, false
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || time.Since(info.ModTime()) < evictionLockTimeout {
+			return nil, false
+		}
+		logrus.Warnf("Taking over abandoned eviction lock %s", path)
+		_ = os.Remove(path)
+	}
+	return nil, false
+}
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNetworkDiskSetLeavesNoTempFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	cache := NewGenericDiskWithOptions(tempDir, DiskOptions{TTL: time.Hour, NetworkFS: true})
+
+	for _, data := range []string{"first", "second"} {
+		if err := cache.Set(filepath.Join("a", "entry.bin"), []byte(data)); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	files, err := os.ReadDir(filepath.Join(tempDir, "a"))
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(files) != 1 || files[0].Name() != "entry.bin" {
+		t.Errorf("cache folder contains %v, want only entry.bin", files)
+	}
+	data, err := cache.Get(filepath.Join("a", "entry.bin"))
+	if err != nil || string(data) != "second" {
+		t.Errorf("Get() = %q, %v, want %q", data, err, "second")
+	}
+}
+
+func TestDiskWalkSkipsInternalFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	cache := NewGenericDiskWithOptions(tempDir, DiskOptions{TTL: time.Hour, NetworkFS: true})
+	if err := cache.Set("entry.bin", []byte("data")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	for _, name := range []string{evictionLockFile, ".entry.bin.123.tmp", ".nfs000001"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := []string{}
+	err := cache.Walk("", func(info EntryInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "entry.bin" {
+		t.Errorf("Walk() keys = %v, want [entry.bin]", keys)
+	}
+}
+
+func TestDiskEvictionLock(t *testing.T) {
+	tempDir := t.TempDir()
+	first := NewGenericDiskWithOptions(tempDir, DiskOptions{NetworkFS: true}).(EvictionLocker)
+	second := NewGenericDiskWithOptions(tempDir, DiskOptions{NetworkFS: true}).(EvictionLocker)
+
+	unlock, ok := first.LockEviction()
+	if !ok {
+		t.Fatal("LockEviction() failed on a free lock")
+	}
+	if _, ok := second.LockEviction(); ok {
+		t.Fatal("LockEviction() succeeded while the lock is held")
+	}
+	unlock()
+	unlock, ok = second.LockEviction()
+	if !ok {
+		t.Fatal("LockEviction() failed after the lock was released")
+	}
+
+	// A lock whose holder crashed is taken over
+	old := time.Now().Add(-2 * evictionLockTimeout)
+	if err := os.Chtimes(filepath.Join(tempDir, evictionLockFile), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := first.LockEviction(); !ok {
+		t.Error("LockEviction() did not take over an abandoned lock")
+	}
+	unlock()
+}
+
+// Without network_fs, the eviction lock is a file lock, released by closing the file (or exiting)
+func TestLocalDiskEvictionLock(t *testing.T) {
+	tempDir := t.TempDir()
+	first := NewGenericDiskWithOptions(tempDir, DiskOptions{}).(EvictionLocker)
+	second := NewGenericDiskWithOptions(tempDir, DiskOptions{}).(EvictionLocker)
+
+	unlock, ok := first.LockEviction()
+	if !ok {
+		t.Fatal("LockEviction() failed on a free lock")
+	}
+	if _, ok := second.LockEviction(); ok {
+		t.Fatal("LockEviction() succeeded while the lock is held")
+	}
+	unlock()
+	unlock, ok = second.LockEviction()
+	if !ok {
+		t.Fatal("LockEviction() failed after the lock was released")
+	}
+	unlock()
+}
@@ -0,0 +1,308 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EvictionPolicy chooses which entries to evict when the cache is full.
+// Implementations do not need to be safe for concurrent use
+type EvictionPolicy interface {
+	// called when an entry is stored or replaced
+	OnSet(key string, size int64)
+	// called when an entry is read
+	OnGet(key string)
+	// called when an entry is removed other than by eviction
+	OnDelete(key string)
+	// removes the next entry to evict from the policy and returns it. false if the policy tracks no entry
+	Evict() (string, bool)
+}
+
+// Names of the available eviction policies
+const (
+	EvictionLRU  = "lru"
+	EvictionLFU  = "lfu"
+	EvictionGDSF = "gdsf"
+	EvictionTTL  = "ttl"
+)
+
+// NewEvictionPolicy creates an eviction policy by name, LRU if empty.
+// ttl is the cache TTL, used by the TTL-first policy
+func NewEvictionPolicy(name string, ttl time.Duration) (EvictionPolicy, error) {
+	switch name {
+	case "", EvictionLRU:
+		return newLRUPolicy(), nil
+	case EvictionLFU:
+		return newLFUPolicy(), nil
+	case EvictionGDSF:
+		return newGDSFPolicy(), nil
+	case EvictionTTL:
+		return newTTLPolicy(ttl), nil
+	default:
+		return nil, fmt.Errorf("unknown eviction policy '%s' (expected %s, %s, %s or %s)", name, EvictionLRU, EvictionLFU, EvictionGDSF, EvictionTTL)
+	}
+}
+
+// Minimum time between two rescans of the wrapped cache, and delay before evicting again when another process holds the eviction lock
+var (
+	evictionRescanInterval = time.Minute
+	evictionRetryDelay     = time.Second
+)
+
+// EvictionLimits are the limits kept by an EvictingCache. 0 means unlimited
+type EvictionLimits struct {
+	// Maximum total size of the entries, in bytes
+	MaxSize int64
+	// Maximum number of entries, the oldest written being evicted first
+	MaxEntries int
+}
+
+// EvictingCache wraps a cache to keep its total size under a limit, evicting entries chosen by a policy,
+// and optionally its number of entries, evicting the oldest ones
+type EvictingCache struct {
+	inner  GenericCache
+	limits EvictionLimits
+	policy EvictionPolicy
+	// tracks entries by write order. nil without MaxEntries
+	oldest EvictionPolicy
+
+	mu    sync.Mutex
+	sizes map[string]int64
+	size  int64
+	// last time the entries of the wrapped cache were listed, which other processes may change
+	scanned time.Time
+	// pending eviction, while another process holds the eviction lock. nil if none
+	retry  *time.Timer
+	closed bool
+}
+
+// NewEvicting wraps a cache to keep its total size under maxSize bytes
+func NewEvicting(inner GenericCache, maxSize int64, policy EvictionPolicy) GenericCache {
+	return NewEvictingWithLimits(inner, EvictionLimits{MaxSize: maxSize}, policy)
+}
+
+// NewEvictingWithLimits wraps a cache to keep it under the given limits
+func NewEvictingWithLimits(inner GenericCache, limits EvictionLimits, policy EvictionPolicy) GenericCache {
+	e := &EvictingCache{
+		inner:  inner,
+		limits: limits,
+		policy: policy,
+		sizes:  make(map[string]int64),
+	}
+	if limits.MaxEntries > 0 {
+		// Without TTL, it orders entries by write time
+		e.oldest = newTTLPolicy(0)
+	}
+	return e
+}
+
+func (e *EvictingCache) Get(key string) ([]byte, error) {
+	data, err := e.inner.Get(key)
+	if err == nil && data != nil {
+		e.mu.Lock()
+		if _, ok := e.sizes[key]; ok {
+			e.policy.OnGet(key)
+		}
+		e.mu.Unlock()
+	}
+	return data, err
+}
+
+func (e *EvictingCache) GetStale(key string) ([]byte, error) {
+	return e.inner.GetStale(key)
+}
+
+func (e *EvictingCache) Set(key string, value []byte) error {
+	if err := e.inner.Set(key, value); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.track(key, int64(len(value)))
+	return e.evict()
+}
+
+func (e *EvictingCache) Delete(key string) error {
+	if err := e.inner.Delete(key); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if size, ok := e.sizes[key]; ok {
+		e.size -= size
+		delete(e.sizes, key)
+		e.policy.OnDelete(key)
+		if e.oldest != nil {
+			e.oldest.OnDelete(key)
+		}
+	}
+	return nil
+}
+
+// SetModTime sets the storage time of an entry, if the wrapped cache supports it
+func (e *EvictingCache) SetModTime(key string, modTime time.Time) error {
+	if setter, ok := e.inner.(ModTimeSetter); ok {
+		return setter.SetModTime(key, modTime)
+	}
+	return nil
+}
+
+func (e *EvictingCache) Walk(prefix string, fn func(info EntryInfo) error) error {
+	return e.inner.Walk(prefix, fn)
+}
+
+// Init initializes the wrapped cache and tracks its existing entries, oldest first
+func (e *EvictingCache) Init() error {
+	if err := e.inner.Init(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.rescan(); err != nil {
+		return err
+	}
+	return e.evict()
+}
+
+// rescan tracks the entries of the wrapped cache again, oldest first, so entries added or removed by other processes
+// sharing it are accounted for. Must be called with mu held
+func (e *EvictingCache) rescan() error {
+	entries := []EntryInfo{}
+	err := e.inner.Walk("", func(info EntryInfo) error {
+		entries = append(entries, info)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list existing cache entries: %w", err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime.Before(entries[j].ModTime) })
+
+	present := make(map[string]bool, len(entries))
+	for _, info := range entries {
+		present[info.Key] = true
+		if size, ok := e.sizes[info.Key]; !ok || size != info.Size {
+			e.track(info.Key, info.Size)
+		}
+	}
+	for key, size := range e.sizes {
+		if !present[key] {
+			e.size -= size
+			delete(e.sizes, key)
+			e.policy.OnDelete(key)
+			if e.oldest != nil {
+				e.oldest.OnDelete(key)
+			}
+		}
+	}
+	e.scanned = time.Now()
+	return nil
+}
+
+// Close cancels a pending eviction, and closes the wrapped cache if it needs closing
+func (e *EvictingCache) Close() error {
+	e.mu.Lock()
+	e.closed = true
+	if e.retry != nil {
+		e.retry.Stop()
+		e.retry = nil
+	}
+	e.mu.Unlock()
+	if closer, ok := e.inner.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// track records the size of a stored entry. Must be called with mu held
+func (e *EvictingCache) track(key string, size int64) {
+	e.size += size - e.sizes[key]
+	e.sizes[key] = size
+	e.policy.OnSet(key, size)
+	if e.oldest != nil {
+		e.oldest.OnSet(key, size)
+	}
+}
+
+// full reports whether the cache exceeds its size limit, and whether it exceeds its entry count limit. Must be called with mu held
+func (e *EvictingCache) full() (bySize bool, byCount bool) {
+	return e.limits.MaxSize > 0 && e.size > e.limits.MaxSize, e.limits.MaxEntries > 0 && len(e.sizes) > e.limits.MaxEntries
+}
+
+// retryEvict evicts again after evictionRetryDelay, unless already planned. Must be called with mu held
+func (e *EvictingCache) retryEvict() {
+	if e.retry != nil || e.closed {
+		return
+	}
+	e.retry = time.AfterFunc(evictionRetryDelay, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.closed {
+			return
+		}
+		e.retry = nil
+		if err := e.evict(); err != nil {
+			logrus.Warnf("EvictingCache: %v", err)
+		}
+	})
+}
+
+// evict removes entries until the cache fits in its limits. Must be called with mu held
+func (e *EvictingCache) evict() error {
+	// The tracked size drifts when other processes add or remove entries
+	if time.Since(e.scanned) >= evictionRescanInterval {
+		if err := e.rescan(); err != nil {
+			return err
+		}
+	}
+	if bySize, byCount := e.full(); !bySize && !byCount {
+		return nil
+	}
+	// Another process sharing the cache is already evicting: try again later, as it may not evict enough for this one
+	if locker, ok := e.inner.(EvictionLocker); ok {
+		unlock, ok := locker.LockEviction()
+		if !ok {
+			logrus.Debugf("EvictingCache: eviction is locked by another process, retrying in %v", evictionRetryDelay)
+			e.retryEvict()
+			return nil
+		}
+		defer unlock()
+	}
+
+	for {
+		bySize, byCount := e.full()
+		var key string
+		var ok bool
+		switch {
+		case byCount:
+			key, ok = e.oldest.Evict()
+			if ok {
+				e.policy.OnDelete(key)
+				logrus.Debugf("EvictingCache: evicting %s (cache entries %d > %d)", key, len(e.sizes), e.limits.MaxEntries)
+			}
+		case bySize:
+			key, ok = e.policy.Evict()
+			if ok {
+				if e.oldest != nil {
+					e.oldest.OnDelete(key)
+				}
+				logrus.Debugf("EvictingCache: evicting %s (cache size %d > %d)", key, e.size, e.limits.MaxSize)
+			}
+		}
+		if !ok {
+			return nil
+		}
+		if err := e.inner.Delete(key); err != nil {
+			return fmt.Errorf("failed to evict cache entry: %w", err)
+		}
+		e.size -= e.sizes[key]
+		delete(e.sizes, key)
+	}
+}
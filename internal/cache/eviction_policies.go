@@ -0,0 +1,232 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"time"
+)
+
+// lruPolicy evicts the least recently used entry
+type lruPolicy struct {
+	order    *list.List // front is the most recently used
+	elements map[string]*list.Element
+}
+
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{order: list.New(), elements: make(map[string]*list.Element)}
+}
+
+func (p *lruPolicy) OnSet(key string, size int64) {
+	p.OnGet(key)
+}
+
+func (p *lruPolicy) OnGet(key string) {
+	if elem, ok := p.elements[key]; ok {
+		p.order.MoveToFront(elem)
+	} else {
+		p.elements[key] = p.order.PushFront(key)
+	}
+}
+
+func (p *lruPolicy) OnDelete(key string) {
+	if elem, ok := p.elements[key]; ok {
+		p.order.Remove(elem)
+		delete(p.elements, key)
+	}
+}
+
+func (p *lruPolicy) Evict() (string, bool) {
+	elem := p.order.Back()
+	if elem == nil {
+		return "", false
+	}
+	key := elem.Value.(string)
+	p.OnDelete(key)
+	return key, true
+}
+
+// policyItem is an entry tracked by a priority-based policy
+type policyItem struct {
+	key      string
+	size     int64
+	hits     int64
+	priority float64 // lowest is evicted first
+	seq      int64   // last access order, breaking priority ties (oldest is evicted first)
+	index    int
+}
+
+// priorityQueue is a min-heap of entries by priority, implementing heap.Interface
+type priorityQueue struct {
+	items []*policyItem
+	byKey map[string]*policyItem
+	seq   int64
+}
+
+func newPriorityQueue() *priorityQueue {
+	return &priorityQueue{byKey: make(map[string]*policyItem)}
+}
+
+func (q *priorityQueue) Len() int { return len(q.items) }
+func (q *priorityQueue) Less(i, j int) bool {
+	if q.items[i].priority != q.items[j].priority {
+		return q.items[i].priority < q.items[j].priority
+	}
+	return q.items[i].seq < q.items[j].seq
+}
+func (q *priorityQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+func (q *priorityQueue) Push(x any) {
+	item := x.(*policyItem)
+	item.index = len(q.items)
+	q.items = append(q.items, item)
+}
+func (q *priorityQueue) Pop() any {
+	item := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return item
+}
+
+// touch returns the item of key, adding it if needed, and marks it as the most recently accessed.
+// The caller must call fix after updating its priority
+func (q *priorityQueue) touch(key string) *policyItem {
+	q.seq++
+	item, ok := q.byKey[key]
+	if !ok {
+		item = &policyItem{key: key, index: -1}
+		q.byKey[key] = item
+	}
+	item.seq = q.seq
+	return item
+}
+
+func (q *priorityQueue) fix(item *policyItem) {
+	if item.index < 0 {
+		heap.Push(q, item)
+	} else {
+		heap.Fix(q, item.index)
+	}
+}
+
+func (q *priorityQueue) remove(key string) {
+	if item, ok := q.byKey[key]; ok {
+		heap.Remove(q, item.index)
+		delete(q.byKey, key)
+	}
+}
+
+func (q *priorityQueue) pop() (*policyItem, bool) {
+	if len(q.items) == 0 {
+		return nil, false
+	}
+	item := heap.Pop(q).(*policyItem)
+	delete(q.byKey, item.key)
+	return item, true
+}
+
+// lfuPolicy evicts the least frequently used entry, the least recently used first among them
+type lfuPolicy struct {
+	queue *priorityQueue
+}
+
+func newLFUPolicy() *lfuPolicy {
+	return &lfuPolicy{queue: newPriorityQueue()}
+}
+
+func (p *lfuPolicy) OnSet(key string, size int64) {
+	p.OnGet(key)
+}
+
+func (p *lfuPolicy) OnGet(key string) {
+	item := p.queue.touch(key)
+	item.hits++
+	item.priority = float64(item.hits)
+	p.queue.fix(item)
+}
+
+func (p *lfuPolicy) OnDelete(key string) {
+	p.queue.remove(key)
+}
+
+func (p *lfuPolicy) Evict() (string, bool) {
+	item, ok := p.queue.pop()
+	if !ok {
+		return "", false
+	}
+	return item.key, true
+}
+
+// gdsfPolicy implements Greedy-Dual-Size-Frequency: evicts entries with the lowest frequency/size,
+// aged by an inflation value so that entries popular in the past are eventually evicted.
+// Favors keeping many small popular entries over a few large ones
+type gdsfPolicy struct {
+	queue     *priorityQueue
+	inflation float64 // priority of the last evicted entry
+}
+
+func newGDSFPolicy() *gdsfPolicy {
+	return &gdsfPolicy{queue: newPriorityQueue()}
+}
+
+func (p *gdsfPolicy) OnSet(key string, size int64) {
+	item := p.queue.touch(key)
+	item.size = max(size, 1)
+	p.access(item)
+}
+
+func (p *gdsfPolicy) OnGet(key string) {
+	p.access(p.queue.touch(key))
+}
+
+func (p *gdsfPolicy) access(item *policyItem) {
+	item.hits++
+	item.priority = p.inflation + float64(item.hits)/float64(max(item.size, 1))
+	p.queue.fix(item)
+}
+
+func (p *gdsfPolicy) OnDelete(key string) {
+	p.queue.remove(key)
+}
+
+func (p *gdsfPolicy) Evict() (string, bool) {
+	item, ok := p.queue.pop()
+	if !ok {
+		return "", false
+	}
+	p.inflation = item.priority
+	return item.key, true
+}
+
+// ttlPolicy evicts the entries expiring first. Reads do not change the order
+type ttlPolicy struct {
+	queue *priorityQueue
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+func newTTLPolicy(ttl time.Duration) *ttlPolicy {
+	return &ttlPolicy{queue: newPriorityQueue(), ttl: ttl, now: time.Now}
+}
+
+func (p *ttlPolicy) OnSet(key string, size int64) {
+	item := p.queue.touch(key)
+	// With no TTL, entries never expire: evict the oldest written first
+	item.priority = float64(p.now().Add(p.ttl).UnixNano())
+	p.queue.fix(item)
+}
+
+func (p *ttlPolicy) OnGet(key string) {}
+
+func (p *ttlPolicy) OnDelete(key string) {
+	p.queue.remove(key)
+}
+
+func (p *ttlPolicy) Evict() (string, bool) {
+	item, ok := p.queue.pop()
+	if !ok {
+		return "", false
+	}
+	return item.key, true
+}
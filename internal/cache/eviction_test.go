@@ -0,0 +1,214 @@
+package cache
+
+import (
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// evictAll returns the keys in eviction order
+func evictAll(p EvictionPolicy) []string {
+	keys := []string{}
+	for {
+		key, ok := p.Evict()
+		if !ok {
+			return keys
+		}
+		keys = append(keys, key)
+	}
+}
+
+func TestEvictionPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		// a and c are read after being set, c twice
+		{EvictionLRU, []string{"b", "d", "a", "c"}},
+		{EvictionLFU, []string{"b", "d", "a", "c"}},
+		// d is large, so evicted first despite being read as much as b
+		{EvictionGDSF, []string{"d", "b", "a", "c"}},
+		{EvictionTTL, []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			p, err := NewEvictionPolicy(tt.policy, time.Hour)
+			if err != nil {
+				t.Fatalf("NewEvictionPolicy() error = %v", err)
+			}
+			p.OnSet("a", 10)
+			p.OnSet("b", 10)
+			p.OnSet("c", 10)
+			p.OnSet("d", 1000)
+			p.OnGet("a")
+			p.OnGet("c")
+			p.OnGet("c")
+
+			if got := evictAll(p); !slices.Equal(got, tt.want) {
+				t.Errorf("eviction order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvictionPolicyOnDelete(t *testing.T) {
+	for _, name := range []string{EvictionLRU, EvictionLFU, EvictionGDSF, EvictionTTL} {
+		p, _ := NewEvictionPolicy(name, 0)
+		p.OnSet("a", 1)
+		p.OnSet("b", 1)
+		p.OnDelete("a")
+		p.OnDelete("missing")
+		if got := evictAll(p); !slices.Equal(got, []string{"b"}) {
+			t.Errorf("%s: eviction after delete = %v, want [b]", name, got)
+		}
+	}
+
+	if _, err := NewEvictionPolicy("random", 0); err == nil {
+		t.Errorf("NewEvictionPolicy() should fail for unknown policies")
+	}
+}
+
+func TestEvictingCache(t *testing.T) {
+	tempDir := t.TempDir()
+	inner := NewGenericDisk(tempDir, 0)
+	if err := inner.Set("old.bin", make([]byte, 40)); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	cache := NewEvicting(inner, 100, newLRUPolicy())
+	if err := cache.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	for _, key := range []string{"a.bin", "b.bin"} {
+		if err := cache.Set(key, make([]byte, 30)); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	// Make old.bin recently used, so a.bin is evicted instead
+	if data, _ := cache.Get("old.bin"); data == nil {
+		t.Fatalf("Get() should find entries existing before Init()")
+	}
+	if err := cache.Set("c.bin", make([]byte, 30)); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	for key, want := range map[string]bool{"old.bin": true, "a.bin": false, "b.bin": true, "c.bin": true} {
+		data, err := cache.Get(key)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if (data != nil) != want {
+			t.Errorf("Get(%s) present = %v, want %v", key, data != nil, want)
+		}
+	}
+}
+
+func TestEvictingCacheMaxEntries(t *testing.T) {
+	inner := NewGenericDisk(t.TempDir(), 0)
+	cache := NewEvictingWithLimits(inner, EvictionLimits{MaxEntries: 2}, newLRUPolicy())
+	if err := cache.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	for _, key := range []string{"a.bin", "b.bin"} {
+		if err := cache.Set(key, make([]byte, 30)); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	// Reads do not protect a.bin: the oldest written entry is evicted, whatever the policy
+	if data, _ := cache.Get("a.bin"); data == nil {
+		t.Fatalf("Get() should find a.bin")
+	}
+	if err := cache.Set("c.bin", make([]byte, 30)); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	// Replacing an entry does not change the count
+	if err := cache.Set("c.bin", make([]byte, 10)); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	for key, want := range map[string]bool{"a.bin": false, "b.bin": true, "c.bin": true} {
+		data, err := cache.Get(key)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if (data != nil) != want {
+			t.Errorf("Get(%s) present = %v, want %v", key, data != nil, want)
+		}
+	}
+}
+
+func TestEvictingCacheRescan(t *testing.T) {
+	previous := evictionRescanInterval
+	t.Cleanup(func() { evictionRescanInterval = previous })
+	evictionRescanInterval = 0
+
+	inner := NewGenericDisk(t.TempDir(), 0)
+	cache := NewEvicting(inner, 100, newLRUPolicy())
+	if err := cache.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	for _, key := range []string{"a.bin", "b.bin"} {
+		if err := cache.Set(key, make([]byte, 40)); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	// Removed by another process: its size is no longer counted
+	if err := inner.Delete("a.bin"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := cache.Set("c.bin", make([]byte, 40)); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if data, _ := cache.Get("b.bin"); data == nil {
+		t.Errorf("b.bin was evicted, while the cache fits in its limit")
+	}
+}
+
+// lockedCache is a cache whose eviction lock is held by another process while locked is set
+type lockedCache struct {
+	GenericCache
+	locked atomic.Bool
+}
+
+func (c *lockedCache) LockEviction() (func(), bool) {
+	return func() {}, !c.locked.Load()
+}
+
+func TestEvictingCacheRetriesLockedEviction(t *testing.T) {
+	previous := evictionRetryDelay
+	t.Cleanup(func() { evictionRetryDelay = previous })
+	evictionRetryDelay = 10 * time.Millisecond
+
+	inner := &lockedCache{GenericCache: NewGenericDisk(t.TempDir(), 0)}
+	inner.locked.Store(true)
+	cache := NewEvicting(inner, 100, newLRUPolicy())
+	if err := cache.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	defer func() { _ = cache.(*EvictingCache).Close() }()
+	for _, key := range []string{"a.bin", "b.bin", "c.bin"} {
+		if err := cache.Set(key, make([]byte, 40)); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	if data, _ := inner.Get("a.bin"); data == nil {
+		t.Fatalf("a.bin was evicted while the eviction lock is held")
+	}
+
+	inner.locked.Store(false)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := inner.Get("a.bin")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if data == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("eviction was not retried once the lock was released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FallbackCache implements Cache interface over a primary cache, and a secondary one read when the primary misses
+// (e.g. a cache a team shares on a network filesystem). The secondary cache is read only: writes only go to the primary.
+// A failing secondary is logged and treated as a miss
+type FallbackCache struct {
+	primary   GenericCache
+	secondary GenericCache
+	// store entries found in the secondary cache in the primary one
+	writeBack bool
+}
+
+// NewFallback creates a cache reading secondary when primary misses
+func NewFallback(primary GenericCache, secondary GenericCache, writeBack bool) *FallbackCache {
+	return &FallbackCache{primary: primary, secondary: secondary, writeBack: writeBack}
+}
+
+func (f *FallbackCache) Get(key string) ([]byte, error) {
+	data, err := f.primary.Get(key)
+	if err != nil || data != nil {
+		return data, err
+	}
+	data, err = f.secondary.Get(key)
+	if err != nil {
+		logrus.Warnf("Secondary cache failed to get %s: %v", key, err)
+		return nil, nil
+	}
+	if data != nil && f.writeBack {
+		if err := f.primary.Set(key, data); err != nil {
+			logrus.Warnf("Failed to write back %s from the secondary cache: %v", key, err)
+		}
+	}
+	return data, nil
+}
+
+// GetStale falls back to the secondary cache, without writing back: expired entries would be fresh again once written
+func (f *FallbackCache) GetStale(key string) ([]byte, error) {
+	data, err := f.primary.GetStale(key)
+	if err != nil || data != nil {
+		return data, err
+	}
+	data, err = f.secondary.GetStale(key)
+	if err != nil {
+		logrus.Warnf("Secondary cache failed to get %s: %v", key, err)
+		return nil, nil
+	}
+	return data, nil
+}
+
+func (f *FallbackCache) Set(key string, value []byte) error {
+	return f.primary.Set(key, value)
+}
+
+// Delete removes the entry from the primary cache only
+func (f *FallbackCache) Delete(key string) error {
+	return f.primary.Delete(key)
+}
+
+func (f *FallbackCache) SetModTime(key string, modTime time.Time) error {
+	if setter, ok := f.primary.(ModTimeSetter); ok {
+		return setter.SetModTime(key, modTime)
+	}
+	return nil
+}
+
+// Walk walks the entries of the primary cache
+func (f *FallbackCache) Walk(prefix string, fn func(info EntryInfo) error) error {
+	return f.primary.Walk(prefix, fn)
+}
+
+// Init initializes both caches. A secondary cache failing to initialize is only logged, e.g. while its share is not mounted
+func (f *FallbackCache) Init() error {
+	if err := f.secondary.Init(); err != nil {
+		logrus.Warnf("Failed to initialize the secondary cache: %v", err)
+	}
+	return f.primary.Init()
+}
+
+// Close closes the caches needing it
+func (f *FallbackCache) Close() error {
+	var errs []error
+	for _, c := range []GenericCache{f.primary, f.secondary} {
+		if closer, ok := c.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
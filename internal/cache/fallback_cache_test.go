@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFallbackCache(t *testing.T) {
+	for _, writeBack := range []bool{true, false} {
+		primary := NewGenericMemory(MemoryOptions{})
+		secondary := NewGenericDisk(t.TempDir(), 0)
+		fallback := NewFallback(primary, secondary, writeBack)
+		if err := fallback.Init(); err != nil {
+			t.Fatalf("Init() error = %v", err)
+		}
+
+		if err := secondary.Set("a.bin", []byte("a")); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+		if data, err := fallback.Get("a.bin"); err != nil || string(data) != "a" {
+			t.Fatalf("Get() = %q, %v, want a from the secondary cache", data, err)
+		}
+		if data, _ := primary.Get("a.bin"); (data != nil) != writeBack {
+			t.Errorf("writeBack=%v: primary has %q after a secondary hit", writeBack, data)
+		}
+
+		if err := fallback.Set("b.bin", []byte("b")); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+		if data, _ := secondary.Get("b.bin"); data != nil {
+			t.Errorf("Set() wrote to the read only secondary cache")
+		}
+		if data, err := fallback.Get("missing.bin"); err != nil || data != nil {
+			t.Errorf("Get() = %q, %v, want a miss", data, err)
+		}
+	}
+}
+
+func TestFallbackCacheFailingSecondary(t *testing.T) {
+	secondary := &flakyCache{GenericCache: NewGenericMemory(MemoryOptions{}), down: true}
+	fallback := NewFallback(NewGenericDisk(t.TempDir(), time.Hour), secondary, true)
+	if err := fallback.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if data, err := fallback.Get("a.bin"); err != nil || data != nil {
+		t.Errorf("Get() = %q, %v, want a miss when the secondary cache fails", data, err)
+	}
+}
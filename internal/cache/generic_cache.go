@@ -1,13 +1,54 @@
 // Handles caching of HTTP responses
 package cache
 
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EntryInfo describes a stored entry
+type EntryInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
 // GenericCache interface for caching operations
 type GenericCache interface {
 	// retrieves cached response data if it exists and is not expired.
 	// returns nil, nil when not found or expired
 	Get(key string) ([]byte, error)
+	// retrieves cached response data even if it expired, as long as it is still kept for stale serving.
+	// returns nil, nil when not found
+	GetStale(key string) ([]byte, error)
 	// stores response data in the cache at the specified path
 	Set(key string, value []byte) error
+	// removes an entry. Removing a missing entry is not an error
+	Delete(key string) error
+	// calls fn for each stored entry whose key starts with prefix, including expired ones
+	Walk(prefix string, fn func(info EntryInfo) error) error
 	// initializes the cache (e.g., creates necessary directories)
 	Init() error
 }
+
+// ModTimeSetter is implemented by caches whose entries' storage time can be changed,
+// e.g. to rewrite entries without restarting their TTL
+type ModTimeSetter interface {
+	// sets the storage time of an entry. Missing entries are ignored
+	SetModTime(key string, modTime time.Time) error
+}
+
+// ValidKey reports whether key can be the key of an entry: a non-empty relative path staying inside the cache directory,
+// without hidden (dot-prefixed) segments, which are internal files
+func ValidKey(key string) bool {
+	if key == "" || !filepath.IsLocal(key) {
+		return false
+	}
+	for _, segment := range strings.Split(filepath.ToSlash(key), "/") {
+		if strings.HasPrefix(segment, ".") {
+			return false
+		}
+	}
+	return true
+}
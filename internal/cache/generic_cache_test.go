@@ -57,11 +57,12 @@ func TestGenericDiskGetExpired(t *testing.T) {
 	tempDir := t.TempDir()
 	cache := NewGenericDisk(tempDir, 100*time.Millisecond) // Very short TTL
 
-	cachePath := filepath.Join(tempDir, "expired.bin")
+	cacheKey := "expired.bin"
+	cachePath := filepath.Join(tempDir, cacheKey)
 	testData := []byte("test data")
 
 	// Set data
-	err := cache.Set(cachePath, testData)
+	err := cache.Set(cacheKey, testData)
 	if err != nil {
 		t.Fatalf("Set() error = %v", err)
 	}
@@ -70,7 +71,7 @@ func TestGenericDiskGetExpired(t *testing.T) {
 	time.Sleep(200 * time.Millisecond)
 
 	// Try to get expired data
-	data, err := cache.Get(cachePath)
+	data, err := cache.Get(cacheKey)
 	if err != nil {
 		t.Errorf("Get() error = %v", err)
 	}
@@ -100,3 +101,123 @@ func TestGenericDiskInit(t *testing.T) {
 		t.Fatalf("Cache directory was not created")
 	}
 }
+
+func TestGenericDiskGetStale(t *testing.T) {
+	tempDir := t.TempDir()
+	cache := NewGenericDiskWithOptions(tempDir, DiskOptions{TTL: 100 * time.Millisecond, StaleTTL: time.Hour})
+
+	testData := []byte("test data")
+	if err := cache.Set("stale.bin", testData); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	// Wait for expiration
+	time.Sleep(200 * time.Millisecond)
+
+	data, err := cache.Get("stale.bin")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if data != nil {
+		t.Errorf("Get() returned data for expired cache, want nil")
+	}
+
+	// Entry is still kept for stale serving
+	data, err = cache.GetStale("stale.bin")
+	if err != nil {
+		t.Fatalf("GetStale() error = %v", err)
+	}
+	if string(data) != string(testData) {
+		t.Errorf("GetStale() data = %s, want %s", string(data), string(testData))
+	}
+}
+
+func TestGenericMemorySnapshot(t *testing.T) {
+	snapshotPath := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	cache := NewGenericMemory(MemoryOptions{SnapshotPath: snapshotPath})
+	if err := cache.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := cache.Set(filepath.Join("example.com", "GET.bin"), []byte("kept")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := cache.Set("deleted.bin", []byte("deleted")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := cache.Delete("deleted.bin"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Simulate a restart
+	restarted := NewGenericMemory(MemoryOptions{SnapshotPath: snapshotPath})
+	if err := restarted.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	data, err := restarted.Get(filepath.Join("example.com", "GET.bin"))
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(data) != "kept" {
+		t.Errorf("Get() after restart = %q, want kept", string(data))
+	}
+	if data, _ := restarted.Get("deleted.bin"); data != nil {
+		t.Errorf("Get() after restart returned a deleted entry")
+	}
+}
+
+func TestGenericMemoryPeriodicSnapshot(t *testing.T) {
+	snapshotPath := filepath.Join(t.TempDir(), "cache.snapshot")
+	cache := NewGenericMemory(MemoryOptions{SnapshotPath: snapshotPath, SnapshotInterval: 50 * time.Millisecond})
+	if err := cache.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	defer func() { _ = cache.Close() }()
+
+	if err := cache.Set("a.bin", []byte("a")); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// Snapshot written without Close, e.g. before a crash
+	if _, err := os.Stat(snapshotPath); err != nil {
+		t.Errorf("periodic snapshot was not written: %v", err)
+	}
+}
+
+func TestGenericDiskKeysOutsideDirectory(t *testing.T) {
+	parent := t.TempDir()
+	cache := NewGenericDisk(filepath.Join(parent, "cache"), 0)
+	if err := cache.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	for _, key := range []string{"../escaped.bin", "a/../../escaped.bin", "/tmp/escaped.bin", ""} {
+		if err := cache.Set(key, []byte("data")); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", key)
+		}
+		if _, err := cache.Get(key); err == nil {
+			t.Errorf("Get(%q) succeeded, want an error", key)
+		}
+		if err := cache.Delete(key); err == nil {
+			t.Errorf("Delete(%q) succeeded, want an error", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escaped.bin")); !os.IsNotExist(err) {
+		t.Errorf("an entry was written outside of the cache directory")
+	}
+
+	if err := os.WriteFile(filepath.Join(parent, "outside.bin"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := cache.Walk("../outside", func(info EntryInfo) error {
+		t.Errorf("Walk() listed %s, outside of the cache directory", info.Key)
+		return nil
+	})
+	if err == nil {
+		t.Errorf("Walk() with a prefix outside of the cache directory succeeded, want an error")
+	}
+}
@@ -0,0 +1,113 @@
+package httpcache
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArchiveExtension is the file extension of cache archives
+const ArchiveExtension = ".tar.zst"
+
+// Export writes the entries whose key starts with prefix (including expired ones still kept) to w,
+// as a zstd-compressed tar archive with one file per entry. Returns the number of exported entries
+func (d *HTTPCache) Export(w io.Writer, prefix string) (int, error) {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create zstd writer: %w", err)
+	}
+	tw := tar.NewWriter(zw)
+
+	exported := 0
+	err = d.cache.Walk(prefix, func(info cache.EntryInfo) error {
+		data, err := d.cache.GetStale(info.Key)
+		if err != nil {
+			return fmt.Errorf("failed to read entry %s: %w", info.Key, err)
+		}
+		if data == nil {
+			return nil // removed in the meantime
+		}
+		header := &tar.Header{
+			Name:    filepath.ToSlash(info.Key),
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: info.ModTime,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+		exported++
+		return nil
+	})
+	if err != nil {
+		return exported, fmt.Errorf("failed to export cache: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return exported, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return exported, fmt.Errorf("failed to write archive: %w", err)
+	}
+	return exported, nil
+}
+
+// Import stores the entries of an archive written by Export, replacing existing entries with the same key.
+// Imported entries keep the storage time they had when exported, so their TTL goes on. Returns the number of imported entries
+func (d *HTTPCache) Import(r io.Reader) (int, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	imported := 0
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return imported, nil
+		}
+		if err != nil {
+			return imported, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		key, err := archiveKey(header.Name)
+		if err != nil {
+			return imported, err
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return imported, fmt.Errorf("failed to read archive entry %s: %w", header.Name, err)
+		}
+		if _, err := Deserialize(data); err != nil {
+			return imported, fmt.Errorf("invalid archive entry %s: %w", header.Name, err)
+		}
+		if err := d.SetRaw(key, data, header.ModTime); err != nil {
+			return imported, fmt.Errorf("failed to store entry %s: %w", key, err)
+		}
+		imported++
+	}
+}
+
+// archiveKey returns the cache key of an archive file, rejecting names escaping the cache (e.g. "../x")
+func archiveKey(name string) (string, error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid archive entry name '%s'", name)
+	}
+	return filepath.FromSlash(clean), nil
+}
@@ -0,0 +1,98 @@
+package httpcache
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestExportImport(t *testing.T) {
+	source := NewHTTP(cache.NewGenericDisk(t.TempDir(), 0))
+	for _, rawURL := range []string{"http://a.example.com/1", "http://a.example.com/2", "http://b.example.com/"} {
+		req := httptest.NewRequest(http.MethodGet, rawURL, nil)
+		resp := &http.Response{StatusCode: 200, ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(rawURL)), Request: req}
+		if err := source.SetReq(req, resp); err != nil {
+			t.Fatalf("SetReq() error = %v", err)
+		}
+	}
+
+	key, err := source.GenerateKey(httptest.NewRequest(http.MethodGet, "http://a.example.com/1", nil), KeyOptions{})
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	storedAt := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	if err := source.SetRaw(key, mustGetRaw(t, source, key), storedAt); err != nil {
+		t.Fatalf("SetRaw() error = %v", err)
+	}
+
+	var archive bytes.Buffer
+	exported, err := source.Export(&archive, "a.example.com")
+	if err != nil || exported != 2 {
+		t.Fatalf("Export() = %d, %v, want 2 entries", exported, err)
+	}
+
+	target := NewHTTP(cache.NewGenericDisk(t.TempDir(), 0))
+	imported, err := target.Import(&archive)
+	if err != nil || imported != 2 {
+		t.Fatalf("Import() = %d, %v, want 2 entries", imported, err)
+	}
+	resp, err := target.GetReq(httptest.NewRequest(http.MethodGet, "http://a.example.com/2", nil))
+	if err != nil || resp == nil {
+		t.Fatalf("GetReq() = %v, %v, want the imported entry", resp, err)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "http://a.example.com/2" {
+		t.Errorf("imported body = %q", body)
+	}
+	if resp, _ := target.GetReq(httptest.NewRequest(http.MethodGet, "http://b.example.com/", nil)); resp != nil {
+		t.Error("entry outside of the exported prefix was imported")
+	}
+
+	// Imported entries keep their storage time
+	found := false
+	err = target.Walk("", func(info cache.EntryInfo) error {
+		if info.Key == key {
+			found = true
+			if !info.ModTime.Equal(storedAt) {
+				t.Errorf("imported entry storage time = %v, want %v", info.ModTime, storedAt)
+			}
+		}
+		return nil
+	})
+	if err != nil || !found {
+		t.Fatalf("Walk() error = %v, found imported entry = %v", err, found)
+	}
+}
+
+func mustGetRaw(t *testing.T, d *HTTPCache, key string) []byte {
+	t.Helper()
+	data, err := d.GetRaw(key)
+	if err != nil || data == nil {
+		t.Fatalf("GetRaw(%s) = %v, %v", key, data, err)
+	}
+	return data
+}
+
+func TestImportRejectsEscapingNames(t *testing.T) {
+	var archive bytes.Buffer
+	zw, _ := zstd.NewWriter(&archive)
+	tw := tar.NewWriter(zw)
+	data := []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	_ = tw.WriteHeader(&tar.Header{Name: "../outside.bin", Mode: 0644, Size: int64(len(data))})
+	_, _ = tw.Write(data)
+	_ = tw.Close()
+	_ = zw.Close()
+
+	target := NewHTTP(cache.NewGenericDisk(t.TempDir(), 0))
+	if _, err := target.Import(&archive); err == nil {
+		t.Error("Import() accepted an entry outside of the cache")
+	}
+}
@@ -0,0 +1,73 @@
+package httpcache
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+)
+
+// EntryFilter selects stored entries. Zero fields do not filter
+type EntryFilter struct {
+	// Host of the request, with or without port
+	Host string
+	// Prefix of the request URL path
+	PathPrefix string
+	// Request method
+	Method string
+	// Time since the entry was stored
+	MinAge time.Duration
+	MaxAge time.Duration
+	// Stored size, in bytes
+	MinSize int64
+	MaxSize int64
+}
+
+// needsRequest reports whether the filter checks the stored request, which requires reading the entry
+func (f EntryFilter) needsRequest() bool {
+	return f.Host != "" || f.PathPrefix != "" || f.Method != ""
+}
+
+// matchInfo checks the conditions known without reading the entry
+func (f EntryFilter) matchInfo(info cache.EntryInfo, now time.Time) bool {
+	age := now.Sub(info.ModTime)
+	return (f.MinAge == 0 || age >= f.MinAge) && (f.MaxAge == 0 || age <= f.MaxAge) &&
+		(f.MinSize == 0 || info.Size >= f.MinSize) && (f.MaxSize == 0 || info.Size <= f.MaxSize)
+}
+
+// matchRequest checks the conditions on the stored request. Entries stored without their request never match them
+func (f EntryFilter) matchRequest(req *http.Request) bool {
+	if req == nil {
+		return false
+	}
+	return (f.Host == "" || strings.EqualFold(req.URL.Host, f.Host) || strings.EqualFold(req.URL.Hostname(), f.Host)) &&
+		strings.HasPrefix(req.URL.Path, f.PathPrefix) &&
+		(f.Method == "" || strings.EqualFold(req.Method, f.Method))
+}
+
+// List calls fn for each stored entry whose key starts with prefix and matching the filter, including expired ones.
+// resp is the stored entry if it had to be read to check the filter, nil otherwise
+func (d *HTTPCache) List(prefix string, filter EntryFilter, fn func(info cache.EntryInfo, resp *http.Response) error) error {
+	now := time.Now()
+	return d.cache.Walk(prefix, func(info cache.EntryInfo) error {
+		if !filter.matchInfo(info, now) {
+			return nil
+		}
+		if !filter.needsRequest() {
+			return fn(info, nil)
+		}
+		resp, err := d.GetStaleKey(info.Key)
+		if err != nil {
+			return err
+		}
+		if resp == nil {
+			return nil // removed in the meantime
+		}
+		if !filter.matchRequest(resp.Request) {
+			_ = resp.Body.Close()
+			return nil
+		}
+		return fn(info, resp)
+	})
+}
@@ -0,0 +1,71 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+)
+
+func TestList(t *testing.T) {
+	httpCache := NewHTTP(cache.NewGenericDisk(t.TempDir(), 0))
+	entries := []struct {
+		method string
+		url    string
+		body   string
+	}{
+		{http.MethodGet, "http://a.example.com/api/users", "small"},
+		{http.MethodPost, "http://a.example.com/api/users", "small"},
+		{http.MethodGet, "http://a.example.com/static/app.js", strings.Repeat("x", 10000)},
+		{http.MethodGet, "http://b.example.com:8080/api/items", "small"},
+	}
+	for _, e := range entries {
+		req := httptest.NewRequest(e.method, e.url, nil)
+		resp := &http.Response{StatusCode: 200, ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(e.body)), Request: req}
+		if err := httpCache.SetReq(req, resp); err != nil {
+			t.Fatalf("SetReq() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter EntryFilter
+		want   []string
+	}{
+		{"no filter", EntryFilter{}, []string{"GET http://a.example.com/api/users", "POST http://a.example.com/api/users", "GET http://a.example.com/static/app.js", "GET http://b.example.com:8080/api/items"}},
+		{"host without port", EntryFilter{Host: "b.example.com"}, []string{"GET http://b.example.com:8080/api/items"}},
+		{"path and method", EntryFilter{PathPrefix: "/api/", Method: "post"}, []string{"POST http://a.example.com/api/users"}},
+		{"min size", EntryFilter{MinSize: 5000}, []string{"GET http://a.example.com/static/app.js"}},
+		{"max size", EntryFilter{Host: "a.example.com", MaxSize: 5000}, []string{"GET http://a.example.com/api/users", "POST http://a.example.com/api/users"}},
+		{"min age", EntryFilter{MinAge: time.Hour}, nil},
+		{"max age", EntryFilter{MaxAge: time.Hour, Method: "POST"}, []string{"POST http://a.example.com/api/users"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := httpCache.List("", tt.filter, func(info cache.EntryInfo, resp *http.Response) error {
+				if resp == nil {
+					var err error
+					if resp, err = httpCache.GetStaleKey(info.Key); err != nil {
+						return err
+					}
+				}
+				got = append(got, resp.Request.Method+" "+resp.Request.URL.String())
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			slices.Sort(got)
+			want := slices.Sorted(slices.Values(tt.want))
+			if !slices.Equal(got, want) {
+				t.Errorf("List() = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -6,8 +6,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/iTrooz/caching-dev-proxy/internal/cache"
 )
@@ -22,16 +26,53 @@ func NewHTTP(cache cache.GenericCache) *HTTPCache {
 	}
 }
 
+// DefaultKeyHeaders are the request headers hashed into cache keys when not configured
+var DefaultKeyHeaders = []string{"Host", "Accept", "Accept-Encoding", "Accept-Language", "Content-Type"}
+
+// KeyOptions alters how cache keys are generated
+type KeyOptions struct {
+	// Query parameters ignored when hashing the query string (e.g. cache busters, tracking parameters)
+	IgnoreQueryParams []string
+	// Request headers hashed into the key. nil means DefaultKeyHeaders
+	Headers []string
+	// Path prefix isolating entries from other namespaces. Empty means no namespace
+	Namespace string
+}
+
+// filterQuery removes the given parameters from a raw query string, keeping the order of the others
+func filterQuery(rawQuery string, ignored []string) string {
+	if len(ignored) == 0 || rawQuery == "" {
+		return rawQuery
+	}
+
+	kept := []string{}
+	for _, part := range strings.Split(rawQuery, "&") {
+		name, _, _ := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if !slices.Contains(ignored, name) {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
 // Generates a unique key to store a value, based on URL, method, selected headers, and body
-func (d *HTTPCache) GenerateKey(request *http.Request) (string, error) {
+func (d *HTTPCache) GenerateKey(request *http.Request, opts KeyOptions) (string, error) {
 	// Hash query parameters
-	hash := sha256.Sum256([]byte(request.URL.RawQuery))
+	rawQuery := filterQuery(request.URL.RawQuery, opts.IgnoreQueryParams)
+	hash := sha256.Sum256([]byte(rawQuery))
 	queryHash := hex.EncodeToString(hash[:])[:8]
 
 	// Hash selected headers
-	headersToHash := []string{"Host", "Accept", "Accept-Encoding", "Accept-Language", "Content-Type"}
+	headersToHash := opts.Headers
+	if headersToHash == nil {
+		headersToHash = DefaultKeyHeaders
+	}
 	headersStr := ""
 	for _, k := range headersToHash {
+		k = http.CanonicalHeaderKey(k)
 		if v, ok := request.Header[k]; ok {
 			headersStr += k + ":" + strings.Join(v, ",") + "\n"
 		}
@@ -54,15 +95,14 @@ func (d *HTTPCache) GenerateKey(request *http.Request) (string, error) {
 	}
 
 	// Build path: /cache_folder/host/path/METHOD[_queryhash][_headershash][_bodyhash].bin
-	host := strings.TrimSuffix(strings.TrimSuffix(request.URL.Host, ":80"), ":443")
-	pathParts := []string{host}
-
-	if request.URL.Path != "" && request.URL.Path != "/" {
-		pathParts = append(pathParts, strings.Trim(request.URL.Path, "/"))
+	pathParts := []string{KeyDir(request.URL)}
+	if opts.Namespace != "" {
+		pathParts = append([]string{opts.Namespace}, pathParts...)
 	}
 
-	filename := request.Method
-	if request.URL.RawQuery != "" {
+	// Methods are tokens, which may still contain characters invalid in file names on Windows
+	filename := safeSegment(request.Method, runtime.GOOS == "windows")
+	if rawQuery != "" {
 		filename += "_q" + queryHash
 	}
 	if headersStr != "" {
@@ -78,8 +118,95 @@ func (d *HTTPCache) GenerateKey(request *http.Request) (string, error) {
 	return filepath.Join(pathParts...), nil
 }
 
+// KeyDir returns the directory part of the keys of a URL, shared by all entries for this URL.
+// It mirrors the host and path, escaping the segments which are not safe directory names (see pathSegments)
+func KeyDir(u *url.URL) string {
+	host := strings.TrimSuffix(strings.TrimSuffix(u.Host, ":80"), ":443")
+	return filepath.Join(pathSegments(host, u.Path)...)
+}
+
+// Walk calls fn for each stored entry whose key starts with prefix, including expired ones
+func (d *HTTPCache) Walk(prefix string, fn func(info cache.EntryInfo) error) error {
+	return d.cache.Walk(prefix, fn)
+}
+
+// FindURL returns the keys of the entries stored for a URL in a namespace (all methods and header variations).
+// Entries stored without their request cannot be matched against the query string and are skipped
+func (d *HTTPCache) FindURL(namespace string, u *url.URL) ([]string, error) {
+	dir := filepath.Join(namespace, KeyDir(u))
+	keys := []string{}
+	err := d.cache.Walk(dir+string(filepath.Separator), func(info cache.EntryInfo) error {
+		if filepath.Dir(info.Key) != dir {
+			return nil // entry of a sub-path
+		}
+
+		resp, err := d.GetKey(info.Key)
+		if err != nil {
+			return err
+		}
+		if resp == nil || resp.Request == nil {
+			return nil
+		}
+		// Host and path are already known to match through the key directory
+		if resp.Request.URL.RequestURI() == u.RequestURI() {
+			keys = append(keys, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// KeyPrefix returns the prefix of the keys of all URLs starting with u (ignoring scheme and query string)
+func KeyPrefix(namespace string, u *url.URL) string {
+	keyPrefix := filepath.Join(namespace, KeyDir(u))
+	// Do not match other hosts or paths sharing the same beginning (e.g. example.com and example.com.au)
+	if u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		keyPrefix += string(filepath.Separator)
+	}
+	return keyPrefix
+}
+
+// Purge removes the entries of a URL in a namespace (see FindURL), or with prefix, all entries of URLs starting with it.
+// Prefix matching ignores the scheme and the query string. Returns the number of removed entries
+func (d *HTTPCache) Purge(namespace string, u *url.URL, prefix bool) (int, error) {
+	var keys []string
+	if prefix {
+		err := d.cache.Walk(KeyPrefix(namespace, u), func(info cache.EntryInfo) error {
+			keys = append(keys, info.Key)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		var err error
+		keys, err = d.FindURL(namespace, u)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for i, key := range keys {
+		if err := d.cache.Delete(key); err != nil {
+			return i, fmt.Errorf("failed to delete cache entry: %w", err)
+		}
+	}
+	return len(keys), nil
+}
+
+// Close closes the underlying cache, if it needs closing (e.g. to save a snapshot)
+func (d *HTTPCache) Close() error {
+	if closer, ok := d.cache.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 func (d *HTTPCache) SetReq(request *http.Request, resp *http.Response) error {
-	cacheKey, err := d.GenerateKey(request)
+	cacheKey, err := d.GenerateKey(request, KeyOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to generate cache key: %w", err)
 	}
@@ -101,7 +228,7 @@ func (d *HTTPCache) SetKey(requestKey string, resp *http.Response) error {
 }
 
 func (d *HTTPCache) GetReq(req *http.Request) (*http.Response, error) {
-	requestKey, err := d.GenerateKey(req)
+	requestKey, err := d.GenerateKey(req, KeyOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate cache key: %w", err)
 	}
@@ -135,3 +262,49 @@ func (d *HTTPCache) GetKey(requestKey string) (*http.Response, error) {
 	}
 	return resp, nil
 }
+
+// GetRaw returns the serialized entry of a key, nil if it is not cached or expired
+func (d *HTTPCache) GetRaw(requestKey string) ([]byte, error) {
+	data, err := d.cache.Get(requestKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache: %w", err)
+	}
+	return data, nil
+}
+
+// SetRaw stores a serialized entry (e.g. returned by GetRaw of another cache).
+// If storedAt is set and the cache supports it, the entry keeps its storage time, so it does not live longer than in the original cache
+func (d *HTTPCache) SetRaw(requestKey string, data []byte, storedAt time.Time) error {
+	if err := d.cache.Set(requestKey, data); err != nil {
+		return fmt.Errorf("failed to set cache: %w", err)
+	}
+	if setter, ok := d.cache.(cache.ModTimeSetter); ok && !storedAt.IsZero() {
+		return setter.SetModTime(requestKey, storedAt)
+	}
+	return nil
+}
+
+// DeleteKey removes an entry
+func (d *HTTPCache) DeleteKey(requestKey string) error {
+	if err := d.cache.Delete(requestKey); err != nil {
+		return fmt.Errorf("failed to delete cache entry: %w", err)
+	}
+	return nil
+}
+
+// GetStaleKey returns the cached response even if it expired, as long as the cache still keeps it
+func (d *HTTPCache) GetStaleKey(requestKey string) (*http.Response, error) {
+	data, err := d.cache.GetStale(requestKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stale cache: %w", err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	resp, err := Deserialize(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize response: %w", err)
+	}
+	return resp, nil
+}
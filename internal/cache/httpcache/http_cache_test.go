@@ -3,6 +3,7 @@ package httpcache
 import (
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -149,3 +150,251 @@ func TestHTTPCacheGetKeyError(t *testing.T) {
 		t.Errorf("Expected cached response, got nil")
 	}
 }
+
+func TestGenerateKeyIgnoreQueryParams(t *testing.T) {
+	httpCache := NewHTTP(cache.NewGenericDisk(t.TempDir(), time.Hour))
+	opts := KeyOptions{IgnoreQueryParams: []string{"_ts", "utm_source"}}
+
+	keyFor := func(rawURL string) string {
+		req, err := http.NewRequest("GET", rawURL, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		key, err := httpCache.GenerateKey(req, opts)
+		if err != nil {
+			t.Fatalf("GenerateKey() error = %v", err)
+		}
+		return key
+	}
+
+	base := keyFor("https://example.com/api?page=2")
+	if got := keyFor("https://example.com/api?page=2&_ts=12345"); got != base {
+		t.Errorf("GenerateKey() with ignored param = %s, want %s", got, base)
+	}
+	if got := keyFor("https://example.com/api?utm_source=mail&page=2&_ts=1"); got != base {
+		t.Errorf("GenerateKey() with several ignored params = %s, want %s", got, base)
+	}
+	if got := keyFor("https://example.com/api?page=3&_ts=1"); got == base {
+		t.Errorf("GenerateKey() should differ when a non-ignored param changes")
+	}
+	if got, want := keyFor("https://example.com/api?_ts=1"), keyFor("https://example.com/api"); got != want {
+		t.Errorf("GenerateKey() with only ignored params = %s, want %s", got, want)
+	}
+}
+
+func TestGenerateKeyHeaders(t *testing.T) {
+	httpCache := NewHTTP(cache.NewGenericDisk(t.TempDir(), time.Hour))
+
+	keyFor := func(authorization string, opts KeyOptions) string {
+		req, err := http.NewRequest("GET", "https://example.com/api", nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", authorization)
+		key, err := httpCache.GenerateKey(req, opts)
+		if err != nil {
+			t.Fatalf("GenerateKey() error = %v", err)
+		}
+		return key
+	}
+
+	// Default headers do not include Authorization
+	if keyFor("alice", KeyOptions{}) != keyFor("bob", KeyOptions{}) {
+		t.Errorf("GenerateKey() with default headers should ignore Authorization")
+	}
+
+	perUser := KeyOptions{Headers: []string{"authorization"}}
+	if keyFor("alice", perUser) == keyFor("bob", perUser) {
+		t.Errorf("GenerateKey() should differ per Authorization when it is a key header")
+	}
+}
+
+func TestSerializeStoresRequest(t *testing.T) {
+	req, err := http.NewRequest("POST", "https://example.com/api/users?page=2", strings.NewReader(`{"name":"test"}`))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp := &http.Response{
+		StatusCode: http.StatusCreated,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Body:       io.NopCloser(strings.NewReader("created")),
+		Header:     http.Header{"Content-Type": []string{"text/plain"}},
+		Request:    req,
+	}
+
+	data, err := Serialize(resp)
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	got, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+
+	if got.StatusCode != http.StatusCreated {
+		t.Errorf("Deserialize() status = %d, want %d", got.StatusCode, http.StatusCreated)
+	}
+	body, _ := io.ReadAll(got.Body)
+	if string(body) != "created" {
+		t.Errorf("Deserialize() body = %s, want created", string(body))
+	}
+
+	if got.Request == nil {
+		t.Fatalf("Deserialize() did not restore the request")
+	}
+	if got.Request.Method != "POST" || got.Request.URL.String() != "https://example.com/api/users?page=2" {
+		t.Errorf("Deserialize() request = %s %s", got.Request.Method, got.Request.URL.String())
+	}
+	if got.Request.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Deserialize() request Content-Type = %s", got.Request.Header.Get("Content-Type"))
+	}
+	if got.Request.Header.Get("User-Agent") != "" {
+		t.Errorf("Deserialize() request should not get a default User-Agent, got %s", got.Request.Header.Get("User-Agent"))
+	}
+	reqBody, _ := io.ReadAll(got.Request.Body)
+	if string(reqBody) != `{"name":"test"}` {
+		t.Errorf("Deserialize() request body = %s", string(reqBody))
+	}
+}
+
+func TestSerializeStoresTrailers(t *testing.T) {
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Body:          io.NopCloser(strings.NewReader("hello")),
+		ContentLength: 5,
+		Header:        http.Header{"Content-Type": []string{"text/plain"}},
+		Trailer:       http.Header{"X-Checksum": []string{"abc"}},
+	}
+
+	data, err := Serialize(resp)
+	if err != nil {
+		t.Fatalf("Serialize() error = %v", err)
+	}
+	got, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize() error = %v", err)
+	}
+	body, _ := io.ReadAll(got.Body)
+	if string(body) != "hello" {
+		t.Errorf("Deserialize() body = %s, want hello", string(body))
+	}
+	if got.Trailer.Get("X-Checksum") != "abc" {
+		t.Errorf("Deserialize() trailer X-Checksum = %q, want abc", got.Trailer.Get("X-Checksum"))
+	}
+}
+
+func TestMigrateEntries(t *testing.T) {
+	tempDir := t.TempDir()
+	genericCache := cache.NewGenericDisk(tempDir, time.Hour)
+	httpCache := NewHTTP(genericCache)
+
+	legacy := "---HTTP-RESPONSE---\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	if err := genericCache.Set("example.com/old/GET.bin", []byte(legacy)); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	stored := time.Now().Add(-30 * time.Minute).Truncate(time.Second)
+	if err := genericCache.(cache.ModTimeSetter).SetModTime("example.com/old/GET.bin", stored); err != nil {
+		t.Fatalf("SetModTime() error = %v", err)
+	}
+	current, _ := Serialize(&http.Response{StatusCode: 200, ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("new"))})
+	if err := genericCache.Set("example.com/new/GET.bin", current); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, err := Deserialize([]byte(VERSION_PREFIX + "99---\n" + legacy)); err == nil {
+		t.Errorf("Deserialize() should reject entries of newer formats")
+	}
+
+	migrated, upToDate, err := httpCache.MigrateEntries()
+	if err != nil || migrated != 1 || upToDate != 1 {
+		t.Fatalf("MigrateEntries() = %d, %d, %v, want 1, 1", migrated, upToDate, err)
+	}
+
+	data, _ := genericCache.Get("example.com/old/GET.bin")
+	if version, err := EntryVersion(data); err != nil || version != FormatVersion {
+		t.Errorf("EntryVersion() of migrated entry = %d, %v", version, err)
+	}
+	resp, err := Deserialize(data)
+	if err != nil {
+		t.Fatalf("Deserialize() of migrated entry error = %v", err)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "ok" {
+		t.Errorf("migrated body = %q, want ok", body)
+	}
+	err = genericCache.Walk("example.com/old/", func(info cache.EntryInfo) error {
+		if !info.ModTime.Equal(stored) {
+			t.Errorf("migrated entry stored at %v, want %v", info.ModTime, stored)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	httpCache := NewHTTP(cache.NewGenericDisk(t.TempDir(), 0))
+
+	store := func(rawURL string) {
+		req, err := http.NewRequest("GET", rawURL, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		key, err := httpCache.GenerateKey(req, KeyOptions{})
+		if err != nil {
+			t.Fatalf("GenerateKey() error = %v", err)
+		}
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Body:       io.NopCloser(strings.NewReader("ok")),
+			Header:     http.Header{},
+			Request:    req,
+		}
+		if err := httpCache.SetKey(key, resp); err != nil {
+			t.Fatalf("SetKey() error = %v", err)
+		}
+	}
+	count := func(rawURL string) int {
+		u, _ := url.Parse(rawURL)
+		keys, err := httpCache.FindURL("", u)
+		if err != nil {
+			t.Fatalf("FindURL() error = %v", err)
+		}
+		return len(keys)
+	}
+	purge := func(rawURL string, prefix bool) int {
+		u, _ := url.Parse(rawURL)
+		n, err := httpCache.Purge("", u, prefix)
+		if err != nil {
+			t.Fatalf("Purge() error = %v", err)
+		}
+		return n
+	}
+
+	for _, u := range []string{"http://example.com/api/a?x=1", "http://example.com/api/a?x=2", "http://example.com/api/b", "http://example.com.au/api/a"} {
+		store(u)
+	}
+
+	if n := purge("http://example.com/api/a?x=1", false); n != 1 {
+		t.Errorf("Purge() of a URL removed %d entries, want 1", n)
+	}
+	if count("http://example.com/api/a?x=2") != 1 {
+		t.Errorf("Purge() of a URL should not remove other query strings")
+	}
+
+	if n := purge("http://example.com/", true); n != 2 {
+		t.Errorf("Purge() of a prefix removed %d entries, want 2", n)
+	}
+	if count("http://example.com/api/b") != 0 {
+		t.Errorf("Purge() of a prefix should remove entries under it")
+	}
+	if count("http://example.com.au/api/a") != 1 {
+		t.Errorf("Purge() of a prefix should not remove other hosts")
+	}
+}
@@ -0,0 +1,98 @@
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits keeping the cache paths of any URL valid on common filesystems
+const (
+	// Longest URL path segment kept as is, in bytes. Filesystems usually limit names to 255 bytes
+	maxSegmentLength = 100
+	// Deepest URL path kept as is. The deeper segments are merged into the last one
+	maxPathDepth = 32
+)
+
+// Device names Windows reserves, even with an extension (e.g. "nul.txt")
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// pathSegments returns the directories of a URL host and path in cache keys.
+// Empty segments are dropped, and segments that cannot safely be used as directory names are escaped (see safeSegment)
+func pathSegments(host string, path string) []string {
+	segments := []string{}
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) > maxPathDepth {
+		segments = append(segments[:maxPathDepth-1], strings.Join(segments[maxPathDepth-1:], "/"))
+	}
+
+	windows := runtime.GOOS == "windows"
+	safe := []string{safeSegment(host, windows)}
+	for _, segment := range segments {
+		safe = append(safe, safeSegment(segment, windows))
+	}
+	return safe
+}
+
+// safeSegment returns the segment if it is a valid directory name which cannot escape its parent,
+// else a name made of its valid characters and of its hash, unique to it
+func safeSegment(segment string, windows bool) string {
+	if isSafeSegment(segment, windows) {
+		return segment
+	}
+
+	sum := sha256.Sum256([]byte(segment))
+	hash := hex.EncodeToString(sum[:])[:16]
+	readable := strings.Map(func(r rune) rune {
+		if isSafeRune(r, windows) && r != '.' {
+			return r
+		}
+		return '_'
+	}, segment)
+	for len(readable) > maxSegmentLength-len(hash)-1 {
+		_, size := utf8.DecodeLastRuneInString(readable)
+		readable = readable[:len(readable)-size]
+	}
+	return readable + "~" + hash
+}
+
+// isSafeSegment reports whether a URL path segment can be used as is as a directory name.
+// Names of entry files (ending with .bin) are not, as the directory would collide with the entry of the parent URL
+func isSafeSegment(segment string, windows bool) bool {
+	if segment == "." || segment == ".." || len(segment) > maxSegmentLength || !utf8.ValidString(segment) || strings.HasSuffix(segment, ".bin") {
+		return false
+	}
+	for _, r := range segment {
+		if !isSafeRune(r, windows) {
+			return false
+		}
+	}
+	if windows {
+		if strings.HasSuffix(segment, ".") || strings.HasSuffix(segment, " ") {
+			return false
+		}
+		base, _, _ := strings.Cut(segment, ".")
+		if windowsReservedNames[strings.ToUpper(base)] {
+			return false
+		}
+	}
+	return true
+}
+
+// isSafeRune reports whether a character is valid in file names
+func isSafeRune(r rune, windows bool) bool {
+	if r < 0x20 || r == 0x7f || r == '/' || r == utf8.RuneError {
+		return false
+	}
+	return !windows || !strings.ContainsRune(`<>:"\|?*`, r)
+}
@@ -0,0 +1,95 @@
+package httpcache
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSafeSegment(t *testing.T) {
+	long := strings.Repeat("a", maxSegmentLength+1)
+	tests := []struct {
+		segment string
+		windows bool
+		kept    bool
+	}{
+		{"users", false, true},
+		{"file.tar.gz", false, true},
+		{"~john", false, true},
+		{"a:b", false, true},
+		{"a:b", true, false},
+		{"..", false, false},
+		{".", false, false},
+		{"a\x00b", false, false},
+		{"line\nbreak", false, false},
+		{long, false, false},
+		{"nul.txt", true, false},
+		{"nul.txt", false, true},
+		{"Com1", true, false},
+		{"trailing.", true, false},
+		{"back\\slash", true, false},
+		{"back\\slash", false, true},
+		{"GET.bin", false, false},
+		{"GET_q1234abcd.bin", false, false},
+	}
+	for _, tt := range tests {
+		got := safeSegment(tt.segment, tt.windows)
+		if kept := got == tt.segment; kept != tt.kept {
+			t.Errorf("safeSegment(%q, windows=%v) = %q, kept = %v, want %v", tt.segment, tt.windows, got, kept, tt.kept)
+		}
+		if !isSafeSegment(got, tt.windows) {
+			t.Errorf("safeSegment(%q, windows=%v) = %q, which is not safe", tt.segment, tt.windows, got)
+		}
+	}
+
+	if safeSegment(long, false) == safeSegment(long+"b", false) {
+		t.Errorf("long segments sharing a prefix should be escaped differently")
+	}
+}
+
+func TestGenerateKeyHostilePaths(t *testing.T) {
+	httpCache := NewHTTP(nil)
+	key := func(rawURL string) string {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		if err != nil {
+			t.Fatalf("NewRequest() error = %v", err)
+		}
+		key, err := httpCache.GenerateKey(req, KeyOptions{Headers: []string{}})
+		if err != nil {
+			t.Fatalf("GenerateKey() error = %v", err)
+		}
+		return key
+	}
+
+	for _, rawURL := range []string{
+		"http://example.com/../../etc/passwd",
+		"http://example.com/a/%2E%2E/%2E%2E/%2E%2E/b",
+		"http://example.com/" + strings.Repeat("x/", 500),
+		"http://example.com/" + strings.Repeat("y", 5000),
+	} {
+		k := key(rawURL)
+		if !filepath.IsLocal(k) || !strings.HasPrefix(k, "example.com"+string(filepath.Separator)) {
+			t.Errorf("key of %s = %s, want a path inside example.com/", rawURL, k)
+		}
+		for _, segment := range strings.Split(k, string(filepath.Separator)) {
+			if len(segment) > maxSegmentLength {
+				t.Errorf("key of %s has a segment of %d bytes", rawURL, len(segment))
+			}
+		}
+		if depth := strings.Count(k, string(filepath.Separator)); depth > maxPathDepth+1 {
+			t.Errorf("key of %s is %d directories deep", rawURL, depth)
+		}
+	}
+
+	if got, want := key("http://example.com/a/b"), filepath.Join("example.com", "a", "b", "GET.bin"); got != want {
+		t.Errorf("key of a regular URL = %s, want %s", got, want)
+	}
+	// The directory of the entries of /a/GET.bin would be the entry file of /a
+	if dir := filepath.Dir(key("http://example.com/a/GET.bin")); dir == key("http://example.com/a") {
+		t.Errorf("entries of /a/GET.bin are in %s, the entry file of /a", dir)
+	}
+	if key("http://example.com/a/../b") == key("http://example.com/b") {
+		t.Errorf("dot segments should not be resolved into other URLs' keys")
+	}
+}
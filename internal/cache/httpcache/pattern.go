@@ -0,0 +1,95 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+)
+
+// URLPattern matches the URLs of stored requests
+type URLPattern struct {
+	re *regexp.Regexp
+	// URL every matching URL starts with, to only walk the related entries. Empty walks the whole namespace
+	literal string
+}
+
+// IsGlob reports whether a URL argument is a glob pattern rather than a URL
+func IsGlob(value string) bool {
+	return strings.Contains(value, "*")
+}
+
+// NewGlobPattern returns a pattern matching whole URLs, where "*" matches any characters (including "/").
+// "?" is not a wildcard, as it starts query strings
+func NewGlobPattern(glob string) *URLPattern {
+	parts := strings.Split(glob, "*")
+	literal := parts[0]
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return &URLPattern{
+		re:      regexp.MustCompile("^" + strings.Join(parts, ".*") + "$"),
+		literal: literal,
+	}
+}
+
+// NewRegexPattern returns a pattern matching URLs containing a match of the regular expression
+func NewRegexPattern(expr string) (*URLPattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+	return &URLPattern{re: re}, nil
+}
+
+// keyPrefix returns the prefix of the keys of all entries possibly matching the pattern
+func (p *URLPattern) keyPrefix(namespace string) string {
+	u, err := url.Parse(p.literal)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		if namespace == "" {
+			return ""
+		}
+		return namespace + string(filepath.Separator)
+	}
+	// The literal part may end in the middle of a host or path segment, so do not add a separator
+	return strings.TrimSuffix(KeyPrefix(namespace, u), string(filepath.Separator))
+}
+
+// Match reports whether the URL of a request matches the pattern
+func (p *URLPattern) Match(req *http.Request) bool {
+	return p.re.MatchString(req.URL.String())
+}
+
+// PurgePattern removes the entries of a namespace whose request URL matches the pattern. Returns the number of removed entries.
+// Entries stored without their request are never matched
+func (d *HTTPCache) PurgePattern(namespace string, pattern *URLPattern) (int, error) {
+	keys := []string{}
+	err := d.List(pattern.keyPrefix(namespace), EntryFilter{}, func(info cache.EntryInfo, _ *http.Response) error {
+		resp, err := d.GetStaleKey(info.Key)
+		if err != nil {
+			return err
+		}
+		if resp == nil {
+			return nil // removed in the meantime
+		}
+		_ = resp.Body.Close()
+		if resp.Request != nil && pattern.Match(resp.Request) {
+			keys = append(keys, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, key := range keys {
+		if err := d.cache.Delete(key); err != nil {
+			return i, fmt.Errorf("failed to delete cache entry: %w", err)
+		}
+	}
+	return len(keys), nil
+}
@@ -0,0 +1,65 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+)
+
+func TestPurgePattern(t *testing.T) {
+	urls := []string{
+		"https://api.example.com/v1/users",
+		"https://api.example.com/v1/users/42?fields=name",
+		"https://api.example.com/v2/users",
+		"https://api.example.com.au/v1/users",
+	}
+
+	tests := []struct {
+		name    string
+		pattern func() (*URLPattern, error)
+		want    int
+	}{
+		{"glob below a path", func() (*URLPattern, error) { return NewGlobPattern("https://api.example.com/v1/*"), nil }, 2},
+		{"glob in the middle", func() (*URLPattern, error) { return NewGlobPattern("https://api.example.com/*/users"), nil }, 2},
+		{"glob in the host", func() (*URLPattern, error) { return NewGlobPattern("https://api.example.*/v1/users"), nil }, 2},
+		{"glob matching the whole URL", func() (*URLPattern, error) { return NewGlobPattern("https://api.example.com/v1/users*"), nil }, 2},
+		{"glob with query string", func() (*URLPattern, error) { return NewGlobPattern("*?fields=*"), nil }, 1},
+		{"regex", func() (*URLPattern, error) { return NewRegexPattern(`/v[12]/users$`) }, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpCache := NewHTTP(cache.NewGenericDisk(t.TempDir(), 0))
+			for _, rawURL := range urls {
+				req := httptest.NewRequest(http.MethodGet, rawURL, nil)
+				key, err := httpCache.GenerateKey(req, KeyOptions{Namespace: "ns"})
+				if err != nil {
+					t.Fatalf("GenerateKey() error = %v", err)
+				}
+				resp := &http.Response{StatusCode: 200, ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("ok")), Request: req}
+				if err := httpCache.SetKey(key, resp); err != nil {
+					t.Fatalf("SetKey() error = %v", err)
+				}
+			}
+
+			pattern, err := tt.pattern()
+			if err != nil {
+				t.Fatalf("invalid pattern: %v", err)
+			}
+			removed, err := httpCache.PurgePattern("ns", pattern)
+			if err != nil {
+				t.Fatalf("PurgePattern() error = %v", err)
+			}
+			if removed != tt.want {
+				t.Errorf("PurgePattern() removed %d entries, want %d", removed, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewRegexPattern("(unclosed"); err == nil {
+		t.Error("NewRegexPattern() accepted an invalid expression")
+	}
+}
@@ -4,32 +4,214 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httputil"
+	"slices"
+	"strconv"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
 )
 
+// FormatVersion is the version of the entry format written by Serialize.
+// Entries without version header are of version 1
+const FormatVersion = 2
+
+// Marks the version header, followed by the format version and "---\n"
+const VERSION_PREFIX = "---CACHE-FORMAT-"
+
 const PREFIX = "---HTTP-RESPONSE---\n"
 
+// Marks the (optional) request section, stored before the response
+const REQUEST_PREFIX = "---HTTP-REQUEST---\n"
+
 // Serialize writes the http.Response to the given writer using gob encoding.
+// If resp.Request is set, it is stored too, so the entry can be traced back to the request that produced it.
+// Trailers are stored after the body, and restored in resp.Trailer once the body of the deserialized response is read
 func Serialize(resp *http.Response) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s%d---\n", VERSION_PREFIX, FormatVersion)
+
+	if resp.Request != nil {
+		buf.WriteString(REQUEST_PREFIX)
+		if err := writeRequest(&buf, resp.Request); err != nil {
+			return nil, fmt.Errorf("failed to serialize request: %w", err)
+		}
+	}
+
+	// Trailers can only be written after a chunked body
+	if len(resp.Trailer) > 0 && !slices.Contains(resp.TransferEncoding, "chunked") {
+		chunked := *resp
+		chunked.TransferEncoding = []string{"chunked"}
+		chunked.ContentLength = -1
+		resp = &chunked
+	}
 	b, err := httputil.DumpResponse(resp, true)
 	if err != nil {
 		return nil, err
 	}
+	buf.WriteString(PREFIX)
+	buf.Write(b)
+
+	return buf.Bytes(), nil
+}
+
+// writeRequest writes the request in proxy wire format (absolute URL), with an explicit Content-Length.
+// The request body is restored after being read
+func writeRequest(w io.Writer, req *http.Request) error {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
 
-	return append([]byte(PREFIX), b...), nil
+	reqCopy := req.Clone(req.Context())
+	reqCopy.TransferEncoding = nil
+	reqCopy.Close = false
+	reqCopy.ContentLength = int64(len(body))
+	if len(body) > 0 {
+		reqCopy.Body = io.NopCloser(bytes.NewReader(body))
+	} else {
+		reqCopy.Body = nil
+	}
+	// Prevent Go from adding its default User-Agent
+	if _, ok := reqCopy.Header["User-Agent"]; !ok {
+		reqCopy.Header["User-Agent"] = []string{""}
+	}
+
+	return reqCopy.WriteProxy(w)
 }
 
 func Deserialize(b []byte) (*http.Response, error) {
-	got_prefix := string(b[0:len(PREFIX)])
-	if got_prefix != PREFIX {
-		return nil, fmt.Errorf("invalid prefix: expected '%s', got '%s'", PREFIX, got_prefix)
+	b, err := migrate(b)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(bytes.NewReader(b))
+
+	// Read optional request section
+	var req *http.Request
+	if bytes.HasPrefix(b, []byte(REQUEST_PREFIX)) {
+		if _, err := reader.Discard(len(REQUEST_PREFIX)); err != nil {
+			return nil, fmt.Errorf("failed to deserialize request: %w", err)
+		}
+		var err error
+		req, err = http.ReadRequest(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to deserialize request: %w", err)
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to deserialize request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	gotPrefix := make([]byte, len(PREFIX))
+	if _, err := io.ReadFull(reader, gotPrefix); err != nil || string(gotPrefix) != PREFIX {
+		return nil, fmt.Errorf("invalid prefix: expected '%s', got '%s'", PREFIX, string(gotPrefix))
 	}
 
-	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b[len(PREFIX):])), nil)
+	resp, err := http.ReadResponse(reader, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to deserialize response: %w", err)
 	}
 
 	return resp, nil
 }
+
+// migrations convert entries of a format version (without version header) to the next version
+var migrations = map[int]func(b []byte) ([]byte, error){
+	// Version 2 only added the version header
+	1: func(b []byte) ([]byte, error) { return b, nil },
+}
+
+// splitVersion returns the format version of an entry, and the entry without its version header
+func splitVersion(b []byte) (int, []byte, error) {
+	if !bytes.HasPrefix(b, []byte(VERSION_PREFIX)) {
+		return 1, b, nil
+	}
+	header, rest, found := bytes.Cut(b[len(VERSION_PREFIX):], []byte("---\n"))
+	version, err := strconv.Atoi(string(header))
+	if !found || err != nil || version < 1 {
+		return 0, nil, fmt.Errorf("invalid entry format version header")
+	}
+	return version, rest, nil
+}
+
+// EntryVersion returns the format version of a serialized entry
+func EntryVersion(b []byte) (int, error) {
+	version, _, err := splitVersion(b)
+	return version, err
+}
+
+// Migrate converts a serialized entry to FormatVersion
+func Migrate(b []byte) ([]byte, error) {
+	b, err := migrate(b)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(fmt.Sprintf("%s%d---\n", VERSION_PREFIX, FormatVersion)), b...), nil
+}
+
+// migrate converts a serialized entry to FormatVersion, and returns it without version header
+func migrate(b []byte) ([]byte, error) {
+	version, b, err := splitVersion(b)
+	if err != nil {
+		return nil, err
+	}
+	if version > FormatVersion {
+		return nil, fmt.Errorf("entry format version %d is newer than the supported version %d, upgrade caching-dev-proxy", version, FormatVersion)
+	}
+	for ; version < FormatVersion; version++ {
+		if b, err = migrations[version](b); err != nil {
+			return nil, fmt.Errorf("failed to migrate entry from format version %d: %w", version, err)
+		}
+	}
+	return b, nil
+}
+
+// MigrateEntries converts the stored entries of older formats to FormatVersion, keeping their storage time when the cache supports it.
+// Returns the number of converted entries, and of entries already of FormatVersion
+func (d *HTTPCache) MigrateEntries() (migrated int, upToDate int, err error) {
+	entries := []cache.EntryInfo{}
+	err = d.cache.Walk("", func(info cache.EntryInfo) error {
+		entries = append(entries, info)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, info := range entries {
+		data, err := d.cache.GetStale(info.Key)
+		if err != nil {
+			return migrated, upToDate, fmt.Errorf("failed to read entry %s: %w", info.Key, err)
+		}
+		if data == nil {
+			continue // removed in the meantime
+		}
+		if version, err := EntryVersion(data); err == nil && version == FormatVersion {
+			upToDate++
+			continue
+		}
+		converted, err := Migrate(data)
+		if err != nil {
+			return migrated, upToDate, fmt.Errorf("failed to migrate entry %s: %w", info.Key, err)
+		}
+		if err := d.cache.Set(info.Key, converted); err != nil {
+			return migrated, upToDate, fmt.Errorf("failed to store entry %s: %w", info.Key, err)
+		}
+		if setter, ok := d.cache.(cache.ModTimeSetter); ok {
+			if err := setter.SetModTime(info.Key, info.ModTime); err != nil {
+				return migrated, upToDate, err
+			}
+		}
+		migrated++
+	}
+	return migrated, upToDate, nil
+}
@@ -0,0 +1,20 @@
+package cache
+
+import "os"
+
+// lockFile locks a file of the cache directory (created if needed) against the other processes using the directory.
+// An exclusive lock has a single holder, while a shared one only excludes exclusive holders. Without wait, ok is false
+// instead of waiting for a conflicting holder. Locks are released when their holder exits, even if it crashed
+func lockFile(path string, exclusive bool, wait bool) (unlock func(), ok bool, err error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	ok, err = lockFD(file, exclusive, wait)
+	if err != nil || !ok {
+		_ = file.Close()
+		return nil, false, err
+	}
+	// Closing the file releases the lock. It is not removed, as other processes may be waiting on it
+	return func() { _ = file.Close() }, true, nil
+}
@@ -0,0 +1,317 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryCache implements Cache interface in memory, optionally persisted in a snapshot file
+type MemoryCache struct {
+	ttl              time.Duration
+	staleTTL         time.Duration
+	snapshotPath     string
+	snapshotInterval time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]*memoryEntry
+	// whether entries changed since the last snapshot
+	dirty bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// memoryEntry is a stored entry. Never modified once stored, so it can be read without holding the lock
+type memoryEntry struct {
+	Key     string
+	Data    []byte
+	ModTime time.Time
+}
+
+// MemoryOptions configures a memory cache
+type MemoryOptions struct {
+	// Time after which entries expire. 0 means infinity
+	TTL time.Duration
+	// Time expired entries are kept to be served stale, before being removed
+	StaleTTL time.Duration
+	// File the cache is saved to, and loaded from on Init. Empty disables snapshots
+	SnapshotPath string
+	// Time between two snapshots. 0 only saves the snapshot on Close
+	SnapshotInterval time.Duration
+}
+
+// NewGenericMemory creates a new memory cache
+func NewGenericMemory(opts MemoryOptions) *MemoryCache {
+	return &MemoryCache{
+		ttl:              opts.TTL,
+		staleTTL:         opts.StaleTTL,
+		snapshotPath:     opts.SnapshotPath,
+		snapshotInterval: opts.SnapshotInterval,
+		entries:          make(map[string]*memoryEntry),
+	}
+}
+
+func (m *MemoryCache) Get(cacheKey string) ([]byte, error) {
+	logrus.Debugf("MemoryCache::Get(key=%s)", cacheKey)
+	if cacheKey == "" {
+		return nil, fmt.Errorf("cache key cannot be empty")
+	}
+
+	m.mu.RLock()
+	entry, ok := m.entries[cacheKey]
+	m.mu.RUnlock()
+	if !ok {
+		logrus.Debugf("MemoryCache::Get(key=%s): Not found", cacheKey)
+		return nil, nil
+	}
+
+	// check TTL (0 means infinity)
+	if m.ttl != 0 && time.Since(entry.ModTime) > m.ttl {
+		if time.Since(entry.ModTime) <= m.ttl+m.staleTTL {
+			logrus.Debugf("Cache expired for %s (ttl was %s), keeping it for stale serving", cacheKey, m.ttl)
+			return nil, nil
+		}
+		logrus.Debugf("Cache expired for %s (ttl was %s), removing", cacheKey, m.ttl)
+		m.mu.Lock()
+		if m.entries[cacheKey] == entry {
+			delete(m.entries, cacheKey)
+			m.dirty = true
+		}
+		m.mu.Unlock()
+		return nil, nil
+	}
+
+	logrus.Debugf("MemoryCache::Get(key=%s): Cache hit", cacheKey)
+	return entry.Data, nil
+}
+
+func (m *MemoryCache) GetStale(cacheKey string) ([]byte, error) {
+	logrus.Debugf("MemoryCache::GetStale(key=%s)", cacheKey)
+	if cacheKey == "" {
+		return nil, fmt.Errorf("cache key cannot be empty")
+	}
+
+	m.mu.RLock()
+	entry, ok := m.entries[cacheKey]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+
+	// Entries past the stale TTL are only waiting to be removed
+	if m.ttl != 0 && time.Since(entry.ModTime) > m.ttl+m.staleTTL {
+		return nil, nil
+	}
+	return entry.Data, nil
+}
+
+// Set stores a response in the cache
+func (m *MemoryCache) Set(cacheKey string, data []byte) error {
+	logrus.Debugf("MemoryCache::Set(key=%s)", cacheKey)
+	if cacheKey == "" {
+		return fmt.Errorf("cache key cannot be empty")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[cacheKey] = &memoryEntry{Key: cacheKey, Data: data, ModTime: time.Now()}
+	m.dirty = true
+	return nil
+}
+
+// SetModTime replaces the storage time of an entry
+func (m *MemoryCache) SetModTime(cacheKey string, modTime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.entries[cacheKey]; ok {
+		m.entries[cacheKey] = &memoryEntry{Key: entry.Key, Data: entry.Data, ModTime: modTime}
+		m.dirty = true
+	}
+	return nil
+}
+
+// Delete removes an entry from the cache
+func (m *MemoryCache) Delete(cacheKey string) error {
+	logrus.Debugf("MemoryCache::Delete(key=%s)", cacheKey)
+	if cacheKey == "" {
+		return fmt.Errorf("cache key cannot be empty")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[cacheKey]; ok {
+		delete(m.entries, cacheKey)
+		m.dirty = true
+	}
+	return nil
+}
+
+// Walk calls fn for each entry whose key starts with prefix, in key order
+func (m *MemoryCache) Walk(prefix string, fn func(info EntryInfo) error) error {
+	entries := m.snapshotEntries(prefix)
+	for _, entry := range entries {
+		if err := fn(EntryInfo{Key: entry.Key, Size: int64(len(entry.Data)), ModTime: entry.ModTime}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotEntries returns the entries whose key starts with prefix, in key order
+func (m *MemoryCache) snapshotEntries(prefix string) []*memoryEntry {
+	m.mu.RLock()
+	entries := make([]*memoryEntry, 0, len(m.entries))
+	for key, entry := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			entries = append(entries, entry)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+// Init loads the snapshot, if any, and starts saving snapshots periodically
+func (m *MemoryCache) Init() error {
+	if m.snapshotPath == "" {
+		return nil
+	}
+	if err := m.load(); err != nil {
+		return err
+	}
+
+	if m.snapshotInterval != 0 {
+		m.stop = make(chan struct{})
+		m.done = make(chan struct{})
+		go m.snapshotLoop()
+	}
+	return nil
+}
+
+func (m *MemoryCache) snapshotLoop() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Snapshot(); err != nil {
+				logrus.Errorf("Failed to snapshot memory cache: %v", err)
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// load reads the entries of the snapshot file. A missing snapshot is not an error
+func (m *MemoryCache) load() error {
+	f, err := os.Open(m.snapshotPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logrus.Debugf("No memory cache snapshot at %s, starting empty", m.snapshotPath)
+			return nil
+		}
+		return fmt.Errorf("failed to open memory cache snapshot: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	decoder := gob.NewDecoder(bufio.NewReader(f))
+	loaded := 0
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		var entry memoryEntry
+		if err := decoder.Decode(&entry); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("failed to read memory cache snapshot '%s': %w", m.snapshotPath, err)
+		}
+		// Skip entries that would be removed on access anyway
+		if m.ttl != 0 && time.Since(entry.ModTime) > m.ttl+m.staleTTL {
+			continue
+		}
+		m.entries[entry.Key] = &entry
+		loaded++
+	}
+	logrus.Infof("Loaded %d entries from memory cache snapshot %s", loaded, m.snapshotPath)
+	return nil
+}
+
+// Snapshot saves the entries to the snapshot file, if they changed since the last snapshot
+func (m *MemoryCache) Snapshot() error {
+	m.mu.Lock()
+	dirty := m.dirty
+	m.dirty = false
+	m.mu.Unlock()
+	if !dirty || m.snapshotPath == "" {
+		return nil
+	}
+
+	if err := m.writeSnapshot(); err != nil {
+		m.mu.Lock()
+		m.dirty = true // retry on next snapshot
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// writeSnapshot writes all entries to a temporary file, then replaces the snapshot file,
+// so a crash while writing never leaves a truncated snapshot
+func (m *MemoryCache) writeSnapshot() error {
+	entries := m.snapshotEntries("")
+
+	if err := os.MkdirAll(filepath.Dir(m.snapshotPath), 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.snapshotPath), filepath.Base(m.snapshotPath)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	writer := bufio.NewWriter(tmp)
+	encoder := gob.NewEncoder(writer)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			_ = tmp.Close()
+			return fmt.Errorf("failed to write snapshot: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), m.snapshotPath); err != nil {
+		return fmt.Errorf("failed to replace snapshot file: %w", err)
+	}
+	logrus.Debugf("Saved %d entries to memory cache snapshot %s", len(entries), m.snapshotPath)
+	return nil
+}
+
+// Close stops periodic snapshots and saves a last snapshot
+func (m *MemoryCache) Close() error {
+	if m.stop != nil {
+		close(m.stop)
+		<-m.done
+		m.stop = nil
+	}
+	return m.Snapshot()
+}
@@ -0,0 +1,107 @@
+// Evaluates rule conditions written in CEL (https://cel.dev) against requests and responses
+package condition
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+)
+
+// Condition is a compiled CEL expression, e.g. `req.host.endsWith(".example.com") && resp.status == 200`.
+//
+// Variables:
+//   - req: method, url, scheme, host, path (strings), query (first value of each parameter) and header (first value of each header, by canonical name)
+//   - resp: status and size (integers, size is -1 if unknown) and header
+type Condition struct {
+	program      cel.Program
+	usesResponse bool
+}
+
+var env = func() *cel.Env {
+	env, err := cel.NewEnv(
+		cel.Variable("req", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("resp", cel.MapType(cel.StringType, cel.DynType)),
+	)
+	if err != nil {
+		panic(fmt.Errorf("failed to create CEL environment: %w", err))
+	}
+	return env
+}()
+
+// Compile compiles a boolean CEL expression
+func Compile(expr string) (*Condition, error) {
+	ast, issues := env.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid expression '%s': %w", expr, issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("expression '%s' must be a boolean, got: %s", expr, ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression '%s': %w", expr, err)
+	}
+
+	condition := &Condition{program: program}
+	for _, reference := range ast.NativeRep().ReferenceMap() {
+		if reference.Name == "resp" {
+			condition.usesResponse = true
+		}
+	}
+	return condition, nil
+}
+
+// UsesResponse reports whether the condition depends on the response, so it cannot be evaluated on a request alone
+func (c *Condition) UsesResponse() bool {
+	return c.usesResponse
+}
+
+// Match evaluates the condition. resp may be nil if the condition does not use it
+func (c *Condition) Match(requ *http.Request, resp *http.Response) (bool, error) {
+	query := map[string]string{}
+	for name, values := range requ.URL.Query() {
+		query[name] = values[0]
+	}
+	vars := map[string]any{
+		"req": map[string]any{
+			"method": requ.Method,
+			"url":    requ.URL.String(),
+			"scheme": requ.URL.Scheme,
+			"host":   strings.ToLower(requ.URL.Host),
+			"path":   requ.URL.Path,
+			"query":  query,
+			"header": firstValues(requ.Header),
+		},
+		"resp": map[string]any{},
+	}
+	if resp != nil {
+		vars["resp"] = map[string]any{
+			"status": resp.StatusCode,
+			"size":   resp.ContentLength,
+			"header": firstValues(resp.Header),
+		}
+	}
+
+	out, _, err := c.program.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	matched, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression returned %v instead of a boolean", out.Value())
+	}
+	return matched, nil
+}
+
+// firstValues returns the first value of each header
+func firstValues(header http.Header) map[string]string {
+	values := make(map[string]string, len(header))
+	for name, value := range header {
+		if len(value) > 0 {
+			values[name] = value[0]
+		}
+	}
+	return values
+}
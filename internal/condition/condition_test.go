@@ -0,0 +1,48 @@
+package condition
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCondition(t *testing.T) {
+	requ, _ := http.NewRequest(http.MethodGet, "https://API.example.com/v1/users?page=2", nil)
+	requ.Header.Set("X-Foo", "bar")
+	resp := &http.Response{StatusCode: 404, ContentLength: 1200, Header: http.Header{"Content-Type": {"application/json"}}}
+
+	tests := []struct {
+		expr          string
+		want          bool
+		wantsResponse bool
+	}{
+		{expr: `req.host.endsWith(".example.com") && req.path.startsWith("/v1/")`, want: true},
+		{expr: `req.header["X-Foo"] == "bar" && req.query["page"] == "2"`, want: true},
+		{expr: `"Authorization" in req.header`, want: false},
+		{expr: `resp.status == 404 && resp.size > 1000`, want: true, wantsResponse: true},
+		{expr: `req.method == "GET" && resp.header["Content-Type"].startsWith("text/")`, want: false, wantsResponse: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			condition, err := Compile(tt.expr)
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			if condition.UsesResponse() != tt.wantsResponse {
+				t.Errorf("UsesResponse() = %v, want %v", condition.UsesResponse(), tt.wantsResponse)
+			}
+			got, err := condition.Match(requ, resp)
+			if err != nil {
+				t.Fatalf("Match() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, invalid := range []string{`req.host ==`, `req.host`, `unknown == 1`} {
+		if _, err := Compile(invalid); err == nil {
+			t.Errorf("Compile(%q) succeeded, want error", invalid)
+		}
+	}
+}
@@ -2,13 +2,16 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/knadh/koanf/parsers/yaml"
-	"github.com/knadh/koanf/providers/file"
+	"github.com/iTrooz/caching-dev-proxy/internal/condition"
+	"github.com/iTrooz/caching-dev-proxy/internal/cron"
+
 	"github.com/knadh/koanf/providers/structs"
 	"github.com/knadh/koanf/v2"
 	"github.com/sirupsen/logrus"
@@ -16,10 +19,230 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	Server ServerConfig `koanf:"server"`
-	Cache  CacheConfig  `koanf:"cache"`
-	Rules  RulesConfig  `koanf:"rules"`
-	Log    LogConfig    `koanf:"log"`
+	Server      ServerConfig      `koanf:"server"`
+	Cache       CacheConfig       `koanf:"cache"`
+	Rules       RulesConfig       `koanf:"rules"`
+	Log         LogConfig         `koanf:"log"`
+	History     HistoryConfig     `koanf:"history"`
+	Admin       AdminConfig       `koanf:"admin"`
+	Maintenance MaintenanceConfig `koanf:"maintenance"`
+	RateLimit   RateLimitConfig   `koanf:"rate_limit"`
+	// URLs refreshed in cache on a schedule, whether requested or not
+	Pinned PinnedConfig `koanf:"pinned"`
+	// External service consulted for caching decisions
+	DecisionService DecisionServiceConfig `koanf:"decision_service"`
+	// Other proxies asked for the entries missing from the cache
+	Peers PeersConfig `koanf:"peers"`
+	// Comparison of upstreams with a candidate version
+	Canary CanaryConfig `koanf:"canary"`
+	// Periodic analysis of stored entries, to guide storage configuration
+	StorageSampling StorageSamplingConfig `koanf:"storage_sampling"`
+	// Origins transparently sent to another one
+	Remap RemapConfig `koanf:"remap"`
+	// CORS headers added to responses, for browser apps calling third-party APIs
+	CORS CORSConfig `koanf:"cors"`
+	// Headers removed or replaced in the responses of some hosts sent to clients, e.g. security headers in the way of local development
+	ResponseHeaders ResponseHeadersConfig `koanf:"response_headers"`
+	// Lua script hooking into request handling
+	Script ScriptConfig `koanf:"script"`
+	// Background fetching of the links of cached pages
+	Prefetch PrefetchConfig `koanf:"prefetch"`
+	// How requests are sent upstream
+	Upstream UpstreamConfig `koanf:"upstream"`
+	// How upstream host names are resolved
+	DNS DNSConfig `koanf:"dns"`
+}
+
+// ResponseHeadersConfig configures headers removed or replaced in responses sent to clients, including cache hits.
+// Stored entries keep the headers of upstream
+type ResponseHeadersConfig struct {
+	// All the overrides matching the host of a request apply, in order
+	Overrides []ResponseHeaderOverride `koanf:"overrides"`
+}
+
+// ResponseHeaderOverride removes and sets headers of the responses of the hosts matching one of its patterns
+type ResponseHeaderOverride struct {
+	// Host patterns (see HostPattern)
+	Match []string `koanf:"match"`
+	// Headers removed, e.g. ["Content-Security-Policy", "Strict-Transport-Security"]
+	Remove []string `koanf:"remove"`
+	// Headers set, replacing existing values. Applied after remove
+	Set map[string]string `koanf:"set"`
+
+	// Match, parsed by ResponseHeadersConfig.Compile
+	patterns HostPatterns
+}
+
+// DNSConfig configures how upstream host names are resolved
+type DNSConfig struct {
+	// Static addresses of upstream hosts, like /etc/hosts entries. The first matching entry applies
+	Overrides []DNSOverride `koanf:"overrides"`
+	// Nameserver ("host" or "host:port") or DNS-over-HTTPS URL resolving the other hosts. Empty uses the system resolver
+	Resolver string `koanf:"resolver"`
+}
+
+// DNSOverride resolves the upstream hosts matching one of its patterns to a static address
+type DNSOverride struct {
+	// Host patterns (see HostPattern)
+	Match []string `koanf:"match"`
+	// IP address
+	Address string `koanf:"address"`
+
+	// Match, parsed by DNSConfig.Compile
+	patterns HostPatterns
+}
+
+// UpstreamConfig configures how requests are sent upstream
+type UpstreamConfig struct {
+	HTTP3 HTTP3Config `koanf:"http3"`
+	Retry RetryConfig `koanf:"retry"`
+	// Timeouts of upstream requests. Rules can override them
+	Timeouts TimeoutsConfig `koanf:"timeouts"`
+	// Connection pooling of upstream requests
+	Transport TransportConfig `koanf:"transport"`
+	// Settings of upstream hosts. For each setting, the first matching entry defining it applies
+	Hosts []UpstreamHost `koanf:"hosts"`
+	// URL of another caching-dev-proxy fetching the requests missing from the cache, so it caches them for all its clients.
+	// Empty fetches them from upstream directly
+	ParentCache string `koanf:"parent_cache"`
+	// Request headers removed from all requests before they are forwarded (and before cache keys are computed), e.g. ["Traceparent"]
+	RemoveRequestHeaders []string `koanf:"remove_request_headers"`
+}
+
+// ProxyDirect is the UpstreamHost.Proxy value connecting directly, ignoring the proxy environment variables
+const ProxyDirect = "direct"
+
+// UpstreamHost holds the settings of the upstream hosts matching one of its patterns
+type UpstreamHost struct {
+	// Host patterns (see HostPattern)
+	Match []string `koanf:"match"`
+	// Parent proxy URL (http, https, or socks5 and socks5h resolving host names on the proxy), or "direct".
+	// Hosts without one use the http_proxy, https_proxy and no_proxy environment variables
+	Proxy string `koanf:"proxy"`
+	// Client certificate and key (PEM files) presented to hosts requiring mutual TLS
+	ClientCert string `koanf:"client_cert"`
+	ClientKey  string `koanf:"client_key"`
+	// PEM bundle of CAs trusted for the host, in addition to the system ones
+	CABundle string `koanf:"ca_bundle"`
+	// Do not verify the certificate of the host. Certificates of other hosts are always verified
+	InsecureSkipVerify bool `koanf:"insecure_skip_verify"`
+
+	// Match, parsed by UpstreamConfig.Compile
+	patterns HostPatterns
+}
+
+// HTTP3Config configures fetching over HTTP/3 (QUIC)
+type HTTP3Config struct {
+	// Send requests over HTTP/3 to hosts advertising it in their Alt-Svc header, like browsers do.
+	// Falls back to HTTP/2 or HTTP/1.1 when HTTP/3 fails
+	Enabled bool `koanf:"enabled"`
+	// Hosts tried over HTTP/3 right away, without waiting for an Alt-Svc header
+	Hosts []string `koanf:"hosts"`
+}
+
+// RetryConfig configures retries of idempotent upstream requests (GET and HEAD without body)
+type RetryConfig struct {
+	// Retries after the first attempt. 0 disables retries
+	Attempts int `koanf:"attempts"`
+	// Wait before the first retry, doubled before each next one
+	Backoff string `koanf:"backoff"`
+	// Maximum wait between two attempts. Empty means no limit
+	MaxBackoff string `koanf:"max_backoff"`
+	// Response status codes retried (e.g. "503", "5xx"), in addition to connection errors
+	StatusCodes []string `koanf:"status_codes"`
+}
+
+// TimeoutsConfig holds timeouts of upstream requests, e.g. "30s". "0" means no limit.
+// In rules, empty values keep the ones of upstream.timeouts
+type TimeoutsConfig struct {
+	// Time to open a connection
+	Dial string `koanf:"dial"`
+	// Time of the TLS handshake of a connection
+	TLSHandshake string `koanf:"tls_handshake"`
+	// Time to receive response headers, once the request is sent
+	ResponseHeader string `koanf:"response_header"`
+	// Time of the whole request, body download included (and retries, see RetryConfig)
+	Total string `koanf:"total"`
+}
+
+// TransportConfig tunes the connections to upstream hosts
+type TransportConfig struct {
+	// Maximum idle connections kept open, all hosts together. 0 means unlimited
+	MaxIdleConns int `koanf:"max_idle_conns"`
+	// Maximum idle connections kept open per host. 0 uses the Go default (2)
+	MaxIdleConnsPerHost int `koanf:"max_idle_conns_per_host"`
+	// Time an idle connection is kept open. Empty means no limit
+	IdleConnTimeout string `koanf:"idle_conn_timeout"`
+	// Interval of TCP keep-alive probes. Empty uses the Go default (15s), negative disables them
+	KeepAlive string `koanf:"keep_alive"`
+	// Open a new connection for each request
+	DisableKeepAlives bool `koanf:"disable_keep_alives"`
+	// Do not ask upstream for gzip responses when clients did not
+	DisableCompression bool `koanf:"disable_compression"`
+}
+
+// Timeouts are parsed TimeoutsConfig, 0 meaning no limit
+type Timeouts struct {
+	Dial           time.Duration
+	TLSHandshake   time.Duration
+	ResponseHeader time.Duration
+	Total          time.Duration
+}
+
+// Override returns the timeouts with the values set in override replacing them
+func (t TimeoutsConfig) Override(override *TimeoutsConfig) TimeoutsConfig {
+	if override == nil {
+		return t
+	}
+	if override.Dial != "" {
+		t.Dial = override.Dial
+	}
+	if override.TLSHandshake != "" {
+		t.TLSHandshake = override.TLSHandshake
+	}
+	if override.ResponseHeader != "" {
+		t.ResponseHeader = override.ResponseHeader
+	}
+	if override.Total != "" {
+		t.Total = override.Total
+	}
+	return t
+}
+
+// Parse parses the timeouts
+func (t TimeoutsConfig) Parse() (timeouts Timeouts, err error) {
+	if timeouts.Dial, err = ParseOptionalDuration(t.Dial); err != nil {
+		return Timeouts{}, fmt.Errorf("invalid dial timeout: %w", err)
+	}
+	if timeouts.TLSHandshake, err = ParseOptionalDuration(t.TLSHandshake); err != nil {
+		return Timeouts{}, fmt.Errorf("invalid tls_handshake timeout: %w", err)
+	}
+	if timeouts.ResponseHeader, err = ParseOptionalDuration(t.ResponseHeader); err != nil {
+		return Timeouts{}, fmt.Errorf("invalid response_header timeout: %w", err)
+	}
+	if timeouts.Total, err = ParseOptionalDuration(t.Total); err != nil {
+		return Timeouts{}, fmt.Errorf("invalid total timeout: %w", err)
+	}
+	return timeouts, nil
+}
+
+// PrefetchConfig configures the prefetching of the same-host links of cached HTML and JSON responses
+type PrefetchConfig struct {
+	Enabled bool `koanf:"enabled"`
+	// Levels of links followed: 1 prefetches the links of requested pages, 2 also the links of prefetched pages, etc.
+	Depth int `koanf:"depth"`
+	// URL globs (see URLGlob) of the links prefetched. Empty prefetches all same-host links
+	Patterns []string `koanf:"patterns"`
+	// Maximum links prefetched per response
+	MaxLinks int `koanf:"max_links"`
+	// Number of concurrent prefetches
+	Workers int `koanf:"workers"`
+}
+
+// ScriptConfig configures the Lua script hooking into request handling (see script.Engine)
+type ScriptConfig struct {
+	// Script file. Empty disables scripting
+	File string `koanf:"file"`
 }
 
 // ServerConfig contains server-related configuration
@@ -27,10 +250,54 @@ type Config struct {
 type ServerConfig struct {
 	HTTP  HTTPConfig  `koanf:"http"`
 	HTTPS HTTPSConfig `koanf:"https"`
+	// Protect the proxy from clients opening too many connections or keeping them open
+	Limits LimitsConfig `koanf:"limits"`
+	// Stop the proxy after this long without requests, e.g. when started by systemd socket activation. Empty never stops
+	ExitWhenIdle string `koanf:"exit_when_idle"`
 }
 
+// LimitsConfig configures limits of client connections
+type LimitsConfig struct {
+	// Maximum number of concurrent client connections per listener. New connections wait for one to close. 0 means unlimited
+	MaxConnections int `koanf:"max_connections"`
+	// Maximum time to read a request, including its body. Empty means no limit
+	ReadTimeout string `koanf:"read_timeout"`
+	// Maximum time to write a response. Empty means no limit
+	WriteTimeout string `koanf:"write_timeout"`
+	// Time an idle keep-alive connection is kept open. Empty uses read_timeout
+	IdleTimeout string `koanf:"idle_timeout"`
+	// Maximum size of request headers (e.g. "64KB"). Empty uses the Go default (1MB)
+	MaxHeaderBytes string `koanf:"max_header_bytes"`
+	// Maximum number of concurrent proxied requests, all listeners together. 0 means unlimited
+	MaxRequests int `koanf:"max_requests"`
+	// Maximum number of concurrent TLS handshakes of intercepted connections. 0 means unlimited
+	MaxHandshakes int `koanf:"max_handshakes"`
+	// What happens to requests and handshakes over max_requests or max_handshakes: "queue" (default) or "reject"
+	OnOverload string `koanf:"on_overload"`
+	// Time requests and handshakes wait in queue before being rejected. Empty means no limit
+	QueueTimeout string `koanf:"queue_timeout"`
+}
+
+// Overload behaviors, see LimitsConfig.OnOverload
+const (
+	// Wait for a request or handshake to finish
+	OverloadQueue = "queue"
+	// Answer 503 Service Unavailable to requests, abort handshakes
+	OverloadReject = "reject"
+)
+
 type HTTPConfig struct {
 	Address string `koanf:"address"`
+	// Listener of HTTP connections redirected to the proxy by the firewall, for devices that cannot use a proxy
+	Transparent TransparentHTTPConfig `koanf:"transparent"`
+}
+
+// TransparentHTTPConfig configures the listener of HTTP connections redirected by iptables REDIRECT or TPROXY rules
+type TransparentHTTPConfig struct {
+	// Empty disables it
+	Address string `koanf:"address"`
+	// Listen for connections of TPROXY rules instead of REDIRECT ones (Linux only, requires CAP_NET_ADMIN)
+	TPROXY bool `koanf:"tproxy"`
 }
 
 type HTTPSConfig struct {
@@ -38,18 +305,173 @@ type HTTPSConfig struct {
 	CAKeyFile   string            `koanf:"ca_key_file"`
 	CACertFile  string            `koanf:"ca_cert_file"`
 	Transparent TransparentConfig `koanf:"transparent"`
+	// Request client certificates on intercepted connections, and isolate the cache of each certificate CN
+	ClientCertNamespace bool `koanf:"client_cert_namespace"`
+	// CA bundle verifying client certificates. Empty accepts any client certificate
+	ClientCAFile string `koanf:"client_ca_file"`
+	// Maximum number of generated host certificates kept in memory, least recently used evicted first. 0 means unlimited
+	CertCacheSize int `koanf:"cert_cache_size"`
+	// Host patterns (see HostPattern) whose connections are tunneled without interception, e.g. for hosts pinning their certificate
+	PassthroughHosts []string `koanf:"passthrough_hosts"`
 }
 
 type TransparentConfig struct {
 	Address string `koanf:"address"`
+	// Decisions on connections by SNI hostname, taken before interception. The first matching rule applies
+	SNIRules []SNIRule `koanf:"sni_rules"`
+	// Action of connections matching no SNI rule. Empty means "intercept"
+	SNIDefault SNIAction `koanf:"sni_default"`
+}
+
+// SNIAction is what happens to transparent HTTPS connections
+type SNIAction string
+
+const (
+	// Intercept the connection, to cache its requests
+	SNIActionIntercept SNIAction = "intercept"
+	// Tunnel the connection without interception
+	SNIActionPassthrough SNIAction = "passthrough"
+	// Close the connection
+	SNIActionBlock SNIAction = "block"
+)
+
+// SNIRule decides what happens to transparent HTTPS connections whose SNI hostname matches one of its patterns
+type SNIRule struct {
+	// Host patterns (see HostPattern)
+	Match  []string  `koanf:"match"`
+	Action SNIAction `koanf:"action"`
+
+	// Match, parsed by TransparentConfig.Compile
+	patterns HostPatterns
+}
+
+// SNIActionFor returns the action of the first SNI rule matching hostname, or the default one. Compile must have been called
+func (c *TransparentConfig) SNIActionFor(hostname string) SNIAction {
+	for _, rule := range c.SNIRules {
+		if rule.patterns.Match(hostname) {
+			return rule.Action
+		}
+	}
+	if c.SNIDefault == "" {
+		return SNIActionIntercept
+	}
+	return c.SNIDefault
+}
+
+// Compile parses the host patterns of the SNI rules, once rather than on each SNIActionFor call
+func (c *TransparentConfig) Compile() error {
+	for i := range c.SNIRules {
+		rule := &c.SNIRules[i]
+		if len(rule.Match) == 0 {
+			return fmt.Errorf("sni rule %d: match must list at least one host pattern", i)
+		}
+		patterns, err := ParseHostPatterns(rule.Match)
+		if err != nil {
+			return fmt.Errorf("sni rule %d: %w", i, err)
+		}
+		rule.patterns = patterns
+	}
+	return nil
 }
 
 // CacheConfig contains cache-related configuration
 type CacheConfig struct {
-	TTL    string `koanf:"ttl"`
-	Folder string `koanf:"folder"`
+	TTL string `koanf:"ttl"`
+	// "normal", "record" (always fetch upstream and store) or "replay" (only serve from cache, fail on miss)
+	Mode CacheMode `koanf:"mode"`
+	// Storage of cached entries: "disk" (in folder) or "memory"
+	Backend string `koanf:"backend"`
+	Folder  string `koanf:"folder"`
+	// The folder is on a network filesystem (NFS, SMB), possibly shared between machines
+	NetworkFS bool `koanf:"network_fs"`
+	// Files of the disk backend: "mirror" (named after URLs) or "hashed" (sharded by key hash, for millions of entries)
+	Layout string `koanf:"layout"`
+	// Persistence of the memory backend across restarts
+	Snapshot SnapshotConfig `koanf:"snapshot"`
+	// Store responses in cache in the background, off the response path
+	AsyncWrites AsyncWritesConfig `koanf:"async_writes"`
+	// Ordered backends (e.g. ["memory", "disk"]), replacing backend. Reads fall through, writes go to all backends
+	Chain []string `koanf:"chain"`
+	// Time a failing backend of the chain is skipped before being retried
+	ChainRetryInterval string `koanf:"chain_retry_interval"`
+	// Disk cache read when this cache misses, e.g. shared by a team on a network filesystem
+	Secondary SecondaryCacheConfig `koanf:"secondary"`
+	// Included in all cache keys. Changing it starts from an empty cache, without deleting the previous entries
+	Namespace string `koanf:"namespace"`
+	// Time expired entries are kept to be served stale (e.g. by placeholders). Empty removes them on expiry
+	StaleTTL string `koanf:"stale_ttl"`
+	// Query parameters ignored in cache keys for every request
+	IgnoreQueryParams []string `koanf:"ignore_query_params"`
+	// Request headers hashed into cache keys
+	KeyHeaders []string `koanf:"key_headers"`
+	// Maximum total size of cached entries (e.g. "500MB", "10GiB"). Empty means unlimited
+	MaxSize string `koanf:"max_size"`
+	// Maximum request body size buffered to be hashed into cache keys (e.g. "10MB"). Larger bodies are streamed upstream
+	// and their responses not cached. Empty means unlimited
+	MaxRequestBody string `koanf:"max_request_body"`
+	// Maximum number of cached entries, the oldest written being evicted first. 0 means unlimited
+	MaxEntries int `koanf:"max_entries"`
+	// Entries evicted first when the cache exceeds max_size: "lru", "lfu", "gdsf" (size-weighted) or "ttl" (expiring first)
+	EvictionPolicy string `koanf:"eviction_policy"`
+	// Maximum difference between upstream Date headers and the local clock before warning. Empty disables the check
+	ClockSkewThreshold string `koanf:"clock_skew_threshold"`
+	// Add an X-Cache-Digest header with the SHA-256 of the stored body to cached responses
+	DigestHeader bool `koanf:"digest_header"`
+	// Add X-Cache-Key and X-Cache-Age headers to responses, to understand cache hits and misses
+	DebugHeaders bool `koanf:"debug_headers"`
+	// Headers redacted from stored entries, while still sent to clients
+	Redact RedactConfig `koanf:"redact"`
+	// Query parameter whose value ("bypass", "refresh" or "purge") acts as the matching X-Cache-* request header. Empty disables it
+	ControlParam string `koanf:"control_param"`
+}
+
+// SnapshotConfig configures snapshots of the memory cache backend
+type SnapshotConfig struct {
+	// File the memory cache is saved to, and loaded from on start. Empty disables snapshots
+	Path string `koanf:"path"`
+	// Time between two snapshots. Empty only saves on shutdown
+	Interval string `koanf:"interval"`
+}
+
+// SecondaryCacheConfig configures the disk cache read when the cache misses. It is read only: entries are stored in the cache only
+type SecondaryCacheConfig struct {
+	// Folder of the secondary cache. Empty disables it
+	Folder    string `koanf:"folder"`
+	NetworkFS bool   `koanf:"network_fs"`
+	// "mirror" or "hashed", as the layout of the cache
+	Layout string `koanf:"layout"`
+	// Store the entries found in the secondary cache in the cache
+	WriteBack bool `koanf:"write_back"`
+}
+
+// RedactConfig configures the headers redacted from stored entries (responses and their requests), e.g. so secrets are not persisted in cache files
+type RedactConfig struct {
+	// Names of the headers, e.g. ["Set-Cookie", "Authorization"]
+	Headers []string `koanf:"headers"`
+	// Remove the headers instead of replacing their values with "[REDACTED]"
+	Remove bool `koanf:"remove"`
+}
+
+// AsyncWritesConfig configures the background workers storing responses in cache
+type AsyncWritesConfig struct {
+	// Number of workers. 0 stores responses before sending them to clients
+	Workers int `koanf:"workers"`
+	// Maximum responses waiting to be stored. When the queue is full, responses are stored before being sent
+	QueueSize int `koanf:"queue_size"`
 }
 
+// CacheMode selects how the cache is used
+type CacheMode string
+
+const (
+	// Serve from cache, fetch upstream on miss and store responses allowed by the rules
+	CacheModeNormal CacheMode = "normal"
+	// Always fetch upstream and store all responses, to record a session
+	CacheModeRecord CacheMode = "record"
+	// Only serve from cache, ignoring expiry, and fail on miss, for deterministic test runs
+	CacheModeReplay CacheMode = "replay"
+)
+
 // RulesMode represents the mode of rule evaluation (whitelist or blacklist)
 type RulesMode string
 
@@ -58,21 +480,297 @@ const (
 	RulesModeBlacklist RulesMode = "blacklist"
 )
 
+// RuleAction is what happens to requests matching a rule
+type RuleAction string
+
+const (
+	// Store responses in cache
+	RuleActionCache RuleAction = "cache"
+	// Do not store responses
+	RuleActionBypass RuleAction = "bypass"
+	// Answer 403 Forbidden without contacting upstream
+	RuleActionBlock RuleAction = "block"
+	// Answer with the mock response of the rule
+	RuleActionMock RuleAction = "mock"
+)
+
+// validSNIAction reports whether action is a known SNI action
+func validSNIAction(action SNIAction) bool {
+	return action == SNIActionIntercept || action == SNIActionPassthrough || action == SNIActionBlock
+}
+
+// ActionOf returns the action of a rule: its own, or the default one of the mode ("cache" in whitelist mode, "bypass" in blacklist mode)
+func (c *RulesConfig) ActionOf(rule *CacheRule) RuleAction {
+	switch {
+	case rule.Action != "":
+		return rule.Action
+	case rule.Mock != nil:
+		return RuleActionMock
+	case rule.Block != nil:
+		return RuleActionBlock
+	case c.Mode == RulesModeWhitelist:
+		return RuleActionCache
+	default:
+		return RuleActionBypass
+	}
+}
+
 type LogConfig struct {
 	Level      string `koanf:"level"`
+	Format     string `koanf:"format"` // "text" or "json"
 	ThirdParty bool   `koanf:"third_party"`
+	// Time between two summaries of request statistics. Empty disables them
+	StatsInterval string `koanf:"stats_interval"`
+	// File appended with one JSON object per request, for tools to build reports from. Empty disables it
+	EventsFile string `koanf:"events_file"`
+}
+
+// StorageSamplingConfig configures the periodic sampling of stored entries, estimating compression ratios and duplicate bodies per host
+type StorageSamplingConfig struct {
+	// Time between two samplings. Empty disables sampling
+	Interval string `koanf:"interval"`
+	// Number of entries sampled each time
+	Entries int `koanf:"entries"`
+}
+
+// HistoryConfig contains the persistent request history configuration
+type HistoryConfig struct {
+	Enabled bool   `koanf:"enabled"`
+	Path    string `koanf:"path"` // SQLite database file
+	// Records older than this are removed. Empty means no time limit
+	Retention string `koanf:"retention"`
+	// Maximum number of records kept. 0 means no limit
+	MaxRecords int `koanf:"max_records"`
+}
+
+// AdminConfig contains the admin API configuration
+type AdminConfig struct {
+	Address string `koanf:"address"` // Empty disables the admin API
+}
+
+// MaintenanceConfig contains the scheduling of background maintenance jobs
+type MaintenanceConfig struct {
+	// Cron expressions of maintenance window starts, e.g. "0 2 * * *". No windows disables maintenance jobs
+	Windows []string `koanf:"windows"`
+	// Duration of each window
+	Duration string `koanf:"duration"`
+	// Jobs run at each window start: "gc", "verify", "compact", "refresh"
+	Jobs []string `koanf:"jobs"`
+	// Pause between two entries processed by jobs still running after the window ends
+	Throttle string `koanf:"throttle"`
+}
+
+// PinnedConfig contains the URLs kept fresh in cache
+type PinnedConfig struct {
+	URLs []PinnedURL `koanf:"urls"`
+}
+
+// PinnedURL is a URL fetched into the cache on a schedule, so it is always fresh
+type PinnedURL struct {
+	// Absolute http(s) URL, requested with GET
+	URL string `koanf:"url"`
+	// Cron expression of the refreshes, e.g. "*/15 * * * *"
+	Schedule string `koanf:"schedule"`
+	// Request headers, e.g. those the cache key is generated from (see cache.key_headers)
+	Headers map[string]string `koanf:"headers"`
+}
+
+// RateLimitConfig configures the handling of upstream 429 Too Many Requests responses
+type RateLimitConfig struct {
+	// Stop sending requests to endpoints answering 429 until their Retry-After passed, serving stale entries or 429 instead
+	Enabled bool `koanf:"enabled"`
+	// Window used when the 429 response has no valid Retry-After header
+	DefaultRetryAfter string `koanf:"default_retry_after"`
+	// Maximum window, protecting against huge Retry-After values. Empty means no limit
+	MaxRetryAfter string `koanf:"max_retry_after"`
+}
+
+// DecisionServiceConfig configures the external cache decision service
+type DecisionServiceConfig struct {
+	// URL receiving a POST with the request and response of each upstream response. Empty disables it
+	URL string `koanf:"url"`
+	// Maximum time to wait for a decision, before falling back to local rules
+	Timeout string `koanf:"timeout"`
+}
+
+// PeersConfig configures the other proxies asked for the entries missing from the cache, before upstream,
+// and the endpoint serving the entries of this proxy to them
+type PeersConfig struct {
+	// Base URLs of the peer endpoints of the peers (e.g. http://alice.lan:8082). Empty disables it
+	URLs []string `koanf:"urls"`
+	// Maximum time to wait for the peers, before going upstream
+	Timeout string `koanf:"timeout"`
+	// Address of the peer endpoint, serving cache entries read-only to the peers. Empty disables it
+	Address string `koanf:"address"`
+	// Shared token sent to the peers, and required from them by the peer endpoint. Empty sends and requires none
+	Token string `koanf:"token"`
 }
 
+// CanaryConfig configures the comparison of upstream responses with the ones of candidate upstreams
+type CanaryConfig struct {
+	// JSON lines file the comparisons are appended to
+	Report      string             `koanf:"report"`
+	Comparisons []CanaryComparison `koanf:"comparisons"`
+}
+
+// CanaryComparison sends requests starting with BaseURI to Candidate too
+type CanaryComparison struct {
+	BaseURI string `koanf:"base_uri"`
+	// Replaces BaseURI in the URL of requests sent to the candidate
+	Candidate string `koanf:"candidate"`
+	// Response sent to the client: "primary" (default, the candidate is requested in the background) or "candidate"
+	Serve string `koanf:"serve"`
+}
+
+// CORSConfig configures the CORS headers added to responses and the preflight requests answered locally
+type CORSConfig struct {
+	// Add CORS headers to all responses. Rules can enable them for matching requests only
+	Enabled bool `koanf:"enabled"`
+	// Access-Control-Allow-Origin. "*" echoes the Origin of the request, so it also works with credentials
+	AllowOrigin string `koanf:"allow_origin"`
+	// Methods allowed by preflight responses. Empty allows the requested one
+	AllowMethods []string `koanf:"allow_methods"`
+	// Headers allowed by preflight responses. Empty allows the requested ones
+	AllowHeaders []string `koanf:"allow_headers"`
+	// Response headers exposed to scripts
+	ExposeHeaders    []string `koanf:"expose_headers"`
+	AllowCredentials bool     `koanf:"allow_credentials"`
+	// Time browsers may cache preflight responses
+	MaxAge string `koanf:"max_age"`
+}
+
+// RemapConfig configures the origins requests are transparently sent to instead of the requested ones
+type RemapConfig struct {
+	Origins []OriginRemap `koanf:"origins"`
+}
+
+// OriginRemap sends requests starting with From to To instead
+type OriginRemap struct {
+	From string `koanf:"from"`
+	// Replaces From in the URL of matching requests
+	To string `koanf:"to"`
+}
+
+// RulesConfig holds the rules, evaluated in order: the first matching rule decides.
+// The mode sets the default action of rules and what happens to requests matching no rule
 type RulesConfig struct {
-	Mode  RulesMode   `koanf:"mode"` // "whitelist" or "blacklist"
+	Mode  RulesMode   `koanf:"mode"` // "whitelist" (cache matching requests only) or "blacklist" (cache all but matching requests)
 	Rules []CacheRule `koanf:"rules"`
 }
 
 // CacheRule defines a caching rule
 type CacheRule struct {
+	// URL prefix of matching requests, or glob pattern if it contains "*" (see URLGlob)
 	BaseURI     string   `koanf:"base_uri"`
 	Methods     []string `koanf:"methods"`
 	StatusCodes []string `koanf:"status_codes,omitempty"` // e.g., ["200", "404", "4xx", "5xx"]
+	// Requests excluded from the rule: path patterns (or URL patterns, if they have a scheme) where "*" matches any characters.
+	// Patterns without "*" are prefixes, e.g. ["/auth/", "/realtime/*"]
+	Except []string `koanf:"except,omitempty"`
+	// CEL expression matching requests must meet, e.g. 'req.header["X-Foo"] == "bar" && resp.status == 200' (see condition.Condition)
+	When string `koanf:"when,omitempty"`
+	// "cache", "bypass", "block" or "mock". Empty means "mock" if mock is set, "block" if block is set, else the default of the rules mode (see RulesConfig.ActionOf)
+	Action RuleAction `koanf:"action,omitempty"`
+	// Request headers matching requests must have, with these values. An empty value accepts any value, e.g. {"X-Requested-With": "fetch"}
+	MatchHeaders map[string]string `koanf:"match_headers,omitempty"`
+	// Request headers matching requests must not have, e.g. ["Authorization"]
+	AbsentHeaders []string `koanf:"absent_headers,omitempty"`
+	// Query parameters matching requests must have, with these values. An empty value accepts any value, e.g. {"page": ""}
+	MatchQuery map[string]string `koanf:"match_query,omitempty"`
+	// Query parameters matching requests must not have, e.g. ["live"]
+	AbsentQuery []string `koanf:"absent_query,omitempty"`
+	// Bounds of the response body size, e.g. "1MB". Empty or 0 means unbounded. Responses of unknown size are buffered to be measured
+	MinSize string `koanf:"min_size,omitempty"`
+	MaxSize string `koanf:"max_size,omitempty"`
+	// Query parameters ignored in cache keys of matching requests, e.g. ["utm_source", "_ts"]
+	IgnoreQueryParams []string `koanf:"ignore_query_params,omitempty"`
+	// Request headers hashed into cache keys of matching requests, replacing cache.key_headers
+	KeyHeaders []string `koanf:"key_headers,omitempty"`
+	// Answer slow cache misses immediately while upstream is fetched in the background
+	Placeholder PlaceholderConfig `koanf:"placeholder,omitempty"`
+	// Answer when upstream is down (connection error, 502, 503 or 504): "pass" the error through (default),
+	// serve the "stale" entry, or return a 503 JSON "error" envelope
+	OnUpstreamError string `koanf:"on_upstream_error,omitempty"`
+	// Answer matching requests with a mock response, without contacting upstream
+	Mock *MockConfig `koanf:"mock,omitempty"`
+	// Response of block rules, answered without contacting upstream. nil answers 403 Forbidden
+	Block *BlockConfig `koanf:"block,omitempty"`
+	// Transform upstream responses of matching requests, before they are cached
+	Rewrite *RewriteConfig `koanf:"rewrite,omitempty"`
+	// Headers set on matching requests before they are forwarded, e.g. {"Authorization": "Bearer ${API_TOKEN}"}.
+	// Environment variables in values are expanded. The first matching rule setting a header wins
+	RequestHeaders map[string]string `koanf:"request_headers,omitempty"`
+	// Headers removed from matching requests before they are forwarded, in addition to upstream.remove_request_headers.
+	// Removed before request_headers are set
+	RemoveRequestHeaders []string `koanf:"remove_request_headers,omitempty"`
+	// Delay responses of matching requests, to simulate slow networks or APIs
+	Latency *LatencyConfig `koanf:"latency,omitempty"`
+	// Add CORS headers (see the cors section) to responses of matching requests, and answer their preflight requests
+	CORS bool `koanf:"cors,omitempty"`
+	// Pass responses of unknown length of matching requests through unbuffered and uncached, like Server-Sent Events
+	Stream bool `koanf:"stream,omitempty"`
+	// Timeouts of upstream requests of matching requests, replacing the ones of upstream.timeouts that are set
+	Timeouts *TimeoutsConfig `koanf:"timeouts,omitempty"`
+}
+
+// LatencyConfig describes a delay added to responses
+type LatencyConfig struct {
+	// Minimum delay, e.g. "500ms"
+	Delay string `koanf:"delay"`
+	// If set, the delay is random between delay and max_delay
+	MaxDelay string `koanf:"max_delay"`
+	// Delay only "hit" (served from cache) or "miss" (fetched from upstream) responses. Empty delays both
+	On string `koanf:"on"`
+}
+
+// RewriteConfig describes transformations of upstream responses
+type RewriteConfig struct {
+	// Replaces the status code. 0 keeps it
+	Status int `koanf:"status"`
+	// Headers set, replacing existing values
+	SetHeaders map[string]string `koanf:"set_headers"`
+	// Headers added, keeping existing values
+	AddHeaders map[string]string `koanf:"add_headers"`
+	// Headers removed
+	RemoveHeaders []string `koanf:"remove_headers"`
+	// Replacements applied to the body, in order
+	Body []BodyReplacement `koanf:"body"`
+}
+
+// BodyReplacement replaces the matches of a regular expression in a body
+type BodyReplacement struct {
+	Find string `koanf:"find"`
+	// May refer to submatches of find, e.g. "${1}"
+	Replace string `koanf:"replace"`
+}
+
+// MockConfig describes a mock response
+type MockConfig struct {
+	// Status code. 0 means 200
+	Status  int               `koanf:"status"`
+	Headers map[string]string `koanf:"headers"`
+	// Inline body
+	Body string `koanf:"body"`
+	// File whose content is the body, read on each request. Its extension sets the default Content-Type
+	File string `koanf:"file"`
+}
+
+// BlockConfig describes the response of block rules
+type BlockConfig struct {
+	// Status code, e.g. 451. 0 means 403
+	Status int `koanf:"status"`
+	// Body, sent as text. Empty names the blocking rule
+	Body string `koanf:"body"`
+}
+
+// PlaceholderConfig configures placeholder responses for slow cache misses
+type PlaceholderConfig struct {
+	// Time to wait for upstream before answering with a placeholder. Empty disables placeholders
+	After string `koanf:"after"`
+	// Retry-After sent along the 202 Accepted placeholder
+	RetryAfter string `koanf:"retry_after"`
+	// Serve the expired entry (see cache.stale_ttl) instead of 202 Accepted when available
+	Stale bool `koanf:"stale"`
 }
 
 // DefaultConfig holds the default configuration values
@@ -80,27 +778,187 @@ var DefaultConfig = Config{
 	Server: ServerConfig{
 		HTTP: HTTPConfig{
 			Address: ":8080",
+			Transparent: TransparentHTTPConfig{
+				Address: "",
+				TPROXY:  false,
+			},
 		},
 		HTTPS: HTTPSConfig{
 			Enabled:    true,
 			CAKeyFile:  "",
 			CACertFile: "",
 			Transparent: TransparentConfig{
-				Address: ":8443",
+				Address:    ":8443",
+				SNIRules:   []SNIRule{},
+				SNIDefault: SNIActionIntercept,
 			},
+			CertCacheSize:    1000,
+			PassthroughHosts: []string{},
+		},
+		Limits: LimitsConfig{
+			MaxConnections: 0,
+			ReadTimeout:    "",
+			WriteTimeout:   "",
+			IdleTimeout:    "2m",
+			MaxHeaderBytes: "",
+			MaxRequests:    0,
+			MaxHandshakes:  0,
+			OnOverload:     OverloadQueue,
+			QueueTimeout:   "",
 		},
+		ExitWhenIdle: "",
 	},
 	Cache: CacheConfig{
-		TTL:    "",
-		Folder: "./cache",
+		TTL:                "",
+		Mode:               CacheModeNormal,
+		Backend:            "disk",
+		Folder:             "./cache",
+		NetworkFS:          false,
+		Layout:             "mirror",
+		Namespace:          "",
+		StaleTTL:           "",
+		IgnoreQueryParams:  []string{},
+		KeyHeaders:         []string{"Host", "Accept", "Accept-Encoding", "Accept-Language", "Content-Type"},
+		ClockSkewThreshold: "1m",
+		MaxSize:            "",
+		MaxEntries:         0,
+		MaxRequestBody:     "",
+		EvictionPolicy:     "lru",
+		Snapshot: SnapshotConfig{
+			Path:     "",
+			Interval: "5m",
+		},
+		AsyncWrites: AsyncWritesConfig{
+			Workers:   0,
+			QueueSize: 100,
+		},
+		Chain:              []string{},
+		ChainRetryInterval: "30s",
+		DigestHeader:       false,
+		DebugHeaders:       false,
+		ControlParam:       "",
+		Secondary: SecondaryCacheConfig{
+			Folder:    "",
+			NetworkFS: false,
+			Layout:    "mirror",
+			WriteBack: true,
+		},
+		Redact: RedactConfig{
+			Headers: []string{},
+			Remove:  false,
+		},
 	},
 	Rules: RulesConfig{
 		Mode:  RulesModeBlacklist,
 		Rules: []CacheRule{},
 	},
 	Log: LogConfig{
-		Level:      "info",
-		ThirdParty: false,
+		Level:         "info",
+		Format:        "text",
+		ThirdParty:    false,
+		StatsInterval: "1h",
+		EventsFile:    "",
+	},
+	History: HistoryConfig{
+		Enabled:    false,
+		Path:       "./history.db",
+		Retention:  "168h",
+		MaxRecords: 100000,
+	},
+	Admin: AdminConfig{
+		Address: "",
+	},
+	RateLimit: RateLimitConfig{
+		Enabled:           true,
+		DefaultRetryAfter: "30s",
+		MaxRetryAfter:     "1h",
+	},
+	DecisionService: DecisionServiceConfig{
+		URL:     "",
+		Timeout: "200ms",
+	},
+	Peers: PeersConfig{
+		URLs:    []string{},
+		Timeout: "300ms",
+		Address: "",
+		Token:   "",
+	},
+	Canary: CanaryConfig{
+		Report:      "./canary-report.jsonl",
+		Comparisons: []CanaryComparison{},
+	},
+	Remap: RemapConfig{
+		Origins: []OriginRemap{},
+	},
+	Script: ScriptConfig{
+		File: "",
+	},
+	Prefetch: PrefetchConfig{
+		Enabled:  false,
+		Depth:    1,
+		Patterns: []string{},
+		MaxLinks: 50,
+		Workers:  2,
+	},
+	Upstream: UpstreamConfig{
+		HTTP3: HTTP3Config{
+			Enabled: false,
+			Hosts:   []string{},
+		},
+		// Same as http.DefaultTransport, without limiting slow downloads
+		Timeouts: TimeoutsConfig{
+			Dial:           "30s",
+			TLSHandshake:   "10s",
+			ResponseHeader: "",
+			Total:          "",
+		},
+		// Same as http.DefaultTransport
+		Transport: TransportConfig{
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 0,
+			IdleConnTimeout:     "90s",
+			KeepAlive:           "30s",
+			DisableKeepAlives:   false,
+			DisableCompression:  false,
+		},
+		Retry: RetryConfig{
+			Attempts:    0,
+			Backoff:     "200ms",
+			MaxBackoff:  "5s",
+			StatusCodes: []string{"502", "503"},
+		},
+		Hosts:                []UpstreamHost{},
+		ParentCache:          "",
+		RemoveRequestHeaders: []string{},
+	},
+	DNS: DNSConfig{
+		Overrides: []DNSOverride{},
+		Resolver:  "",
+	},
+	CORS: CORSConfig{
+		Enabled:          false,
+		AllowOrigin:      "*",
+		AllowMethods:     []string{},
+		AllowHeaders:     []string{},
+		ExposeHeaders:    []string{},
+		AllowCredentials: false,
+		MaxAge:           "10m",
+	},
+	ResponseHeaders: ResponseHeadersConfig{
+		Overrides: []ResponseHeaderOverride{},
+	},
+	StorageSampling: StorageSamplingConfig{
+		Interval: "",
+		Entries:  200,
+	},
+	Maintenance: MaintenanceConfig{
+		Windows:  []string{},
+		Duration: "1h",
+		Jobs:     []string{"gc", "verify", "compact"},
+		Throttle: "100ms",
+	},
+	Pinned: PinnedConfig{
+		URLs: []PinnedURL{},
 	},
 }
 
@@ -113,11 +971,15 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("loading defaults: %w", err)
 	}
 
-	// Load YAML file if present
+	// Load YAML file if present, with the files it includes
 	logrus.Debugf("Loading config from %s", path)
 	if _, err := os.Stat(path); err == nil {
-		if err := k.Load(file.Provider(path), yaml.Parser()); err != nil {
-			return nil, fmt.Errorf("loading config file: %w", err)
+		layers, err := loadLayers(path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := mergeLayers(k, layers); err != nil {
+			return nil, err
 		}
 	}
 
@@ -129,6 +991,24 @@ func Load(path string) (*Config, error) {
 	return &config, nil
 }
 
+// ToMap returns the configuration as a map keyed like the configuration file
+func (c *Config) ToMap() (map[string]any, error) {
+	k := koanf.New(":")
+	if err := k.Load(structs.Provider(c, "koanf"), nil); err != nil {
+		return nil, fmt.Errorf("converting config: %w", err)
+	}
+	return k.Raw(), nil
+}
+
+// ParseOptionalDuration parses a duration, returning 0 for an empty string
+func ParseOptionalDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	} else {
+		return time.ParseDuration(value)
+	}
+}
+
 // GetCacheTTL parses and returns the cache TTL duration
 func (c *Config) GetCacheTTL() (time.Duration, error) {
 	if c.Cache.TTL == "" {
@@ -138,16 +1018,534 @@ func (c *Config) GetCacheTTL() (time.Duration, error) {
 	}
 }
 
+// GetStaleTTL parses and returns the duration expired entries are kept for stale serving
+func (c *Config) GetStaleTTL() (time.Duration, error) {
+	return ParseOptionalDuration(c.Cache.StaleTTL)
+}
+
+// sizeUnits maps size suffixes to their number of bytes
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	// Longest suffixes first
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// ParseSize parses a size in bytes with an optional unit (e.g. "1024", "500MB", "10GiB"). Empty returns 0
+func ParseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	number := value
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(value), strings.ToUpper(unit.suffix)) {
+			number = strings.TrimSpace(value[:len(value)-len(unit.suffix)])
+			multiplier = unit.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// GetCacheMaxSize parses and returns the maximum cache size in bytes
+func (c *Config) GetCacheMaxSize() (int64, error) {
+	return ParseSize(c.Cache.MaxSize)
+}
+
+// GetMaxRequestBody parses and returns the maximum request body size buffered to compute cache keys, 0 if unlimited
+func (c *Config) GetMaxRequestBody() (int64, error) {
+	return ParseSize(c.Cache.MaxRequestBody)
+}
+
+// GetSnapshotInterval parses and returns the time between two memory cache snapshots
+func (c *Config) GetSnapshotInterval() (time.Duration, error) {
+	return ParseOptionalDuration(c.Cache.Snapshot.Interval)
+}
+
+// GetChainRetryInterval parses and returns the time a failing backend of the chain is skipped
+func (c *Config) GetChainRetryInterval() (time.Duration, error) {
+	return ParseOptionalDuration(c.Cache.ChainRetryInterval)
+}
+
+// GetClockSkewThreshold parses and returns the clock skew warning threshold
+func (c *Config) GetClockSkewThreshold() (time.Duration, error) {
+	if c.Cache.ClockSkewThreshold == "" {
+		return 0, nil // disabled
+	} else {
+		return time.ParseDuration(c.Cache.ClockSkewThreshold)
+	}
+}
+
+// GetHistoryRetention parses and returns the history retention duration
+func (c *Config) GetHistoryRetention() (time.Duration, error) {
+	if c.History.Retention == "" {
+		return 0, nil // infinite
+	} else {
+		return time.ParseDuration(c.History.Retention)
+	}
+}
+
+// GetMaintenanceDuration parses and returns the duration of maintenance windows
+func (c *Config) GetMaintenanceDuration() (time.Duration, error) {
+	return ParseOptionalDuration(c.Maintenance.Duration)
+}
+
+// GetMaintenanceThrottle parses and returns the pause of maintenance jobs outside of windows
+func (c *Config) GetMaintenanceThrottle() (time.Duration, error) {
+	return ParseOptionalDuration(c.Maintenance.Throttle)
+}
+
+// GetRateLimitRetryAfter parses and returns the default and maximum rate limit windows
+func (c *Config) GetRateLimitRetryAfter() (time.Duration, time.Duration, error) {
+	defaultRetryAfter, err := ParseOptionalDuration(c.RateLimit.DefaultRetryAfter)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid default_retry_after: %w", err)
+	}
+	maxRetryAfter, err := ParseOptionalDuration(c.RateLimit.MaxRetryAfter)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid max_retry_after: %w", err)
+	}
+	return defaultRetryAfter, maxRetryAfter, nil
+}
+
+// GetStatsInterval parses and returns the time between two statistics summaries, 0 if disabled
+func (c *Config) GetStatsInterval() (time.Duration, error) {
+	return ParseOptionalDuration(c.Log.StatsInterval)
+}
+
+// GetStorageSamplingInterval parses and returns the time between two samplings of stored entries, 0 if disabled
+func (c *Config) GetStorageSamplingInterval() (time.Duration, error) {
+	return ParseOptionalDuration(c.StorageSampling.Interval)
+}
+
+// GetDecisionServiceTimeout parses and returns the decision service timeout
+func (c *Config) GetDecisionServiceTimeout() (time.Duration, error) {
+	return ParseOptionalDuration(c.DecisionService.Timeout)
+}
+
+// GetPeersTimeout parses and returns the time to wait for peers
+func (c *Config) GetPeersTimeout() (time.Duration, error) {
+	return ParseOptionalDuration(c.Peers.Timeout)
+}
+
+// GetRetryBackoff parses and returns the wait before the first retry of upstream requests, and the maximum wait (0 if unlimited)
+func (c *Config) GetRetryBackoff() (backoff time.Duration, maxBackoff time.Duration, err error) {
+	if backoff, err = ParseOptionalDuration(c.Upstream.Retry.Backoff); err != nil {
+		return 0, 0, fmt.Errorf("invalid backoff: %w", err)
+	}
+	if maxBackoff, err = ParseOptionalDuration(c.Upstream.Retry.MaxBackoff); err != nil {
+		return 0, 0, fmt.Errorf("invalid max backoff: %w", err)
+	}
+	return backoff, maxBackoff, nil
+}
+
+// GetTransportDurations parses and returns the idle connection timeout (0 if unlimited) and TCP keep-alive interval of upstream connections
+func (c *Config) GetTransportDurations() (idleConnTimeout time.Duration, keepAlive time.Duration, err error) {
+	if idleConnTimeout, err = ParseOptionalDuration(c.Upstream.Transport.IdleConnTimeout); err != nil {
+		return 0, 0, fmt.Errorf("invalid idle connection timeout: %w", err)
+	}
+	if keepAlive, err = ParseOptionalDuration(c.Upstream.Transport.KeepAlive); err != nil {
+		return 0, 0, fmt.Errorf("invalid keep-alive interval: %w", err)
+	}
+	return idleConnTimeout, keepAlive, nil
+}
+
+// GetServerTimeouts parses and returns the read, write and idle timeouts of client connections, 0 if unlimited
+func (c *Config) GetServerTimeouts() (read time.Duration, write time.Duration, idle time.Duration, err error) {
+	if read, err = ParseOptionalDuration(c.Server.Limits.ReadTimeout); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid read timeout: %w", err)
+	}
+	if write, err = ParseOptionalDuration(c.Server.Limits.WriteTimeout); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid write timeout: %w", err)
+	}
+	if idle, err = ParseOptionalDuration(c.Server.Limits.IdleTimeout); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid idle timeout: %w", err)
+	}
+	return read, write, idle, nil
+}
+
+// GetMaxHeaderBytes parses and returns the maximum size of request headers, 0 for the default
+func (c *Config) GetMaxHeaderBytes() (int, error) {
+	size, err := ParseSize(c.Server.Limits.MaxHeaderBytes)
+	return int(size), err
+}
+
+// GetQueueTimeout parses and returns the time requests and handshakes wait for a slot, 0 for no limit
+func (c *Config) GetQueueTimeout() (time.Duration, error) {
+	return ParseOptionalDuration(c.Server.Limits.QueueTimeout)
+}
+
 // Validate validates the configuration
 func (c *Config) Validate() error {
+	if _, _, _, err := c.GetServerTimeouts(); err != nil {
+		return fmt.Errorf("invalid server limits: %w", err)
+	}
+	if _, err := c.GetMaxHeaderBytes(); err != nil {
+		return fmt.Errorf("invalid server limits: invalid max header bytes: %w", err)
+	}
+	if _, err := ParseOptionalDuration(c.Server.ExitWhenIdle); err != nil {
+		return fmt.Errorf("invalid server exit_when_idle: %w", err)
+	}
+	for _, pattern := range c.Server.HTTPS.PassthroughHosts {
+		if _, err := ParseHostPattern(pattern); err != nil {
+			return fmt.Errorf("invalid server https passthrough host: %w", err)
+		}
+	}
+	if err := c.Server.HTTPS.Transparent.Compile(); err != nil {
+		return err
+	}
+	for i, rule := range c.Server.HTTPS.Transparent.SNIRules {
+		if !validSNIAction(rule.Action) {
+			return fmt.Errorf("sni rule %d: action must be 'intercept', 'passthrough' or 'block', got: '%s'", i, rule.Action)
+		}
+	}
+	if c.Server.HTTPS.Transparent.SNIDefault != "" && !validSNIAction(c.Server.HTTPS.Transparent.SNIDefault) {
+		return fmt.Errorf("sni_default must be 'intercept', 'passthrough' or 'block', got: '%s'", c.Server.HTTPS.Transparent.SNIDefault)
+	}
+	if c.Server.HTTPS.CertCacheSize < 0 {
+		return fmt.Errorf("invalid server https: cert cache size cannot be negative, got: %d", c.Server.HTTPS.CertCacheSize)
+	}
+	if c.Server.Limits.MaxConnections < 0 {
+		return fmt.Errorf("invalid server limits: max connections cannot be negative, got: %d", c.Server.Limits.MaxConnections)
+	}
+	if c.Server.Limits.MaxRequests < 0 {
+		return fmt.Errorf("invalid server limits: max requests cannot be negative, got: %d", c.Server.Limits.MaxRequests)
+	}
+	if c.Server.Limits.MaxHandshakes < 0 {
+		return fmt.Errorf("invalid server limits: max handshakes cannot be negative, got: %d", c.Server.Limits.MaxHandshakes)
+	}
+	switch c.Server.Limits.OnOverload {
+	case "", OverloadQueue, OverloadReject:
+	default:
+		return fmt.Errorf("invalid server limits: on_overload must be 'queue' or 'reject', got: '%s'", c.Server.Limits.OnOverload)
+	}
+	if _, err := c.GetQueueTimeout(); err != nil {
+		return fmt.Errorf("invalid server limits: invalid queue timeout: %w", err)
+	}
+
 	if _, err := c.GetCacheTTL(); err != nil {
 		return fmt.Errorf("invalid cache TTL format: %w", err)
 	}
 
+	if _, err := c.GetStaleTTL(); err != nil {
+		return fmt.Errorf("invalid cache stale TTL format: %w", err)
+	}
+
+	switch c.Cache.Backend {
+	case "", "disk", "memory":
+	default:
+		return fmt.Errorf("cache backend must be 'disk' or 'memory', got: %s", c.Cache.Backend)
+	}
+	switch c.Cache.Layout {
+	case "", "mirror", "hashed":
+	default:
+		return fmt.Errorf("cache layout must be 'mirror' or 'hashed', got: %s", c.Cache.Layout)
+	}
+	switch c.Cache.Secondary.Layout {
+	case "", "mirror", "hashed":
+	default:
+		return fmt.Errorf("secondary cache layout must be 'mirror' or 'hashed', got: %s", c.Cache.Secondary.Layout)
+	}
+	for _, backend := range c.Cache.Chain {
+		if backend != "disk" && backend != "memory" {
+			return fmt.Errorf("cache chain backends must be 'disk' or 'memory', got: %s", backend)
+		}
+	}
+	if _, err := c.GetChainRetryInterval(); err != nil {
+		return fmt.Errorf("invalid cache chain retry interval: %w", err)
+	}
+	if _, err := c.GetSnapshotInterval(); err != nil {
+		return fmt.Errorf("invalid cache snapshot interval: %w", err)
+	}
+
+	if _, err := c.GetCacheMaxSize(); err != nil {
+		return fmt.Errorf("invalid cache max size: %w", err)
+	}
+	if _, err := c.GetMaxRequestBody(); err != nil {
+		return fmt.Errorf("invalid cache max request body: %w", err)
+	}
+	if c.Cache.MaxEntries < 0 {
+		return fmt.Errorf("cache max entries cannot be negative, got: %d", c.Cache.MaxEntries)
+	}
+	switch c.Cache.Mode {
+	case "", CacheModeNormal, CacheModeRecord, CacheModeReplay:
+	default:
+		return fmt.Errorf("cache mode must be 'normal', 'record' or 'replay', got: %s", c.Cache.Mode)
+	}
+
+	switch c.Cache.EvictionPolicy {
+	case "", "lru", "lfu", "gdsf", "ttl":
+	default:
+		return fmt.Errorf("cache eviction policy must be 'lru', 'lfu', 'gdsf' or 'ttl', got: %s", c.Cache.EvictionPolicy)
+	}
+
+	if _, err := c.GetClockSkewThreshold(); err != nil {
+		return fmt.Errorf("invalid clock skew threshold format: %w", err)
+	}
+
+	if _, err := c.GetHistoryRetention(); err != nil {
+		return fmt.Errorf("invalid history retention format: %w", err)
+	}
+	if c.History.Enabled && c.History.Path == "" {
+		return fmt.Errorf("history path cannot be empty when history is enabled")
+	}
+
+	if _, err := c.GetMaintenanceDuration(); err != nil {
+		return fmt.Errorf("invalid maintenance duration format: %w", err)
+	}
+	if _, err := c.GetMaintenanceThrottle(); err != nil {
+		return fmt.Errorf("invalid maintenance throttle format: %w", err)
+	}
+	for _, window := range c.Maintenance.Windows {
+		if _, err := cron.Parse(window); err != nil {
+			return fmt.Errorf("invalid maintenance window: %w", err)
+		}
+	}
+	for _, pinned := range c.Pinned.URLs {
+		if u, err := url.Parse(pinned.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("pinned url must be an absolute http(s) URL, got: '%s'", pinned.URL)
+		}
+		if _, err := cron.Parse(pinned.Schedule); err != nil {
+			return fmt.Errorf("invalid schedule of pinned url %s: %w", pinned.URL, err)
+		}
+	}
+	for _, job := range c.Maintenance.Jobs {
+		switch job {
+		case "gc", "verify", "compact", "refresh":
+		default:
+			return fmt.Errorf("maintenance jobs must be 'gc', 'verify', 'compact' or 'refresh', got: %s", job)
+		}
+	}
+
+	if _, _, err := c.GetRateLimitRetryAfter(); err != nil {
+		return fmt.Errorf("invalid rate limit configuration: %w", err)
+	}
+
+	for i, comparison := range c.Canary.Comparisons {
+		if u, err := url.Parse(comparison.Candidate); err != nil || !u.IsAbs() {
+			return fmt.Errorf("canary comparison %d: candidate must be an absolute URL, got: '%s'", i, comparison.Candidate)
+		}
+		if comparison.Serve != "" && comparison.Serve != "primary" && comparison.Serve != "candidate" {
+			return fmt.Errorf("canary comparison %d: serve must be 'primary' or 'candidate', got: %s", i, comparison.Serve)
+		}
+	}
+	for i, remap := range c.Remap.Origins {
+		if u, err := url.Parse(remap.From); err != nil || !u.IsAbs() {
+			return fmt.Errorf("remap origin %d: from must be an absolute URL, got: '%s'", i, remap.From)
+		}
+		if u, err := url.Parse(remap.To); err != nil || !u.IsAbs() {
+			return fmt.Errorf("remap origin %d: to must be an absolute URL, got: '%s'", i, remap.To)
+		}
+	}
+	for i, host := range c.Upstream.HTTP3.Hosts {
+		if host == "" || strings.ContainsAny(host, "/:") {
+			return fmt.Errorf("upstream http3 host %d: must be a host name, got: '%s'", i, host)
+		}
+	}
+	if err := c.Upstream.Validate(); err != nil {
+		return err
+	}
+	if err := c.DNS.Validate(); err != nil {
+		return err
+	}
+	if err := c.ResponseHeaders.Validate(); err != nil {
+		return err
+	}
+	if _, err := ParseOptionalDuration(c.CORS.MaxAge); err != nil {
+		return fmt.Errorf("invalid cors max_age: %w", err)
+	}
+	if c.Prefetch.Enabled {
+		if c.Prefetch.Depth < 1 || c.Prefetch.Workers < 1 {
+			return fmt.Errorf("invalid prefetch: depth and workers must be at least 1")
+		}
+		if c.Prefetch.MaxLinks < 0 {
+			return fmt.Errorf("invalid prefetch: max links cannot be negative, got: %d", c.Prefetch.MaxLinks)
+		}
+		for _, pattern := range c.Prefetch.Patterns {
+			if _, err := ParseURLGlob(pattern); err != nil {
+				return fmt.Errorf("invalid prefetch pattern: %w", err)
+			}
+		}
+	}
+	if len(c.Canary.Comparisons) > 0 && c.Canary.Report == "" {
+		return fmt.Errorf("canary comparisons require canary.report")
+	}
+
+	if c.Cache.AsyncWrites.Workers < 0 || c.Cache.AsyncWrites.QueueSize < 0 {
+		return fmt.Errorf("invalid cache async writes: workers and queue size cannot be negative")
+	}
+	if c.Upstream.Retry.Attempts < 0 {
+		return fmt.Errorf("invalid upstream retry: attempts cannot be negative, got: %d", c.Upstream.Retry.Attempts)
+	}
+	if _, _, err := c.GetRetryBackoff(); err != nil {
+		return fmt.Errorf("invalid upstream retry: %w", err)
+	}
+	if _, err := c.Upstream.Timeouts.Parse(); err != nil {
+		return fmt.Errorf("invalid upstream timeouts: %w", err)
+	}
+	if c.Upstream.Transport.MaxIdleConns < 0 || c.Upstream.Transport.MaxIdleConnsPerHost < 0 {
+		return fmt.Errorf("invalid upstream transport: maximum idle connections cannot be negative")
+	}
+	if _, _, err := c.GetTransportDurations(); err != nil {
+		return fmt.Errorf("invalid upstream transport: %w", err)
+	}
+	if c.Upstream.ParentCache != "" {
+		if u, err := url.Parse(c.Upstream.ParentCache); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("upstream parent cache must be an absolute http(s) URL, got: '%s'", c.Upstream.ParentCache)
+		}
+	}
+
+	if _, err := c.GetDecisionServiceTimeout(); err != nil {
+		return fmt.Errorf("invalid decision service timeout format: %w", err)
+	}
+	for _, peer := range c.Peers.URLs {
+		if u, err := url.Parse(peer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("peer url must be an absolute http(s) URL, got: '%s'", peer)
+		}
+	}
+	if _, err := c.GetPeersTimeout(); err != nil {
+		return fmt.Errorf("invalid peers timeout format: %w", err)
+	}
+
+	if _, err := c.GetStatsInterval(); err != nil {
+		return fmt.Errorf("invalid stats interval format: %w", err)
+	}
+	if _, err := c.GetStorageSamplingInterval(); err != nil {
+		return fmt.Errorf("invalid storage sampling interval format: %w", err)
+	}
+	if c.StorageSampling.Entries < 0 {
+		return fmt.Errorf("storage sampling entries cannot be negative, got: %d", c.StorageSampling.Entries)
+	}
+
+	if c.Log.Format != "" && c.Log.Format != "text" && c.Log.Format != "json" {
+		return fmt.Errorf("log format must be 'text' or 'json', got: %s", c.Log.Format)
+	}
+
 	if c.Rules.Mode != "whitelist" && c.Rules.Mode != "blacklist" {
 		return fmt.Errorf("rules mode must be 'whitelist' or 'blacklist', got: %s", c.Rules.Mode)
 	}
 
+	for i, rule := range c.Rules.Rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("invalid rule #%d (%s): %w", i+1, rule.BaseURI, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate validates a rule
+func (r *CacheRule) Validate() error {
+	switch r.Action {
+	case "", RuleActionCache, RuleActionBypass, RuleActionBlock:
+		if r.Mock != nil && r.Action != "" {
+			return fmt.Errorf("mock can only be set on rules with action 'mock', got: %s", r.Action)
+		}
+		if r.Block != nil && r.Action != "" && r.Action != RuleActionBlock {
+			return fmt.Errorf("block can only be set on rules with action 'block', got: %s", r.Action)
+		}
+		if r.Mock != nil && r.Block != nil {
+			return fmt.Errorf("mock and block cannot be set together")
+		}
+	case RuleActionMock:
+		if r.Mock == nil {
+			return fmt.Errorf("action 'mock' requires mock to be set")
+		}
+		if r.Block != nil {
+			return fmt.Errorf("block can only be set on rules with action 'block', got: %s", r.Action)
+		}
+	default:
+		return fmt.Errorf("action must be 'cache', 'bypass', 'block' or 'mock', got: %s", r.Action)
+	}
+	usesResponse := false
+	if r.When != "" {
+		when, err := condition.Compile(r.When)
+		if err != nil {
+			return fmt.Errorf("invalid when: %w", err)
+		}
+		usesResponse = when.UsesResponse()
+	}
+	if (r.Action == RuleActionBlock || r.Block != nil || r.Mock != nil) && (len(r.StatusCodes) > 0 || r.MinSize != "" || r.MaxSize != "" || usesResponse) {
+		return fmt.Errorf("block and mock rules apply before upstream is contacted, so they cannot have status_codes, min_size, max_size or a when expression using resp")
+	}
+	if IsURLGlob(r.BaseURI) {
+		if _, err := ParseURLGlob(r.BaseURI); err != nil {
+			return err
+		}
+	}
+	minSize, err := ParseSize(r.MinSize)
+	if err != nil {
+		return fmt.Errorf("invalid min_size: %w", err)
+	}
+	maxSize, err := ParseSize(r.MaxSize)
+	if err != nil {
+		return fmt.Errorf("invalid max_size: %w", err)
+	}
+	if maxSize != 0 && maxSize < minSize {
+		return fmt.Errorf("max_size (%s) must not be smaller than min_size (%s)", r.MaxSize, r.MinSize)
+	}
+	if r.Timeouts != nil {
+		if _, err := r.Timeouts.Parse(); err != nil {
+			return err
+		}
+	}
+	if _, err := ParseOptionalDuration(r.Placeholder.After); err != nil {
+		return fmt.Errorf("invalid placeholder delay: %w", err)
+	}
+	if _, err := ParseOptionalDuration(r.Placeholder.RetryAfter); err != nil {
+		return fmt.Errorf("invalid placeholder retry_after: %w", err)
+	}
+	if r.Mock != nil {
+		if r.Mock.Status != 0 && (r.Mock.Status < 100 || r.Mock.Status > 599) {
+			return fmt.Errorf("invalid mock status: %d", r.Mock.Status)
+		}
+		if r.Mock.Body != "" && r.Mock.File != "" {
+			return fmt.Errorf("mock body and file cannot be set together")
+		}
+	}
+	if r.Block != nil && r.Block.Status != 0 && (r.Block.Status < 100 || r.Block.Status > 599) {
+		return fmt.Errorf("invalid block status: %d", r.Block.Status)
+	}
+	if r.Rewrite != nil {
+		if r.Rewrite.Status != 0 && (r.Rewrite.Status < 100 || r.Rewrite.Status > 599) {
+			return fmt.Errorf("invalid rewrite status: %d", r.Rewrite.Status)
+		}
+		for _, replacement := range r.Rewrite.Body {
+			if _, err := regexp.Compile(replacement.Find); err != nil {
+				return fmt.Errorf("invalid rewrite body expression '%s': %w", replacement.Find, err)
+			}
+		}
+	}
+	if r.Latency != nil {
+		delay, err := ParseOptionalDuration(r.Latency.Delay)
+		if err != nil {
+			return fmt.Errorf("invalid latency delay: %w", err)
+		}
+		maxDelay, err := ParseOptionalDuration(r.Latency.MaxDelay)
+		if err != nil {
+			return fmt.Errorf("invalid latency max_delay: %w", err)
+		}
+		if r.Latency.MaxDelay != "" && maxDelay < delay {
+			return fmt.Errorf("latency max_delay (%s) must not be shorter than delay (%s)", r.Latency.MaxDelay, r.Latency.Delay)
+		}
+		if r.Latency.On != "" && r.Latency.On != "hit" && r.Latency.On != "miss" {
+			return fmt.Errorf("latency on must be 'hit' or 'miss', got: %s", r.Latency.On)
+		}
+	}
+	switch r.OnUpstreamError {
+	case "", "pass", "stale", "error":
+	default:
+		return fmt.Errorf("on_upstream_error must be 'pass', 'stale' or 'error', got: %s", r.OnUpstreamError)
+	}
 	return nil
 }
 
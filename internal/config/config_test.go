@@ -86,6 +86,69 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "invalid rewrite expression",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist", Rules: []CacheRule{{BaseURI: "https://api.example.com", Rewrite: &RewriteConfig{Body: []BodyReplacement{{Find: "(unclosed"}}}}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid mock status",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist", Rules: []CacheRule{{BaseURI: "https://api.example.com", Mock: &MockConfig{Status: 1000}}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "mock action without mock",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist", Rules: []CacheRule{{BaseURI: "https://api.example.com", Action: RuleActionMock}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "block action with status codes",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist", Rules: []CacheRule{{BaseURI: "https://api.example.com", Action: RuleActionBlock, StatusCodes: []string{"200"}}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid block status",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist", Rules: []CacheRule{{BaseURI: "https://api.example.com", Block: &BlockConfig{Status: 42}}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "block on cache action",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist", Rules: []CacheRule{{BaseURI: "https://api.example.com", Action: RuleActionCache, Block: &BlockConfig{Status: 451}}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid cache mode",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache", Mode: "playback"},
+				Rules:  RulesConfig{Mode: "whitelist"},
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid mode",
 			config: Config{
@@ -95,6 +158,151 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "invalid server idle timeout",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}, Limits: LimitsConfig{IdleTimeout: "soon"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative max connections",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}, Limits: LimitsConfig{MaxConnections: -1}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid rule timeout",
+			config: Config{
+				Server:   ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:    CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:    RulesConfig{Mode: "whitelist", Rules: []CacheRule{{BaseURI: "https://example.com", Timeouts: &TimeoutsConfig{Total: "forever"}}}},
+				Upstream: UpstreamConfig{Timeouts: TimeoutsConfig{Dial: "5s"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid cache layout",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache", Layout: "flat"},
+				Rules:  RulesConfig{Mode: "whitelist"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid pinned schedule",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist"},
+				Pinned: PinnedConfig{URLs: []PinnedURL{{URL: "https://example.com/status", Schedule: "every minute"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "relative pinned url",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist"},
+				Pinned: PinnedConfig{URLs: []PinnedURL{{URL: "/status", Schedule: "@hourly"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "relative peer url",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist"},
+				Peers:  PeersConfig{URLs: []string{"alice.lan:8081"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "socks parent cache",
+			config: Config{
+				Server:   ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:    CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:    RulesConfig{Mode: "whitelist"},
+				Upstream: UpstreamConfig{ParentCache: "socks5://cache.office.lan:8080"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid prefetch pattern",
+			config: Config{
+				Server:   ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:    CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:    RulesConfig{Mode: "whitelist"},
+				Prefetch: PrefetchConfig{Enabled: true, Depth: 1, Workers: 1, Patterns: []string{"/assets/**"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid overload behavior",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}, Limits: LimitsConfig{MaxRequests: 10, OnOverload: "drop"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid queue timeout",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}, Limits: LimitsConfig{MaxRequests: 10, QueueTimeout: "later"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid upstream proxy",
+			config: Config{
+				Server:   ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:    CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:    RulesConfig{Mode: "whitelist"},
+				Upstream: UpstreamConfig{Hosts: []UpstreamHost{{Match: []string{"**"}, Proxy: "proxy.corp:3128"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid dns override address",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist"},
+				DNS:    DNSConfig{Overrides: []DNSOverride{{Match: []string{"api.example.com"}, Address: "staging.example.com"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid dns resolver",
+			config: Config{
+				Server: ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:  CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:  RulesConfig{Mode: "whitelist"},
+				DNS:    DNSConfig{Resolver: "http://dns.example.com/dns-query"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "response header override without headers",
+			config: Config{
+				Server:          ServerConfig{HTTP: HTTPConfig{Address: ":8080"}},
+				Cache:           CacheConfig{TTL: "1h", Folder: "/tmp/cache"},
+				Rules:           RulesConfig{Mode: "whitelist"},
+				ResponseHeaders: ResponseHeadersConfig{Overrides: []ResponseHeaderOverride{{Match: []string{"*.example.com"}}}},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -122,3 +330,143 @@ func TestGetCacheTTL(t *testing.T) {
 		t.Errorf("GetCacheTTL() = %v, want %v", ttl, expected)
 	}
 }
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1024", 1024, false},
+		{"100B", 100, false},
+		{"500MB", 500_000_000, false},
+		{"1.5 GiB", 3 << 29, false},
+		{"10gb", 10_000_000_000, false},
+		{"lots", 0, true},
+		{"-1MB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSize(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLoadIncludes(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"config.yaml": `
+include: ["team.yaml", "personal/*.yaml"]
+cache:
+  ttl: "1h"
+rules:
+  rules:
+    - base_uri: "https://main.example.com"
+      methods: ["GET"]
+`,
+		"team.yaml": `
+cache:
+  ttl: "30m"
+  folder: "/team/cache"
+log:
+  level: "warn"
+rules:
+  mode: "blacklist"
+  rules:
+    - base_uri: "https://team.example.com"
+      methods: ["GET"]
+`,
+		"personal/overrides.yaml": `
+include: ["../common.yaml"]
+log:
+  level: "debug"
+rules:
+  rules:
+    - base_uri: "https://personal.example.com"
+      methods: ["GET"]
+`,
+		"common.yaml": `
+history:
+  enabled: true
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(tempDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, err := Load(filepath.Join(tempDir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if config.Cache.TTL != "1h" {
+		t.Errorf("cache TTL = %s, want the including file value 1h", config.Cache.TTL)
+	}
+	if config.Cache.Folder != "/team/cache" {
+		t.Errorf("cache folder = %s, want the included value /team/cache", config.Cache.Folder)
+	}
+	if config.Log.Level != "debug" {
+		t.Errorf("log level = %s, want the later include value debug", config.Log.Level)
+	}
+	if config.Rules.Mode != "blacklist" {
+		t.Errorf("rules mode = %s, want blacklist", config.Rules.Mode)
+	}
+	if !config.History.Enabled {
+		t.Errorf("history not enabled by the nested include")
+	}
+	want := []string{"https://main.example.com", "https://personal.example.com", "https://team.example.com"}
+	if len(config.Rules.Rules) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(config.Rules.Rules), len(want))
+	}
+	for i, rule := range config.Rules.Rules {
+		if rule.BaseURI != want[i] {
+			t.Errorf("rule %d base URI = %s, want %s", i, rule.BaseURI, want[i])
+		}
+	}
+}
+
+func TestLoadIncludeCycle(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "a.yaml"), []byte(`include: ["b.yaml"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "b.yaml"), []byte(`include: ["a.yaml"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(filepath.Join(tempDir, "a.yaml")); err == nil {
+		t.Error("Load() succeeded on an include cycle")
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "missing.yaml"), []byte(`include: ["nothing.yaml"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(filepath.Join(tempDir, "missing.yaml")); err == nil {
+		t.Error("Load() succeeded with a missing included file")
+	}
+}
+
+func TestTimeoutsOverride(t *testing.T) {
+	global := TimeoutsConfig{Dial: "30s", TLSHandshake: "10s", Total: "1m"}
+	got, err := global.Override(&TimeoutsConfig{ResponseHeader: "5s", Total: "0"}).Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := Timeouts{Dial: 30 * time.Second, TLSHandshake: 10 * time.Second, ResponseHeader: 5 * time.Second, Total: 0}
+	if got != want {
+		t.Errorf("Override() = %+v, want %+v", got, want)
+	}
+	if global.Override(nil) != global {
+		t.Errorf("Override(nil) changed the timeouts")
+	}
+}
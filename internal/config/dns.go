@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Enabled reports whether host names are resolved differently than by the system resolver
+func (c *DNSConfig) Enabled() bool {
+	return len(c.Overrides) > 0 || c.Resolver != ""
+}
+
+// Override returns the static address of host, or "" if no override matches it. Compile must have been called
+func (c *DNSConfig) Override(host string) string {
+	for _, override := range c.Overrides {
+		if override.patterns.Match(host) {
+			return override.Address
+		}
+	}
+	return ""
+}
+
+// Compile parses the host patterns of the overrides, once rather than on each Override call
+func (c *DNSConfig) Compile() error {
+	for i := range c.Overrides {
+		override := &c.Overrides[i]
+		if len(override.Match) == 0 {
+			return fmt.Errorf("dns override %d: match must list at least one host pattern", i)
+		}
+		patterns, err := ParseHostPatterns(override.Match)
+		if err != nil {
+			return fmt.Errorf("dns override %d: %w", i, err)
+		}
+		override.patterns = patterns
+	}
+	return nil
+}
+
+// IsDoH reports whether the resolver is a DNS-over-HTTPS URL rather than a nameserver
+func (c *DNSConfig) IsDoH() bool {
+	return strings.HasPrefix(c.Resolver, "https://")
+}
+
+// Nameserver returns the host:port address of the nameserver resolver, with the default DNS port if it has none
+func (c *DNSConfig) Nameserver() string {
+	if _, _, err := net.SplitHostPort(c.Resolver); err == nil {
+		return c.Resolver
+	}
+	return net.JoinHostPort(strings.Trim(c.Resolver, "[]"), "53")
+}
+
+// Validate checks the overrides and the resolver, and compiles the overrides
+func (c *DNSConfig) Validate() error {
+	if err := c.Compile(); err != nil {
+		return err
+	}
+	for i, override := range c.Overrides {
+		if net.ParseIP(override.Address) == nil {
+			return fmt.Errorf("dns override %d: address must be an IP address, got: '%s'", i, override.Address)
+		}
+	}
+	if c.Resolver == "" {
+		return nil
+	}
+	if c.IsDoH() {
+		if u, err := url.Parse(c.Resolver); err != nil || u.Host == "" {
+			return fmt.Errorf("invalid dns resolver URL '%s'", c.Resolver)
+		}
+		return nil
+	}
+	if host, _, err := net.SplitHostPort(c.Nameserver()); err != nil || host == "" || strings.Contains(c.Resolver, "/") {
+		return fmt.Errorf("dns resolver must be a nameserver (host or host:port) or an https:// DNS-over-HTTPS URL, got: '%s'", c.Resolver)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// CheckFiles checks the files the configuration refers to: certificates must be readable and valid,
+// and the directories of files written by the proxy must exist. Returns one error per problem
+func (c *Config) CheckFiles() []error {
+	errs := []error{}
+
+	https := c.Server.HTTPS
+	if https.Enabled && https.CACertFile != "" && https.CAKeyFile != "" {
+		if _, err := tls.LoadX509KeyPair(https.CACertFile, https.CAKeyFile); err != nil {
+			errs = append(errs, fmt.Errorf("server.https: invalid CA certificate or key: %w", err))
+		}
+	} else if https.Enabled && (https.CACertFile != "" || https.CAKeyFile != "") {
+		errs = append(errs, fmt.Errorf("server.https: ca_cert_file and ca_key_file must be set together"))
+	}
+	if https.Enabled && https.ClientCAFile != "" {
+		if pem, err := os.ReadFile(https.ClientCAFile); err != nil {
+			errs = append(errs, fmt.Errorf("server.https: invalid client CA bundle: %w", err))
+		} else if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			errs = append(errs, fmt.Errorf("server.https: no certificate found in client CA bundle %s", https.ClientCAFile))
+		}
+	}
+
+	for i, host := range c.Upstream.Hosts {
+		if host.ClientCert != "" && host.ClientKey != "" {
+			if _, err := tls.LoadX509KeyPair(host.ClientCert, host.ClientKey); err != nil {
+				errs = append(errs, fmt.Errorf("upstream.hosts[%d]: invalid client certificate or key: %w", i, err))
+			}
+		}
+		if host.CABundle != "" {
+			if pem, err := os.ReadFile(host.CABundle); err != nil {
+				errs = append(errs, fmt.Errorf("upstream.hosts[%d]: invalid CA bundle: %w", i, err))
+			} else if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+				errs = append(errs, fmt.Errorf("upstream.hosts[%d]: no certificate found in CA bundle %s", i, host.CABundle))
+			}
+		}
+	}
+
+	for i, rule := range c.Rules.Rules {
+		if rule.Mock == nil || rule.Mock.File == "" {
+			continue
+		}
+		if _, err := os.Stat(rule.Mock.File); err != nil {
+			errs = append(errs, fmt.Errorf("rules.rules[%d].mock.file: %w", i, err))
+		}
+	}
+
+	if c.Script.File != "" {
+		if _, err := os.Stat(c.Script.File); err != nil {
+			errs = append(errs, fmt.Errorf("script.file: %w", err))
+		}
+	}
+
+	written := map[string]string{}
+	if c.History.Enabled {
+		written["history.path"] = c.History.Path
+	}
+	if c.Cache.Snapshot.Path != "" {
+		written["cache.snapshot.path"] = c.Cache.Snapshot.Path
+	}
+	if len(c.Canary.Comparisons) > 0 {
+		written["canary.report"] = c.Canary.Report
+	}
+	for _, key := range []string{"history.path", "cache.snapshot.path", "canary.report"} {
+		path, ok := written[key]
+		if !ok {
+			continue
+		}
+		if info, err := os.Stat(filepath.Dir(path)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: directory of %s: %w", key, path, err))
+		} else if !info.IsDir() {
+			errs = append(errs, fmt.Errorf("%s: %s is not a directory", key, filepath.Dir(path)))
+		}
+	}
+	return errs
+}
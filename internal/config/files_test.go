@@ -0,0 +1,41 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	bundle := filepath.Join(tempDir, "clients.pem")
+	if err := os.WriteFile(bundle, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{}
+	cfg.Server.HTTPS = HTTPSConfig{Enabled: true, CACertFile: filepath.Join(tempDir, "ca.crt"), CAKeyFile: filepath.Join(tempDir, "ca.key"), ClientCAFile: bundle}
+	cfg.History = HistoryConfig{Enabled: true, Path: filepath.Join(tempDir, "missing", "history.db")}
+	cfg.Cache.Snapshot.Path = filepath.Join(tempDir, "snapshot.bin")
+	cfg.Rules.Rules = []CacheRule{{BaseURI: "https://api.example.com/v2", Mock: &MockConfig{File: filepath.Join(tempDir, "users.json")}}}
+
+	errs := cfg.CheckFiles()
+	want := []string{"invalid CA certificate or key", "no certificate found in client CA bundle", "rules.rules[0].mock.file", "history.path"}
+	if len(errs) != len(want) {
+		t.Fatalf("CheckFiles() = %v, want %d errors", errs, len(want))
+	}
+	for i, err := range errs {
+		if !strings.Contains(err.Error(), want[i]) {
+			t.Errorf("error %d = %v, want it to mention %q", i, err, want[i])
+		}
+	}
+
+	// Files are not used when TLS interception is disabled
+	cfg.Server.HTTPS.Enabled = false
+	cfg.History.Path = filepath.Join(tempDir, "history.db")
+	cfg.Rules.Rules = nil
+	if errs := cfg.CheckFiles(); len(errs) != 0 {
+		t.Errorf("CheckFiles() = %v, want no errors", errs)
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// URLGlob matches URLs against a glob pattern such as "https://*.googleapis.com/**", on scheme, host and path separately.
+// In the host, "*" matches a single label and "**" any labels. In the path, "*" matches within a segment and "**" across segments.
+// A pattern without path matches any path. Query strings are ignored
+type URLGlob struct {
+	scheme *regexp.Regexp
+	host   *regexp.Regexp
+	// nil matches any path
+	path *regexp.Regexp
+}
+
+// IsURLGlob reports whether a base URI is a glob pattern rather than a URL prefix
+func IsURLGlob(baseURI string) bool {
+	return strings.Contains(baseURI, "*")
+}
+
+// ParseURLGlob parses a glob pattern of the form scheme://host[/path]
+func ParseURLGlob(pattern string) (*URLGlob, error) {
+	scheme, rest, found := strings.Cut(pattern, "://")
+	if !found || scheme == "" {
+		return nil, fmt.Errorf("glob pattern must start with a scheme, e.g. 'https://', got: '%s'", pattern)
+	}
+	host, path, hasPath := strings.Cut(rest, "/")
+	if host == "" {
+		return nil, fmt.Errorf("glob pattern must have a host, got: '%s'", pattern)
+	}
+	if strings.ContainsAny(path, "?#") {
+		return nil, fmt.Errorf("glob pattern must not have a query or fragment, got: '%s'", pattern)
+	}
+
+	glob := &URLGlob{
+		scheme: globRegexp(scheme, ""),
+		host:   globRegexp(host, "."),
+	}
+	if hasPath {
+		glob.path = globRegexp("/"+path, "/")
+	}
+	return glob, nil
+}
+
+// globRegexp compiles a glob where "**" matches anything and "*" anything but separator (anything if empty), case-insensitively for all but paths
+func globRegexp(glob string, separator string) *regexp.Regexp {
+	single := ".*"
+	if separator != "" {
+		single = "[^" + regexp.QuoteMeta(separator) + "]*"
+	}
+	parts := strings.Split(glob, "**")
+	for i, part := range parts {
+		literals := strings.Split(part, "*")
+		for j, literal := range literals {
+			literals[j] = regexp.QuoteMeta(literal)
+		}
+		parts[i] = strings.Join(literals, single)
+	}
+	expr := "^" + strings.Join(parts, ".*") + "$"
+	if separator != "/" {
+		expr = "(?i)" + expr
+	}
+	return regexp.MustCompile(expr)
+}
+
+// Match reports whether the URL matches the pattern
+func (g *URLGlob) Match(u *url.URL) bool {
+	if !g.scheme.MatchString(u.Scheme) || !g.host.MatchString(u.Host) {
+		return false
+	}
+	if g.path == nil {
+		return true
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return g.path.MatchString(path)
+}
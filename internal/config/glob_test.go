@@ -0,0 +1,45 @@
+package config
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestURLGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		want    bool
+	}{
+		{"https://*.googleapis.com/**", "https://storage.googleapis.com/b/o?alt=media", true},
+		{"https://*.googleapis.com/**", "https://STORAGE.googleapis.com/b", true},
+		{"https://*.googleapis.com/**", "https://a.b.googleapis.com/b", false},
+		{"https://**.googleapis.com/**", "https://a.b.googleapis.com/b", true},
+		{"https://*.googleapis.com/**", "http://storage.googleapis.com/b", false},
+		{"https://*.googleapis.com/**", "https://googleapis.com.evil.com/b", false},
+		{"*://example.com", "http://example.com/any/path", true},
+		{"https://example.com/api/*/users", "https://example.com/api/v1/users", true},
+		{"https://example.com/api/*/users", "https://example.com/api/v1/beta/users", false},
+		{"https://example.com/api/**/users", "https://example.com/api/v1/beta/users", true},
+		{"https://example.com/api/*", "https://example.com/API/v1", false},
+		{"http://localhost:*/", "http://localhost:3000/", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.url, func(t *testing.T) {
+			glob, err := ParseURLGlob(tt.pattern)
+			if err != nil {
+				t.Fatalf("ParseURLGlob() error = %v", err)
+			}
+			u, _ := url.Parse(tt.url)
+			if got := glob.Match(u); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"*.example.com/**", "https:///**", "https://example.com/*?a=1"} {
+		if _, err := ParseURLGlob(invalid); err == nil {
+			t.Errorf("ParseURLGlob(%q) succeeded, want error", invalid)
+		}
+	}
+}
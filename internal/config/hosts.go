@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// HostPattern matches host names against a glob (as in rule base URIs, "*" matches a single label and "**" any labels),
+// or IP addresses against a network in CIDR notation, e.g. "10.0.0.0/8"
+type HostPattern struct {
+	glob    *regexp.Regexp
+	network *net.IPNet
+}
+
+// ParseHostPattern parses a host glob or a CIDR network
+func ParseHostPattern(pattern string) (*HostPattern, error) {
+	if strings.Contains(pattern, "/") {
+		_, network, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR network '%s': %w", pattern, err)
+		}
+		return &HostPattern{network: network}, nil
+	}
+	if pattern == "" || strings.ContainsAny(pattern, ":?#") {
+		return nil, fmt.Errorf("host pattern must be a host name glob or a CIDR network, got: '%s'", pattern)
+	}
+	return &HostPattern{glob: globRegexp(pattern, ".")}, nil
+}
+
+// Match reports whether a host name or IP address (without port) matches the pattern
+func (p *HostPattern) Match(host string) bool {
+	if p.network != nil {
+		ip := net.ParseIP(strings.Trim(host, "[]"))
+		return ip != nil && p.network.Contains(ip)
+	}
+	return p.glob.MatchString(host)
+}
+
+// HostPatterns matches hosts matching any of its patterns
+type HostPatterns []*HostPattern
+
+// ParseHostPatterns parses a list of host globs and CIDR networks
+func ParseHostPatterns(patterns []string) (HostPatterns, error) {
+	parsed := make(HostPatterns, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, err := ParseHostPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+// Match reports whether a host name or IP address (without port) matches one of the patterns
+func (p HostPatterns) Match(host string) bool {
+	for _, pattern := range p {
+		if pattern.Match(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// For returns the first upstream.hosts entry matching host for which has returns true, or nil. Compile must have been called
+func (c *UpstreamConfig) For(host string, has func(*UpstreamHost) bool) *UpstreamHost {
+	for i := range c.Hosts {
+		entry := &c.Hosts[i]
+		if has(entry) && entry.patterns.Match(host) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Compile parses the host patterns of the upstream.hosts entries, once rather than on each For call
+func (c *UpstreamConfig) Compile() error {
+	for i := range c.Hosts {
+		entry := &c.Hosts[i]
+		if len(entry.Match) == 0 {
+			return fmt.Errorf("upstream host %d: match must list at least one host pattern", i)
+		}
+		patterns, err := ParseHostPatterns(entry.Match)
+		if err != nil {
+			return fmt.Errorf("upstream host %d: %w", i, err)
+		}
+		entry.patterns = patterns
+	}
+	return nil
+}
+
+// Validate checks the upstream.hosts entries, and compiles them
+func (c *UpstreamConfig) Validate() error {
+	if err := c.Compile(); err != nil {
+		return err
+	}
+	for i, entry := range c.Hosts {
+		if (entry.ClientCert == "") != (entry.ClientKey == "") {
+			return fmt.Errorf("upstream host %d: client_cert and client_key must be set together", i)
+		}
+		if entry.Proxy != "" && entry.Proxy != ProxyDirect {
+			u, err := url.Parse(entry.Proxy)
+			if err != nil || !slices.Contains([]string{"http", "https", "socks5", "socks5h"}, u.Scheme) || u.Host == "" {
+				return fmt.Errorf("upstream host %d: proxy must be an http://, https://, socks5:// or socks5h:// URL, or '%s', got: '%s'", i, ProxyDirect, entry.Proxy)
+			}
+		}
+	}
+	return nil
+}
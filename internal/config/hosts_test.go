@@ -0,0 +1,85 @@
+package config
+
+import "testing"
+
+func TestHostPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{"example.com", "EXAMPLE.com", true},
+		{"example.com", "api.example.com", false},
+		{"*.example.com", "api.example.com", true},
+		{"*.example.com", "a.api.example.com", false},
+		{"**.example.com", "a.api.example.com", true},
+		{"**", "anything.example.org", true},
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "192.168.1.1", false},
+		{"10.0.0.0/8", "10.example.com", false},
+		{"fd00::/8", "[fd00::1]", true},
+	}
+	for _, tt := range tests {
+		p, err := ParseHostPattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("ParseHostPattern(%q) error = %v", tt.pattern, err)
+		}
+		if got := p.Match(tt.host); got != tt.want {
+			t.Errorf("HostPattern(%q).Match(%q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+
+	for _, pattern := range []string{"", "example.com:443", "10.0.0.0/33"} {
+		if _, err := ParseHostPattern(pattern); err == nil {
+			t.Errorf("ParseHostPattern(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func TestUpstreamConfigFor(t *testing.T) {
+	cfg := UpstreamConfig{Hosts: []UpstreamHost{
+		{Match: []string{"*.internal.example.com"}},
+		{Match: []string{"*.internal.example.com", "10.0.0.0/8"}, Proxy: ProxyDirect},
+		{Match: []string{"**"}, Proxy: "http://proxy.corp:3128"},
+	}}
+	if err := cfg.Compile(); err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	hasProxy := func(h *UpstreamHost) bool { return h.Proxy != "" }
+
+	if got := cfg.For("api.internal.example.com", hasProxy); got == nil || got.Proxy != ProxyDirect {
+		t.Errorf("For(internal host) = %v, want the direct entry, skipping the one without proxy", got)
+	}
+	if got := cfg.For("github.com", hasProxy); got == nil || got.Proxy != "http://proxy.corp:3128" {
+		t.Errorf("For(external host) = %v, want the corporate proxy", got)
+	}
+	if got := (&UpstreamConfig{}).For("github.com", hasProxy); got != nil {
+		t.Errorf("For() without entries = %v, want nil", got)
+	}
+}
+
+func TestSNIActionFor(t *testing.T) {
+	transparent := TransparentConfig{
+		SNIRules: []SNIRule{
+			{Match: []string{"api.example.com", "*.googleapis.com"}, Action: SNIActionIntercept},
+			{Match: []string{"**.example.com"}, Action: SNIActionBlock},
+		},
+		SNIDefault: SNIActionPassthrough,
+	}
+	if err := transparent.Compile(); err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	for hostname, want := range map[string]SNIAction{
+		"api.example.com":        SNIActionIntercept,
+		"storage.googleapis.com": SNIActionIntercept,
+		"www.example.com":        SNIActionBlock,
+		"github.com":             SNIActionPassthrough,
+	} {
+		if got := transparent.SNIActionFor(hostname); got != want {
+			t.Errorf("SNIActionFor(%s) = %s, want %s", hostname, got, want)
+		}
+	}
+	if got := (&TransparentConfig{}).SNIActionFor("github.com"); got != SNIActionIntercept {
+		t.Errorf("SNIActionFor() without default = %s, want %s", got, SNIActionIntercept)
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// includeKey lists the config files (paths or glob patterns, relative to the including file) merged under a config file
+const includeKey = "include"
+
+// rulesKey is the list of rules, concatenated across config files instead of replaced
+const rulesKey = "rules:rules"
+
+// loadLayers loads a config file and the files it includes, recursively.
+// Layers are returned in merge order: the included files in order, then the including file
+func loadLayers(path string, including []string) ([]*koanf.Koanf, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("resolving config file path '%s': %w", path, err)
+	}
+	for _, parent := range including {
+		if parent == absPath {
+			return nil, fmt.Errorf("config file '%s' includes itself", path)
+		}
+	}
+
+	k := koanf.New(":")
+	if err := k.Load(file.Provider(path), yaml.Parser()); err != nil {
+		return nil, fmt.Errorf("loading config file '%s': %w", path, err)
+	}
+	if _, ok := k.Get(includeKey).([]any); k.Exists(includeKey) && !ok {
+		return nil, fmt.Errorf("'include' of config file '%s' must be a list", path)
+	}
+	includes := k.Strings(includeKey)
+	k.Delete(includeKey)
+
+	layers := []*koanf.Koanf{}
+	for _, pattern := range includes {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		paths := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			if paths, err = filepath.Glob(pattern); err != nil {
+				return nil, fmt.Errorf("invalid include pattern '%s' in '%s': %w", pattern, path, err)
+			}
+			sort.Strings(paths)
+		} else if _, err := os.Stat(pattern); err != nil {
+			return nil, fmt.Errorf("config file '%s' included by '%s': %w", pattern, path, err)
+		}
+		for _, included := range paths {
+			logrus.Debugf("Loading config from %s (included by %s)", included, path)
+			includedLayers, err := loadLayers(included, append(including, absPath))
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, includedLayers...)
+		}
+	}
+	return append(layers, k), nil
+}
+
+// mergeLayers merges config layers into k. Values of later layers replace earlier ones, except rules:
+// they are concatenated, later layers first, so later layers also take precedence as the first matching rule applies
+func mergeLayers(k *koanf.Koanf, layers []*koanf.Koanf) error {
+	rules := []any{}
+	hasRules := false
+	for _, layer := range layers {
+		if layer.Exists(rulesKey) {
+			layerRules, ok := layer.Get(rulesKey).([]any)
+			if !ok {
+				return fmt.Errorf("rules.rules must be a list")
+			}
+			rules = append(append([]any{}, layerRules...), rules...)
+			hasRules = true
+		}
+		if err := k.Merge(layer); err != nil {
+			return fmt.Errorf("merging config files: %w", err)
+		}
+	}
+	if hasRules {
+		if err := k.Set(rulesKey, rules); err != nil {
+			return fmt.Errorf("merging config rules: %w", err)
+		}
+	}
+	return nil
+}
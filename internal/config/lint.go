@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Lint returns warnings about valid but likely unintended configurations, each with a suggested fix.
+// Must be called on a configuration that passed Validate
+func (c *Config) Lint() []string {
+	warnings := []string{}
+
+	ttl, _ := c.GetCacheTTL()
+	if ttl == 0 && c.Rules.Mode == RulesModeBlacklist {
+		warnings = append(warnings, "cache.ttl is empty in blacklist mode: every cached response is kept forever. Set cache.ttl (e.g. \"1h\") if entries should expire")
+	}
+	if c.Rules.Mode == RulesModeWhitelist && len(c.Rules.Rules) == 0 {
+		warnings = append(warnings, "whitelist mode with no rules: nothing is cached. Add rules, or use blacklist mode to cache everything")
+	}
+
+	for i, rule := range c.Rules.Rules {
+		name := fmt.Sprintf("rule #%d (%s)", i+1, rule.BaseURI)
+
+		if len(rule.Methods) == 0 {
+			warnings = append(warnings, fmt.Sprintf("%s has no methods, so it never matches. Add methods, e.g. [\"GET\"]", name))
+			continue
+		}
+		if strings.HasPrefix(rule.BaseURI, "https://") && !c.Server.HTTPS.Enabled {
+			warnings = append(warnings, fmt.Sprintf("%s targets HTTPS but TLS interception is disabled, so it never matches. Set server.https.enabled to true", name))
+		}
+
+		for _, header := range slices.Sorted(maps.Keys(rule.RequestHeaders)) {
+			os.Expand(rule.RequestHeaders[header], func(variable string) string {
+				if _, ok := os.LookupEnv(variable); !ok {
+					warnings = append(warnings, fmt.Sprintf("%s sets request header %s from environment variable %s, which is not set", name, header, variable))
+				}
+				return ""
+			})
+		}
+
+		for j, earlier := range c.Rules.Rules[:i] {
+			if earlier.shadows(rule) {
+				warnings = append(warnings, fmt.Sprintf("%s is shadowed by rule #%d (%s), which matches all of its requests first. Remove it, or move it before rule #%d", name, j+1, earlier.BaseURI, j+1))
+				break
+			}
+		}
+	}
+
+	return warnings
+}
+
+// shadows reports whether every request matching other also matches r
+func (r *CacheRule) shadows(other CacheRule) bool {
+	// Glob patterns are not compared, unless identical
+	if (IsURLGlob(r.BaseURI) || IsURLGlob(other.BaseURI)) && r.BaseURI != other.BaseURI {
+		return false
+	}
+	if !strings.HasPrefix(other.BaseURI, r.BaseURI) {
+		return false
+	}
+	for name, value := range r.MatchHeaders {
+		otherValue, ok := other.MatchHeaders[name]
+		if !ok || (value != "" && otherValue != value) {
+			return false
+		}
+	}
+	for _, name := range r.AbsentHeaders {
+		if !slices.Contains(other.AbsentHeaders, name) {
+			return false
+		}
+	}
+	// Expressions, exceptions and size bounds are not compared, unless identical
+	if r.When != "" && r.When != other.When {
+		return false
+	}
+	if len(r.Except) > 0 && !slices.Equal(r.Except, other.Except) {
+		return false
+	}
+	if (r.MinSize != "" || r.MaxSize != "") && (r.MinSize != other.MinSize || r.MaxSize != other.MaxSize) {
+		return false
+	}
+	for name, value := range r.MatchQuery {
+		otherValue, ok := other.MatchQuery[name]
+		if !ok || (value != "" && otherValue != value) {
+			return false
+		}
+	}
+	for _, name := range r.AbsentQuery {
+		if !slices.Contains(other.AbsentQuery, name) {
+			return false
+		}
+	}
+	for _, method := range other.Methods {
+		if !slices.ContainsFunc(r.Methods, func(m string) bool { return strings.EqualFold(m, method) }) {
+			return false
+		}
+	}
+	if len(r.StatusCodes) == 0 {
+		return true
+	}
+	if len(other.StatusCodes) == 0 {
+		return false
+	}
+	for _, status := range other.StatusCodes {
+		if !slices.ContainsFunc(r.StatusCodes, func(pattern string) bool {
+			return pattern == status || (strings.HasSuffix(pattern, "xx") && len(pattern) == 3 && status[:1] == pattern[:1])
+		}) {
+			return false
+		}
+	}
+	return true
+}
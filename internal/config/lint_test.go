@@ -0,0 +1,97 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []string // substrings of expected warnings, in order
+	}{
+		{
+			name:   "defaults cache forever",
+			modify: func(c *Config) {},
+			want:   []string{"kept forever"},
+		},
+		{
+			name:   "clean config",
+			modify: func(c *Config) { c.Cache.TTL = "1h" },
+			want:   []string{},
+		},
+		{
+			name: "empty whitelist",
+			modify: func(c *Config) {
+				c.Cache.TTL = "1h"
+				c.Rules.Mode = RulesModeWhitelist
+			},
+			want: []string{"nothing is cached"},
+		},
+		{
+			name: "rules",
+			modify: func(c *Config) {
+				c.Cache.TTL = "1h"
+				c.Server.HTTPS.Enabled = false
+				c.Rules.Rules = []CacheRule{
+					NewCacheRule("http://example.com/api", "GET", "POST"),
+					NewCacheRule("http://example.com/api/users", "get"),
+					NewCacheRule("http://example.com/api/users", "DELETE"),
+					NewCacheRule("http://example.com/static"),
+					NewCacheRule("https://example.com", "GET"),
+					{BaseURI: "http://example.org", Methods: []string{"GET"}, StatusCodes: []string{"2xx"}},
+					{BaseURI: "http://example.org/a", Methods: []string{"GET"}, StatusCodes: []string{"200"}},
+					{BaseURI: "http://example.org/b", Methods: []string{"GET"}},
+				}
+			},
+			want: []string{
+				"rule #2 (http://example.com/api/users) is shadowed by rule #1",
+				"rule #4 (http://example.com/static) has no methods",
+				"rule #5 (https://example.com) targets HTTPS",
+				"rule #7 (http://example.org/a) is shadowed by rule #6",
+			},
+		},
+		{
+			name: "header conditions",
+			modify: func(c *Config) {
+				c.Cache.TTL = "1h"
+				c.Rules.Rules = []CacheRule{
+					{BaseURI: "http://example.com", Methods: []string{"GET"}, AbsentHeaders: []string{"Authorization"}},
+					{BaseURI: "http://example.com/api", Methods: []string{"GET"}},
+					{BaseURI: "http://example.net", Methods: []string{"GET"}, MatchHeaders: map[string]string{"X-Requested-With": ""}},
+					{BaseURI: "http://example.net/api", Methods: []string{"GET"}, MatchHeaders: map[string]string{"X-Requested-With": "fetch"}},
+				}
+			},
+			want: []string{"rule #4 (http://example.net/api) is shadowed by rule #3"},
+		},
+		{
+			name: "unset request header variables",
+			modify: func(c *Config) {
+				c.Cache.TTL = "1h"
+				c.Rules.Rules = []CacheRule{{
+					BaseURI:        "https://api.example.com",
+					Methods:        []string{"GET"},
+					RequestHeaders: map[string]string{"Authorization": "Bearer ${LINT_TEST_UNSET_TOKEN}", "X-Env": "${LINT_TEST_ENV}"},
+				}}
+			},
+			want: []string{"request header Authorization from environment variable LINT_TEST_UNSET_TOKEN"},
+		},
+	}
+	t.Setenv("LINT_TEST_ENV", "staging")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig
+			tt.modify(&cfg)
+			warnings := cfg.Lint()
+			if len(warnings) != len(tt.want) {
+				t.Fatalf("Lint() = %q, want %d warnings", warnings, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(warnings[i], want) {
+					t.Errorf("Lint()[%d] = %q, want it to contain %q", i, warnings[i], want)
+				}
+			}
+		})
+	}
+}
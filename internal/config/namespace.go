@@ -0,0 +1,102 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// NextNamespace returns the namespace following the given one, by incrementing its trailing number.
+// e.g. "" -> "v2", "v2" -> "v3", "feature-x" -> "feature-x-2"
+func NextNamespace(namespace string) string {
+	if namespace == "" {
+		return "v2"
+	}
+
+	digits := len(namespace)
+	for digits > 0 && namespace[digits-1] >= '0' && namespace[digits-1] <= '9' {
+		digits--
+	}
+	if digits == len(namespace) {
+		return namespace + "-2"
+	}
+	n, err := strconv.Atoi(namespace[digits:])
+	if err != nil {
+		return namespace + "-2" // too many digits
+	}
+	return namespace[:digits] + strconv.Itoa(n+1)
+}
+
+// BumpNamespace sets cache.namespace to its next value in the config file at path, and returns the new namespace.
+// Other keys and comments of the file are kept
+func BumpNamespace(path string) (string, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return "", err
+	}
+	namespace := NextNamespace(cfg.Cache.Namespace)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read config file: %w", err)
+	}
+	data, err = SetYAMLValue(data, namespace, "cache", "namespace")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write config file: %w", err)
+	}
+	return namespace, nil
+}
+
+// SetYAMLValue sets a string value in a YAML config file, adding the missing keys. Other keys and comments are kept
+func SetYAMLValue(data []byte, value string, keys ...string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config file root is not a mapping")
+	}
+
+	node := doc.Content[0]
+	for i, key := range keys[:len(keys)-1] {
+		node = mappingValue(node, key, yaml.MappingNode)
+		if node.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("config file '%s' key is not a mapping", strings.Join(keys[:i+1], "."))
+		}
+	}
+	valueNode := mappingValue(node, keys[len(keys)-1], yaml.ScalarNode)
+	valueNode.Kind = yaml.ScalarNode
+	valueNode.Tag = "!!str"
+	valueNode.Value = value
+	valueNode.Content = nil
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to encode config file: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// mappingValue returns the value node of key in a YAML mapping, adding it with the given kind if missing
+func mappingValue(mapping *yaml.Node, key string, kind yaml.Kind) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	value := &yaml.Node{Kind: kind}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	return value
+}
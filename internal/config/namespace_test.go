@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNextNamespace(t *testing.T) {
+	tests := []struct {
+		namespace string
+		want      string
+	}{
+		{"", "v2"},
+		{"v2", "v3"},
+		{"v9", "v10"},
+		{"feature-x", "feature-x-2"},
+		{"feature-x-2", "feature-x-3"},
+		{"2024", "2025"},
+	}
+	for _, tt := range tests {
+		if got := NextNamespace(tt.namespace); got != tt.want {
+			t.Errorf("NextNamespace(%q) = %q, want %q", tt.namespace, got, tt.want)
+		}
+	}
+}
+
+func TestBumpNamespace(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	configContent := `# Proxy config
+cache:
+  ttl: "30m"  # keep this comment
+rules:
+  mode: "whitelist"
+`
+	if err := os.WriteFile(configFile, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	for _, want := range []string{"v2", "v3"} {
+		namespace, err := BumpNamespace(configFile)
+		if err != nil {
+			t.Fatalf("BumpNamespace() error = %v", err)
+		}
+		if namespace != want {
+			t.Errorf("BumpNamespace() = %q, want %q", namespace, want)
+		}
+	}
+
+	config, err := Load(configFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.Cache.Namespace != "v3" || config.Cache.TTL != "30m" || config.Rules.Mode != "whitelist" {
+		t.Errorf("Unexpected config after bump: %+v", config)
+	}
+
+	data, _ := os.ReadFile(configFile)
+	if !strings.Contains(string(data), "# keep this comment") {
+		t.Errorf("BumpNamespace() dropped comments:\n%s", data)
+	}
+}
+
+func TestSetYAMLValue(t *testing.T) {
+	data := []byte("server:\n  https:\n    ca_cert_file: \"./ca.crt\" # CA certificate\n")
+	data, err := SetYAMLValue(data, "/etc/proxy/ca.crt", "server", "https", "ca_cert_file")
+	if err != nil {
+		t.Fatalf("SetYAMLValue() error = %v", err)
+	}
+	data, err = SetYAMLValue(data, "/etc/proxy/ca.key", "server", "https", "ca_key_file")
+	if err != nil {
+		t.Fatalf("SetYAMLValue() error = %v", err)
+	}
+	want := "server:\n  https:\n    ca_cert_file: \"/etc/proxy/ca.crt\" # CA certificate\n    ca_key_file: /etc/proxy/ca.key\n"
+	if string(data) != want {
+		t.Errorf("SetYAMLValue() = %q, want %q", data, want)
+	}
+
+	if _, err := SetYAMLValue([]byte("server: 1\n"), "x", "server", "https", "ca_cert_file"); err == nil {
+		t.Error("SetYAMLValue() succeeded through a scalar key")
+	}
+}
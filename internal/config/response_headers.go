@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Matching returns the overrides matching host, in order. Compile must have been called
+func (c *ResponseHeadersConfig) Matching(host string) []ResponseHeaderOverride {
+	matching := []ResponseHeaderOverride{}
+	for _, override := range c.Overrides {
+		if override.patterns.Match(host) {
+			matching = append(matching, override)
+		}
+	}
+	return matching
+}
+
+// Compile parses the host patterns of the overrides, once rather than on each Matching call
+func (c *ResponseHeadersConfig) Compile() error {
+	for i := range c.Overrides {
+		override := &c.Overrides[i]
+		if len(override.Match) == 0 {
+			return fmt.Errorf("response header override %d: match must list at least one host pattern", i)
+		}
+		patterns, err := ParseHostPatterns(override.Match)
+		if err != nil {
+			return fmt.Errorf("response header override %d: %w", i, err)
+		}
+		override.patterns = patterns
+	}
+	return nil
+}
+
+// Validate checks the overrides, and compiles them
+func (c *ResponseHeadersConfig) Validate() error {
+	if err := c.Compile(); err != nil {
+		return err
+	}
+	for i, override := range c.Overrides {
+		if len(override.Remove) == 0 && len(override.Set) == 0 {
+			return fmt.Errorf("response header override %d: remove or set must list at least one header", i)
+		}
+	}
+	return nil
+}
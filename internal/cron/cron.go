@@ -0,0 +1,158 @@
+// Parses cron expressions and computes their next occurrences
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed 5-field cron expression: minute hour day-of-month month day-of-week
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // bitsets of allowed values
+	// whether day-of-month / day-of-week are restricted (not "*"). When both are, either can match, like in crontab
+	domRestricted, dowRestricted bool
+}
+
+// field bounds, in order
+var fields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // 0 and 7 are both sunday
+}
+
+// shortcuts of common expressions
+var shortcuts = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// Parse parses a cron expression, e.g. "0 2 * * *" (every day at 2:00) or "*/15 9-17 * * 1-5".
+// Supports lists (1,2), ranges (1-5), steps (*/15, 0-30/5) and @daily-like shortcuts
+func Parse(expr string) (*Schedule, error) {
+	if shortcut, ok := shortcuts[strings.TrimSpace(expr)]; ok {
+		expr = shortcut
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("cron expression '%s' must have %d fields, got %d", expr, len(fields), len(parts))
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, part := range parts {
+		set, err := parseField(part, fields[i].min, fields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in cron expression '%s': %w", fields[i].name, expr, err)
+		}
+		sets[i] = set
+	}
+	// Sunday can be written 0 or 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &Schedule{
+		minute:        sets[0],
+		hour:          sets[1],
+		dom:           sets[2],
+		month:         sets[3],
+		dow:           sets[4],
+		domRestricted: parts[2] != "*",
+		dowRestricted: parts[4] != "*",
+	}, nil
+}
+
+// parseField parses a comma-separated list of values, ranges and steps into a bitset
+func parseField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step '%s'", stepPart)
+			}
+		}
+
+		var low, high int
+		switch {
+		case rangePart == "*":
+			low, high = min, max
+		case strings.Contains(rangePart, "-"):
+			lowStr, highStr, _ := strings.Cut(rangePart, "-")
+			var err1, err2 error
+			low, err1 = strconv.Atoi(lowStr)
+			high, err2 = strconv.Atoi(highStr)
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range '%s'", rangePart)
+			}
+		default:
+			value, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value '%s'", rangePart)
+			}
+			low, high = value, value
+			if hasStep {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("'%s' is out of range %d-%d", rangePart, min, max)
+		}
+
+		for v := low; v <= high; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// Matches reports whether the schedule fires at the minute of t
+func (s *Schedule) Matches(t time.Time) bool {
+	return s.minute&(1<<uint(t.Minute())) != 0 && s.hour&(1<<uint(t.Hour())) != 0 &&
+		s.month&(1<<uint(t.Month())) != 0 && s.dayMatches(t)
+}
+
+// Next returns the first time the schedule fires strictly after t, truncated to the minute.
+// Returns the zero time if it never fires (e.g. February 30th)
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+	// Any valid schedule fires at least once in 5 years (leap days included)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether the schedule fires on the day of t
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
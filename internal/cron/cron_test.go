@@ -0,0 +1,48 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) should fail", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Wednesday
+	from := time.Date(2025, 1, 15, 10, 30, 45, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2025, 1, 15, 10, 31, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2025, 1, 16, 2, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2025, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", time.Date(2025, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"0 3 * * 0", time.Date(2025, 1, 19, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2025, 1, 19, 3, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		// Day of month or day of week, like crontab
+		{"0 0 20 * 5", time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		schedule, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", tt.expr, err)
+		}
+		if got := schedule.Next(from); !got.Equal(tt.want) {
+			t.Errorf("Parse(%q).Next() = %v, want %v", tt.expr, got, tt.want)
+		}
+		if !tt.want.IsZero() && !schedule.Matches(tt.want) {
+			t.Errorf("Parse(%q).Matches(%v) = false", tt.expr, tt.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Lists the differences between two HTTP responses, e.g. to compare two versions of an upstream
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Maximum number of differences listed for a body
+const maxBodyDifferences = 20
+
+// DefaultIgnoredHeaders are headers expected to differ between two responses of a same request
+var DefaultIgnoredHeaders = []string{"Date", "Age", "Expires", "Last-Modified", "Etag", "Set-Cookie", "Content-Length", "X-Request-Id"}
+
+// Response is the part of a response compared
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Responses returns the differences from a to b, one human readable line each. Empty if they are equivalent
+func Responses(a, b Response, ignoredHeaders []string) []string {
+	differences := []string{}
+	if a.Status != b.Status {
+		differences = append(differences, fmt.Sprintf("status: %d -> %d", a.Status, b.Status))
+	}
+	differences = append(differences, Headers(a.Header, b.Header, ignoredHeaders)...)
+	differences = append(differences, Body(a.Body, b.Body)...)
+	return differences
+}
+
+// Headers returns the differences between two sets of headers, by header name
+func Headers(a, b http.Header, ignored []string) []string {
+	names := []string{}
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	differences := []string{}
+	for _, name := range names {
+		if slices.ContainsFunc(ignored, func(ignoredName string) bool { return strings.EqualFold(ignoredName, name) }) {
+			continue
+		}
+		aValue, aOk := a[name]
+		bValue, bOk := b[name]
+		switch {
+		case !aOk:
+			differences = append(differences, fmt.Sprintf("header %s: added %q", name, strings.Join(bValue, ", ")))
+		case !bOk:
+			differences = append(differences, fmt.Sprintf("header %s: removed %q", name, strings.Join(aValue, ", ")))
+		case !slices.Equal(aValue, bValue):
+			differences = append(differences, fmt.Sprintf("header %s: %q -> %q", name, strings.Join(aValue, ", "), strings.Join(bValue, ", ")))
+		}
+	}
+	return differences
+}
+
+// Body returns the differences between two bodies: by JSON path for JSON documents, by line for text
+func Body(a, b []byte) []string {
+	if bytes.Equal(a, b) {
+		return []string{}
+	}
+
+	var aJSON, bJSON any
+	if json.Unmarshal(a, &aJSON) == nil && json.Unmarshal(b, &bJSON) == nil {
+		differences := []string{}
+		jsonDifferences("$", aJSON, bJSON, &differences)
+		return truncate(differences)
+	}
+
+	if utf8.Valid(a) && utf8.Valid(b) {
+		return truncate(textDifferences(a, b))
+	}
+	return []string{fmt.Sprintf("body: binary content differs (%d bytes -> %d bytes)", len(a), len(b))}
+}
+
+// jsonDifferences appends the differences between two decoded JSON values, with the path of each
+func jsonDifferences(path string, a, b any, differences *[]string) {
+	if len(*differences) > maxBodyDifferences {
+		return
+	}
+	switch aValue := a.(type) {
+	case map[string]any:
+		bValue, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := []string{}
+		for key := range aValue {
+			keys = append(keys, key)
+		}
+		for key := range bValue {
+			if _, ok := aValue[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			aChild, aOk := aValue[key]
+			bChild, bOk := bValue[key]
+			switch {
+			case !aOk:
+				*differences = append(*differences, fmt.Sprintf("body %s.%s: added %s", path, key, compact(bChild)))
+			case !bOk:
+				*differences = append(*differences, fmt.Sprintf("body %s.%s: removed", path, key))
+			default:
+				jsonDifferences(path+"."+key, aChild, bChild, differences)
+			}
+		}
+		return
+	case []any:
+		bValue, ok := b.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < min(len(aValue), len(bValue)); i++ {
+			jsonDifferences(fmt.Sprintf("%s[%d]", path, i), aValue[i], bValue[i], differences)
+		}
+		if len(aValue) != len(bValue) {
+			*differences = append(*differences, fmt.Sprintf("body %s: %d items -> %d items", path, len(aValue), len(bValue)))
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*differences = append(*differences, fmt.Sprintf("body %s: %s -> %s", path, compact(a), compact(b)))
+	}
+}
+
+// textDifferences compares two texts line by line
+func textDifferences(a, b []byte) []string {
+	aLines := strings.Split(string(a), "\n")
+	bLines := strings.Split(string(b), "\n")
+	differences := []string{}
+	for i := 0; i < min(len(aLines), len(bLines)); i++ {
+		if aLines[i] != bLines[i] {
+			differences = append(differences, fmt.Sprintf("body line %d: %q -> %q", i+1, aLines[i], bLines[i]))
+		}
+	}
+	if len(aLines) != len(bLines) {
+		differences = append(differences, fmt.Sprintf("body: %d lines -> %d lines", len(aLines), len(bLines)))
+	}
+	return differences
+}
+
+// truncate limits the number of body differences listed
+func truncate(differences []string) []string {
+	if len(differences) <= maxBodyDifferences {
+		return differences
+	}
+	return append(differences[:maxBodyDifferences], fmt.Sprintf("body: %d more differences", len(differences)-maxBodyDifferences))
+}
+
+// compact returns a JSON value on a single line
+func compact(v any) string {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(encoded)
+}
@@ -0,0 +1,62 @@
+package diff
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResponses(t *testing.T) {
+	a := Response{
+		Status: 200,
+		Header: http.Header{"Content-Type": {"application/json"}, "Date": {"Mon"}, "X-Old": {"1"}},
+		Body:   []byte(`{"name": "a", "tags": ["x", "y"], "count": 1}`),
+	}
+	b := Response{
+		Status: 500,
+		Header: http.Header{"Content-Type": {"text/plain"}, "Date": {"Tue"}, "X-New": {"2"}},
+		Body:   []byte(`{"name": "b", "tags": ["x"], "extra": null, "count": 1}`),
+	}
+
+	expected := []string{
+		"status: 200 -> 500",
+		`header Content-Type: "application/json" -> "text/plain"`,
+		`header X-New: added "2"`,
+		`header X-Old: removed "1"`,
+		"body $.extra: added null",
+		`body $.name: "a" -> "b"`,
+		"body $.tags: 2 items -> 1 items",
+	}
+	if got := Responses(a, b, DefaultIgnoredHeaders); !slices.Equal(got, expected) {
+		t.Errorf("Responses() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+	if got := Responses(a, a, nil); len(got) != 0 {
+		t.Errorf("Responses() of equal responses = %v, want none", got)
+	}
+}
+
+func TestBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		expected []string
+	}{
+		{"equal", "same", "same", []string{}},
+		{"text", "a\nb\nc", "a\nB\nc\nd", []string{`body line 2: "b" -> "B"`, "body: 3 lines -> 4 lines"}},
+		{"binary", "\xff\x00", "\xff\x01\x02", []string{"body: binary content differs (2 bytes -> 3 bytes)"}},
+		{"JSON type change", `{"a": 1}`, `{"a": "1"}`, []string{`body $.a: 1 -> "1"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Body([]byte(tt.a), []byte(tt.b)); !slices.Equal(got, tt.expected) {
+				t.Errorf("Body() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+
+	many := Body([]byte(strings.Repeat("a\n", 30)), []byte(strings.Repeat("b\n", 30)))
+	if len(many) != maxBodyDifferences+1 || many[maxBodyDifferences] != "body: 10 more differences" {
+		t.Errorf("differences should be truncated, got %d: %v", len(many), many[len(many)-1])
+	}
+}
@@ -0,0 +1,135 @@
+// Reads HAR (HTTP Archive) files, e.g. saved from browser devtools, as requests and responses
+package har
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// file is the subset of the HAR 1.2 format needed to rebuild requests and responses
+type file struct {
+	Log struct {
+		Entries []entry `json:"entries"`
+	} `json:"log"`
+}
+
+type entry struct {
+	Request struct {
+		Method   string   `json:"method"`
+		URL      string   `json:"url"`
+		Headers  []header `json:"headers"`
+		PostData *struct {
+			MimeType string `json:"mimeType"`
+			Text     string `json:"text"`
+		} `json:"postData"`
+	} `json:"request"`
+	Response struct {
+		Status     int      `json:"status"`
+		StatusText string   `json:"statusText"`
+		Headers    []header `json:"headers"`
+		Content    struct {
+			MimeType string `json:"mimeType"`
+			Text     string `json:"text"`
+			Encoding string `json:"encoding"`
+		} `json:"content"`
+	} `json:"response"`
+}
+
+type header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Headers not kept from the archive: HTTP/2 pseudo-headers (e.g. ":authority") are skipped separately.
+// Bodies are stored decoded in HAR files, so their encoding and length do not apply anymore
+var skippedHeaders = []string{"Host", "Content-Encoding", "Content-Length", "Transfer-Encoding", "Connection"}
+
+// Entry is a request and its response, read from an archive
+type Entry struct {
+	Request  *http.Request
+	Response *http.Response
+}
+
+// Read reads the entries of a HAR file. Entries without a response (e.g. blocked or failed requests)
+// and of other schemes than http and https (e.g. data: URLs) are skipped
+func Read(r io.Reader) ([]Entry, error) {
+	var archive file
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return nil, fmt.Errorf("invalid HAR file: %w", err)
+	}
+
+	entries := []Entry{}
+	for i, e := range archive.Log.Entries {
+		u, err := url.Parse(e.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL of entry #%d: %w", i+1, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || e.Response.Status == 0 {
+			continue
+		}
+
+		entry, err := e.build(u)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry #%d (%s): %w", i+1, e.Request.URL, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// build rebuilds the request and response of an entry
+func (e *entry) build(u *url.URL) (Entry, error) {
+	var requestBody []byte
+	if e.Request.PostData != nil {
+		requestBody = []byte(e.Request.PostData.Text)
+	}
+	req, err := http.NewRequest(e.Request.Method, u.String(), bytes.NewReader(requestBody))
+	if err != nil {
+		return Entry{}, err
+	}
+	req.Header = toHeader(e.Request.Headers)
+
+	body := []byte(e.Response.Content.Text)
+	if e.Response.Content.Encoding == "base64" {
+		if body, err = base64.StdEncoding.DecodeString(e.Response.Content.Text); err != nil {
+			return Entry{}, fmt.Errorf("invalid base64 body: %w", err)
+		}
+	}
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Response.Status, e.Response.StatusText),
+		StatusCode:    e.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        toHeader(e.Response.Headers),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+	if e.Response.StatusText == "" {
+		resp.Status = fmt.Sprintf("%d %s", e.Response.Status, http.StatusText(e.Response.Status))
+	}
+	return Entry{Request: req, Response: resp}, nil
+}
+
+// toHeader converts HAR headers, skipping pseudo-headers and those not applying to the rebuilt message
+func toHeader(headers []header) http.Header {
+	result := http.Header{}
+	for _, h := range headers {
+		if strings.HasPrefix(h.Name, ":") {
+			continue
+		}
+		skipped := slices.ContainsFunc(skippedHeaders, func(name string) bool { return strings.EqualFold(h.Name, name) })
+		if !skipped {
+			result.Add(h.Name, h.Value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,58 @@
+package har
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const archive = `{"log": {"version": "1.2", "entries": [
+	{
+		"request": {"method": "POST", "url": "https://api.example.com/search?q=go", "httpVersion": "h2",
+			"headers": [{"name": ":authority", "value": "api.example.com"}, {"name": "accept", "value": "application/json"}],
+			"postData": {"mimeType": "application/json", "text": "{\"page\": 2}"}},
+		"response": {"status": 200, "statusText": "", "httpVersion": "h2",
+			"headers": [{"name": "content-type", "value": "application/json"}, {"name": "content-encoding", "value": "br"}, {"name": "content-length", "value": "12"}],
+			"content": {"mimeType": "application/json", "text": "eyJvayI6IHRydWV9", "encoding": "base64"}}
+	},
+	{
+		"request": {"method": "GET", "url": "data:image/png;base64,AAAA", "headers": []},
+		"response": {"status": 200, "headers": [], "content": {"text": ""}}
+	},
+	{
+		"request": {"method": "GET", "url": "https://blocked.example.com/", "headers": []},
+		"response": {"status": 0, "headers": [], "content": {}}
+	}
+]}}`
+
+func TestRead(t *testing.T) {
+	entries, err := Read(strings.NewReader(archive))
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Read() returned %d entries, want 1 (data: URL and failed request skipped)", len(entries))
+	}
+
+	req, resp := entries[0].Request, entries[0].Response
+	body, _ := io.ReadAll(req.Body)
+	if req.Method != http.MethodPost || req.URL.String() != "https://api.example.com/search?q=go" || string(body) != `{"page": 2}` {
+		t.Errorf("request = %s %s %q", req.Method, req.URL, body)
+	}
+	if len(req.Header) != 1 || req.Header.Get("Accept") != "application/json" {
+		t.Errorf("request headers = %v, want only Accept", req.Header)
+	}
+
+	body, _ = io.ReadAll(resp.Body)
+	if resp.Status != "200 OK" || string(body) != `{"ok": true}` {
+		t.Errorf("response = %s %q", resp.Status, body)
+	}
+	if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Content-Length") != "" || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("response headers = %v, want decoded body headers", resp.Header)
+	}
+
+	if _, err := Read(strings.NewReader("not json")); err == nil {
+		t.Error("Read() accepted an invalid file")
+	}
+}
@@ -0,0 +1,151 @@
+// Stores a rolling window of request summaries in SQLite, so they survive restarts
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS requests (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp_ms INTEGER NOT NULL,
+	source TEXT NOT NULL,
+	method TEXT NOT NULL,
+	url TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	cache_status TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL,
+	request_size INTEGER NOT NULL,
+	response_size INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS requests_timestamp ON requests(timestamp_ms);
+`
+
+// Record is the summary of a proxied request
+type Record struct {
+	Time        time.Time
+	Source      string
+	Method      string
+	URL         string
+	Status      int
+	CacheStatus string
+	Duration    time.Duration
+	// Sizes are -1 when unknown
+	RequestSize  int64
+	ResponseSize int64
+}
+
+// DB is a SQLite database of request records
+type DB struct {
+	db         *sql.DB
+	retention  time.Duration
+	maxRecords int
+}
+
+// Open opens (and creates if needed) the history database at path.
+// retention and maxRecords bound the rolling window, 0 meaning unbounded
+func Open(path string, retention time.Duration, maxRecords int) (*DB, error) {
+	// Wait for concurrent writers instead of failing with SQLITE_BUSY
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database '%s': %w", path, err)
+	}
+	// Several connections let long exports read (in WAL mode) while new records are inserted
+	db.SetMaxOpenConns(4)
+
+	if _, err := db.Exec("PRAGMA journal_mode=WAL;" + schema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize history database '%s': %w", path, err)
+	}
+
+	h := &DB{
+		db:         db,
+		retention:  retention,
+		maxRecords: maxRecords,
+	}
+	if err := h.Prune(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// Insert stores a record
+func (h *DB) Insert(r Record) error {
+	_, err := h.db.Exec(
+		"INSERT INTO requests (timestamp_ms, source, method, url, status, cache_status, duration_ms, request_size, response_size) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		r.Time.UnixMilli(), r.Source, r.Method, r.URL, r.Status, r.CacheStatus, r.Duration.Milliseconds(), r.RequestSize, r.ResponseSize,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert history record: %w", err)
+	}
+	return nil
+}
+
+// Prune removes records outside of the rolling window
+func (h *DB) Prune() error {
+	if h.retention != 0 {
+		limit := time.Now().Add(-h.retention).UnixMilli()
+		if _, err := h.db.Exec("DELETE FROM requests WHERE timestamp_ms < ?", limit); err != nil {
+			return fmt.Errorf("failed to prune old history records: %w", err)
+		}
+	}
+	if h.maxRecords != 0 {
+		if _, err := h.db.Exec("DELETE FROM requests WHERE id <= (SELECT id FROM requests ORDER BY id DESC LIMIT 1 OFFSET ?)", h.maxRecords); err != nil {
+			return fmt.Errorf("failed to prune extra history records: %w", err)
+		}
+	}
+	return nil
+}
+
+// Compact reclaims the space of removed records
+func (h *DB) Compact() error {
+	if _, err := h.db.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("failed to compact history database: %w", err)
+	}
+	return nil
+}
+
+// Query calls fn for each record in [from, to), oldest first. Zero times mean unbounded
+func (h *DB) Query(from, to time.Time, fn func(Record) error) error {
+	query := "SELECT timestamp_ms, source, method, url, status, cache_status, duration_ms, request_size, response_size FROM requests WHERE 1=1"
+	args := []any{}
+	if !from.IsZero() {
+		query += " AND timestamp_ms >= ?"
+		args = append(args, from.UnixMilli())
+	}
+	if !to.IsZero() {
+		query += " AND timestamp_ms < ?"
+		args = append(args, to.UnixMilli())
+	}
+	query += " ORDER BY id"
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query history: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var r Record
+		var timestampMs, durationMs int64
+		if err := rows.Scan(&timestampMs, &r.Source, &r.Method, &r.URL, &r.Status, &r.CacheStatus, &durationMs, &r.RequestSize, &r.ResponseSize); err != nil {
+			return fmt.Errorf("failed to read history record: %w", err)
+		}
+		r.Time = time.UnixMilli(timestampMs)
+		r.Duration = time.Duration(durationMs) * time.Millisecond
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Close closes the database
+func (h *DB) Close() error {
+	return h.db.Close()
+}
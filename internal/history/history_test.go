@@ -0,0 +1,86 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func collect(t *testing.T, h *DB, from, to time.Time) []Record {
+	records := []Record{}
+	err := h.Query(from, to, func(r Record) error {
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	return records
+}
+
+func TestInsertAndQuery(t *testing.T) {
+	h, err := Open(filepath.Join(t.TempDir(), "history.db"), 0, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer func() { _ = h.Close() }()
+
+	now := time.Now()
+	for i := range 3 {
+		err := h.Insert(Record{
+			Time:         now.Add(time.Duration(i) * time.Minute),
+			Source:       "HTTP/EXPLI",
+			Method:       "GET",
+			URL:          "http://example.com/",
+			Status:       200,
+			CacheStatus:  "MISS",
+			Duration:     42 * time.Millisecond,
+			RequestSize:  0,
+			ResponseSize: 1234,
+		})
+		if err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	}
+
+	records := collect(t, h, time.Time{}, time.Time{})
+	if len(records) != 3 {
+		t.Fatalf("Query() returned %d records, want 3", len(records))
+	}
+	if records[0].Duration != 42*time.Millisecond || records[0].ResponseSize != 1234 || records[0].CacheStatus != "MISS" {
+		t.Errorf("Query() returned unexpected record: %+v", records[0])
+	}
+
+	records = collect(t, h, now.Add(time.Minute), time.Time{})
+	if len(records) != 2 {
+		t.Errorf("Query() with lower bound returned %d records, want 2", len(records))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	h, err := Open(path, time.Hour, 2)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer func() { _ = h.Close() }()
+
+	now := time.Now()
+	for _, ts := range []time.Time{now.Add(-2 * time.Hour), now.Add(-3 * time.Minute), now.Add(-2 * time.Minute), now.Add(-time.Minute)} {
+		if err := h.Insert(Record{Time: ts, Method: "GET", URL: "http://example.com/"}); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	}
+
+	if err := h.Prune(); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+
+	records := collect(t, h, time.Time{}, time.Time{})
+	if len(records) != 2 {
+		t.Fatalf("Prune() kept %d records, want 2", len(records))
+	}
+	if !records[1].Time.Equal(now.Add(-time.Minute).Truncate(time.Millisecond)) {
+		t.Errorf("Prune() should keep the most recent records, got %v", records[1].Time)
+	}
+}
@@ -0,0 +1,235 @@
+// Builds load profiles (request mix and timing) from the request history, and replays them for performance testing
+package loadprofile
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/history"
+)
+
+// Number of quantiles kept to describe the distribution of times between requests
+const intervalQuantiles = 100
+
+// Profile describes the shape of recorded traffic
+type Profile struct {
+	// Time span and number of the recorded requests
+	Duration time.Duration `json:"duration"`
+	Requests int           `json:"requests"`
+	// Requested endpoints, most requested first
+	Endpoints []Endpoint `json:"endpoints"`
+	// Quantiles of the time between two requests, from fastest to slowest
+	Intervals []time.Duration `json:"intervals"`
+}
+
+// Endpoint is a request of the mix, with the number of times it was recorded
+type Endpoint struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Count  int    `json:"count"`
+}
+
+// Build builds the profile of the requests recorded in [from, to). Zero times mean unbounded
+func Build(db *history.DB, from, to time.Time) (*Profile, error) {
+	counts := map[Endpoint]int{}
+	gaps := []time.Duration{}
+	var first, last time.Time
+	requests := 0
+	err := db.Query(from, to, func(r history.Record) error {
+		counts[Endpoint{Method: r.Method, URL: r.URL}]++
+		if requests == 0 {
+			first = r.Time
+		} else if gap := r.Time.Sub(last); gap >= 0 {
+			gaps = append(gaps, gap)
+		}
+		last = r.Time
+		requests++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if requests == 0 {
+		return nil, fmt.Errorf("no request recorded in this time range")
+	}
+
+	profile := &Profile{
+		Duration:  last.Sub(first),
+		Requests:  requests,
+		Endpoints: make([]Endpoint, 0, len(counts)),
+		Intervals: quantiles(gaps, intervalQuantiles),
+	}
+	for endpoint, count := range counts {
+		endpoint.Count = count
+		profile.Endpoints = append(profile.Endpoints, endpoint)
+	}
+	sort.Slice(profile.Endpoints, func(i, j int) bool {
+		a, b := profile.Endpoints[i], profile.Endpoints[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.URL+a.Method < b.URL+b.Method
+	})
+	return profile, nil
+}
+
+// quantiles returns n+1 evenly spaced quantiles of values (min to max)
+func quantiles(values []time.Duration, n int) []time.Duration {
+	if len(values) == 0 {
+		return []time.Duration{}
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	result := make([]time.Duration, n+1)
+	for i := range result {
+		result[i] = sorted[i*(len(sorted)-1)/n]
+	}
+	return result
+}
+
+// nextInterval draws a time between two requests from the recorded distribution
+func (p *Profile) nextInterval(rng *rand.Rand) time.Duration {
+	if len(p.Intervals) == 0 {
+		return 0
+	}
+	return p.Intervals[rng.IntN(len(p.Intervals))]
+}
+
+// nextEndpoint draws an endpoint, weighted by its recorded count
+func (p *Profile) nextEndpoint(rng *rand.Rand) Endpoint {
+	n := rng.IntN(p.Requests)
+	for _, endpoint := range p.Endpoints {
+		if n < endpoint.Count {
+			return endpoint
+		}
+		n -= endpoint.Count
+	}
+	return p.Endpoints[len(p.Endpoints)-1]
+}
+
+// ReplayOptions configures a replay
+type ReplayOptions struct {
+	// Client sending the requests (e.g. through the proxy)
+	Client *http.Client
+	// Replaces the scheme and host of recorded URLs, to target another upstream. nil keeps them
+	Upstream *url.URL
+	// Time multiplier: 2 sends requests twice as fast as recorded
+	Speed float64
+	// Number of requests to send. 0 means the recorded number
+	Requests int
+	// Seed of the random generator, for reproducible replays
+	Seed uint64
+}
+
+// ReplayResult summarizes a replay
+type ReplayResult struct {
+	Requests int            `json:"requests"`
+	Errors   int            `json:"errors"`
+	ByStatus map[int]int    `json:"by_status"`
+	Duration time.Duration  `json:"duration"`
+	Latency  LatencySummary `json:"latency"`
+}
+
+// LatencySummary holds the quantiles of request durations
+type LatencySummary struct {
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+// Replay sends requests following the profile, each in its own goroutine so slow responses do not delay the schedule.
+// Recorded request bodies and headers are not known, so requests are sent without them
+func Replay(ctx context.Context, p *Profile, opts ReplayOptions) (*ReplayResult, error) {
+	if len(p.Endpoints) == 0 {
+		return nil, fmt.Errorf("profile has no endpoints")
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	if opts.Requests == 0 {
+		opts.Requests = p.Requests
+	}
+	rng := rand.New(rand.NewPCG(opts.Seed, opts.Seed))
+
+	result := &ReplayResult{ByStatus: map[int]int{}}
+	latencies := []time.Duration{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	for i := 0; i < opts.Requests; i++ {
+		if i > 0 {
+			wait := time.Duration(float64(p.nextInterval(rng)) / opts.Speed)
+			select {
+			case <-ctx.Done():
+				wg.Wait()
+				return nil, ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		endpoint := p.nextEndpoint(rng)
+		target, err := url.Parse(endpoint.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL in profile '%s': %w", endpoint.URL, err)
+		}
+		if opts.Upstream != nil {
+			target.Scheme = opts.Upstream.Scheme
+			target.Host = opts.Upstream.Host
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			requestStart := time.Now()
+			status, err := send(ctx, opts.Client, endpoint.Method, target.String())
+			latency := time.Since(requestStart)
+
+			mu.Lock()
+			defer mu.Unlock()
+			result.Requests++
+			if err != nil {
+				result.Errors++
+				return
+			}
+			result.ByStatus[status]++
+			latencies = append(latencies, latency)
+		}()
+	}
+	wg.Wait()
+
+	result.Duration = time.Since(start)
+	if summary := quantiles(latencies, 100); len(summary) != 0 {
+		result.Latency = LatencySummary{P50: summary[50], P90: summary[90], P99: summary[99], Max: summary[100]}
+	}
+	return result, nil
+}
+
+// send sends a request and discards the response body. Returns the response status
+func send(ctx context.Context, client *http.Client, method string, target string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return 0, err
+	}
+	return resp.StatusCode, nil
+}
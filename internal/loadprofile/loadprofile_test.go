@@ -0,0 +1,93 @@
+package loadprofile
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/history"
+)
+
+func TestQuantiles(t *testing.T) {
+	values := []time.Duration{4, 1, 3, 2, 5}
+	got := quantiles(values, 4)
+	expected := []time.Duration{1, 2, 3, 4, 5}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("quantiles = %v, want %v", got, expected)
+		}
+	}
+	if len(quantiles(nil, 4)) != 0 {
+		t.Errorf("quantiles of no values should be empty")
+	}
+}
+
+func TestBuild(t *testing.T) {
+	db, err := history.Open(filepath.Join(t.TempDir(), "history.db"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+
+	start := time.Now().Add(-time.Hour)
+	urls := []string{"http://example.com/a", "http://example.com/b", "http://example.com/a", "http://example.com/a"}
+	for i, u := range urls {
+		if err := db.Insert(history.Record{Time: start.Add(time.Duration(i) * time.Second), Method: "GET", URL: u, Status: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	profile, err := Build(db, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Requests != 4 || profile.Duration != 3*time.Second {
+		t.Errorf("requests = %d, duration = %v, want 4 and 3s", profile.Requests, profile.Duration)
+	}
+	if len(profile.Endpoints) != 2 || profile.Endpoints[0].URL != "http://example.com/a" || profile.Endpoints[0].Count != 3 {
+		t.Errorf("unexpected endpoints: %+v", profile.Endpoints)
+	}
+	if profile.Intervals[0] != time.Second || profile.Intervals[intervalQuantiles] != time.Second {
+		t.Errorf("unexpected intervals: %v", profile.Intervals)
+	}
+
+	if _, err := Build(db, time.Now(), time.Time{}); err == nil {
+		t.Errorf("expected an error for an empty time range")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	profile := &Profile{
+		Requests: 4,
+		Endpoints: []Endpoint{
+			{Method: "GET", URL: "http://recorded.example.com/ok", Count: 3},
+			{Method: "GET", URL: "http://recorded.example.com/missing", Count: 1},
+		},
+		Intervals: []time.Duration{10 * time.Millisecond},
+	}
+	result, err := Replay(context.Background(), profile, ReplayOptions{Upstream: upstreamURL, Speed: 10, Requests: 20, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Requests != 20 || result.Errors != 0 || hits.Load() != 20 {
+		t.Errorf("unexpected result: %+v (upstream hits: %d)", result, hits.Load())
+	}
+	if result.ByStatus[200]+result.ByStatus[404] != 20 || result.ByStatus[404] == 0 {
+		t.Errorf("unexpected statuses: %v", result.ByStatus)
+	}
+}
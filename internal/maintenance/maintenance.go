@@ -0,0 +1,115 @@
+// Runs background jobs (GC, verification...) in scheduled maintenance windows,
+// so they do not compete with interactive traffic
+package maintenance
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cron"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Job is a maintenance job. Run must call throttle.Wait() between two units of work (e.g. cache entries)
+type Job struct {
+	Name string
+	Run  func(throttle *Throttle) error
+}
+
+// Scheduler runs jobs at the start of each maintenance window
+type Scheduler struct {
+	windows  []*cron.Schedule
+	duration time.Duration
+	delay    time.Duration
+	jobs     []Job
+	now      func() time.Time
+}
+
+// New creates a scheduler of windows starting on the given cron expressions and lasting duration.
+// Outside windows, jobs wait delay between two units of work
+func New(windows []string, duration time.Duration, delay time.Duration, jobs []Job) (*Scheduler, error) {
+	s := &Scheduler{
+		duration: duration,
+		delay:    delay,
+		jobs:     jobs,
+		now:      time.Now,
+	}
+	for _, expr := range windows {
+		schedule, err := cron.Parse(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window: %w", err)
+		}
+		s.windows = append(s.windows, schedule)
+	}
+	return s, nil
+}
+
+// InWindow reports whether t is inside a maintenance window
+func (s *Scheduler) InWindow(t time.Time) bool {
+	for _, window := range s.windows {
+		// Latest window start not after t
+		start := window.Next(t.Add(-s.duration))
+		if !start.IsZero() && !start.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextStart returns the next window start after t, zero if none
+func (s *Scheduler) nextStart(t time.Time) time.Time {
+	var next time.Time
+	for _, window := range s.windows {
+		start := window.Next(t)
+		if !start.IsZero() && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+	return next
+}
+
+// Run runs the jobs at each window start, until stop is closed
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	for {
+		next := s.nextStart(s.now())
+		if next.IsZero() {
+			logrus.Warnf("Maintenance windows never start, maintenance jobs disabled")
+			return
+		}
+		logrus.Debugf("Next maintenance window at %v", next)
+
+		select {
+		case <-time.After(time.Until(next)):
+			s.RunJobs()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// RunJobs runs all jobs once
+func (s *Scheduler) RunJobs() {
+	for _, job := range s.jobs {
+		logrus.Infof("Running maintenance job %s", job.Name)
+		start := s.now()
+		if err := job.Run(&Throttle{scheduler: s}); err != nil {
+			logrus.Errorf("Maintenance job %s failed: %v", job.Name, err)
+			continue
+		}
+		logrus.Infof("Maintenance job %s done in %v", job.Name, s.now().Sub(start).Round(time.Millisecond))
+	}
+}
+
+// Throttle slows jobs down when they run outside of maintenance windows
+type Throttle struct {
+	scheduler *Scheduler
+}
+
+// Wait pauses between two units of work if outside of a maintenance window
+func (t *Throttle) Wait() {
+	if t == nil || t.scheduler.delay == 0 || t.scheduler.InWindow(t.scheduler.now()) {
+		return
+	}
+	time.Sleep(t.scheduler.delay)
+}
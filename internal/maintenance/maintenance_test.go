@@ -0,0 +1,53 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInWindow(t *testing.T) {
+	s, err := New([]string{"0 2 * * *", "30 12 * * 6"}, 2*time.Hour, 0, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		t    time.Time
+		want bool
+	}{
+		{time.Date(2025, 1, 15, 1, 59, 0, 0, time.Local), false},
+		{time.Date(2025, 1, 15, 2, 0, 0, 0, time.Local), true},
+		{time.Date(2025, 1, 15, 3, 59, 0, 0, time.Local), true},
+		{time.Date(2025, 1, 15, 4, 0, 0, 0, time.Local), false},
+		// Saturday window
+		{time.Date(2025, 1, 18, 14, 0, 0, 0, time.Local), true},
+		{time.Date(2025, 1, 15, 14, 0, 0, 0, time.Local), false},
+	}
+	for _, tt := range tests {
+		if got := s.InWindow(tt.t); got != tt.want {
+			t.Errorf("InWindow(%v) = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+
+	if _, err := New([]string{"every night"}, time.Hour, 0, nil); err == nil {
+		t.Errorf("New() should fail on invalid windows")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	s, _ := New([]string{"0 2 * * *"}, time.Hour, 50*time.Millisecond, nil)
+
+	s.now = func() time.Time { return time.Date(2025, 1, 15, 2, 30, 0, 0, time.Local) }
+	start := time.Now()
+	(&Throttle{scheduler: s}).Wait()
+	if time.Since(start) > 40*time.Millisecond {
+		t.Errorf("Wait() should not pause inside a window")
+	}
+
+	s.now = func() time.Time { return time.Date(2025, 1, 15, 12, 0, 0, 0, time.Local) }
+	start = time.Now()
+	(&Throttle{scheduler: s}).Wait()
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Wait() should pause outside of windows")
+	}
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+
+	"github.com/elazarl/goproxy"
+)
+
+// ruleAction returns the action of a matching rule. Rules not from the config take the default action of the rules mode
+func (s *Server) ruleAction(rule Rule) config.RuleAction {
+	if configRule, ok := rule.(*ConfigRule); ok {
+		return s.config.Rules.ActionOf(&configRule.CacheRule)
+	}
+	return s.config.Rules.ActionOf(&config.CacheRule{})
+}
+
+// hasResponseConditions reports whether matching the rule depends on the response
+func (r *ConfigRule) hasResponseConditions() bool {
+	return len(r.StatusCodes) > 0 || r.hasSizeConditions() || (r.when != nil && r.when.UsesResponse())
+}
+
+// requestRule returns the first rule matching the request that can be decided before upstream is contacted, or nil.
+// Rules with response conditions are skipped, as they may not match the response
+func (s *Server) requestRule(requ *http.Request) *ConfigRule {
+	for _, rule := range s.rules {
+		configRule, ok := rule.(*ConfigRule)
+		if !ok || configRule.hasResponseConditions() {
+			continue
+		}
+		if configRule.MatchRequest(requ) {
+			return configRule
+		}
+	}
+	return nil
+}
+
+// blockResponse answers a request matching a block rule, with the status and body of its block option if set
+func blockResponse(requ *http.Request, rule *ConfigRule) *http.Response {
+	status, body := http.StatusForbidden, fmt.Sprintf("blocked by the proxy rule for %s\n", rule.BaseURI)
+	if rule.Block != nil {
+		if rule.Block.Status != 0 {
+			status = rule.Block.Status
+		}
+		if rule.Block.Body != "" {
+			body = rule.Block.Body
+		}
+	}
+	return goproxy.NewResponse(requ, goproxy.ContentTypeText, status, body)
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestRuleActions(t *testing.T) {
+	upstreamRequests := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests++
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir(), TTL: "1h"},
+		Rules: config.RulesConfig{Mode: config.RulesModeWhitelist, Rules: []config.CacheRule{
+			{BaseURI: upstream.URL + "/api/live", Methods: []string{"GET"}, Action: config.RuleActionBypass},
+			{BaseURI: upstream.URL + "/api", Methods: []string{"GET"}},
+			{BaseURI: upstream.URL + "/admin", Methods: []string{"GET"}, Action: config.RuleActionBlock},
+			{BaseURI: upstream.URL + "/prod", Methods: []string{"GET"}, Block: &config.BlockConfig{Status: http.StatusUnavailableForLegalReasons, Body: "no production traffic from tests\n"}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	tests := []struct {
+		path         string
+		wantStatus   int
+		wantCache    []string
+		wantUpstream int
+	}{
+		{path: "/api/live/score", wantStatus: http.StatusOK, wantCache: []string{"DISABLED", "DISABLED"}, wantUpstream: 2},
+		{path: "/api/users", wantStatus: http.StatusOK, wantCache: []string{"MISS", "HIT"}, wantUpstream: 1},
+		{path: "/admin/users", wantStatus: http.StatusForbidden, wantCache: []string{"BLOCKED", "BLOCKED"}, wantUpstream: 0},
+		{path: "/prod/orders", wantStatus: http.StatusUnavailableForLegalReasons, wantCache: []string{"BLOCKED", "BLOCKED"}, wantUpstream: 0},
+		{path: "/other", wantStatus: http.StatusOK, wantCache: []string{"DISABLED", "DISABLED"}, wantUpstream: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			upstreamRequests = 0
+			for i, want := range tt.wantCache {
+				resp, err := client.Get(upstream.URL + tt.path)
+				if err != nil {
+					t.Fatalf("GET error = %v", err)
+				}
+				_ = resp.Body.Close()
+				if resp.StatusCode != tt.wantStatus || resp.Header.Get("X-Cache") != want {
+					t.Errorf("GET #%d = %d with X-Cache %s, want %d with X-Cache %s", i+1, resp.StatusCode, resp.Header.Get("X-Cache"), tt.wantStatus, want)
+				}
+			}
+			if upstreamRequests != tt.wantUpstream {
+				t.Errorf("upstream requests = %d, want %d", upstreamRequests, tt.wantUpstream)
+			}
+		})
+	}
+}
+
+func TestBlockResponse(t *testing.T) {
+	requ := httptest.NewRequest(http.MethodGet, "https://api.example.com/orders", nil)
+	tests := []struct {
+		block      *config.BlockConfig
+		wantStatus int
+		wantBody   string
+	}{
+		{nil, http.StatusForbidden, "blocked by the proxy rule for https://api.example.com\n"},
+		{&config.BlockConfig{Status: http.StatusUnavailableForLegalReasons}, http.StatusUnavailableForLegalReasons, "blocked by the proxy rule for https://api.example.com\n"},
+		{&config.BlockConfig{Body: "production is off limits\n"}, http.StatusForbidden, "production is off limits\n"},
+	}
+	for _, tt := range tests {
+		rule := &ConfigRule{CacheRule: config.CacheRule{BaseURI: "https://api.example.com", Action: config.RuleActionBlock, Block: tt.block}}
+		resp := blockResponse(requ, rule)
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != tt.wantStatus || string(body) != tt.wantBody {
+			t.Errorf("blockResponse(%+v) = %d %q, want %d %q", tt.block, resp.StatusCode, body, tt.wantStatus, tt.wantBody)
+		}
+	}
+}
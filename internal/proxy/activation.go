@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// First file descriptor passed by systemd socket activation
+const listenFdsStart = 3
+
+// activatedListeners holds the sockets passed by systemd socket activation (see sd_listen_fds(3))
+type activatedListeners struct {
+	mu        sync.Mutex
+	listeners []net.Listener
+	names     []string
+}
+
+var inherited = sync.OnceValues(func() (*activatedListeners, error) {
+	return listenersFromEnv(os.Getpid())
+})
+
+// listenersFromEnv returns the sockets passed through LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES, and unsets these variables
+// so child processes do not take them for their own
+func listenersFromEnv(pid int) (*activatedListeners, error) {
+	activated := &activatedListeners{}
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return activated, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: '%s'", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := range count {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(listenFdsStart+i), name)
+		ln, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited socket %d (%s) is not a listening socket: %w", listenFdsStart+i, name, err)
+		}
+		activated.listeners = append(activated.listeners, ln)
+		activated.names = append(activated.names, name)
+	}
+	return activated, nil
+}
+
+// take returns the inherited socket named name (see FileDescriptorName= of systemd socket units), or else the one at index,
+// or nil if there is none. Each socket is returned once
+func (a *activatedListeners) take(name string, index int) net.Listener {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	pick := -1
+	for i, n := range a.names {
+		if n == name && a.listeners[i] != nil {
+			pick = i
+			break
+		}
+	}
+	if pick == -1 && index < len(a.listeners) && !isListenerName(a.names[index]) {
+		pick = index
+	}
+	if pick == -1 || a.listeners[pick] == nil {
+		return nil
+	}
+	ln := a.listeners[pick]
+	a.listeners[pick] = nil
+	return ln
+}
+
+// isListenerName reports whether a socket name is one of the names the proxy looks for, so it is not taken by position for another listener
+func isListenerName(name string) bool {
+	return name == "http" || name == "https" || name == "http-transparent"
+}
+
+// listen returns the socket passed by systemd for a listener (name "http", "https" or "http-transparent", or else by position), or listens on address
+func listen(name string, index int, address string) (net.Listener, error) {
+	return listenWith(&net.ListenConfig{}, name, index, address)
+}
+
+// listenWith is listen, creating the socket with lc if systemd did not pass it
+func listenWith(lc *net.ListenConfig, name string, index int, address string) (net.Listener, error) {
+	activated, err := inherited()
+	if err != nil {
+		return nil, err
+	}
+	if ln := activated.take(name, index); ln != nil {
+		logrus.Infof("Using socket %s passed by systemd for %s", ln.Addr(), name)
+		return ln, nil
+	}
+	return lc.Listen(context.Background(), "tcp", address)
+}
+
+// idleTracker records the last activity of the proxy
+type idleTracker struct {
+	last atomic.Int64
+}
+
+func (t *idleTracker) touch() {
+	t.last.Store(time.Now().UnixNano())
+}
+
+// idleFor returns the time since the last activity
+func (t *idleTracker) idleFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, t.last.Load()))
+}
+
+// shutdownWhenIdle stops the server once it received no request for timeout
+func (s *Server) shutdownWhenIdle(server *http.Server, timeout time.Duration) {
+	interval := max(timeout/10, time.Second)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if s.idle.idleFor(now) < timeout {
+			continue
+		}
+		logrus.Infof("No request for %v, stopping", timeout)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := server.Shutdown(ctx); err != nil {
+			logrus.Errorf("Failed to stop cleanly: %v", err)
+		}
+		cancel()
+		return
+	}
+}
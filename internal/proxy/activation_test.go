@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestActivatedListenersTake(t *testing.T) {
+	newListener := func() net.Listener {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		t.Cleanup(func() { _ = ln.Close() })
+		return ln
+	}
+
+	t.Run("by name", func(t *testing.T) {
+		https, http := newListener(), newListener()
+		activated := &activatedListeners{listeners: []net.Listener{https, http}, names: []string{"https", "http"}}
+		if got := activated.take("http", 0); got != http {
+			t.Errorf("take(http) = %v, want the socket named http", got)
+		}
+		if got := activated.take("http", 0); got != nil {
+			t.Errorf("take(http) returned a socket twice")
+		}
+		if got := activated.take("https", 1); got != https {
+			t.Errorf("take(https) = %v, want the socket named https", got)
+		}
+	})
+
+	t.Run("by position", func(t *testing.T) {
+		first, second := newListener(), newListener()
+		activated := &activatedListeners{listeners: []net.Listener{first, second}, names: []string{"", "unknown"}}
+		if got := activated.take("http", 0); got != first {
+			t.Errorf("take(http, 0) = %v, want the first socket", got)
+		}
+		if got := activated.take("https", 1); got != second {
+			t.Errorf("take(https, 1) = %v, want the second socket", got)
+		}
+	})
+
+	t.Run("named for another listener", func(t *testing.T) {
+		activated := &activatedListeners{listeners: []net.Listener{newListener()}, names: []string{"https"}}
+		if got := activated.take("http", 0); got != nil {
+			t.Errorf("take(http, 0) took the socket named https")
+		}
+	})
+}
+
+func TestListenersFromEnvOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "2")
+	activated, err := listenersFromEnv(2)
+	if err != nil {
+		t.Fatalf("listenersFromEnv() error = %v", err)
+	}
+	if len(activated.listeners) != 0 {
+		t.Errorf("took %d sockets meant for another process", len(activated.listeners))
+	}
+}
+
+func TestIdleTracker(t *testing.T) {
+	var idle idleTracker
+	idle.touch()
+	if got := idle.idleFor(time.Now().Add(time.Minute)); got < time.Minute || got > time.Minute+time.Second {
+		t.Errorf("idleFor() = %v, want about 1m", got)
+	}
+}
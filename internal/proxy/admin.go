@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/admin"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StartAdmin serves the admin API in the background. Returns an error if it cannot listen on address
+func (s *Server) StartAdmin(address string) error {
+	api := admin.New(admin.Options{
+		History: s.history.DB(),
+		Cache:   s.cacheManager,
+		Config:  s.config,
+		Stats:   s.stats.Snapshot,
+		KeyNamespace: func(clientIdentity string) string {
+			return KeyNamespace(s.config, clientIdentity)
+		},
+		Explain:         s.explainDecision,
+		SetServeHeaders: s.SetServeHeaders,
+		StorageSample:   s.storageSampler.Last,
+		EntryExpiry: func(resp *http.Response) time.Time {
+			return InspectEntry(resp).ExpiresAt(s.cacheTTL)
+		},
+		Events:       s.liveEvents.Subscribe,
+		DiffCached:   s.DiffCached,
+		VerifyCached: s.VerifyCached,
+	})
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := http.Serve(ln, api); err != nil {
+			logrus.Errorf("Admin API failed: %v", err)
+		}
+	}()
+	return nil
+}
@@ -1,22 +1,161 @@
 package proxy
 
 import (
+	"fmt"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
 )
 
+// NewCacheManager creates the HTTP cache described by the configuration
+func NewCacheManager(cfg *config.Config) (*httpcache.HTTPCache, error) {
+	cacheTTL, err := cfg.GetCacheTTL()
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache TTL: %w", err)
+	}
+	staleTTL, err := cfg.GetStaleTTL()
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache stale TTL: %w", err)
+	}
+
+	maxSize, err := cfg.GetCacheMaxSize()
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache max size: %w", err)
+	}
+
+	var generic cache.GenericCache
+	if len(cfg.Cache.Chain) > 0 {
+		retryInterval, err := cfg.GetChainRetryInterval()
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache chain retry interval: %w", err)
+		}
+		backends := []cache.NamedCache{}
+		for _, name := range cfg.Cache.Chain {
+			backend, err := newBackend(cfg, name, cacheTTL, staleTTL)
+			if err != nil {
+				return nil, err
+			}
+			backends = append(backends, cache.NamedCache{Name: name, Cache: backend})
+		}
+		generic = cache.NewChain(backends, retryInterval)
+	} else {
+		generic, err = newBackend(cfg, cfg.Cache.Backend, cacheTTL, staleTTL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if maxSize != 0 || cfg.Cache.MaxEntries > 0 {
+		policy, err := cache.NewEvictionPolicy(cfg.Cache.EvictionPolicy, cacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		generic = cache.NewEvictingWithLimits(generic, cache.EvictionLimits{MaxSize: maxSize, MaxEntries: cfg.Cache.MaxEntries}, policy)
+	}
+	if secondary := cfg.Cache.Secondary; secondary.Folder != "" {
+		// Outside of eviction, which only applies to the entries of the cache
+		generic = cache.NewFallback(generic, cache.NewGenericDiskWithOptions(secondary.Folder, cache.DiskOptions{
+			TTL:       cacheTTL,
+			StaleTTL:  staleTTL,
+			NetworkFS: secondary.NetworkFS,
+			Layout:    secondary.Layout,
+		}), secondary.WriteBack)
+	}
+	if err := generic.Init(); err != nil {
+		return nil, fmt.Errorf("failed to initialize cache: %w", err)
+	}
+	return httpcache.New(generic), nil
+}
+
+// newBackend creates a cache storage backend by name
+func newBackend(cfg *config.Config, name string, ttl time.Duration, staleTTL time.Duration) (cache.GenericCache, error) {
+	switch name {
+	case "memory":
+		snapshotInterval, err := cfg.GetSnapshotInterval()
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache snapshot interval: %w", err)
+		}
+		return cache.NewGenericMemory(cache.MemoryOptions{
+			TTL:              ttl,
+			StaleTTL:         staleTTL,
+			SnapshotPath:     cfg.Cache.Snapshot.Path,
+			SnapshotInterval: snapshotInterval,
+		}), nil
+	case "", "disk":
+		return cache.NewGenericDiskWithOptions(cfg.Cache.Folder, cache.DiskOptions{TTL: ttl, StaleTTL: staleTTL, NetworkFS: cfg.Cache.NetworkFS, Layout: cfg.Cache.Layout}), nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend '%s'", name)
+	}
+}
+
+// namespaceDir turns a namespace name into a key directory, which cannot collide with hosts
+func namespaceDir(name string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_.", r) {
+			return r
+		}
+		return '_'
+	}, name)
+	return "@" + sanitized
+}
+
+// KeyNamespace returns the key namespace of requests, from the configured cache namespace and the client identity (may be empty)
+func KeyNamespace(cfg *config.Config, clientIdentity string) string {
+	parts := []string{}
+	if cfg.Cache.Namespace != "" {
+		parts = append(parts, namespaceDir(cfg.Cache.Namespace))
+	}
+	if clientIdentity != "" {
+		parts = append(parts, namespaceDir("client-"+clientIdentity))
+	}
+	return filepath.Join(parts...)
+}
+
+// keyOptions returns the cache key options applying to a request, from global config and matching rules
+func (s *Server) keyOptions(requ *http.Request, userData *ctxUserData) httpcache.KeyOptions {
+	opts := httpcache.KeyOptions{
+		IgnoreQueryParams: append([]string{}, s.config.Cache.IgnoreQueryParams...),
+		Headers:           s.config.Cache.KeyHeaders,
+		Namespace:         KeyNamespace(s.config, userData.clientIdentity),
+	}
+	keyHeadersOverridden := false
+	for _, rule := range s.matchingConfigRules(requ) {
+		opts.IgnoreQueryParams = append(opts.IgnoreQueryParams, rule.IgnoreQueryParams...)
+		// First matching rule defining key headers wins
+		if !keyHeadersOverridden && rule.KeyHeaders != nil {
+			opts.Headers = rule.KeyHeaders
+			keyHeadersOverridden = true
+		}
+	}
+	return opts
+}
+
 // shouldBeCached determines if a response should be cached based on rules
 func (s *Server) shouldBeCached(requ *http.Request, resp *http.Response) bool {
-	matched := false
-	for _, rule := range s.rules {
-		if rule.Match(requ, resp) {
-			matched = true
-			break
-		}
+	// Rate limits are remembered for their Retry-After window only
+	if s.rateLimiter != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return false
 	}
 
-	if s.config.Rules.Mode == "whitelist" {
-		return matched
-	} else {
-		return !matched
+	// The first matching rule decides
+	if i := s.matchingRule(requ, resp); i != -1 {
+		return s.ruleAction(s.rules[i]) == config.RuleActionCache
+	}
+	return s.config.Rules.Mode != config.RulesModeWhitelist
+}
+
+// matchingRule returns the index of the first rule matching a response, -1 if none does
+func (s *Server) matchingRule(requ *http.Request, resp *http.Response) int {
+	s.measureBody(requ, resp)
+	for i, rule := range s.rules {
+		if rule.Match(requ, resp) {
+			return i
+		}
 	}
+	return -1
 }
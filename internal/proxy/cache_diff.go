@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/admin"
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+	"github.com/iTrooz/caching-dev-proxy/internal/diff"
+)
+
+// DiffCached sends the stored requests of the cached entries of a URL to upstream again, bypassing the cache,
+// and lists the differences of the live responses from the entries. The entries are left as they are
+func (s *Server) DiffCached(namespace string, u *url.URL) ([]admin.EntryDiff, error) {
+	keys, err := s.cacheManager.FindURL(namespace, u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search cache: %w", err)
+	}
+	diffs := []admin.EntryDiff{}
+	for _, key := range keys {
+		cached, err := s.cacheManager.GetStaleKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cache entry %s: %w", key, err)
+		}
+		if cached == nil {
+			continue // removed in the meantime
+		}
+		diffs = append(diffs, s.diffEntry(key, cached, false))
+	}
+	return diffs, nil
+}
+
+// VerifyCached revalidates with upstream the cached entries whose key starts with prefix and matching filter, or n of them drawn at random if n > 0.
+// Entries are revalidated in key order with conditional requests when they have validators (ETag, Last-Modified), and fn gets the result of each
+func (s *Server) VerifyCached(prefix string, filter httpcache.EntryFilter, n int, fn func(admin.EntryDiff) error) error {
+	rng := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	keys := []string{}
+	var total int64
+	err := s.cacheManager.List(prefix, filter, func(info cache.EntryInfo, resp *http.Response) error {
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		if strings.HasSuffix(info.Key, partialSuffix) {
+			return nil
+		}
+		total++
+		if n == 0 || len(keys) < n {
+			keys = append(keys, info.Key)
+		} else if i := rng.Int64N(total); i < int64(n) {
+			keys[i] = info.Key
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list entries: %w", err)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		cached, err := s.cacheManager.GetStaleKey(key)
+		if err != nil {
+			return fmt.Errorf("failed to read cache entry %s: %w", key, err)
+		}
+		if cached == nil {
+			continue // removed in the meantime
+		}
+		if err := fn(s.diffEntry(key, cached, true)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffEntry compares a cached entry with the live response to its stored request.
+// If conditional is set, the request carries the validators of the entry, so upstream may answer 304 Not Modified instead
+func (s *Server) diffEntry(key string, cached *http.Response, conditional bool) admin.EntryDiff {
+	entryDiff := admin.EntryDiff{Key: key, CachedStatus: cached.StatusCode, Differences: []string{}}
+	// Headers set when serving the entry are not part of the comparison, only what upstream sent
+	entryDiff.Stored = InspectEntry(cached).Stored
+	for name := range cached.Header {
+		if strings.HasPrefix(name, "X-Cache") {
+			delete(cached.Header, name)
+		}
+	}
+	if reason := replayable(cached.Request); reason != "" {
+		entryDiff.Error = reason
+		return entryDiff
+	}
+	entryDiff.Method = cached.Request.Method
+	entryDiff.URL = cached.Request.URL.String()
+
+	cachedBody, err := comparableBody(cached)
+	if err != nil {
+		entryDiff.Error = fmt.Sprintf("failed to read cached body: %v", err)
+		return entryDiff
+	}
+	req := cached.Request.Clone(context.Background())
+	req.RequestURI = ""
+	// Those of the client the entry was stored for would be answered for its own copy
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+		req.Header.Del(name)
+	}
+	if conditional {
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := cached.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+	live, err := s.upstream.RoundTrip(req)
+	if err != nil {
+		entryDiff.Error = fmt.Sprintf("upstream request failed: %v", err)
+		return entryDiff
+	}
+	entryDiff.LiveStatus = live.StatusCode
+	if conditional && live.StatusCode == http.StatusNotModified {
+		_ = live.Body.Close()
+		entryDiff.NotModified = true
+		return entryDiff
+	}
+	liveBody, err := comparableBody(live)
+	if err != nil {
+		entryDiff.Error = fmt.Sprintf("failed to read live body: %v", err)
+		return entryDiff
+	}
+
+	entryDiff.Changed = cached.StatusCode != live.StatusCode || !bytes.Equal(cachedBody, liveBody)
+	entryDiff.Differences = diff.Responses(
+		diff.Response{Status: cached.StatusCode, Header: cached.Header, Body: cachedBody},
+		diff.Response{Status: live.StatusCode, Header: live.Header, Body: liveBody},
+		diff.DefaultIgnoredHeaders,
+	)
+	return entryDiff
+}
+
+// comparableBody reads the body of a response, decompressed if gzip-encoded, so compressed and uncompressed bodies compare equal
+func comparableBody(resp *http.Response) ([]byte, error) {
+	body, err := rewritableBody(resp)
+	if err != nil || body != nil {
+		return body, err
+	}
+	// Other encoding, compared as is
+	defer func() { _ = resp.Body.Close() }()
+	return io.ReadAll(resp.Body)
+}
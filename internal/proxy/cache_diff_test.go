@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/admin"
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestDiffCached(t *testing.T) {
+	var version atomic.Value
+	version.Store("1")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"version": "`+version.Load().(string)+`"}`)
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	get := func() (string, string) {
+		resp, err := client.Get(upstream.URL + "/config")
+		if err != nil {
+			t.Fatalf("GET error = %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.Header.Get("X-Cache"), string(body)
+	}
+	get()
+
+	u, _ := url.Parse(upstream.URL + "/config")
+	diffs, err := s.DiffCached("", u)
+	if err != nil {
+		t.Fatalf("DiffCached() error = %v", err)
+	}
+	if len(diffs) != 1 || diffs[0].Error != "" || len(diffs[0].Differences) != 0 || diffs[0].Stored.IsZero() {
+		t.Fatalf("DiffCached() = %+v, want an up to date entry", diffs)
+	}
+
+	version.Store("2")
+	diffs, err = s.DiffCached("", u)
+	if err != nil {
+		t.Fatalf("DiffCached() error = %v", err)
+	}
+	if len(diffs) != 1 || diffs[0].URL != u.String() || diffs[0].CachedStatus != http.StatusOK || diffs[0].LiveStatus != http.StatusOK {
+		t.Fatalf("DiffCached() = %+v, want the entry of %s", diffs, u)
+	}
+	if want := `body $.version: "1" -> "2"`; !slices.Contains(diffs[0].Differences, want) {
+		t.Errorf("differences = %q, want %q", diffs[0].Differences, want)
+	}
+	if cacheStatus, body := get(); cacheStatus != "HIT" || body != `{"version": "1"}` {
+		t.Errorf("X-Cache = %s, body = %s, want the entry left as it was", cacheStatus, body)
+	}
+
+	other, _ := url.Parse(upstream.URL + "/missing")
+	if diffs, err := s.DiffCached("", other); err != nil || len(diffs) != 0 {
+		t.Errorf("DiffCached() = %+v, %v, want no entry", diffs, err)
+	}
+}
+
+func TestVerifyCached(t *testing.T) {
+	var version atomic.Value
+	version.Store("1")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/etag" {
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			_, _ = io.WriteString(w, "stable")
+			return
+		}
+		_, _ = io.WriteString(w, "version "+version.Load().(string))
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for _, path := range []string{"/etag", "/plain"} {
+		resp, err := client.Get(upstream.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+	version.Store("2")
+
+	results := map[string]admin.EntryDiff{}
+	err = s.VerifyCached("", httpcache.EntryFilter{}, 0, func(entryDiff admin.EntryDiff) error {
+		results[entryDiff.URL] = entryDiff
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("VerifyCached() error = %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("VerifyCached() = %+v, want both entries", results)
+	}
+	if r := results[upstream.URL+"/etag"]; !r.NotModified || r.Changed || r.LiveStatus != http.StatusNotModified {
+		t.Errorf("entry with ETag = %+v, want revalidated as not modified", r)
+	}
+	if r := results[upstream.URL+"/plain"]; !r.Changed || r.NotModified {
+		t.Errorf("entry without validators = %+v, want changed", r)
+	}
+
+	sampled := 0
+	err = s.VerifyCached("", httpcache.EntryFilter{}, 1, func(admin.EntryDiff) error {
+		sampled++
+		return nil
+	})
+	if err != nil || sampled != 1 {
+		t.Errorf("VerifyCached(sample 1) verified %d entries, error = %v, want 1", sampled, err)
+	}
+}
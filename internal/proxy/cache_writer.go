@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+
+	"github.com/sirupsen/logrus"
+)
+
+// cacheWriter stores responses in cache from a bounded queue, emptied by background workers (see cache.async_writes)
+type cacheWriter struct {
+	cache *httpcache.HTTPCache
+	queue chan cacheWrite
+	wg    sync.WaitGroup
+	// held for writing while closing, so no response is queued once the queue is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+// cacheWrite is a response waiting to be stored
+type cacheWrite struct {
+	key  string
+	resp *http.Response
+}
+
+// newCacheWriter starts the workers. Without workers, responses are stored synchronously
+func newCacheWriter(cache *httpcache.HTTPCache, workers int, queueSize int) *cacheWriter {
+	w := &cacheWriter{cache: cache}
+	if workers <= 0 {
+		return w
+	}
+	w.queue = make(chan cacheWrite, queueSize)
+	for range workers {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			for write := range w.queue {
+				if err := w.cache.SetKey(write.key, write.resp); err != nil {
+					logrus.Errorf("Failed to cache response of %s: %v", write.resp.Request.URL.String(), err)
+				}
+			}
+		}()
+	}
+	return w
+}
+
+// store stores the response under key, in the background if there is room in the queue.
+// Errors of background writes are logged, not returned. Once the writer is closed, responses are stored synchronously
+func (w *cacheWriter) store(key string, resp *http.Response) error {
+	if w.queue == nil {
+		return w.cache.SetKey(key, resp)
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return w.cache.SetKey(key, resp)
+	}
+	select {
+	case w.queue <- cacheWrite{key: key, resp: resp}:
+		return nil
+	default:
+		logrus.Debugf("Cache write queue is full, storing %s before responding", resp.Request.URL.String())
+		return w.cache.SetKey(key, resp)
+	}
+}
+
+// close waits for the queued responses to be stored. Responses stored afterwards, by requests still being handled, are stored synchronously
+func (w *cacheWriter) close() {
+	if w.queue == nil {
+		return
+	}
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+	w.wg.Wait()
+}
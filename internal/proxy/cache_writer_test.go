@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestCacheWriter(t *testing.T) {
+	cacheManager, err := NewCacheManager(&config.Config{Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A queue smaller than the writes, so some are stored synchronously
+	writer := newCacheWriter(cacheManager, 2, 1)
+
+	const writes = 20
+	for i := range writes {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://example.com/%d", i), nil)
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			ProtoMajor: 1, ProtoMinor: 1,
+			Header:  http.Header{},
+			Body:    io.NopCloser(strings.NewReader(fmt.Sprintf("body %d", i))),
+			Request: req,
+		}
+		if err := writer.store(fmt.Sprintf("key-%d", i), resp); err != nil {
+			t.Fatalf("store() error = %v", err)
+		}
+	}
+	writer.close()
+
+	for i := range writes {
+		resp, err := cacheManager.GetKey(fmt.Sprintf("key-%d", i))
+		if err != nil || resp == nil {
+			t.Fatalf("entry %d not stored: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != fmt.Sprintf("body %d", i) {
+			t.Errorf("entry %d body = %q", i, body)
+		}
+	}
+}
+
+func TestCacheWriterStoreAfterClose(t *testing.T) {
+	cacheManager, err := NewCacheManager(&config.Config{Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := newCacheWriter(cacheManager, 2, 1)
+	writer.close()
+
+	// A request still being handled at shutdown must not panic
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/late", nil)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1, ProtoMinor: 1,
+		Header:  http.Header{},
+		Body:    io.NopCloser(strings.NewReader("late")),
+		Request: req,
+	}
+	if err := writer.store("late", resp); err != nil {
+		t.Fatalf("store() error = %v", err)
+	}
+	if resp, err := cacheManager.GetKey("late"); err != nil || resp == nil {
+		t.Fatalf("entry not stored: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+	"github.com/iTrooz/caching-dev-proxy/internal/diff"
+
+	"github.com/sirupsen/logrus"
+)
+
+// canaryRecord is a line of the canary report
+type canaryRecord struct {
+	Time            time.Time `json:"time"`
+	Method          string    `json:"method"`
+	URL             string    `json:"url"`
+	CandidateURL    string    `json:"candidate_url"`
+	PrimaryStatus   int       `json:"primary_status"`
+	CandidateStatus int       `json:"candidate_status"`
+	Equal           bool      `json:"equal"`
+	Differences     []string  `json:"differences"`
+}
+
+// canaryReporter appends comparisons to the canary report
+type canaryReporter struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (r *canaryReporter) Write(record canaryRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	file, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open canary report: %w", err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write canary report: %w", err)
+	}
+	return file.Close()
+}
+
+// canaryComparison returns the comparison configured for a request, or nil
+func (s *Server) canaryComparison(requ *http.Request) *config.CanaryComparison {
+	for i, comparison := range s.config.Canary.Comparisons {
+		if strings.HasPrefix(requ.URL.String(), comparison.BaseURI) {
+			return &s.config.Canary.Comparisons[i]
+		}
+	}
+	return nil
+}
+
+// compareCanary sends the request to the candidate upstream of its comparison, if any, stores its response and reports the differences.
+// Returns the response to serve: the primary one, or the candidate one if configured
+func (s *Server) compareCanary(requ *http.Request, resp *http.Response, userData *ctxUserData) *http.Response {
+	comparison := s.canaryComparison(requ)
+	if comparison == nil {
+		return resp
+	}
+	primary, err := copyResponse(resp)
+	if err != nil {
+		logrus.Errorf("Canary(url=%s): Failed to read primary response: %v", requ.URL.String(), err)
+		return resp
+	}
+	primaryBody, _ := io.ReadAll(primary.Body)
+	// Headers added by the proxy are not part of the comparison
+	primaryHeader := primary.Header.Clone()
+	for name := range primaryHeader {
+		if strings.HasPrefix(name, "X-Cache") {
+			delete(primaryHeader, name)
+		}
+	}
+
+	candidateURL, err := url.Parse(comparison.Candidate + strings.TrimPrefix(requ.URL.String(), comparison.BaseURI))
+	if err != nil {
+		logrus.Errorf("Canary(url=%s): Invalid candidate URL: %v", requ.URL.String(), err)
+		return resp
+	}
+	candidateReq := withBody(requ.Clone(context.Background()), userData.requestBody)
+	candidateReq.URL = candidateURL
+	candidateReq.Host = candidateURL.Host
+	candidateReq.RequestURI = ""
+
+	compare := func() (*http.Response, []byte, error) {
+		candidate, body, err := s.fetchCandidate(candidateReq, userData)
+		if err != nil {
+			return nil, nil, err
+		}
+		differences := diff.Responses(
+			diff.Response{Status: primary.StatusCode, Header: primaryHeader, Body: primaryBody},
+			diff.Response{Status: candidate.StatusCode, Header: candidate.Header, Body: body},
+			diff.DefaultIgnoredHeaders,
+		)
+		if len(differences) != 0 {
+			logrus.Infof("Canary(url=%s): %d differences with %s", requ.URL.String(), len(differences), candidateURL.String())
+		}
+		err = s.canaryReport.Write(canaryRecord{
+			Time:            time.Now(),
+			Method:          requ.Method,
+			URL:             requ.URL.String(),
+			CandidateURL:    candidateURL.String(),
+			PrimaryStatus:   primary.StatusCode,
+			CandidateStatus: candidate.StatusCode,
+			Equal:           len(differences) == 0,
+			Differences:     differences,
+		})
+		if err != nil {
+			logrus.Errorf("Canary(url=%s): %v", requ.URL.String(), err)
+		}
+		return candidate, body, nil
+	}
+
+	if comparison.Serve != "candidate" {
+		go func() {
+			if _, _, err := compare(); err != nil {
+				logrus.Errorf("Canary(url=%s): Failed to request candidate %s: %v", requ.URL.String(), candidateURL.String(), err)
+			}
+		}()
+		resp.Header.Set("X-Cache-Canary", "primary")
+		return resp
+	}
+
+	candidate, body, err := compare()
+	if err != nil {
+		logrus.Errorf("Canary(url=%s): Failed to request candidate %s, serving primary: %v", requ.URL.String(), candidateURL.String(), err)
+		resp.Header.Set("X-Cache-Canary", "primary")
+		return resp
+	}
+	served := *candidate
+	served.Header = candidate.Header.Clone()
+	served.Header.Set("X-Cache", resp.Header.Get("X-Cache"))
+	served.Header.Set("X-Cache-Canary", "candidate")
+	served.Body = io.NopCloser(bytes.NewReader(body))
+	served.Request = requ
+	return &served
+}
+
+// fetchCandidate requests the candidate upstream, and stores its response under its own cache key
+func (s *Server) fetchCandidate(req *http.Request, userData *ctxUserData) (*http.Response, []byte, error) {
+	resp, err := s.upstream.RoundTrip(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	respCopy, err := copyResponse(resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, _ := io.ReadAll(respCopy.Body)
+
+	if cacheable, ttl := s.cacheDecision(req, resp); cacheable {
+		ttl = overrideTTL(ttl, userData.ttl)
+		// Same request apart from its URL: only the key directory differs. Headers were already altered for upstream, so the key cannot be generated again
+		key := filepath.Join(KeyNamespace(s.config, userData.clientIdentity), httpcache.KeyDir(req.URL), filepath.Base(userData.key))
+		respCopy.Body = io.NopCloser(bytes.NewReader(body))
+		respCopy.Request = withBody(req, userData.requestBody)
+		stored, err := s.toStore(respCopy, ttl)
+		if err == nil {
+			err = s.cacheManager.SetKey(key, stored)
+		}
+		if err != nil {
+			logrus.Errorf("Canary(url=%s): Failed to cache candidate response: %v", req.URL.String(), err)
+		}
+	}
+	return resp, body, nil
+}
@@ -1,29 +1,88 @@
 package proxy
 
 import (
+	"container/list"
 	"crypto/tls"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-// simpleCertStore implements goproxy.CertStorage for certificate caching
-type simpleCertStore struct {
-	certs map[string]*tls.Certificate
+// Time before expiry a host certificate is generated again, so clients never receive an expired certificate
+const certRenewBefore = 24 * time.Hour
+
+// certStore implements goproxy.CertStorage, keeping generated host certificates in memory.
+// It holds at most maxSize certificates (0 means unlimited), evicting the least recently used one
+type certStore struct {
+	maxSize int
+	// Replaced in tests
+	now func() time.Time
+
+	mu sync.Mutex
+	// hostnames, most recently used first
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+// certEntry is an element of certStore.order
+type certEntry struct {
+	hostname string
+	cert     *tls.Certificate
 }
 
-func (s *simpleCertStore) Fetch(hostname string, gen func() (*tls.Certificate, error)) (*tls.Certificate, error) {
-	cert, ok := s.certs[hostname]
-	if ok {
+func newCertStore(maxSize int) *certStore {
+	return &certStore{maxSize: maxSize, now: time.Now, order: list.New(), elements: make(map[string]*list.Element)}
+}
+
+func (s *certStore) Fetch(hostname string, gen func() (*tls.Certificate, error)) (*tls.Certificate, error) {
+	if cert := s.get(hostname); cert != nil {
 		return cert, nil
 	}
 
+	// Generated without holding the lock, as it is slow
 	cert, err := gen()
 	if err != nil {
 		logrus.Errorf("Failed to generate certificate for hostname '%s': %v", hostname, err)
 		return nil, fmt.Errorf("failed to generate certificate for hostname '%s': %w", hostname, err)
 	}
-
-	s.certs[hostname] = cert
+	s.put(hostname, cert)
 	return cert, nil
 }
+
+// get returns the certificate of hostname, or nil if there is none or it is about to expire
+func (s *certStore) get(hostname string) *tls.Certificate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.elements[hostname]
+	if !ok {
+		return nil
+	}
+	cert := element.Value.(*certEntry).cert
+	if cert.Leaf != nil && s.now().Add(certRenewBefore).After(cert.Leaf.NotAfter) {
+		logrus.Debugf("Certificate for hostname '%s' expires on %s, generating it again", hostname, cert.Leaf.NotAfter)
+		s.order.Remove(element)
+		delete(s.elements, hostname)
+		return nil
+	}
+	s.order.MoveToFront(element)
+	return cert
+}
+
+// put stores the certificate of hostname, evicting the least recently used ones over the maximum size
+func (s *certStore) put(hostname string, cert *tls.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.elements[hostname]; ok {
+		element.Value.(*certEntry).cert = cert
+		s.order.MoveToFront(element)
+		return
+	}
+	s.elements[hostname] = s.order.PushFront(&certEntry{hostname: hostname, cert: cert})
+	for s.maxSize > 0 && s.order.Len() > s.maxSize {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.elements, oldest.Value.(*certEntry).hostname)
+	}
+}
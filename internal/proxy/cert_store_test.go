@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestCertStore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := newCertStore(2)
+	store.now = func() time.Time { return now }
+
+	generated := map[string]int{}
+	fetch := func(hostname string) *tls.Certificate {
+		cert, err := store.Fetch(hostname, func() (*tls.Certificate, error) {
+			generated[hostname]++
+			return &tls.Certificate{Leaf: &x509.Certificate{NotAfter: now.Add(7 * 24 * time.Hour)}}, nil
+		})
+		if err != nil {
+			t.Fatalf("Fetch(%s) error = %v", hostname, err)
+		}
+		return cert
+	}
+
+	first := fetch("a.example.com")
+	if fetch("a.example.com") != first || generated["a.example.com"] != 1 {
+		t.Errorf("cached certificate was generated again")
+	}
+
+	// b is the least recently used when c is added
+	fetch("b.example.com")
+	fetch("a.example.com")
+	fetch("c.example.com")
+	fetch("a.example.com")
+	fetch("b.example.com")
+	if generated["a.example.com"] != 1 || generated["b.example.com"] != 2 {
+		t.Errorf("generated = %v, want b evicted and generated again", generated)
+	}
+
+	now = now.Add(6*24*time.Hour + time.Hour)
+	if fetch("b.example.com") == nil || generated["b.example.com"] != 3 {
+		t.Errorf("certificate expiring within %v was not generated again", certRenewBefore)
+	}
+}
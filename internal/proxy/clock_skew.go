@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// minimum delay between two clock skew warnings, to avoid flooding logs
+const clockSkewWarnInterval = 10 * time.Minute
+
+// clockSkewDetector compares upstream Date headers against the local clock.
+// TTL logic relies on the local clock, so a wrong clock (e.g. VM resumed from sleep) silently breaks expiration
+type clockSkewDetector struct {
+	threshold time.Duration
+
+	mu         sync.Mutex
+	lastWarned time.Time
+}
+
+func newClockSkewDetector(threshold time.Duration) *clockSkewDetector {
+	return &clockSkewDetector{threshold: threshold}
+}
+
+// clockSkew returns the difference between the local clock and the upstream Date header.
+// A positive value means the local clock is ahead of upstream.
+// returns false if the response has no usable Date header
+func clockSkew(resp *http.Response, now time.Time) (time.Duration, bool) {
+	dateStr := resp.Header.Get("Date")
+	if dateStr == "" {
+		return 0, false
+	}
+	date, err := http.ParseTime(dateStr)
+	if err != nil {
+		return 0, false
+	}
+	return now.Sub(date), true
+}
+
+// Check warns if the response Date header is too far from the local clock.
+// Must only be called with responses freshly received from upstream
+func (d *clockSkewDetector) Check(resp *http.Response, now time.Time) {
+	if d == nil || d.threshold == 0 {
+		return
+	}
+
+	skew, ok := clockSkew(resp, now)
+	if !ok {
+		return
+	}
+	if skew < d.threshold && skew > -d.threshold {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.lastWarned.IsZero() && now.Sub(d.lastWarned) < clockSkewWarnInterval {
+		return
+	}
+	d.lastWarned = now
+
+	host := ""
+	if resp.Request != nil {
+		host = resp.Request.URL.Host
+	}
+	logrus.Warnf("Local clock differs from upstream %s by %v (threshold %v). Cache TTLs may behave incorrectly, check your system clock", host, skew.Round(time.Second), d.threshold)
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elazarl/goproxy"
+	"github.com/sirupsen/logrus"
+)
+
+// Request headers letting clients (e.g. test code) manage the cache state of a request in-band, without the admin API
+const (
+	// Fetch the response from upstream even if cached, replacing the entry
+	refreshHeader = "X-Cache-Refresh"
+	// Remove the entry of the request, without forwarding it
+	purgeHeader = "X-Cache-Purge"
+	// TTL of the entry stored for the request (e.g. "30s"), instead of the effective one
+	ttlHeader = "X-Cache-TTL"
+)
+
+// requestTTL parses the TTL asked by the client with X-Cache-TTL. Returns 0 if none or invalid
+func requestTTL(req *http.Request) time.Duration {
+	value := req.Header.Get(ttlHeader)
+	if value == "" {
+		return 0
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		logrus.Warnf("OnRequest(url=%s): Invalid %s '%s', using the effective TTL", req.URL.String(), ttlHeader, value)
+		return 0
+	}
+	return ttl
+}
+
+// overrideTTL returns the TTL asked by the client for the entry of a request if any, else ttl
+func overrideTTL(ttl time.Duration, requested time.Duration) time.Duration {
+	if requested > 0 {
+		return requested
+	}
+	return ttl
+}
+
+// purgeResponse removes the cached entry of a request, and the data of its interrupted download if any, answering 204 No Content
+func (s *Server) purgeResponse(req *http.Request, userData *ctxUserData) *http.Response {
+	for _, key := range []string{userData.key, userData.key + partialSuffix} {
+		if err := s.cacheManager.DeleteKey(key); err != nil {
+			logrus.Errorf("OnRequest(url=%s): Failed to purge cached response: %v", req.URL.String(), err)
+			resp := goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusInternalServerError, "Failed to purge the cached response.\n")
+			resp.Header.Set("X-Cache", "ERROR")
+			userData.status = "ERROR"
+			return resp
+		}
+	}
+	logrus.Debugf("OnRequest(url=%s): Purged cached response because of %s", req.URL.String(), purgeHeader)
+	resp := goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusNoContent, "")
+	resp.Header.Del("Content-Type")
+	resp.Header.Set("X-Cache", "PURGED")
+	userData.status = "PURGED"
+	return resp
+}
+
+// controlParamHeaders maps the values of the cache.control_param query parameter to the request header they stand for
+var controlParamHeaders = map[string]string{
+	"bypass":  "X-Cache-Bypass",
+	"refresh": refreshHeader,
+	"purge":   purgeHeader,
+}
+
+// applyControlParam removes the cache.control_param query parameter from a request, setting the request headers its values stand for,
+// for clients that cannot easily set headers (browsers, curl one-liners)
+func (s *Server) applyControlParam(req *http.Request) {
+	name := s.config.Cache.ControlParam
+	if name == "" || req.URL.RawQuery == "" {
+		return
+	}
+	rawQuery, values := cutQueryParam(req.URL.RawQuery, name)
+	if values == nil {
+		return
+	}
+	req.URL.RawQuery = rawQuery
+	for _, value := range values {
+		header, ok := controlParamHeaders[value]
+		if !ok {
+			logrus.Warnf("OnRequest(url=%s): Invalid %s value '%s', expected 'bypass', 'refresh' or 'purge'", req.URL.String(), name, value)
+			continue
+		}
+		req.Header.Set(header, "1")
+	}
+}
+
+// cutQueryParam removes a parameter from a raw query string, keeping the order of the others. Returns its values, nil if absent
+func cutQueryParam(rawQuery string, name string) (string, []string) {
+	var values []string
+	kept := []string{}
+	for _, part := range strings.Split(rawQuery, "&") {
+		key, value, _ := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if key != name {
+			kept = append(kept, part)
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		values = append(values, value)
+	}
+	return strings.Join(kept, "&"), values
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestControlHeaders(t *testing.T) {
+	var upstreamHits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(refreshHeader) != "" || r.Header.Get(purgeHeader) != "" || r.Header.Get(ttlHeader) != "" {
+			t.Errorf("upstream got cache control headers: %v", r.Header)
+		}
+		_, _ = io.WriteString(w, "version "+strconv.Itoa(int(upstreamHits.Add(1))))
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	tests := []struct {
+		header      string
+		status      int
+		cacheStatus string
+		body        string
+	}{
+		{"", http.StatusOK, "MISS", "version 1"},
+		{"", http.StatusOK, "HIT", "version 1"},
+		{refreshHeader, http.StatusOK, "REFRESH", "version 2"},
+		{"", http.StatusOK, "HIT", "version 2"},
+		{purgeHeader, http.StatusNoContent, "PURGED", ""},
+		{"", http.StatusOK, "MISS", "version 3"},
+		{purgeHeader, http.StatusNoContent, "PURGED", ""},
+		// Expired as soon as stored
+		{ttlHeader, http.StatusOK, "MISS", "version 4"},
+		{"", http.StatusOK, "MISS", "version 5"},
+		{"", http.StatusOK, "HIT", "version 5"},
+	}
+	for i, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/data", nil)
+		switch tt.header {
+		case "":
+		case ttlHeader:
+			req.Header.Set(tt.header, "1ns")
+		default:
+			req.Header.Set(tt.header, "1")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request %d error = %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != tt.status || resp.Header.Get("X-Cache") != tt.cacheStatus || string(body) != tt.body {
+			t.Errorf("request %d with %q: status = %d, X-Cache = %s, body = %q, want %d, %s, %q", i, tt.header,
+				resp.StatusCode, resp.Header.Get("X-Cache"), body, tt.status, tt.cacheStatus, tt.body)
+		}
+	}
+	if hits := upstreamHits.Load(); hits != 5 {
+		t.Errorf("upstream hits = %d, want 5", hits)
+	}
+}
+
+func TestControlParam(t *testing.T) {
+	var upstreamHits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery != "b=2&a=1" {
+			t.Errorf("upstream query = %q, want the control parameter removed", r.URL.RawQuery)
+		}
+		_, _ = io.WriteString(w, "version "+strconv.Itoa(int(upstreamHits.Add(1))))
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir(), ControlParam: "_cdp"},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	tests := []struct {
+		query       string
+		cacheStatus string
+		body        string
+	}{
+		{"b=2&a=1", "MISS", "version 1"},
+		{"_cdp=refresh&b=2&a=1", "REFRESH", "version 2"},
+		{"b=2&_cdp=bypass&a=1", "BYPASS", "version 3"},
+		{"b=2&a=1", "HIT", "version 2"},
+		{"b=2&a=1&_cdp=purge", "PURGED", ""},
+		{"b=2&a=1&_cdp=unknown", "MISS", "version 4"},
+	}
+	for _, tt := range tests {
+		resp, err := client.Get(upstream.URL + "/data?" + tt.query)
+		if err != nil {
+			t.Fatalf("GET ?%s error = %v", tt.query, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.Header.Get("X-Cache") != tt.cacheStatus || string(body) != tt.body {
+			t.Errorf("GET ?%s: X-Cache = %s, body = %q, want %s, %q", tt.query, resp.Header.Get("X-Cache"), body, tt.cacheStatus, tt.body)
+		}
+	}
+}
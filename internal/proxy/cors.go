@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+
+	"github.com/elazarl/goproxy"
+)
+
+// isPreflight reports whether the request is a CORS preflight request
+func isPreflight(requ *http.Request) bool {
+	return requ.Method == http.MethodOptions && requ.Header.Get("Origin") != "" && requ.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// corsEnabled reports whether CORS headers are added to the response of the request, globally or by a matching rule.
+// Preflight requests match rules with the method they ask for
+func (s *Server) corsEnabled(requ *http.Request) bool {
+	if s.config.CORS.Enabled {
+		return true
+	}
+	if isPreflight(requ) {
+		requested := *requ
+		requested.Method = requ.Header.Get("Access-Control-Request-Method")
+		requ = &requested
+	}
+	for _, rule := range s.matchingConfigRules(requ) {
+		if rule.CORS {
+			return true
+		}
+	}
+	return false
+}
+
+// preflightResponse answers a CORS preflight request locally
+func (s *Server) preflightResponse(requ *http.Request) *http.Response {
+	cors := &s.config.CORS
+	resp := goproxy.NewResponse(requ, goproxy.ContentTypeText, http.StatusNoContent, "")
+	setCORSHeaders(requ, resp, cors)
+
+	methods := strings.Join(cors.AllowMethods, ", ")
+	if methods == "" {
+		methods = requ.Header.Get("Access-Control-Request-Method")
+	}
+	resp.Header.Set("Access-Control-Allow-Methods", methods)
+	headers := strings.Join(cors.AllowHeaders, ", ")
+	if headers == "" {
+		headers = requ.Header.Get("Access-Control-Request-Headers")
+	}
+	if headers != "" {
+		resp.Header.Set("Access-Control-Allow-Headers", headers)
+	}
+	if s.corsMaxAge > 0 {
+		resp.Header.Set("Access-Control-Max-Age", strconv.Itoa(int(s.corsMaxAge.Seconds())))
+	}
+	return resp
+}
+
+// setCORSHeaders sets the CORS headers of a response, replacing the ones sent by upstream
+func setCORSHeaders(requ *http.Request, resp *http.Response, cors *config.CORSConfig) {
+	origin := cors.AllowOrigin
+	if origin == "*" && requ.Header.Get("Origin") != "" {
+		origin = requ.Header.Get("Origin")
+		resp.Header.Add("Vary", "Origin")
+	}
+	if origin != "" {
+		resp.Header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if cors.AllowCredentials {
+		resp.Header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(cors.ExposeHeaders) > 0 {
+		resp.Header.Set("Access-Control-Expose-Headers", strings.Join(cors.ExposeHeaders, ", "))
+	}
+}
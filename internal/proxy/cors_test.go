@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestCORS(t *testing.T) {
+	upstreamRequests := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests++
+	}))
+	defer upstream.Close()
+
+	cfg := config.DefaultConfig
+	cfg.Cache.Folder = t.TempDir()
+	cfg.Server.HTTPS.Enabled = false
+	cfg.CORS.AllowCredentials = true
+	cfg.CORS.ExposeHeaders = []string{"X-Total-Count"}
+	cfg.Rules = config.RulesConfig{Mode: config.RulesModeBlacklist, Rules: []config.CacheRule{
+		{BaseURI: upstream.URL + "/api", Methods: []string{"GET", "POST"}, CORS: true},
+	}}
+	s, err := New(&cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	send := func(method, path string, header http.Header) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, upstream.URL+path, nil)
+		req.Header = header
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s error = %v", method, path, err)
+		}
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	preflight := send(http.MethodOptions, "/api/users", http.Header{
+		"Origin":                         {"http://localhost:5173"},
+		"Access-Control-Request-Method":  {"POST"},
+		"Access-Control-Request-Headers": {"content-type"},
+	})
+	if preflight.StatusCode != http.StatusNoContent || upstreamRequests != 0 {
+		t.Fatalf("preflight status = %d, upstream requests = %d, want 204 answered locally", preflight.StatusCode, upstreamRequests)
+	}
+	for name, want := range map[string]string{
+		"Access-Control-Allow-Origin":      "http://localhost:5173",
+		"Access-Control-Allow-Methods":     "POST",
+		"Access-Control-Allow-Headers":     "content-type",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Max-Age":           "600",
+	} {
+		if got := preflight.Header.Get(name); got != want {
+			t.Errorf("preflight %s = %q, want %q", name, got, want)
+		}
+	}
+
+	resp := send(http.MethodGet, "/api/users", http.Header{"Origin": {"http://localhost:5173"}})
+	if resp.Header.Get("Access-Control-Allow-Origin") != "http://localhost:5173" || resp.Header.Get("Access-Control-Expose-Headers") != "X-Total-Count" {
+		t.Errorf("GET CORS headers = %v", resp.Header)
+	}
+
+	// Not matching a CORS rule: forwarded as-is
+	preflight = send(http.MethodOptions, "/other", http.Header{"Origin": {"http://localhost:5173"}, "Access-Control-Request-Method": {"GET"}})
+	if preflight.Header.Get("Access-Control-Allow-Origin") != "" || upstreamRequests != 2 {
+		t.Errorf("unmatched preflight got CORS headers or was not forwarded: %v", preflight.Header)
+	}
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// expiresHeader stores the expiry decided for an entry (e.g. by the decision service) along the cached response.
+// It can only make entries expire before cache.ttl, and is never sent to clients
+const expiresHeader = "X-Cache-Expires"
+
+// decisionClient consults an external HTTP service for caching decisions, so policies can be centralized
+type decisionClient struct {
+	url    string
+	client *http.Client
+}
+
+func newDecisionClient(url string, timeout time.Duration) *decisionClient {
+	return &decisionClient{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// decisionRequest is the JSON body sent to the decision service
+type decisionRequest struct {
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"response_headers"`
+	// decision of local rules
+	Cache bool `json:"cache"`
+}
+
+// decisionResponse is the JSON answer of the decision service. Omitted fields keep the local decision
+type decisionResponse struct {
+	Cache *bool  `json:"cache"`
+	TTL   string `json:"ttl"`
+}
+
+// Decide asks the decision service whether to cache a response, and for how long (0 for cache.ttl).
+// Falls back to the local decision on any error
+func (d *decisionClient) Decide(requ *http.Request, resp *http.Response, local bool) (bool, time.Duration) {
+	decision, err := d.query(requ, resp, local)
+	if err != nil {
+		logrus.Warnf("Decision service failed for %s, using local rules: %v", requ.URL.String(), err)
+		return local, 0
+	}
+
+	cache := local
+	if decision.Cache != nil {
+		cache = *decision.Cache
+	}
+	var ttl time.Duration
+	if decision.TTL != "" {
+		ttl, err = time.ParseDuration(decision.TTL)
+		if err != nil || ttl < 0 {
+			logrus.Warnf("Decision service returned invalid TTL '%s' for %s, using cache.ttl", decision.TTL, requ.URL.String())
+			ttl = 0
+		}
+	}
+	logrus.Debugf("Decision service for %s: cache=%v ttl=%v", requ.URL.String(), cache, ttl)
+	return cache, ttl
+}
+
+func (d *decisionClient) query(requ *http.Request, resp *http.Response, local bool) (*decisionResponse, error) {
+	body, err := json.Marshal(decisionRequest{
+		Method:          requ.Method,
+		URL:             requ.URL.String(),
+		RequestHeaders:  requ.Header,
+		Status:          resp.StatusCode,
+		ResponseHeaders: resp.Header,
+		Cache:           local,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode decision request: %w", err)
+	}
+
+	// The client request may be gone (e.g. background fetches): the timeout bounds the callout instead
+	callout, err := http.NewRequestWithContext(context.Background(), http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	callout.Header.Set("Content-Type", "application/json")
+
+	calloutResp, err := d.client.Do(callout)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = calloutResp.Body.Close() }()
+	if calloutResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("decision service answered %s", calloutResp.Status)
+	}
+
+	var decision decisionResponse
+	if err := json.NewDecoder(calloutResp.Body).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("invalid decision service response: %w", err)
+	}
+	return &decision, nil
+}
+
+// cacheDecision returns whether a response should be cached, and its TTL (0 for cache.ttl).
+// Local rules and the script decide, unless a decision service is configured. Interceptors have the last word. Partial content is never cached
+func (s *Server) cacheDecision(requ *http.Request, resp *http.Response) (bool, time.Duration) {
+	// A partial body would later be served for the full resource
+	if resp.StatusCode == http.StatusPartialContent {
+		return false, 0
+	}
+	local := s.scriptDecision(requ, resp, s.shouldBeCached(requ, resp))
+	cacheable, ttl := local, time.Duration(0)
+	if s.decisions != nil {
+		cacheable, ttl = s.decisions.Decide(requ, resp, local)
+	}
+	return s.interceptCacheDecision(requ, resp, cacheable), ttl
+}
+
+// withExpiry returns a copy of the response to store, carrying its expiry if ttl is set
+func withExpiry(resp *http.Response, ttl time.Duration) *http.Response {
+	if ttl == 0 {
+		return resp
+	}
+	respCopy := *resp
+	respCopy.Header = resp.Header.Clone()
+	respCopy.Header.Set(expiresHeader, time.Now().Add(ttl).UTC().Format(time.RFC3339Nano))
+	return &respCopy
+}
+
+// expired reports whether a cached response is past the expiry stored with it, and removes the expiry header
+func expired(resp *http.Response, now time.Time) bool {
+	value := resp.Header.Get(expiresHeader)
+	if value == "" {
+		return false
+	}
+	resp.Header.Del(expiresHeader)
+	expires, err := time.Parse(time.RFC3339Nano, value)
+	return err == nil && now.After(expires)
+}
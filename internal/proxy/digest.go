@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// digestHeader carries the SHA-256 of the body as it was stored, so clients can check what they receive
+const digestHeader = "X-Cache-Digest"
+
+// bodyDigest returns the digest header value of a body
+func bodyDigest(body []byte) string {
+	hash := sha256.Sum256(body)
+	return "sha256=" + hex.EncodeToString(hash[:])
+}
+
+// withDigest returns a copy of the response carrying the digest of its body
+func withDigest(resp *http.Response) (*http.Response, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	respCopy := *resp
+	respCopy.Header = resp.Header.Clone()
+	respCopy.Header.Set(digestHeader, bodyDigest(body))
+	respCopy.Body = io.NopCloser(bytes.NewReader(body))
+	return &respCopy, nil
+}
+
+// storedHeader stores the time an entry was stored along the cached response. It is never sent to clients
+const storedHeader = "X-Cache-Stored"
+
+// toStore returns the response to store in cache, with its storage time, expiry and digest headers, and sensitive headers redacted
+func (s *Server) toStore(resp *http.Response, ttl time.Duration) (*http.Response, error) {
+	stored := *withExpiry(resp, ttl)
+	stored.Header = stored.Header.Clone()
+	stored.Header.Set(storedHeader, time.Now().UTC().Format(time.RFC3339Nano))
+	s.redactEntry(&stored)
+	if !s.config.Cache.DigestHeader {
+		return &stored, nil
+	}
+	return withDigest(&stored)
+}
+
+// fromStore prepares a cached response to be served, removing internal headers.
+// Returns whether it is past the expiry stored with it
+func (s *Server) fromStore(resp *http.Response, now time.Time) bool {
+	if !s.config.Cache.DigestHeader {
+		// Stored while the option was enabled
+		resp.Header.Del(digestHeader)
+	}
+	if storedAt, err := time.Parse(time.RFC3339Nano, resp.Header.Get(storedHeader)); err == nil && s.config.Cache.DebugHeaders {
+		resp.Header.Set("X-Cache-Age", strconv.Itoa(int(now.Sub(storedAt).Seconds())))
+	}
+	resp.Header.Del(storedHeader)
+	applyServeHeaders(resp)
+	return expired(resp, now)
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+// Maximum size of a DNS message
+const dnsMaxMessageSize = 65535
+
+// dnsResolver resolves upstream host names with the static overrides and the resolver of the dns configuration
+type dnsResolver struct {
+	config   *config.DNSConfig
+	resolver *net.Resolver
+	dialer   *net.Dialer
+}
+
+// newDNSResolver creates the resolver of upstream connections, opened with dialer. Dial timeouts are applied by upstreamTransports (see upstream.timeouts)
+func newDNSResolver(cfg *config.DNSConfig, dialer *net.Dialer) *dnsResolver {
+	r := &dnsResolver{config: cfg, resolver: net.DefaultResolver, dialer: dialer}
+	switch {
+	case cfg.IsDoH():
+		doh := &dohClient{url: cfg.Resolver, client: &http.Client{}}
+		r.resolver = &net.Resolver{PreferGo: true, Dial: doh.dial}
+	case cfg.Resolver != "":
+		nameserver := cfg.Nameserver()
+		r.resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return r.dialer.DialContext(ctx, network, nameserver)
+		}}
+	}
+	return r
+}
+
+// lookup returns the addresses of host
+func (r *dnsResolver) lookup(ctx context.Context, host string) ([]string, error) {
+	if address := r.config.Override(host); address != "" {
+		return []string{address}, nil
+	}
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	return r.resolver.LookupHost(ctx, host)
+}
+
+// DialContext connects to addr, trying the addresses of its host in turn
+func (r *dnsResolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := r.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var firstErr error
+	for _, address := range addresses {
+		conn, err := r.dialer.DialContext(ctx, network, net.JoinHostPort(address, port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("no address found for %s", host)
+	}
+	return nil, firstErr
+}
+
+// dohClient sends DNS queries over DNS-over-HTTPS (RFC 8484)
+type dohClient struct {
+	url    string
+	client *http.Client
+}
+
+// dial returns a connection sending the queries of the Go resolver to the DNS-over-HTTPS server, whatever the nameserver dialed
+func (c *dohClient) dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	return &dohConn{ctx: ctx, client: c}, nil
+}
+
+// dohConn is a DNS stream connection (messages prefixed by their length) answering queries with a DNS-over-HTTPS server.
+// It is not a net.PacketConn, so the Go resolver always uses this framing. Deadlines are ignored, queries are bound by the context of the resolver
+type dohConn struct {
+	ctx    context.Context
+	client *dohClient
+	// framed answer of the last query
+	answer *bytes.Reader
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	if len(b) < 2 {
+		return 0, fmt.Errorf("invalid DNS query")
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, c.client.url, bytes.NewReader(b[2:]))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := c.client.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("DNS-over-HTTPS server answered %s", resp.Status)
+	}
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, dnsMaxMessageSize))
+	if err != nil {
+		return 0, err
+	}
+	c.answer = bytes.NewReader(append([]byte{byte(len(answer) >> 8), byte(len(answer))}, answer...))
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.answer == nil {
+		return 0, io.EOF
+	}
+	return c.answer.Read(b)
+}
+
+func (c *dohConn) Close() error                     { return nil }
+func (c *dohConn) LocalAddr() net.Addr              { return dohAddr(c.client.url) }
+func (c *dohConn) RemoteAddr() net.Addr             { return dohAddr(c.client.url) }
+func (c *dohConn) SetDeadline(time.Time) error      { return nil }
+func (c *dohConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(time.Time) error { return nil }
+
+// dohAddr is the address of a DNS-over-HTTPS server
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
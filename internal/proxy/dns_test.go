@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNSOverride(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "staging "+r.Host)
+	}))
+	defer upstream.Close()
+	_, port, _ := net.SplitHostPort(upstream.Listener.Addr().String())
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeWhitelist},
+		DNS: config.DNSConfig{Overrides: []config.DNSOverride{
+			{Match: []string{"*.staging.test"}, Address: "127.0.0.1"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	target := "http://api.staging.test:" + port + "/"
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", target, err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if want := "staging api.staging.test:" + port; string(body) != want {
+		t.Errorf("GET %s = %q, want %q", target, body, want)
+	}
+}
+
+func TestDNSOverHTTPS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		var msg dnsmessage.Message
+		if err := msg.Unpack(query); err != nil || len(msg.Questions) != 1 {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		question := msg.Questions[0]
+		msg.Header.Response = true
+		if !strings.HasSuffix(question.Name.String(), "doh.test.") {
+			msg.Header.RCode = dnsmessage.RCodeNameError
+		} else if question.Type == dnsmessage.TypeA {
+			msg.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 10}},
+			}}
+		}
+		answer, _ := msg.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(answer)
+	}))
+	defer server.Close()
+
+	doh := &dohClient{url: server.URL + "/dns-query", client: server.Client()}
+	resolver := &net.Resolver{PreferGo: true, Dial: doh.dial}
+
+	addresses, err := resolver.LookupHost(context.Background(), "api.doh.test")
+	if err != nil {
+		t.Fatalf("LookupHost() error = %v", err)
+	}
+	if !slices.Contains(addresses, "192.0.2.10") {
+		t.Errorf("LookupHost() = %v, want 192.0.2.10", addresses)
+	}
+	if _, err := resolver.LookupHost(context.Background(), "unknown.example"); err == nil {
+		t.Errorf("LookupHost() of an unknown host succeeded")
+	}
+}
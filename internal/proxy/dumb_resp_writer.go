@@ -16,8 +16,8 @@ func (dumb dumbResponseWriter) Header() http.Header {
 }
 
 func (dumb dumbResponseWriter) Write(buf []byte) (int, error) {
-	if bytes.Equal(buf, []byte("HTTP/1.0 200 OK\r\n\r\n")) {
-		return len(buf), nil // throw away the HTTP OK response from the faux CONNECT request
+	if bytes.Equal(buf, []byte("HTTP/1.0 200 OK\r\n\r\n")) || bytes.Equal(buf, []byte("HTTP/1.0 200 Connection established\r\n\r\n")) {
+		return len(buf), nil // throw away the HTTP OK response from the faux CONNECT request (intercepted or tunneled)
 	}
 	return dumb.Conn.Write(buf)
 }
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/admin"
+
+	"github.com/sirupsen/logrus"
+)
+
+// number of events that can wait to be sent to a subscriber. Events are dropped for subscribers too slow to keep up
+const eventSubscriberQueueSize = 256
+
+// eventLog appends one JSON object per request to the events file, for tools to build reports from
+type eventLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (l *eventLog) Write(event admin.RequestEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Opened for each event, so the file can be rotated
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open events file: %w", err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write events file: %w", err)
+	}
+	return file.Close()
+}
+
+// eventHub sends request events to live subscribers, e.g. clients of /api/events
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan admin.RequestEvent]struct{}
+}
+
+// Subscribe returns a channel receiving the events published until unsubscribe is called
+func (h *eventHub) Subscribe() (<-chan admin.RequestEvent, func()) {
+	events := make(chan admin.RequestEvent, eventSubscriberQueueSize)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers == nil {
+		h.subscribers = make(map[chan admin.RequestEvent]struct{})
+	}
+	h.subscribers[events] = struct{}{}
+	return events, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subscribers, events)
+	}
+}
+
+// active reports whether events have subscribers
+func (h *eventHub) active() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers) > 0
+}
+
+// Publish sends an event to all subscribers, without waiting for slow ones
+func (h *eventHub) Publish(event admin.RequestEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for events := range h.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// recordEvent writes the event of a handled request to the events file, and sends it to live subscribers
+func (s *Server) recordEvent(requ *http.Request, resp *http.Response, userData *ctxUserData, duration time.Duration) {
+	if s.events == nil && !s.liveEvents.active() {
+		return
+	}
+	event := admin.RequestEvent{
+		Time:      userData.start,
+		Method:    requ.Method,
+		URL:       requ.URL.String(),
+		Status:    resp.StatusCode,
+		Decision:  resp.Header.Get("X-Cache"),
+		LatencyMS: float64(duration.Microseconds()) / 1000,
+		Size:      resp.ContentLength,
+	}
+	// Rules are only evaluated on responses from upstream
+	if userData.status == "" && !userData.bypass && !userData.streaming {
+		if i := s.matchingRule(requ, resp); i != -1 {
+			event.Rule = &i
+		}
+	}
+	s.liveEvents.Publish(event)
+	if s.events == nil {
+		return
+	}
+	if err := s.events.Write(event); err != nil {
+		logrus.Errorf("Failed to record request event: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/admin"
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestEventsFile(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	}))
+	defer upstream.Close()
+
+	eventsFile := filepath.Join(t.TempDir(), "events.jsonl")
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist, Rules: []config.CacheRule{
+			{BaseURI: upstream.URL + "/live", Methods: []string{"GET"}},
+		}},
+		Log: config.LogConfig{EventsFile: eventsFile},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for _, path := range []string{"/a", "/a", "/live"} {
+		resp, err := client.Get(upstream.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	file, err := os.Open(eventsFile)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer func() { _ = file.Close() }()
+	var events []admin.RequestEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event admin.RequestEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid event line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+
+	for i, want := range []struct {
+		path     string
+		decision string
+		rule     int
+	}{
+		{"/a", "MISS", -1},
+		{"/a", "HIT", -1},
+		{"/live", "DISABLED", 0},
+	} {
+		event := events[i]
+		if event.URL != upstream.URL+want.path || event.Method != http.MethodGet || event.Status != http.StatusOK || event.Decision != want.decision {
+			t.Errorf("event %d = %+v, want GET %s with decision %s", i, event, want.path, want.decision)
+		}
+		rule := -1
+		if event.Rule != nil {
+			rule = *event.Rule
+		}
+		if rule != want.rule {
+			t.Errorf("event %d rule = %d, want %d", i, rule, want.rule)
+		}
+		if event.Time.IsZero() || event.LatencyMS <= 0 {
+			t.Errorf("event %d has no time or latency: %+v", i, event)
+		}
+	}
+	if events[0].Size != int64(len("hello")) {
+		t.Errorf("size = %d, want %d", events[0].Size, len("hello"))
+	}
+}
+
+func TestLiveEvents(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	events, unsubscribe := s.liveEvents.Subscribe()
+	resp, err := client.Get(upstream.URL + "/a")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	_ = resp.Body.Close()
+	select {
+	case event := <-events:
+		if event.URL != upstream.URL+"/a" || event.Decision != "MISS" {
+			t.Errorf("event = %+v, want the MISS of /a", event)
+		}
+	default:
+		t.Fatalf("no event was published")
+	}
+
+	unsubscribe()
+	if s.liveEvents.active() {
+		t.Errorf("events are still active after unsubscribing")
+	}
+}
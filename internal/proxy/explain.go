@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/admin"
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+// explainHeader asks, in a request, for the explanation of the caching decision, sent back in the response header of the same name
+const explainHeader = "X-Cache-Explain"
+
+// Explain checks the rule against a request and its response, and tells why it matches or not
+func (r *ConfigRule) Explain(requ *http.Request, resp *http.Response) (bool, string) {
+	if !r.matchesURL(requ) {
+		if r.glob != nil {
+			return false, "URL does not match base_uri pattern"
+		}
+		return false, "URL does not start with base_uri"
+	}
+	if pattern := r.exception(requ); pattern != "" {
+		return false, fmt.Sprintf("URL excluded by except pattern %s", pattern)
+	}
+	if reason := r.headerMismatch(requ); reason != "" {
+		return false, reason
+	}
+	if reason := r.queryMismatch(requ); reason != "" {
+		return false, reason
+	}
+
+	methodMatches := false
+	for _, m := range r.Methods {
+		if strings.EqualFold(m, requ.Method) {
+			methodMatches = true
+			break
+		}
+	}
+	if !methodMatches {
+		return false, fmt.Sprintf("method %s not in methods %v", requ.Method, r.Methods)
+	}
+
+	if len(r.StatusCodes) > 0 {
+		statusMatches := false
+		for _, statusPattern := range r.StatusCodes {
+			if config.MatchesStatusCode(resp.StatusCode, statusPattern) {
+				statusMatches = true
+				break
+			}
+		}
+		if !statusMatches {
+			return false, fmt.Sprintf("status %d not in status_codes %v", resp.StatusCode, r.StatusCodes)
+		}
+	}
+	if reason := r.sizeMismatch(resp); reason != "" {
+		return false, reason
+	}
+	if r.when != nil {
+		matched, err := r.when.Match(requ, resp)
+		if err != nil {
+			return false, fmt.Sprintf("when expression failed: %v", err)
+		}
+		if !matched {
+			return false, "when expression is false"
+		}
+	}
+	return true, "matched"
+}
+
+// explainDecision details how shouldBeCached decides for a response
+func (s *Server) explainDecision(requ *http.Request, resp *http.Response) admin.Explanation {
+	explanation := admin.Explanation{
+		Mode:  string(s.config.Rules.Mode),
+		Rules: make([]admin.RuleTrace, len(s.rules)),
+	}
+
+	matched := -1
+	for i, rule := range s.rules {
+		trace := admin.RuleTrace{Index: i, Evaluated: matched == -1}
+		if configRule, ok := rule.(*ConfigRule); ok {
+			trace.BaseURI = configRule.BaseURI
+		}
+		if trace.Evaluated {
+			if configRule, ok := rule.(*ConfigRule); ok {
+				trace.Matched, trace.Reason = configRule.Explain(requ, resp)
+			} else if trace.Matched = rule.Match(requ, resp); trace.Matched {
+				trace.Reason = "matched"
+			} else {
+				trace.Reason = "no match"
+			}
+			if trace.Matched {
+				matched = i
+			}
+		}
+		explanation.Rules[i] = trace
+	}
+
+	whitelist := s.config.Rules.Mode == config.RulesModeWhitelist
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		explanation.Reason = "partial responses (206) are never cached"
+	case s.rateLimiter != nil && resp.StatusCode == http.StatusTooManyRequests:
+		explanation.Reason = "429 responses are not cached while rate_limit is enabled"
+	case matched != -1:
+		explanation.Cache = s.ruleAction(s.rules[matched]) == config.RuleActionCache
+		if configRule, ok := s.rules[matched].(*ConfigRule); ok && configRule.Action != "" {
+			explanation.Reason = fmt.Sprintf("rule %d matched, with action %s", matched, configRule.Action)
+		} else {
+			explanation.Reason = fmt.Sprintf("rule %d matched in %s mode", matched, s.config.Rules.Mode)
+		}
+	case whitelist:
+		explanation.Reason = "no rule matched in whitelist mode"
+	default:
+		explanation.Cache = true
+		explanation.Reason = "no rule matched in blacklist mode"
+	}
+	return explanation
+}
+
+// explainResponse explains on a single line why a response got its X-Cache status
+func (s *Server) explainResponse(requ *http.Request, resp *http.Response, userData *ctxUserData) string {
+	status := resp.Header.Get("X-Cache")
+	switch {
+	case userData.bypass:
+		return "X-Cache-Bypass header set: cache not used"
+	case userData.largeBody:
+		return "BYPASS: request body larger than cache.max_request_body, streamed upstream: cache not used"
+	case userData.status != "":
+		// Rules are evaluated when storing responses, not when serving them
+		return fmt.Sprintf("%s: served by the proxy, rules not evaluated", status)
+	case userData.streaming:
+		return "STREAM: streaming response passed through unbuffered, never cached"
+	}
+	explanation := s.explainDecision(requ, resp)
+	if s.decisions != nil && explanation.Cache != (status == "MISS" || status == "REFRESH") {
+		return fmt.Sprintf("%s: overridden by the decision service; %s", status, explanation)
+	}
+	return fmt.Sprintf("%s: %s", status, explanation)
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestExplainDecision(t *testing.T) {
+	cfg := &config.Config{Rules: *config.NewRulesConfig(config.RulesModeWhitelist,
+		config.NewCacheRule("https://other.example.com", "GET"),
+		config.CacheRule{BaseURI: "https://api.example.com", Methods: []string{"GET"}, StatusCodes: []string{"200"}},
+		config.NewCacheRule("https://api.example.com", "GET", "POST"),
+		config.NewCacheRule("https://api.example.com/users", "GET"),
+	)}
+	s := &Server{config: cfg}
+	for _, rule := range cfg.Rules.Rules {
+		s.rules = append(s.rules, &ConfigRule{CacheRule: rule})
+	}
+
+	requ, _ := http.NewRequest("GET", "https://api.example.com/users", nil)
+	explanation := s.explainDecision(requ, &http.Response{StatusCode: 404})
+
+	if !explanation.Cache || explanation.Reason != "rule 2 matched in whitelist mode" {
+		t.Errorf("decision = %v (%s), want cache by rule 2", explanation.Cache, explanation.Reason)
+	}
+	expectedReasons := []string{"URL does not start with base_uri", "status 404 not in status_codes [200]", "matched", ""}
+	for i, trace := range explanation.Rules {
+		if trace.Reason != expectedReasons[i] || trace.Evaluated != (i < 3) {
+			t.Errorf("rule %d: evaluated = %v, reason = %q, want %q", i, trace.Evaluated, trace.Reason, expectedReasons[i])
+		}
+	}
+	if line := explanation.String(); !strings.HasPrefix(line, "mode=whitelist; rule 0 (https://other.example.com): URL does not start") || strings.Contains(line, "rule 3") {
+		t.Errorf("unexpected single line explanation: %q", line)
+	}
+
+	requ, _ = http.NewRequest("DELETE", "https://api.example.com/users", nil)
+	explanation = s.explainDecision(requ, &http.Response{StatusCode: 200})
+	if explanation.Cache || explanation.Rules[2].Reason != "method DELETE not in methods [GET POST]" {
+		t.Errorf("unexpected explanation for DELETE: %+v", explanation)
+	}
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+	"github.com/iTrooz/caching-dev-proxy/internal/har"
+)
+
+// ImportHAR stores the entries of a HAR file (e.g. saved from browser devtools) in the cache, under the keys
+// the proxy computes for their requests, so replaying the session through the proxy serves them.
+// All entries are stored, regardless of the rules. Returns the number of stored entries
+func ImportHAR(cfg *config.Config, cacheManager *httpcache.HTTPCache, r io.Reader) (int, error) {
+	entries, err := har.Read(r)
+	if err != nil {
+		return 0, err
+	}
+
+	// Only used to compute keys and stored entries like a running proxy
+	rules, err := configRules(cfg)
+	if err != nil {
+		return 0, err
+	}
+	s := &Server{config: cfg, cacheManager: cacheManager, rules: rules}
+	for i, entry := range entries {
+		key, err := cacheManager.GenerateKey(entry.Request, s.keyOptions(entry.Request, &ctxUserData{}))
+		if err != nil {
+			return i, fmt.Errorf("failed to generate cache key of %s: %w", entry.Request.URL, err)
+		}
+		stored, err := s.toStore(entry.Response, 0)
+		if err == nil {
+			err = cacheManager.SetKey(key, stored)
+		}
+		if err != nil {
+			return i, fmt.Errorf("failed to store %s: %w", entry.Request.URL, err)
+		}
+	}
+	return len(entries), nil
+}
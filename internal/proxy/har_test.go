@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestImportHAR(t *testing.T) {
+	// Upstream is never contacted: the imported entries are served
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("upstream contacted for %s", r.URL)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	archive := fmt.Sprintf(`{"log": {"entries": [{
+		"request": {"method": "GET", "url": "%s/users", "headers": [{"name": "Accept", "value": "application/json"}]},
+		"response": {"status": 200, "statusText": "OK", "headers": [{"name": "Content-Type", "value": "application/json"}], "content": {"text": "[\"alice\"]"}}
+	}]}}`, upstream.URL)
+	imported, err := ImportHAR(cfg, s.cacheManager, strings.NewReader(archive))
+	if err != nil || imported != 1 {
+		t.Fatalf("ImportHAR() = %d, %v, want 1 entry", imported, err)
+	}
+
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableCompression: true}}
+
+	req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/users", nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.Header.Get("X-Cache") != "HIT" || string(body) != `["alice"]` {
+		t.Errorf("X-Cache = %s, body = %q, want the imported entry", resp.Header.Get("X-Cache"), body)
+	}
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/history"
+
+	"github.com/sirupsen/logrus"
+)
+
+// number of records that can wait to be written to the history database
+const historyQueueSize = 1024
+
+// number of inserted records between two prunes of the history database
+const historyPruneInterval = 1000
+
+// historyRecorder writes request records to the history database in the background,
+// so slow disk writes do not delay responses
+type historyRecorder struct {
+	db    *history.DB
+	queue chan history.Record
+	// closed once the queued records are written
+	done chan struct{}
+	// held for writing while closing, so no record is queued once the queue is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newHistoryRecorder(db *history.DB) *historyRecorder {
+	h := &historyRecorder{
+		db:    db,
+		queue: make(chan history.Record, historyQueueSize),
+		done:  make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+func (h *historyRecorder) run() {
+	defer close(h.done)
+	inserted := 0
+	for record := range h.queue {
+		if err := h.db.Insert(record); err != nil {
+			logrus.Errorf("Failed to record request history: %v", err)
+			continue
+		}
+		inserted++
+		if inserted%historyPruneInterval == 0 {
+			if err := h.db.Prune(); err != nil {
+				logrus.Errorf("Failed to prune request history: %v", err)
+			}
+		}
+	}
+}
+
+// DB returns the history database, or nil if history is disabled
+func (h *historyRecorder) DB() *history.DB {
+	if h == nil {
+		return nil
+	}
+	return h.db
+}
+
+// Record queues a record for writing. Does nothing if history is disabled
+func (h *historyRecorder) Record(requ *http.Request, resp *http.Response, userData *ctxUserData, duration time.Duration) {
+	if h == nil {
+		return
+	}
+
+	record := history.Record{
+		Time:         userData.start,
+		Source:       userData.source,
+		Method:       requ.Method,
+		URL:          requ.URL.String(),
+		Status:       resp.StatusCode,
+		CacheStatus:  resp.Header.Get("X-Cache"),
+		Duration:     duration,
+		RequestSize:  requ.ContentLength,
+		ResponseSize: resp.ContentLength,
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closed {
+		logrus.Debugf("Request history is closed, dropping record for %s", record.URL)
+		return
+	}
+	select {
+	case h.queue <- record:
+	default:
+		logrus.Warnf("Request history queue is full, dropping record for %s", record.URL)
+	}
+}
+
+// close writes the queued records, and closes the database. Records of requests still being handled are dropped
+func (h *historyRecorder) close() error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil
+	}
+	h.closed = true
+	close(h.queue)
+	h.mu.Unlock()
+	<-h.done
+	return h.db.Close()
+}
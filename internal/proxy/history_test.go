@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/history"
+)
+
+// Records queued when closing are written, and records of later requests are dropped
+func TestHistoryRecorderClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	db, err := history.Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	recorder := newHistoryRecorder(db)
+
+	const records = 100
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	for range records {
+		recorder.Record(req, resp, &ctxUserData{start: time.Now()}, time.Millisecond)
+	}
+	if err := recorder.close(); err != nil {
+		t.Fatalf("close() error = %v", err)
+	}
+	recorder.Record(req, resp, &ctxUserData{start: time.Now()}, time.Millisecond)
+
+	db, err = history.Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	count := 0
+	if err := db.Query(time.Time{}, time.Time{}, func(history.Record) error { count++; return nil }); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if count != records {
+		t.Errorf("%d records written, want %d", count, records)
+	}
+}
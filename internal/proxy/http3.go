@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Time an Alt-Svc advertisement is valid without a "ma" parameter (see RFC 7838)
+	altSvcDefaultMaxAge = 24 * time.Hour
+	// Time HTTP/3 is not tried again for a host it failed for
+	http3RetryAfter = 5 * time.Minute
+	// Time to wait for a QUIC handshake before falling back, so unreachable UDP ports do not delay requests for long
+	http3HandshakeTimeout = 3 * time.Second
+)
+
+// http3Transport sends requests over HTTP/3 to hosts supporting it, like browsers do, and over the regular transport otherwise.
+// Hosts support HTTP/3 if they advertise it in their Alt-Svc header, or if they are listed in upstream.http3.hosts
+type http3Transport struct {
+	fallback *upstreamTransports
+	// hosts tried over HTTP/3 without waiting for an Alt-Svc header
+	hosts []string
+	// resolves host names with the dns configuration. nil uses the system resolver
+	resolver *dnsResolver
+
+	mu sync.Mutex
+	// HTTP/3 transports, by fallback transport so they share its TLS settings
+	h3 map[*http.Transport]*http3.Transport
+	// expiry of the HTTP/3 advertisements, by host:port
+	advertised map[string]time.Time
+	// time until which HTTP/3 is not tried again after a failure, by host:port
+	broken map[string]time.Time
+}
+
+func newHTTP3Transport(fallback *upstreamTransports, hosts []string, resolver *dnsResolver) *http3Transport {
+	// Browsers fall back to HTTP/2 before HTTP/1.1
+	fallback.base.ForceAttemptHTTP2 = true
+	return &http3Transport{
+		fallback:   fallback,
+		hosts:      hosts,
+		resolver:   resolver,
+		h3:         make(map[*http.Transport]*http3.Transport),
+		advertised: make(map[string]time.Time),
+		broken:     make(map[string]time.Time),
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *http3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	authority := originAuthority(req)
+	fallback := t.fallback.forRequest(req)
+	if t.useHTTP3(req, fallback, authority, time.Now()) {
+		resp, err := t.h3For(fallback).RoundTrip(req)
+		if err == nil {
+			t.learn(authority, resp.Header.Values("Alt-Svc"), time.Now())
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		logrus.Debugf("HTTP/3 request to %s failed, falling back to TCP: %v", req.URL.String(), err)
+		t.mu.Lock()
+		t.broken[authority] = time.Now().Add(http3RetryAfter)
+		t.mu.Unlock()
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	resp, err := fallback.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.learn(authority, resp.Header.Values("Alt-Svc"), time.Now())
+	return resp, nil
+}
+
+// useHTTP3 reports whether the request should be tried over HTTP/3
+func (t *http3Transport) useHTTP3(req *http.Request, fallback *http.Transport, authority string, now time.Time) bool {
+	if req.URL.Scheme != "https" {
+		return false
+	}
+	// The body could not be sent again over the fallback transport
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	// HTTP/3 cannot go through an HTTP proxy
+	if fallback.Proxy != nil {
+		if proxyURL, err := fallback.Proxy(req); err != nil || proxyURL != nil {
+			return false
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until, ok := t.broken[authority]; ok {
+		if now.Before(until) {
+			return false
+		}
+		delete(t.broken, authority)
+	}
+	if slices.ContainsFunc(t.hosts, func(host string) bool { return strings.EqualFold(host, req.URL.Hostname()) }) {
+		return true
+	}
+	expiry, ok := t.advertised[authority]
+	return ok && now.Before(expiry)
+}
+
+// learn records the HTTP/3 support advertised by the Alt-Svc headers of a response.
+// Only alternatives on the same host and port are used, as HTTP/3 requests are sent to the origin
+func (t *http3Transport) learn(authority string, altSvc []string, now time.Time) {
+	if len(altSvc) == 0 {
+		return
+	}
+	_, port, _ := net.SplitHostPort(authority)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, header := range altSvc {
+		if strings.TrimSpace(header) == "clear" {
+			delete(t.advertised, authority)
+			continue
+		}
+		if maxAge, ok := parseAltSvcHTTP3(header, port); ok {
+			t.advertised[authority] = now.Add(maxAge)
+		}
+	}
+}
+
+// parseAltSvcHTTP3 returns the max age of the HTTP/3 alternative on port of an Alt-Svc header, if it advertises one
+func parseAltSvcHTTP3(header string, port string) (time.Duration, bool) {
+	for _, alternative := range strings.Split(header, ",") {
+		params := strings.Split(alternative, ";")
+		protocol, authority, found := strings.Cut(strings.TrimSpace(params[0]), "=")
+		if !found || protocol != "h3" || strings.Trim(authority, `"`) != ":"+port {
+			continue
+		}
+		maxAge := altSvcDefaultMaxAge
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if name != "ma" {
+				continue
+			}
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		}
+		return maxAge, true
+	}
+	return 0, false
+}
+
+// originAuthority returns the host:port of the request URL, with the default port of its scheme if none is set
+func originAuthority(req *http.Request) string {
+	port := req.URL.Port()
+	if port == "" {
+		port = "443"
+		if req.URL.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(req.URL.Hostname()), port)
+}
+
+// h3For returns the HTTP/3 transport with the TLS settings of a fallback transport
+func (t *http3Transport) h3For(fallback *http.Transport) *http3.Transport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h3, ok := t.h3[fallback]
+	if !ok {
+		var tlsConfig *tls.Config
+		if fallback.TLSClientConfig != nil {
+			tlsConfig = fallback.TLSClientConfig.Clone()
+		}
+		h3 = &http3.Transport{
+			TLSClientConfig: tlsConfig,
+			QUICConfig:      &quic.Config{HandshakeIdleTimeout: http3HandshakeTimeout},
+		}
+		if t.resolver != nil {
+			h3.Dial = t.dial
+		}
+		t.h3[fallback] = h3
+	}
+	return h3
+}
+
+// dial opens a QUIC connection to the first address of the host of addr, found with the resolver
+func (t *http3Transport) dial(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := t.resolver.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no address found for %s", host)
+	}
+	return quic.DialAddrEarly(ctx, net.JoinHostPort(addresses[0], port), tlsConfig, quicConfig)
+}
+
+// Close closes the HTTP/3 connections
+func (t *http3Transport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var errs []error
+	for _, h3 := range t.h3 {
+		errs = append(errs, h3.Close())
+	}
+	return errors.Join(errs...)
+}
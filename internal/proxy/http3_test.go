@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestParseAltSvcHTTP3(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+		wantOk bool
+	}{
+		{header: `h3=":443"; ma=3600`, want: time.Hour, wantOk: true},
+		{header: `h2=":443", h3=":443"`, want: altSvcDefaultMaxAge, wantOk: true},
+		{header: `h3="alt.example.com:443"`, wantOk: false},
+		{header: `h3=":8443"`, wantOk: false},
+		{header: `h2=":443"; ma=60`, wantOk: false},
+	}
+	for _, tt := range tests {
+		got, ok := parseAltSvcHTTP3(tt.header, "443")
+		if ok != tt.wantOk || got != tt.want {
+			t.Errorf("parseAltSvcHTTP3(%q) = %v, %v, want %v, %v", tt.header, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestHTTP3TransportAltSvc(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, port, _ := net.SplitHostPort(r.Host)
+		w.Header().Set("Alt-Svc", fmt.Sprintf(`h3=":%s"; ma=60`, port))
+		_, _ = io.WriteString(w, r.Proto)
+	})
+	upstream := httptest.NewTLSServer(handler)
+	defer upstream.Close()
+
+	// Serve HTTP/3 on the same port, over UDP
+	udpConn, err := net.ListenPacket("udp", upstream.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to listen on UDP: %v", err)
+	}
+	h3Server := &http3.Server{Handler: handler, TLSConfig: http3.ConfigureTLSConfig(upstream.TLS)}
+	go func() { _ = h3Server.Serve(udpConn) }()
+
+	base := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	transports, _ := newUpstreamTransports(base, &config.UpstreamConfig{})
+	transport := newHTTP3Transport(transports, nil, nil)
+	defer func() { _ = transport.Close() }()
+	get := func() string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL, nil)
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip() error = %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if got := get(); got != "HTTP/1.1" {
+		t.Errorf("first request sent over %s, want HTTP/1.1 as HTTP/3 support is not known yet", got)
+	}
+	if got := get(); got != "HTTP/3.0" {
+		t.Errorf("request after Alt-Svc sent over %s, want HTTP/3.0", got)
+	}
+
+	_ = h3Server.Close()
+	_ = udpConn.Close()
+	_ = transport.h3For(base).Close()
+	transport.h3[base] = &http3.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		QUICConfig:      &quic.Config{HandshakeIdleTimeout: 200 * time.Millisecond},
+	}
+	if got := get(); got != "HTTP/1.1" {
+		t.Errorf("request with HTTP/3 down sent over %s, want fallback to HTTP/1.1", got)
+	}
+}
@@ -3,11 +3,14 @@ package proxy
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 
 	"github.com/iTrooz/caching-dev-proxy/internal/config"
 
@@ -41,35 +44,137 @@ func (s *Server) setupHTTPSProxyHandler() {
 	if caCert == nil {
 		// Use goproxy's default certificate
 		logrus.Warnf("TLS interception enabled but no CA certificate loaded, using goproxy default certificate")
-		s.proxy.OnRequest().HandleConnect(goproxy.AlwaysMitm)
-	} else {
-		// Make goproxy use our provided CA certificate
-		customCaMitm := &goproxy.ConnectAction{
-			Action:    goproxy.ConnectMitm,
-			TLSConfig: goproxy.TLSConfigFromCA(caCert),
+		caCert = &goproxy.GoproxyCa
+	}
+
+	clientCAs, err := loadClientCAs(s.config)
+	if err != nil {
+		logrus.Errorf("Failed to load client CA bundle: %v", err)
+		return
+	}
+
+	// Make goproxy use our CA certificate
+	tlsConfigFromCA := goproxy.TLSConfigFromCA(caCert)
+	mitmTLSConfig := func(host string, ctx *goproxy.ProxyCtx) (*tls.Config, error) {
+		tlsConfig, err := tlsConfigFromCA(host, ctx)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = s.withHandshakeLimit(tlsConfig)
+		if !s.config.Server.HTTPS.ClientCertNamespace {
+			return tlsConfig, nil
+		}
+		return withClientIdentity(tlsConfig, clientCAs, ctx.UserData.(*ctxUserData)), nil
+	}
+	// Intercepted connections are served by net/http rather than by the MITM loop of goproxy,
+	// which forwards neither 1xx responses nor trailers
+	customCaMitm := &goproxy.ConnectAction{
+		Action: goproxy.ConnectHijack,
+		Hijack: func(req *http.Request, client net.Conn, ctx *goproxy.ProxyCtx) {
+			tlsConfig, err := mitmTLSConfig(req.URL.Host, ctx)
+			if err != nil {
+				logrus.Warnf("Cannot intercept %s: %v", req.URL.Host, err)
+				_, _ = io.WriteString(client, "HTTP/1.0 502 Bad Gateway\r\n\r\n")
+				_ = client.Close()
+				return
+			}
+			s.serveMITM(req, client, tlsConfig, ctx.UserData.(*ctxUserData))
+		},
+	}
+	customAlwaysMitm := goproxy.FuncHttpsHandler(func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+		logrus.Debugf("Handling CONNECT request for %s", host)
+
+		// Use user data from transparent proxying if available
+		userData, ok := ctx.Req.Context().Value(ctxUserDataKey{}).(*ctxUserData)
+		if !ok {
+			userData = &ctxUserData{source: SrcHTTPSExplicit}
+		}
+		ctx.UserData = userData
+
+		hostname := host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			hostname = h
 		}
-		customAlwaysMitm := goproxy.FuncHttpsHandler(func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
-			logrus.Debugf("Handling CONNECT request for %s", host)
-
-			// Use user data from transparent proxying if available
-			if userData, ok := ctx.Req.Context().Value(ctxUserData{}).(*ctxUserData); ok {
-				ctx.UserData = userData
-			} else {
-				ctx.UserData = &ctxUserData{source: SrcHTTPSExplicit}
+		if s.passthrough.Match(hostname) {
+			logrus.Debugf("Tunneling %s without interception (server.https.passthrough_hosts)", host)
+			return goproxy.OkConnect, host
+		}
+		// Transparent connections are decided on their SNI hostname
+		if userData.source == SrcHTTPSTransparent {
+			switch s.config.Server.HTTPS.Transparent.SNIActionFor(hostname) {
+			case config.SNIActionPassthrough:
+				logrus.Debugf("Tunneling %s without interception (sni_rules)", host)
+				return goproxy.OkConnect, host
+			case config.SNIActionBlock:
+				logrus.Infof("Blocked transparent HTTPS connection to %s (sni_rules)", host)
+				return goproxy.RejectConnect, host
 			}
+		}
+
+		return customCaMitm, host
+	})
+	s.proxy.OnRequest().HandleConnect(customAlwaysMitm)
+}
+
+// loadClientCAs loads the CA bundle verifying client certificates, or returns nil if not configured
+func loadClientCAs(cfg *config.Config) (*x509.CertPool, error) {
+	if cfg.Server.HTTPS.ClientCAFile == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(cfg.Server.HTTPS.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in client CA bundle %s", cfg.Server.HTTPS.ClientCAFile)
+	}
+	return pool, nil
+}
 
-			return customCaMitm, host
-		})
-		s.proxy.OnRequest().HandleConnect(customAlwaysMitm)
+// withHandshakeLimit makes handshakes with the TLS config take a slot of server.limits.max_handshakes until they conclude
+func (s *Server) withHandshakeLimit(tlsConfig *tls.Config) *tls.Config {
+	if s.handshakeLimit == nil {
+		return tlsConfig
+	}
+	tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		release, ok := s.handshakeLimit.acquire(hello.Context())
+		if !ok {
+			return nil, fmt.Errorf("too many concurrent TLS handshakes")
+		}
+		// Canceled when the handshake concludes, successfully or not
+		context.AfterFunc(hello.Context(), release)
+		return nil, nil
+	}
+	return tlsConfig
+}
+
+// withClientIdentity makes the TLS config request a client certificate, and records its CN in userData.
+// Without clientCAs, certificates are not verified and the identity is only declarative
+func withClientIdentity(tlsConfig *tls.Config, clientCAs *x509.CertPool, userData *ctxUserData) *tls.Config {
+	if clientCAs != nil {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		tlsConfig.ClientCAs = clientCAs
+	} else {
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	}
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) > 0 {
+			userData.clientIdentity = state.PeerCertificates[0].Subject.CommonName
+			logrus.Debugf("Client presented certificate with CN=%s", userData.clientIdentity)
+		}
+		return nil
 	}
+	return tlsConfig
 }
 
 // StartTransparentHTTPS enables transparent HTTPS proxying
 func (s *Server) StartTransparentHTTPS(httpsAddr string) {
-	ln, err := net.Listen("tcp", httpsAddr)
+	ln, err := listen("https", 1, httpsAddr)
 	if err != nil {
 		log.Fatalf("Error listening for https connections - %v", err)
 	}
+	ln = newLimitListener(ln, s.config.Server.Limits.MaxConnections)
 	for {
 		c, err := ln.Accept()
 		if err != nil {
@@ -80,10 +185,12 @@ func (s *Server) StartTransparentHTTPS(httpsAddr string) {
 			tlsConn, err := vhost.TLS(c)
 			if err != nil {
 				log.Printf("Error accepting new connection - %v", err)
+				_ = c.Close()
 				return
 			}
 			if tlsConn.Host() == "" {
 				log.Printf("Cannot support non-SNI enabled clients")
+				_ = c.Close()
 				return
 			}
 			// Create request
@@ -98,7 +205,7 @@ func (s *Server) StartTransparentHTTPS(httpsAddr string) {
 				RemoteAddr: c.RemoteAddr().String(),
 			}
 			// Set source
-			connectReq = connectReq.WithContext(context.WithValue(context.Background(), ctxUserData{}, &ctxUserData{
+			connectReq = connectReq.WithContext(context.WithValue(context.Background(), ctxUserDataKey{}, &ctxUserData{
 				source: SrcHTTPSTransparent,
 			}))
 			// Send to goproxy
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// EntryDetails describes a stored entry, from the internal headers stored along its response
+type EntryDetails struct {
+	// Time the entry was stored. Zero if unknown
+	Stored time.Time
+	// Expiry decided for the entry (e.g. by the decision service). Zero if it expires with the cache TTL
+	Expires time.Time
+	// Headers replacing the stored ones when the entry is served (see SetServeHeaders)
+	ServeHeaders http.Header
+}
+
+// InspectEntry returns the details of a stored response, and removes the internal headers from it
+func InspectEntry(resp *http.Response) EntryDetails {
+	details := EntryDetails{}
+	if stored, err := time.Parse(time.RFC3339Nano, resp.Header.Get(storedHeader)); err == nil {
+		details.Stored = stored
+	}
+	if expires, err := time.Parse(time.RFC3339Nano, resp.Header.Get(expiresHeader)); err == nil {
+		details.Expires = expires
+	}
+	if value := resp.Header.Get(serveHeadersHeader); value != "" {
+		_ = json.Unmarshal([]byte(value), &details.ServeHeaders)
+	}
+	resp.Header.Del(storedHeader)
+	resp.Header.Del(expiresHeader)
+	resp.Header.Del(serveHeadersHeader)
+	return details
+}
+
+// ExpiresAt returns when the entry expires, given the cache TTL (0 meaning no expiry).
+// Zero if it never expires, or if its storage time is unknown
+func (d EntryDetails) ExpiresAt(ttl time.Duration) time.Time {
+	if !d.Expires.IsZero() || d.Stored.IsZero() || ttl == 0 {
+		return d.Expires
+	}
+	return d.Stored.Add(ttl)
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestInspectEntry(t *testing.T) {
+	stored := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Content-Type", "text/plain")
+	resp.Header.Set(storedHeader, stored.Format(time.RFC3339Nano))
+	resp.Header.Set(expiresHeader, stored.Add(time.Hour).Format(time.RFC3339Nano))
+	resp.Header.Set(serveHeadersHeader, `{"Content-Type": ["application/pdf"]}`)
+
+	details := InspectEntry(resp)
+	if !details.Stored.Equal(stored) || !details.Expires.Equal(stored.Add(time.Hour)) {
+		t.Errorf("stored = %v, expires = %v", details.Stored, details.Expires)
+	}
+	if got := details.ExpiresAt(24 * time.Hour); !got.Equal(stored.Add(time.Hour)) {
+		t.Errorf("ExpiresAt() = %v, want the decided expiry", got)
+	}
+	if got := (EntryDetails{Stored: stored}).ExpiresAt(24 * time.Hour); !got.Equal(stored.Add(24 * time.Hour)) {
+		t.Errorf("ExpiresAt() = %v, want the storage time plus TTL", got)
+	}
+	if got := (EntryDetails{Stored: stored}).ExpiresAt(0); !got.IsZero() {
+		t.Errorf("ExpiresAt() = %v, want no expiry without TTL", got)
+	}
+	if details.ServeHeaders.Get("Content-Type") != "application/pdf" {
+		t.Errorf("serve headers = %v", details.ServeHeaders)
+	}
+	if len(resp.Header) != 1 || resp.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("internal headers not removed: %v", resp.Header)
+	}
+
+	// Entries stored by older versions have no internal headers
+	if details := InspectEntry(&http.Response{Header: http.Header{}}); !details.Stored.IsZero() || !details.Expires.IsZero() {
+		t.Errorf("unexpected details of an entry without internal headers: %+v", details)
+	}
+}
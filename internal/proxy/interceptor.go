@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+// Interceptor plugs custom logic (e.g. auth injection, metrics, redaction) into request handling.
+// Register it with Server.Use. Embed NopInterceptor to only implement some of the methods
+type Interceptor interface {
+	// OnRequest is called before the request is handled, and may modify it.
+	// Returning a response answers the request with it, without using the cache or upstream
+	OnRequest(req *http.Request) *http.Response
+	// OnCacheDecision is called with the caching decision for an upstream response, and returns the final one
+	OnCacheDecision(req *http.Request, resp *http.Response, cache bool) bool
+	// OnResponse is called on every response, after it is cached, and returns the response sent to the client
+	OnResponse(req *http.Request, resp *http.Response) *http.Response
+}
+
+// NopInterceptor is an Interceptor changing nothing
+type NopInterceptor struct{}
+
+func (NopInterceptor) OnRequest(*http.Request) *http.Response { return nil }
+
+func (NopInterceptor) OnCacheDecision(_ *http.Request, _ *http.Response, cache bool) bool {
+	return cache
+}
+
+func (NopInterceptor) OnResponse(_ *http.Request, resp *http.Response) *http.Response { return resp }
+
+// Use registers an interceptor. Interceptors are called in registration order. Must be called before the server starts
+func (s *Server) Use(interceptor Interceptor) {
+	s.interceptors = append(s.interceptors, interceptor)
+}
+
+// interceptRequest calls the OnRequest method of interceptors, until one answers the request
+func (s *Server) interceptRequest(req *http.Request) *http.Response {
+	for _, interceptor := range s.interceptors {
+		if resp := interceptor.OnRequest(req); resp != nil {
+			return resp
+		}
+	}
+	return nil
+}
+
+// interceptCacheDecision passes the caching decision through the OnCacheDecision method of interceptors
+func (s *Server) interceptCacheDecision(req *http.Request, resp *http.Response, cache bool) bool {
+	for _, interceptor := range s.interceptors {
+		cache = interceptor.OnCacheDecision(req, resp, cache)
+	}
+	return cache
+}
+
+// interceptResponse passes the response through the OnResponse method of interceptors
+func (s *Server) interceptResponse(req *http.Request, resp *http.Response) *http.Response {
+	for _, interceptor := range s.interceptors {
+		resp = interceptor.OnResponse(req, resp)
+	}
+	return resp
+}
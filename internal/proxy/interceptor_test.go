@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+
+	"github.com/elazarl/goproxy"
+)
+
+type testInterceptor struct {
+	NopInterceptor
+	decisions int
+}
+
+func (i *testInterceptor) OnRequest(req *http.Request) *http.Response {
+	if req.URL.Path == "/denied" {
+		return goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusUnauthorized, "denied")
+	}
+	req.Header.Set("Authorization", "Bearer injected")
+	return nil
+}
+
+func (i *testInterceptor) OnCacheDecision(req *http.Request, resp *http.Response, cache bool) bool {
+	i.decisions++
+	return true
+}
+
+func (i *testInterceptor) OnResponse(req *http.Request, resp *http.Response) *http.Response {
+	resp.Header.Set("X-Intercepted", "1")
+	return resp
+}
+
+func TestInterceptor(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer injected" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir(), TTL: "1h"},
+		Rules: config.RulesConfig{Mode: config.RulesModeWhitelist},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	interceptor := &testInterceptor{}
+	s.Use(interceptor)
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantCache  string
+	}{
+		{path: "/denied", wantStatus: http.StatusUnauthorized, wantCache: "INTERCEPTED"},
+		{path: "/users", wantStatus: http.StatusOK, wantCache: "MISS"},
+		{path: "/users", wantStatus: http.StatusOK, wantCache: "HIT"},
+	}
+	for _, tt := range tests {
+		resp, err := client.Get(upstream.URL + tt.path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", tt.path, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != tt.wantStatus || resp.Header.Get("X-Cache") != tt.wantCache || resp.Header.Get("X-Intercepted") != "1" {
+			t.Errorf("GET %s = %d (X-Cache %s, X-Intercepted %q), want %d (X-Cache %s)", tt.path, resp.StatusCode, resp.Header.Get("X-Cache"), resp.Header.Get("X-Intercepted"), tt.wantStatus, tt.wantCache)
+		}
+	}
+	if interceptor.decisions != 1 {
+		t.Errorf("OnCacheDecision calls = %d, want 1", interceptor.decisions)
+	}
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/elazarl/goproxy"
+)
+
+type clientWriterKey struct{}
+
+// clientWriter sends the client what goproxy cannot: 1xx responses before the final response, and trailers after its body
+type clientWriter struct {
+	w http.ResponseWriter
+
+	mu sync.Mutex
+	// whether the final response is being written. Later 1xx responses (e.g. of a background fetch) are dropped
+	final bool
+}
+
+// serveProxy proxies a request with goproxy, letting the handlers reach the client connection through the request context
+func (s *Server) serveProxy(w http.ResponseWriter, req *http.Request) {
+	s.proxy.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), clientWriterKey{}, &clientWriter{w: w})))
+}
+
+// Handler returns the HTTP handler of the proxy, as served by Start
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(s.serveProxy)
+}
+
+// forwardInterim returns the request, making its 1xx responses from upstream (e.g. 103 Early Hints) be forwarded to the client.
+// 100 Continue is not forwarded: the client gets it from the proxy server when its request body is read
+func forwardInterim(req *http.Request) *http.Request {
+	cw, ok := req.Context().Value(clientWriterKey{}).(*clientWriter)
+	if !ok {
+		return req
+	}
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusContinue {
+				return nil
+			}
+			cw.mu.Lock()
+			defer cw.mu.Unlock()
+			if cw.final {
+				return nil
+			}
+			h := cw.w.Header()
+			for k, v := range header {
+				h[k] = v
+			}
+			cw.w.WriteHeader(code)
+			for k := range header {
+				delete(h, k)
+			}
+			return nil
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// sendTrailers is the last response handler: it stops forwarding 1xx responses,
+// and makes the trailers of the response be sent once its body is
+func sendTrailers(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
+	if ctx.Req == nil {
+		return resp
+	}
+	cw, ok := ctx.Req.Context().Value(clientWriterKey{}).(*clientWriter)
+	if !ok {
+		return resp
+	}
+	cw.mu.Lock()
+	cw.final = true
+	cw.mu.Unlock()
+	if resp == nil || len(resp.Trailer) == 0 {
+		return resp
+	}
+
+	// Announced before the body, as net/http only sends the announced trailers
+	keys := make([]string, 0, len(resp.Trailer))
+	for k := range resp.Trailer {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	resp.Header.Set("Trailer", strings.Join(keys, ", "))
+	resp.Body = &trailerBody{ReadCloser: resp.Body, trailer: resp.Trailer, header: cw.w.Header()}
+	return resp
+}
+
+// trailerBody sets the trailers of a response in the header of the client ResponseWriter once its body is read,
+// as the trailers of upstream are only known then
+type trailerBody struct {
+	io.ReadCloser
+	trailer http.Header
+	header  http.Header
+}
+
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		for k, v := range b.trailer {
+			b.header[k] = v
+		}
+	}
+	return n, err
+}
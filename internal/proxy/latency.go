@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// latencyFor returns the delay to add to the response of the request, from the first matching rule configuring latency.
+// status is the cache status of the response (see ctxUserData.status)
+func (s *Server) latencyFor(requ *http.Request, status string) time.Duration {
+	for _, rule := range s.matchingConfigRules(requ) {
+		if rule.Latency == nil {
+			continue
+		}
+		switch rule.Latency.On {
+		case "hit":
+			if status != "HIT" && status != "STALE" {
+				return 0
+			}
+		case "miss":
+			if status != "" {
+				return 0
+			}
+		}
+		delay, maxDelay := rule.latencyDelay, rule.latencyMaxDelay
+		if maxDelay > delay {
+			delay += rand.N(maxDelay - delay + 1)
+		}
+		return delay
+	}
+	return 0
+}
+
+// injectLatency waits for the delay configured for the response, or until the client goes away
+func (s *Server) injectLatency(requ *http.Request, userData *ctxUserData) {
+	delay := s.latencyFor(requ, userData.status)
+	if delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-requ.Context().Done():
+	}
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestLatencyFor(t *testing.T) {
+	rules, err := configRules(&config.Config{Rules: config.RulesConfig{Rules: []config.CacheRule{
+		{BaseURI: "http://example.com/hit", Methods: []string{"GET"}, Latency: &config.LatencyConfig{Delay: "1s", On: "hit"}},
+		{BaseURI: "http://example.com/miss", Methods: []string{"GET"}, Latency: &config.LatencyConfig{Delay: "1s", On: "miss"}},
+		{BaseURI: "http://example.com/random", Methods: []string{"GET"}, Latency: &config.LatencyConfig{Delay: "1s", MaxDelay: "2s"}},
+	}}})
+	if err != nil {
+		t.Fatalf("configRules() error = %v", err)
+	}
+	s := &Server{rules: rules}
+
+	tests := []struct {
+		name     string
+		url      string
+		status   string
+		min, max time.Duration
+	}{
+		{name: "no latency rule", url: "http://example.com/other", status: "HIT"},
+		{name: "hit only, on hit", url: "http://example.com/hit", status: "HIT", min: time.Second, max: time.Second},
+		{name: "hit only, on stale", url: "http://example.com/hit", status: "STALE", min: time.Second, max: time.Second},
+		{name: "hit only, on miss", url: "http://example.com/hit", status: ""},
+		{name: "miss only, on miss", url: "http://example.com/miss", status: "", min: time.Second, max: time.Second},
+		{name: "miss only, on hit", url: "http://example.com/miss", status: "HIT"},
+		{name: "random", url: "http://example.com/random", status: "MOCK", min: time.Second, max: 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requ, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+			for range 20 {
+				if got := s.latencyFor(requ, tt.status); got < tt.min || got > tt.max {
+					t.Fatalf("latencyFor() = %v, want between %v and %v", got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// limitListener accepts at most max concurrent connections: Accept waits for a connection to close once the limit is reached
+type limitListener struct {
+	net.Listener
+	slots chan struct{}
+}
+
+// limitConn releases its slot of the listener when closed
+type limitConn struct {
+	net.Conn
+	release sync.Once
+	slots   chan struct{}
+}
+
+// newLimitListener limits the concurrent connections of a listener. max <= 0 returns it unchanged
+func newLimitListener(ln net.Listener, max int) net.Listener {
+	if max <= 0 {
+		return ln
+	}
+	return &limitListener{Listener: ln, slots: make(chan struct{}, max)}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		logrus.Warnf("Reached the maximum of %d client connections on %s, waiting for one to close", cap(l.slots), l.Addr())
+		l.slots <- struct{}{}
+	}
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitConn{Conn: conn, slots: l.slots}, nil
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.release.Do(func() { <-c.slots })
+	return err
+}
+
+// concurrencyLimit bounds the number of concurrent operations (proxied requests, TLS handshakes), queuing or rejecting the others
+type concurrencyLimit struct {
+	// name of the operations, for logs
+	name  string
+	slots chan struct{}
+	// reject operations over the limit instead of queuing them
+	reject bool
+	// time an operation waits in queue, 0 for no limit
+	timeout time.Duration
+}
+
+// newConcurrencyLimit returns a limit of max concurrent operations, or nil (no limit) if max <= 0
+func newConcurrencyLimit(name string, max int, cfg *config.LimitsConfig) (*concurrencyLimit, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+	timeout, err := config.ParseOptionalDuration(cfg.QueueTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid queue timeout: %w", err)
+	}
+	return &concurrencyLimit{
+		name:    name,
+		slots:   make(chan struct{}, max),
+		reject:  cfg.OnOverload == config.OverloadReject,
+		timeout: timeout,
+	}, nil
+}
+
+// acquire takes a slot, waiting for one if allowed. It returns the function releasing the slot, or false if the operation must be rejected.
+// A nil limit always succeeds
+func (l *concurrencyLimit) acquire(ctx context.Context) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	var once sync.Once
+	release := func() { once.Do(func() { <-l.slots }) }
+	select {
+	case l.slots <- struct{}{}:
+		return release, true
+	default:
+	}
+	if l.reject {
+		logrus.Warnf("Reached the maximum of %d concurrent %s, rejecting", cap(l.slots), l.name)
+		return nil, false
+	}
+
+	logrus.Debugf("Reached the maximum of %d concurrent %s, waiting for one to finish", cap(l.slots), l.name)
+	var timeout <-chan time.Time
+	if l.timeout > 0 {
+		timer := time.NewTimer(l.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return release, true
+	case <-timeout:
+		logrus.Warnf("Waited %s for one of the %d concurrent %s to finish, rejecting", l.timeout, cap(l.slots), l.name)
+	case <-ctx.Done():
+	}
+	return nil, false
+}
+
+// newHTTPServer creates the HTTP server of the proxy, with the configured connection limits
+func (s *Server) newHTTPServer() (*http.Server, error) {
+	read, write, idle, err := s.config.GetServerTimeouts()
+	if err != nil {
+		return nil, err
+	}
+	maxHeaderBytes, err := s.config.GetMaxHeaderBytes()
+	if err != nil {
+		return nil, fmt.Errorf("invalid max header bytes: %w", err)
+	}
+	return &http.Server{
+		Handler:        s.Handler(),
+		ReadTimeout:    read,
+		WriteTimeout:   write,
+		IdleTimeout:    idle,
+		MaxHeaderBytes: maxHeaderBytes,
+		ConnState: func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew || state == http.StateActive {
+				s.idle.touch()
+			}
+			// CONNECT tunnels are hijacked: the timeouts of a single request must not cut them
+			if state == http.StateHijacked {
+				_ = conn.SetDeadline(time.Time{})
+			}
+		},
+	}, nil
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestLimitListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := newLimitListener(inner, 1)
+	defer func() { _ = ln.Close() }()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn
+	}
+
+	dial()
+	first := <-accepted
+	dial()
+	select {
+	case <-accepted:
+		t.Fatal("second connection accepted while the first one is open")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Closing twice must release a single slot
+	_ = first.Close()
+	_ = first.Close()
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("second connection not accepted after the first one closed")
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	if limit, err := newConcurrencyLimit("requests", 0, &config.LimitsConfig{}); err != nil || limit != nil {
+		t.Fatalf("newConcurrencyLimit(0) = %v, %v, want no limit", limit, err)
+	}
+	if _, ok := (*concurrencyLimit)(nil).acquire(context.Background()); !ok {
+		t.Fatal("acquire() without limit failed")
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		limit, err := newConcurrencyLimit("requests", 1, &config.LimitsConfig{OnOverload: config.OverloadReject})
+		if err != nil {
+			t.Fatal(err)
+		}
+		release, ok := limit.acquire(context.Background())
+		if !ok {
+			t.Fatal("first acquire() failed")
+		}
+		if _, ok := limit.acquire(context.Background()); ok {
+			t.Fatal("acquire() over the limit succeeded")
+		}
+		// Releasing twice must free a single slot
+		release()
+		release()
+		if _, ok := limit.acquire(context.Background()); !ok {
+			t.Fatal("acquire() after release failed")
+		}
+		if _, ok := limit.acquire(context.Background()); ok {
+			t.Fatal("slot released twice")
+		}
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		limit, err := newConcurrencyLimit("requests", 1, &config.LimitsConfig{OnOverload: config.OverloadQueue, QueueTimeout: "50ms"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		release, _ := limit.acquire(context.Background())
+		if _, ok := limit.acquire(context.Background()); ok {
+			t.Fatal("acquire() succeeded while the slot is taken")
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, ok := limit.acquire(ctx); ok {
+			t.Fatal("acquire() succeeded with a canceled context")
+		}
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			release()
+		}()
+		if _, ok := limit.acquire(context.Background()); !ok {
+			t.Fatal("queued acquire() failed although the slot was released in time")
+		}
+	})
+}
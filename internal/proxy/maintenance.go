@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+	"github.com/iTrooz/caching-dev-proxy/internal/maintenance"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newMaintenanceScheduler creates the scheduler of the configured maintenance jobs
+func (s *Server) newMaintenanceScheduler() (*maintenance.Scheduler, error) {
+	duration, err := s.config.GetMaintenanceDuration()
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance duration: %w", err)
+	}
+	throttle, err := s.config.GetMaintenanceThrottle()
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance throttle: %w", err)
+	}
+
+	available := map[string]func(*maintenance.Throttle) error{
+		"gc":      s.gcJob,
+		"verify":  s.verifyJob,
+		"compact": s.compactJob,
+		"refresh": s.refreshJob,
+	}
+	jobs := []maintenance.Job{}
+	for _, name := range s.config.Maintenance.Jobs {
+		run, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown maintenance job '%s'", name)
+		}
+		jobs = append(jobs, maintenance.Job{Name: name, Run: run})
+	}
+
+	return maintenance.New(s.config.Maintenance.Windows, duration, throttle, jobs)
+}
+
+// entryAge classifies an entry from its own expiry (X-Cache-Expires, else its storage time plus the cache TTL):
+// fresh, expired (still kept for stale serving), or removable
+func (s *Server) entryAge(info cache.EntryInfo, now time.Time) (expired bool, removable bool, err error) {
+	if s.cacheTTL != 0 && now.Sub(info.ModTime) > s.cacheTTL+s.staleTTL {
+		return true, true, nil // no longer kept by the cache
+	}
+	resp, err := s.cacheManager.GetStaleKey(info.Key)
+	if err != nil || resp == nil {
+		return false, false, err
+	}
+	_ = resp.Body.Close()
+	details := InspectEntry(resp)
+	if details.Stored.IsZero() {
+		details.Stored = info.ModTime
+	}
+	expiresAt := details.ExpiresAt(s.cacheTTL)
+	if expiresAt.IsZero() {
+		return false, false, nil
+	}
+	return now.After(expiresAt), now.After(expiresAt.Add(s.staleTTL)), nil
+}
+
+// gcJob removes entries past their expiry (and stale TTL), which are otherwise only removed when requested again
+func (s *Server) gcJob(throttle *maintenance.Throttle) error {
+	removed := 0
+	keys := []string{}
+	now := time.Now()
+	err := s.cacheManager.Walk("", func(info cache.EntryInfo) error {
+		throttle.Wait()
+		_, removable, err := s.entryAge(info, now)
+		if err != nil {
+			logrus.Warnf("Maintenance: failed to read cache entry %s: %v", info.Key, err)
+			return nil
+		}
+		if removable {
+			keys = append(keys, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		throttle.Wait()
+		if err := s.cacheManager.DeleteKey(key); err != nil {
+			return err
+		}
+		removed++
+	}
+	logrus.Infof("Maintenance: removed %d expired cache entries", removed)
+	return nil
+}
+
+// verifyJob removes entries that cannot be read back (e.g. truncated by a crash)
+func (s *Server) verifyJob(throttle *maintenance.Throttle) error {
+	corrupted := []string{}
+	err := s.cacheManager.Walk("", func(info cache.EntryInfo) error {
+		throttle.Wait()
+		resp, err := s.cacheManager.GetStaleKey(info.Key)
+		if err != nil {
+			logrus.Warnf("Maintenance: cache entry %s is corrupted: %v", info.Key, err)
+			corrupted = append(corrupted, info.Key)
+			return nil
+		}
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range corrupted {
+		if err := s.cacheManager.DeleteKey(key); err != nil {
+			return err
+		}
+	}
+	logrus.Infof("Maintenance: removed %d corrupted cache entries", len(corrupted))
+	return nil
+}
+
+// compactJob prunes and compacts the history database
+func (s *Server) compactJob(throttle *maintenance.Throttle) error {
+	db := s.history.DB()
+	if db == nil {
+		return nil
+	}
+	if err := db.Prune(); err != nil {
+		return err
+	}
+	return db.Compact()
+}
+
+// refreshJob fetches again the expired entries still kept for stale serving, so they are fresh when requested
+func (s *Server) refreshJob(throttle *maintenance.Throttle) error {
+	expired := []string{}
+	now := time.Now()
+	err := s.cacheManager.Walk("", func(info cache.EntryInfo) error {
+		throttle.Wait()
+		isExpired, removable, err := s.entryAge(info, now)
+		if err != nil {
+			logrus.Warnf("Maintenance: failed to read cache entry %s: %v", info.Key, err)
+			return nil
+		}
+		if isExpired && !removable {
+			expired = append(expired, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	refreshed := 0
+	for _, key := range expired {
+		throttle.Wait()
+		if err := s.refreshEntry(key); err != nil {
+			logrus.Warnf("Maintenance: failed to refresh cache entry %s: %v", key, err)
+			continue
+		}
+		refreshed++
+	}
+	logrus.Infof("Maintenance: refreshed %d of %d expired cache entries", refreshed, len(expired))
+	return nil
+}
+
+// refreshEntry sends the stored request of an entry to upstream again, and stores the new response
+func (s *Server) refreshEntry(key string) error {
+	stored, err := s.cacheManager.GetStaleKey(key)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return nil // removed in the meantime
+	}
+	_ = stored.Body.Close()
+	if reason := replayable(stored.Request); reason != "" {
+		return errors.New(reason)
+	}
+
+	body, err := io.ReadAll(stored.Request.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read stored request body: %w", err)
+	}
+	req := stored.Request.Clone(stored.Request.Context())
+	req.RequestURI = ""
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	_, err = s.fetchIntoCache(req, body, key)
+	return err
+}
+
+// fetchIntoCache sends the request (with the given body) to upstream, and stores the response under key if the rules cache it.
+// Returns the response, with its body
+func (s *Server) fetchIntoCache(req *http.Request, body []byte, key string) (*http.Response, error) {
+	resp, err := s.upstream.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respCopy, err := copyResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	cacheable, ttl := s.cacheDecision(req, respCopy)
+	if !cacheable {
+		return nil, fmt.Errorf("upstream answered %s, which is not cached", resp.Status)
+	}
+	respCopy.Request = withBody(req, body)
+	stored, err := s.toStore(respCopy, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.cacheManager.SetKey(key, stored); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
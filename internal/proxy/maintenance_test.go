@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestMaintenanceGCAndVerify(t *testing.T) {
+	cacheDir := t.TempDir()
+	cfg := &config.Config{
+		Cache: config.CacheConfig{TTL: "1h", Folder: cacheDir},
+		Rules: config.RulesConfig{Mode: "blacklist"},
+	}
+	server, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	write := func(key string, data string, age time.Duration) {
+		path := filepath.Join(cacheDir, key)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-age)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	valid := "---HTTP-RESPONSE---\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+	withExpiry := func(expires time.Time) string {
+		return "---HTTP-RESPONSE---\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n" + expiresHeader + ": " + expires.UTC().Format(time.RFC3339Nano) + "\r\n\r\nok"
+	}
+	write("example.com/fresh/GET.bin", valid, time.Minute)
+	write("example.com/expired/GET.bin", valid, 2*time.Hour)
+	write("example.com/corrupted/GET.bin", "garbage", time.Minute)
+	// Entries with their own expiry, shorter than the cache TTL
+	write("example.com/short-fresh/GET.bin", withExpiry(time.Now().Add(time.Minute)), time.Minute)
+	write("example.com/short-expired/GET.bin", withExpiry(time.Now().Add(-time.Minute)), 2*time.Minute)
+
+	if err := server.gcJob(nil); err != nil {
+		t.Fatalf("gcJob() error = %v", err)
+	}
+	if err := server.verifyJob(nil); err != nil {
+		t.Fatalf("verifyJob() error = %v", err)
+	}
+
+	for key, want := range map[string]bool{
+		"example.com/fresh/GET.bin":         true,
+		"example.com/expired/GET.bin":       false,
+		"example.com/corrupted/GET.bin":     false,
+		"example.com/short-fresh/GET.bin":   true,
+		"example.com/short-expired/GET.bin": false,
+	} {
+		_, err := os.Stat(filepath.Join(cacheDir, key))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s exists = %v, want %v", key, exists, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// serveMITM serves the requests of an intercepted CONNECT tunnel to the proxy, over TLS with the given config
+func (s *Server) serveMITM(connectReq *http.Request, client net.Conn, tlsConfig *tls.Config, userData *ctxUserData) {
+	server, err := s.newHTTPServer()
+	if err != nil {
+		logrus.Errorf("Cannot intercept %s: %v", connectReq.URL.Host, err)
+		_ = client.Close()
+		return
+	}
+	if _, err := io.WriteString(client, "HTTP/1.0 200 OK\r\n\r\n"); err != nil {
+		_ = client.Close()
+		return
+	}
+
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Same URL as the MITM loop of goproxy made, which cache keys depend on
+		if !req.URL.IsAbs() {
+			req.URL.Scheme = "https"
+			req.URL.Host = connectReq.Host
+		}
+		req.RemoteAddr = connectReq.RemoteAddr
+		s.serveProxy(w, req.WithContext(context.WithValue(req.Context(), ctxUserDataKey{}, userData)))
+	})
+	// HTTP/1.1 only, as goproxy negotiates
+	server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+
+	ln := newConnListener(tls.Server(client, tlsConfig))
+	connState := server.ConnState
+	server.ConnState = func(conn net.Conn, state http.ConnState) {
+		connState(conn, state)
+		if state == http.StateClosed || state == http.StateHijacked {
+			_ = ln.Close()
+		}
+	}
+	// In the background, so that the server of the CONNECT request does not wait for the tunnel to close
+	go func() { _ = server.Serve(ln) }()
+}
+
+// connListener is a net.Listener accepting a single connection, closed once the connection is done with
+type connListener struct {
+	conns  chan net.Conn
+	addr   net.Addr
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	conns := make(chan net.Conn, 1)
+	conns <- conn
+	return &connListener{conns: conns, addr: conn.LocalAddr(), closed: make(chan struct{})}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
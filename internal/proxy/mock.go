@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+
+	"github.com/elazarl/goproxy"
+	"github.com/sirupsen/logrus"
+)
+
+// mockResponse builds the response of a mock. The file is read on each request, so it can be edited while the proxy runs
+func mockResponse(requ *http.Request, mock *config.MockConfig) *http.Response {
+	status := mock.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := mock.Body
+	contentType := "text/plain; charset=utf-8"
+	if mock.File != "" {
+		data, err := os.ReadFile(mock.File)
+		if err != nil {
+			logrus.Errorf("OnRequest(url=%s): Failed to read mock file: %v", requ.URL.String(), err)
+			return goproxy.NewResponse(requ, goproxy.ContentTypeText, http.StatusInternalServerError, fmt.Sprintf("failed to read mock file: %v", err))
+		}
+		body = string(data)
+		if byExtension := mime.TypeByExtension(filepath.Ext(mock.File)); byExtension != "" {
+			contentType = byExtension
+		}
+	}
+
+	resp := goproxy.NewResponse(requ, contentType, status, body)
+	for name, value := range mock.Headers {
+		resp.Header.Set(name, value)
+	}
+	return resp
+}
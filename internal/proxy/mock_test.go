@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestMock(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "upstream")
+	}))
+	defer upstream.Close()
+
+	mockFile := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(mockFile, []byte(`[{"name": "alice"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist, Rules: []config.CacheRule{
+			{BaseURI: upstream.URL + "/v2/users", Methods: []string{"GET"}, Mock: &config.MockConfig{File: mockFile}},
+			{BaseURI: upstream.URL + "/v2/", Methods: []string{"GET", "POST"}, Mock: &config.MockConfig{
+				Status: http.StatusCreated, Headers: map[string]string{"Location": "/v2/orders/1"}, Body: "created",
+			}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	tests := []struct {
+		method      string
+		path        string
+		status      int
+		contentType string
+		body        string
+	}{
+		{http.MethodGet, "/v2/users", http.StatusOK, "application/json", `[{"name": "alice"}]`},
+		{http.MethodPost, "/v2/orders", http.StatusCreated, "text/plain; charset=utf-8", "created"},
+		{http.MethodGet, "/v1/users", http.StatusOK, "text/plain; charset=utf-8", "upstream"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, upstream.URL+tt.path, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s error = %v", tt.method, tt.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != tt.status || resp.Header.Get("Content-Type") != tt.contentType || string(body) != tt.body {
+			t.Errorf("%s %s = %d %s %q, want %d %s %q", tt.method, tt.path, resp.StatusCode, resp.Header.Get("Content-Type"), body, tt.status, tt.contentType, tt.body)
+		}
+		if tt.status == http.StatusCreated && resp.Header.Get("Location") != "/v2/orders/1" {
+			t.Errorf("mock headers not set: %v", resp.Header)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/elazarl/goproxy"
+	"github.com/sirupsen/logrus"
+)
+
+// replayResponse returns the recorded response of a request in replay mode, even if expired.
+// Requests that were not recorded fail, instead of reaching upstream
+func (s *Server) replayResponse(req *http.Request, userData *ctxUserData) *http.Response {
+	recorded, err := s.cacheManager.GetStaleKey(userData.key)
+	if err != nil {
+		logrus.Errorf("OnRequest(url=%s): Failed to get recorded response: %v", req.URL.String(), err)
+	}
+	if recorded == nil {
+		logrus.Warnf("OnRequest(url=%s): Not recorded, failing in replay mode", req.URL.String())
+		resp := goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusGatewayTimeout, "This request was not recorded, and upstream is not contacted in replay mode.\n")
+		resp.Header.Set("X-Cache", "MISS")
+		userData.status = "MISS"
+		return resp
+	}
+
+	s.fromStore(recorded, time.Now()) // expiry is ignored
+	recorded.Request = req
+	recorded = rangeResponse(req, recorded)
+	recorded.Header.Set("X-Cache", "HIT")
+	userData.status = "HIT"
+	return recorded
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var upstreamHits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits.Add(1)
+		_, _ = io.WriteString(w, "recorded "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	// Whitelist without rules: nothing would be cached outside of record mode
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir(), Mode: config.CacheModeRecord},
+		Rules: config.RulesConfig{Mode: config.RulesModeWhitelist},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	get := func(path string) (int, string, string) {
+		resp, err := client.Get(upstream.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("X-Cache"), string(body)
+	}
+
+	// Record mode always fetches upstream, even when already stored
+	for range 2 {
+		if status, cacheStatus, _ := get("/a"); status != http.StatusOK || cacheStatus != "MISS" {
+			t.Errorf("recording: status = %d, X-Cache = %s, want 200 MISS", status, cacheStatus)
+		}
+	}
+	if upstreamHits.Load() != 2 {
+		t.Errorf("upstream was hit %d times while recording, want 2", upstreamHits.Load())
+	}
+
+	s.config.Cache.Mode = config.CacheModeReplay
+	if status, cacheStatus, body := get("/a"); status != http.StatusOK || cacheStatus != "HIT" || body != "recorded /a" {
+		t.Errorf("replaying: status = %d, X-Cache = %s, body = %q, want the recorded response", status, cacheStatus, body)
+	}
+	if status, _, _ := get("/b"); status != http.StatusGatewayTimeout {
+		t.Errorf("replaying an unrecorded request: status = %d, want %d", status, http.StatusGatewayTimeout)
+	}
+	if upstreamHits.Load() != 2 {
+		t.Errorf("upstream was hit %d times in total, want no hit while replaying", upstreamHits.Load())
+	}
+}
@@ -0,0 +1,74 @@
+//go:build linux
+
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// redirectedDestination returns the destination of a connection before an iptables REDIRECT rule sent it to the proxy
+func redirectedDestination(conn *net.TCPConn) (*net.TCPAddr, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	ipv4 := conn.LocalAddr().(*net.TCPAddr).IP.To4() != nil
+	var addr *net.TCPAddr
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		// getsockopt fills a sockaddr_in (IPv4) or sockaddr_in6 (IPv6): read it through structs of at least this size.
+		// SO_ORIGINAL_DST and IP6T_SO_ORIGINAL_DST have the same value
+		if ipv4 {
+			mreq, err := unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, unix.SO_ORIGINAL_DST)
+			if err != nil {
+				sockErr = err
+				return
+			}
+			addr = &net.TCPAddr{
+				IP:   net.IPv4(mreq.Multiaddr[4], mreq.Multiaddr[5], mreq.Multiaddr[6], mreq.Multiaddr[7]),
+				Port: int(binary.BigEndian.Uint16(mreq.Multiaddr[2:4])),
+			}
+			return
+		}
+		info, err := unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, unix.SO_ORIGINAL_DST)
+		if err != nil {
+			sockErr = err
+			return
+		}
+		// The port is in network byte order
+		var port [2]byte
+		binary.NativeEndian.PutUint16(port[:], info.Addr.Port)
+		addr = &net.TCPAddr{IP: net.IP(info.Addr.Addr[:]), Port: int(binary.BigEndian.Uint16(port[:]))}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, fmt.Errorf("failed to get the original destination: %w", sockErr)
+	}
+	return addr, nil
+}
+
+// setTransparent lets a listening socket accept connections of iptables TPROXY rules, addressed to other hosts
+func setTransparent(network, _ string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if network == "tcp6" {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+			return
+		}
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("failed to enable TPROXY (requires CAP_NET_ADMIN): %w", sockErr)
+	}
+	return nil
+}
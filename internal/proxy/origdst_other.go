@@ -0,0 +1,19 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// redirectedDestination returns the destination of a connection before an iptables REDIRECT rule sent it to the proxy
+func redirectedDestination(*net.TCPConn) (*net.TCPAddr, error) {
+	return nil, fmt.Errorf("original destinations of redirected connections are only available on Linux")
+}
+
+// setTransparent lets a listening socket accept connections of iptables TPROXY rules, addressed to other hosts
+func setTransparent(string, string, syscall.RawConn) error {
+	return fmt.Errorf("TPROXY is only supported on Linux")
+}
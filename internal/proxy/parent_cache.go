@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// parentCacheTransport sends upstream requests to another caching-dev-proxy, which fetches and caches them for all its clients.
+// HTTPS requests are sent as plain proxy requests with an absolute https URL rather than through a CONNECT tunnel,
+// so the parent caches them without intercepting TLS, and its CA does not need to be trusted
+type parentCacheTransport struct {
+	parent    *url.URL
+	transport *http.Transport
+}
+
+// newParentCacheTransport creates the transport of requests to a parent cache. Its connections are opened like the ones of base,
+// but directly: the parent proxies of upstream hosts do not apply to it
+func newParentCacheTransport(parentURL string, base *http.Transport) (*parentCacheTransport, error) {
+	parent, err := url.Parse(parentURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parent cache URL: %w", err)
+	}
+	transport := base.Clone()
+	transport.Proxy = nil
+	return &parentCacheTransport{parent: parent, transport: transport}, nil
+}
+
+func (t *parentCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	// The opaque URL is sent as is in the request line, as the absolute URL of proxy requests
+	out.URL = &url.URL{Scheme: t.parent.Scheme, Host: t.parent.Host, Opaque: req.URL.String()}
+	if out.Host == "" {
+		out.Host = req.URL.Host
+	}
+	resp, err := t.transport.RoundTrip(out)
+	if err != nil {
+		return nil, fmt.Errorf("parent cache %s: %w", t.parent.Redacted(), err)
+	}
+	resp.Request = req
+	return resp, nil
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestParentCache(t *testing.T) {
+	var upstreamHits atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits.Add(1)
+		_, _ = io.WriteString(w, "hello "+r.URL.Path)
+	})
+	upstream := httptest.NewServer(handler)
+	defer upstream.Close()
+	upstreamTLS := httptest.NewTLSServer(handler)
+	defer upstreamTLS.Close()
+
+	proxyClient := func(parentCache string) *http.Client {
+		s, err := New(&config.Config{
+			Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir()},
+			Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+			Upstream: config.UpstreamConfig{
+				Hosts:       []config.UpstreamHost{{Match: []string{"127.0.0.1"}, InsecureSkipVerify: true}},
+				ParentCache: parentCache,
+			},
+		})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		proxyServer := httptest.NewServer(s.GetProxy())
+		t.Cleanup(proxyServer.Close)
+		proxyURL, _ := url.Parse(proxyServer.URL)
+		return &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	}
+	get := func(client *http.Client, rawURL string, bypass bool) (string, string) {
+		req, _ := http.NewRequest(http.MethodGet, rawURL, nil)
+		if bypass {
+			req.Header.Set("X-Cache-Bypass", "1")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", rawURL, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.Header.Get("X-Cache"), string(body)
+	}
+
+	parentServer, err := New(&config.Config{
+		Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+		Upstream: config.UpstreamConfig{
+			Hosts: []config.UpstreamHost{{Match: []string{"127.0.0.1"}, InsecureSkipVerify: true}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	parent := httptest.NewServer(parentServer.GetProxy())
+	defer parent.Close()
+
+	client := proxyClient(parent.URL)
+	if cacheStatus, body := get(client, upstream.URL+"/shared", false); cacheStatus != "MISS" || body != "hello /shared" {
+		t.Errorf("X-Cache = %s, body = %q, want the response of upstream", cacheStatus, body)
+	}
+	if cacheStatus, _ := get(client, upstream.URL+"/shared", false); cacheStatus != "HIT" {
+		t.Errorf("X-Cache = %s, want the response stored locally", cacheStatus)
+	}
+	if cacheStatus, _ := get(proxyClient(parent.URL), upstream.URL+"/shared", false); cacheStatus != "MISS" {
+		t.Errorf("X-Cache = %s, want MISS from another client of the parent", cacheStatus)
+	}
+	if upstreamHits.Load() != 1 {
+		t.Errorf("upstream was hit %d times, want 1, the parent caching the response", upstreamHits.Load())
+	}
+
+	if _, body := get(client, upstream.URL+"/shared", true); body != "hello /shared" || upstreamHits.Load() != 2 {
+		t.Errorf("body = %q, upstream hits = %d, want the parent to bypass its cache too", body, upstreamHits.Load())
+	}
+
+	// HTTPS requests reach the parent as proxy requests of https URLs, without a tunnel
+	transport, err := newParentCacheTransport(parent.URL, &http.Transport{})
+	if err != nil {
+		t.Fatalf("newParentCacheTransport() error = %v", err)
+	}
+	for range 2 {
+		req, _ := http.NewRequest(http.MethodGet, upstreamTLS.URL+"/secure", nil)
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip() error = %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != "hello /secure" || resp.Request != req {
+			t.Errorf("body = %q, want the response of the HTTPS upstream", body)
+		}
+	}
+	if upstreamHits.Load() != 3 {
+		t.Errorf("upstream was hit %d times, want 3, the parent caching the HTTPS response", upstreamHits.Load())
+	}
+}
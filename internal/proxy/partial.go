@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/elazarl/goproxy"
+	"github.com/sirupsen/logrus"
+)
+
+// partialSuffix is appended to the cache key of a response to store the data of its interrupted download
+const partialSuffix = ".partial"
+
+// partialBodyError is returned when reading a response body failed partway
+type partialBodyError struct {
+	body []byte
+	err  error
+}
+
+func (e *partialBodyError) Error() string {
+	return fmt.Sprintf("response body interrupted after %d bytes: %v", len(e.body), e.err)
+}
+
+func (e *partialBodyError) Unwrap() error {
+	return e.err
+}
+
+// resumable reports whether an interrupted download of the response can be completed later with a ranged request
+func resumable(req *http.Request, resp *http.Response) bool {
+	etag := resp.Header.Get("ETag")
+	return req.Method == http.MethodGet && resp.StatusCode == http.StatusOK &&
+		etag != "" && !strings.HasPrefix(etag, "W/") && resp.Header.Get("Accept-Ranges") != "none"
+}
+
+// savePartial keeps the data received before a download was interrupted, to complete it on the next attempt.
+// It is stored without its request, so it is not listed, refreshed or served as a cache entry
+func (s *Server) savePartial(key string, req *http.Request, resp *http.Response, body []byte) {
+	if len(body) == 0 || !resumable(req, resp) {
+		return
+	}
+	partial := *resp
+	partial.Header = resp.Header.Clone()
+	partial.Request = nil
+	s.redactHeaders(partial.Header)
+	partial.Body = io.NopCloser(bytes.NewReader(body))
+	partial.ContentLength = int64(len(body))
+	if err := s.cacheManager.SetKey(key+partialSuffix, &partial); err != nil {
+		logrus.Errorf("Failed to keep partial download of %s: %v", req.URL.String(), err)
+		return
+	}
+	logrus.Infof("Kept %d bytes of the interrupted download of %s, to resume it on the next request", len(body), req.URL.String())
+}
+
+// prepareResume turns the upstream request into a ranged request completing an interrupted download, if one was kept
+func (s *Server) prepareResume(req *http.Request, userData *ctxUserData) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return
+	}
+	partial, err := s.cacheManager.GetKey(userData.key + partialSuffix)
+	if err != nil || partial == nil {
+		return
+	}
+	body, err := io.ReadAll(partial.Body)
+	_ = partial.Body.Close()
+	if err != nil || len(body) == 0 {
+		return
+	}
+	partial.Body = nil
+	userData.partial = partial
+	userData.partialBody = body
+
+	logrus.Debugf("OnRequest(url=%s): Resuming interrupted download from byte %d", req.URL.String(), len(body))
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(body)))
+	req.Header.Set("If-Range", partial.Header.Get("ETag"))
+}
+
+// resumedResponse completes the kept partial data with the ranged upstream response.
+// If upstream sent the full response (e.g. it changed), it is used as-is. If it sent an unexpected range, the full response is requested again
+func (s *Server) resumedResponse(req *http.Request, resp *http.Response, userData *ctxUserData) *http.Response {
+	req.Header.Del("Range")
+	req.Header.Del("If-Range")
+	partial, partialBody := userData.partial, userData.partialBody
+	userData.partial, userData.partialBody = nil, nil
+	if err := s.cacheManager.DeleteKey(userData.key + partialSuffix); err != nil {
+		logrus.Errorf("Failed to remove partial download of %s: %v", req.URL.String(), err)
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return resp
+	}
+	start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+	if !ok || start != int64(len(partialBody)) || resp.Header.Get("ETag") != partial.Header.Get("ETag") {
+		logrus.Warnf("OnResponse(url=%s): Upstream answered an unexpected range (%s), downloading again from the start", req.URL.String(), resp.Header.Get("Content-Range"))
+		_ = resp.Body.Close()
+		retry := req.Clone(req.Context())
+		retry.RequestURI = ""
+		full, err := s.upstream.RoundTrip(retry)
+		if err != nil {
+			logrus.Errorf("OnResponse(url=%s): Failed to download again: %v", req.URL.String(), err)
+			return goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusBadGateway, "failed to download again from upstream")
+		}
+		return full
+	}
+
+	logrus.Infof("Resumed the interrupted download of %s from byte %d", req.URL.String(), start)
+	combined := *partial
+	combined.Request = req
+	combined.Header = partial.Header.Clone()
+	combined.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(partialBody), resp.Body), resp.Body}
+	combined.ContentLength = -1
+	combined.Header.Del("Content-Length")
+	if total >= 0 {
+		combined.ContentLength = total
+		combined.Header.Set("Content-Length", strconv.FormatInt(total, 10))
+	}
+	return &combined
+}
+
+// parseContentRange parses a "bytes start-end/total" Content-Range header. total is -1 if unknown
+func parseContentRange(value string) (start int64, total int64, ok bool) {
+	value, found := strings.CutPrefix(value, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	byteRange, totalStr, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, false
+	}
+	startStr, _, found := strings.Cut(byteRange, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	total = -1
+	if totalStr != "*" {
+		if total, err = strconv.ParseInt(totalStr, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, total, true
+}
@@ -0,0 +1,25 @@
+package proxy
+
+import "testing"
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		value string
+		start int64
+		total int64
+		ok    bool
+	}{
+		{"bytes 100-199/200", 100, 200, true},
+		{"bytes 0-99/*", 0, -1, true},
+		{"bytes */200", 0, 0, false},
+		{"items 0-1/2", 0, 0, false},
+		{"bytes 10-20", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, total, ok := parseContentRange(tt.value)
+		if ok != tt.ok || (ok && (start != tt.start || total != tt.total)) {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v, want %d, %d, %v", tt.value, start, total, ok, tt.start, tt.total, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/admin"
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+
+	"github.com/sirupsen/logrus"
+)
+
+// peerClient asks other proxies for the entries missing from the cache, through their peer endpoint,
+// so instances on a LAN share a warm cache. Peers only answer from their own cache, so they never ask each other in loops
+type peerClient struct {
+	urls    []string
+	timeout time.Duration
+	token   string // Sent as a bearer token if set
+	client  *http.Client
+}
+
+func newPeerClient(urls []string, timeout time.Duration, token string) *peerClient {
+	return &peerClient{
+		urls:    urls,
+		timeout: timeout,
+		token:   token,
+		client:  &http.Client{},
+	}
+}
+
+// Fetch returns the serialized entry of a key from the first peer answering with it, or nil if none does in time
+func (p *peerClient) Fetch(ctx context.Context, key string) []byte {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	found := make(chan []byte, len(p.urls))
+	for _, peer := range p.urls {
+		go func(peer string) {
+			data, err := p.fetchFrom(ctx, peer, key)
+			if err != nil {
+				logrus.Debugf("Peer %s: %v", peer, err)
+			}
+			found <- data
+		}(peer)
+	}
+	for range p.urls {
+		if data := <-found; data != nil {
+			return data
+		}
+	}
+	return nil
+}
+
+// fetchFrom returns the serialized entry of a key from a peer, nil if it does not have it
+func (p *peerClient) fetchFrom(ctx context.Context, peer string, key string) ([]byte, error) {
+	entryURL := strings.TrimSuffix(peer, "/") + "/api/cache/entry?key=" + url.QueryEscape(key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, entryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("answered %s", resp.Status)
+	}
+}
+
+// StartPeers serves the entries of the cache to peer proxies, read-only, in the background. Returns an error if it cannot listen on address
+func (s *Server) StartPeers(address string) error {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	handler := admin.NewPeerHandler(s.cacheManager, s.config.Peers.Token)
+	go func() {
+		if err := http.Serve(ln, handler); err != nil {
+			logrus.Errorf("Peer endpoint failed, entries are not shared with peers: %v", err)
+		}
+	}()
+	return nil
+}
+
+// peerResponse returns the response of a peer to a request missing from the cache, after storing it.
+// nil if peers are not configured, or none has a fresh entry
+func (s *Server) peerResponse(requ *http.Request, key string) *http.Response {
+	if s.peers == nil {
+		return nil
+	}
+	data := s.peers.Fetch(requ.Context(), key)
+	if data == nil {
+		return nil
+	}
+	resp, err := httpcache.Deserialize(data)
+	if err != nil {
+		logrus.Warnf("Invalid entry from peer for %s: %v", requ.URL.String(), err)
+		return nil
+	}
+
+	// The entry keeps the age it had on the peer
+	now := time.Now()
+	storedAt, _ := time.Parse(time.RFC3339Nano, resp.Header.Get(storedHeader))
+	if s.cacheTTL != 0 && !storedAt.IsZero() && now.Sub(storedAt) > s.cacheTTL {
+		return nil
+	}
+	if s.fromStore(resp, now) {
+		return nil
+	}
+	if err := s.cacheManager.SetRaw(key, data, storedAt); err != nil {
+		logrus.Warnf("Failed to store entry from peer for %s: %v", requ.URL.String(), err)
+	}
+	return resp
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/admin"
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestPeerCache(t *testing.T) {
+	var upstreamHits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits.Add(1)
+		_, _ = io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	proxyClient := func(token string, peers ...string) (*Server, *http.Client) {
+		s, err := New(&config.Config{
+			Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir()},
+			Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+			Peers: config.PeersConfig{URLs: peers, Timeout: "1s", Token: token},
+		})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		proxyServer := httptest.NewServer(s.GetProxy())
+		t.Cleanup(proxyServer.Close)
+		proxyURL, _ := url.Parse(proxyServer.URL)
+		return s, &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	}
+	get := func(client *http.Client, path string) (string, string) {
+		resp, err := client.Get(upstream.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.Header.Get("X-Cache"), string(body)
+	}
+
+	peer, peerClient := proxyClient("")
+	peerEndpoint := httptest.NewServer(admin.NewPeerHandler(peer.cacheManager, "secret"))
+	defer peerEndpoint.Close()
+	if cacheStatus, _ := get(peerClient, "/shared"); cacheStatus != "MISS" {
+		t.Fatalf("peer: X-Cache = %s, want MISS", cacheStatus)
+	}
+
+	_, wrongTokenClient := proxyClient("other", peerEndpoint.URL)
+	if cacheStatus, _ := get(wrongTokenClient, "/shared"); cacheStatus != "MISS" {
+		t.Errorf("X-Cache = %s, want MISS with a wrong peer token", cacheStatus)
+	}
+
+	_, client := proxyClient("secret", peerEndpoint.URL)
+	if cacheStatus, body := get(client, "/shared"); cacheStatus != "PEER" || body != "hello /shared" {
+		t.Errorf("X-Cache = %s, body = %q, want the entry of the peer", cacheStatus, body)
+	}
+	if cacheStatus, _ := get(client, "/shared"); cacheStatus != "HIT" {
+		t.Errorf("X-Cache = %s, want the entry of the peer stored locally", cacheStatus)
+	}
+	if upstreamHits.Load() != 2 {
+		t.Errorf("upstream was hit %d times, want 2", upstreamHits.Load())
+	}
+
+	if cacheStatus, _ := get(client, "/other"); cacheStatus != "MISS" {
+		t.Errorf("X-Cache = %s, want MISS for an entry no peer has", cacheStatus)
+	}
+}
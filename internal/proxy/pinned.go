@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+	"github.com/iTrooz/caching-dev-proxy/internal/cron"
+
+	"github.com/sirupsen/logrus"
+)
+
+// refreshPinned fetches a pinned URL into the cache now if it is not cached yet, then at each occurrence of its schedule
+func (s *Server) refreshPinned(pinned config.PinnedURL, schedule *cron.Schedule) {
+	cached, err := s.isPinnedCached(pinned)
+	if err != nil {
+		logrus.Warnf("Failed to look up pinned URL %s in cache: %v", pinned.URL, err)
+	}
+	if !cached {
+		s.fetchPinned(pinned)
+	}
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			logrus.Warnf("Schedule of pinned URL %s never fires, it will not be refreshed", pinned.URL)
+			return
+		}
+		time.Sleep(time.Until(next))
+		s.fetchPinned(pinned)
+	}
+}
+
+// fetchPinned fetches a pinned URL into the cache, logging failures
+func (s *Server) fetchPinned(pinned config.PinnedURL) {
+	req, key, err := s.pinnedRequest(pinned)
+	if err == nil {
+		_, err = s.fetchIntoCache(req, nil, key)
+	}
+	if err != nil {
+		logrus.Warnf("Failed to refresh pinned URL %s: %v", pinned.URL, err)
+		return
+	}
+	logrus.Debugf("Refreshed pinned URL %s", pinned.URL)
+}
+
+// isPinnedCached reports whether a pinned URL has a fresh cache entry
+func (s *Server) isPinnedCached(pinned config.PinnedURL) (bool, error) {
+	_, key, err := s.pinnedRequest(pinned)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.cacheManager.GetKey(key)
+	if err != nil || resp == nil {
+		return false, err
+	}
+	_ = resp.Body.Close()
+	return true, nil
+}
+
+// pinnedRequest returns the request of a pinned URL, and its cache key
+func (s *Server) pinnedRequest(pinned config.PinnedURL) (*http.Request, string, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, pinned.URL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid pinned URL: %w", err)
+	}
+	for name, value := range pinned.Headers {
+		req.Header.Set(name, value)
+	}
+	s.injectRequestHeaders(req)
+	key, err := s.cacheManager.GenerateKey(req, s.keyOptions(req, &ctxUserData{}))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate cache key: %w", err)
+	}
+	return req, s.scriptKey(req, key), nil
+}
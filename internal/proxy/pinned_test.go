@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestFetchPinned(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	pinned := config.PinnedURL{URL: upstream.URL + "/status", Schedule: "*/5 * * * *", Headers: map[string]string{"Accept": "application/json"}}
+	s, err := New(&config.Config{
+		Cache:  config.CacheConfig{TTL: "1h", Folder: t.TempDir()},
+		Rules:  config.RulesConfig{Mode: config.RulesModeBlacklist},
+		Pinned: config.PinnedConfig{URLs: []config.PinnedURL{pinned}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if cached, err := s.isPinnedCached(pinned); err != nil || cached {
+		t.Fatalf("isPinnedCached() = %v, %v before fetching, want false", cached, err)
+	}
+	s.fetchPinned(pinned)
+	s.fetchPinned(pinned)
+	if got := hits.Load(); got != 2 {
+		t.Errorf("upstream hits = %d, want 2: pinned URLs are fetched even when cached", got)
+	}
+	if cached, err := s.isPinnedCached(pinned); err != nil || !cached {
+		t.Fatalf("isPinnedCached() = %v, %v after fetching, want true", cached, err)
+	}
+
+	// Stored under the key of client requests with the same headers
+	req, _ := http.NewRequest(http.MethodGet, pinned.URL, nil)
+	req.Header.Set("Accept", "application/json")
+	key, _ := s.cacheManager.GenerateKey(req, s.keyOptions(req, &ctxUserData{}))
+	resp, err := s.cacheManager.GetKey(key)
+	if err != nil || resp == nil {
+		t.Fatalf("GetKey() = %v, %v, want the pinned entry", resp, err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("cached body = %q, want %q", body, "ok")
+	}
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/elazarl/goproxy"
+	"github.com/sirupsen/logrus"
+)
+
+// placeholder is the parsed placeholder configuration of a rule
+type placeholder struct {
+	after      time.Duration
+	retryAfter time.Duration
+	stale      bool
+}
+
+// pendingFetch is an upstream fetch that may outlive the client request that started it
+type pendingFetch struct {
+	done chan struct{}
+
+	mu sync.Mutex
+	// set when no client waits for the result anymore: the fetch stores it in cache itself
+	abandoned bool
+	resp      *http.Response
+	body      []byte
+	err       error
+}
+
+// placeholderFor returns the placeholder configuration of the first matching rule defining one, or nil
+func (s *Server) placeholderFor(requ *http.Request) *placeholder {
+	for _, rule := range s.matchingConfigRules(requ) {
+		if rule.Placeholder.After == "" {
+			continue
+		}
+		return &placeholder{
+			after:      rule.placeholderAfter,
+			retryAfter: rule.placeholderRetryAfter,
+			stale:      rule.Placeholder.Stale,
+		}
+	}
+	return nil
+}
+
+// fetchWithPlaceholder fetches the request from upstream, but answers with a placeholder if it takes too long.
+// The fetch then continues in the background and stores the response in cache when done
+func (s *Server) fetchWithPlaceholder(requ *http.Request, ctx *goproxy.ProxyCtx, userData *ctxUserData, p *placeholder) *http.Response {
+	fetch, err := s.startFetch(requ, ctx, userData.key, userData.ttl)
+	if err != nil {
+		logrus.Errorf("OnRequest(url=%s): Failed to start background fetch: %v", requ.URL.String(), err)
+		return nil
+	}
+
+	timer := time.NewTimer(p.after)
+	defer timer.Stop()
+	select {
+	case <-fetch.done:
+	case <-timer.C:
+	}
+
+	fetch.mu.Lock()
+	defer fetch.mu.Unlock()
+	select {
+	case <-fetch.done:
+		// Upstream answered in time, serve it as a regular response
+		if fetch.err != nil {
+			ctx.Error = fetch.err
+			resp := goproxy.NewResponse(requ, goproxy.ContentTypeText, http.StatusBadGateway, fmt.Sprintf("upstream error: %v", fetch.err))
+			resp.Header.Set("X-Cache", "ERROR")
+			userData.status = "ERROR"
+			return resp
+		}
+		resp := *fetch.resp
+		resp.Header = fetch.resp.Header.Clone()
+		resp.Body = io.NopCloser(bytes.NewReader(fetch.body))
+		resp.Request = requ
+		return &resp
+	default:
+	}
+
+	fetch.abandoned = true
+	logrus.Debugf("OnRequest(url=%s): Upstream is slow, answering with placeholder", requ.URL.String())
+
+	if p.stale {
+		if staleResp := s.staleResponse(requ, userData); staleResp != nil {
+			return staleResp
+		}
+	}
+
+	resp := goproxy.NewResponse(requ, goproxy.ContentTypeText, http.StatusAccepted, "Upstream is slow, the response is being fetched in the background. Retry later.\n")
+	if p.retryAfter != 0 {
+		resp.Header.Set("Retry-After", strconv.Itoa(int(p.retryAfter.Seconds())))
+	}
+	resp.Header.Set("X-Cache", "PLACEHOLDER")
+	userData.status = "PLACEHOLDER"
+	return resp
+}
+
+// startFetch starts fetching the request from upstream in the background, or joins an already running fetch for the same key.
+// clientTTL is the TTL asked by the client for the entry, 0 for the effective one
+func (s *Server) startFetch(requ *http.Request, ctx *goproxy.ProxyCtx, key string, clientTTL time.Duration) (*pendingFetch, error) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if fetch, ok := s.pending[key]; ok {
+		return fetch, nil
+	}
+
+	var body []byte
+	if requ.Body != nil {
+		var err error
+		body, err = io.ReadAll(requ.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		requ.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	// The fetch must survive the client going away
+	upstreamReq := requ.Clone(context.WithoutCancel(requ.Context()))
+	upstreamReq.Body = io.NopCloser(bytes.NewReader(body))
+	goproxy.RemoveProxyHeaders(ctx, upstreamReq)
+
+	fetch := &pendingFetch{done: make(chan struct{})}
+	s.pending[key] = fetch
+
+	go func() {
+		resp, err := ctx.RoundTrip(upstreamReq)
+		var respBody []byte
+		if err == nil {
+			respBody, err = io.ReadAll(resp.Body)
+			if closeErr := resp.Body.Close(); err == nil && closeErr != nil {
+				err = closeErr
+			}
+		}
+
+		s.pendingMu.Lock()
+		delete(s.pending, key)
+		s.pendingMu.Unlock()
+
+		fetch.mu.Lock()
+		defer fetch.mu.Unlock()
+		fetch.resp, fetch.body, fetch.err = resp, respBody, err
+		close(fetch.done)
+
+		if err != nil {
+			logrus.Errorf("Background fetch of %s failed: %v", requ.URL.String(), err)
+			return
+		}
+		s.rateLimiter.Observe(upstreamReq, resp, time.Now())
+		if !fetch.abandoned {
+			// The waiting client request handles caching
+			return
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+		resp.Request = withBody(upstreamReq, body)
+		cacheable, ttl := s.cacheDecision(upstreamReq, resp)
+		if !cacheable {
+			return
+		}
+		ttl = overrideTTL(ttl, clientTTL)
+		stored, err := s.toStore(resp, ttl)
+		if err == nil {
+			err = s.cacheManager.SetKey(key, stored)
+		}
+		if err != nil {
+			logrus.Errorf("Failed to cache background fetch of %s: %v", requ.URL.String(), err)
+			return
+		}
+		logrus.Infof("Background fetch of %s done, stored in cache", requ.URL.String())
+	}()
+
+	return fetch, nil
+}
+
+// staleResponse returns the expired entry of the request kept for stale serving (see cache.stale_ttl), or nil if there is none
+func (s *Server) staleResponse(requ *http.Request, userData *ctxUserData) *http.Response {
+	staleResp, err := s.cacheManager.GetStaleKey(userData.key)
+	if err != nil {
+		logrus.Errorf("OnRequest(url=%s): Failed to get stale response: %v", requ.URL.String(), err)
+		return nil
+	}
+	if staleResp == nil {
+		return nil
+	}
+	s.fromStore(staleResp, time.Now())
+	staleResp.Request = requ
+	staleResp = rangeResponse(requ, staleResp)
+	staleResp.Header.Set("X-Cache", "STALE")
+	userData.status = "STALE"
+	return staleResp
+}
@@ -0,0 +1,317 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
+)
+
+// Maximum pages waiting for their links to be prefetched. Further pages are not prefetched
+const prefetchQueueSize = 100
+
+// Request headers of pages not copied to the requests of their links, as they describe the page request only
+var prefetchDroppedHeaders = []string{"Accept", "Range", "If-Range", "If-None-Match", "If-Modified-Since", "Content-Type", "Content-Length", "Content-Encoding"}
+
+// prefetcher fetches the same-host links of cached HTML and JSON responses into the cache, in the background (see prefetch)
+type prefetcher struct {
+	server   *Server
+	config   *config.PrefetchConfig
+	patterns []*config.URLGlob
+	queue    chan prefetchPage
+	wg       sync.WaitGroup
+	// canceled on close, interrupting the prefetches
+	ctx    context.Context
+	cancel context.CancelFunc
+	// held for writing while closing, so no page is queued once the queue is closed
+	queueMu sync.RWMutex
+	closed  bool
+
+	mu sync.Mutex
+	// links being prefetched, by URL
+	pending map[string]bool
+}
+
+// prefetchPage is a cached response whose links are to be prefetched
+type prefetchPage struct {
+	req      *http.Request
+	resp     *http.Response
+	body     []byte
+	identity string // client identity, for the cache namespace
+	depth    int    // levels of links left to follow
+}
+
+func newPrefetcher(s *Server, cfg *config.PrefetchConfig) (*prefetcher, error) {
+	p := &prefetcher{
+		server:  s,
+		config:  cfg,
+		queue:   make(chan prefetchPage, prefetchQueueSize),
+		pending: make(map[string]bool),
+	}
+	for _, pattern := range cfg.Patterns {
+		glob, err := config.ParseURLGlob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefetch pattern: %w", err)
+		}
+		p.patterns = append(p.patterns, glob)
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	for range cfg.Workers {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for page := range p.queue {
+				p.prefetchLinks(page)
+			}
+		}()
+	}
+	return p, nil
+}
+
+// close stops the prefetches, and waits for the workers to return. Pages visited afterwards are not prefetched
+func (p *prefetcher) close() {
+	if p == nil {
+		return
+	}
+	p.queueMu.Lock()
+	if p.closed {
+		p.queueMu.Unlock()
+		return
+	}
+	p.closed = true
+	p.cancel()
+	close(p.queue)
+	p.queueMu.Unlock()
+	p.wg.Wait()
+}
+
+// visit queues the links of a response that was just cached to be prefetched. The body of resp is kept readable
+func (p *prefetcher) visit(req *http.Request, resp *http.Response, userData *ctxUserData) {
+	if p == nil || linkFormat(resp) == "" {
+		return
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	page := *resp
+	page.Header = resp.Header.Clone()
+	page.Body = nil
+	pageReq := req.Clone(p.ctx)
+	if userData.requestHeader != nil {
+		pageReq.Header = userData.requestHeader
+	}
+	p.queueMu.RLock()
+	defer p.queueMu.RUnlock()
+	if p.closed {
+		return
+	}
+	select {
+	case p.queue <- prefetchPage{req: pageReq, resp: &page, body: body, identity: userData.clientIdentity, depth: p.config.Depth}:
+	default:
+		logrus.Debugf("Prefetch queue is full, not prefetching the links of %s", req.URL.String())
+	}
+}
+
+// prefetchLinks fetches the links of the page which are not cached yet, and the links of these while depth is left
+func (p *prefetcher) prefetchLinks(page prefetchPage) {
+	resp := page.resp
+	resp.Body = io.NopCloser(bytes.NewReader(page.body))
+	body, err := rewritableBody(resp)
+	if err != nil || body == nil {
+		return
+	}
+	links := p.filter(page.req.URL, extractLinks(linkFormat(resp), page.req.URL, body))
+	for _, link := range links {
+		if p.ctx.Err() != nil {
+			return
+		}
+		if !p.start(link) {
+			continue
+		}
+		req, key, cached := p.request(page, link)
+		if cached {
+			p.done(link)
+			continue
+		}
+		logrus.Debugf("Prefetching %s, linked from %s", link.String(), page.req.URL.String())
+		linkResp, err := p.server.fetchIntoCache(req, nil, key)
+		p.done(link)
+		if err != nil {
+			logrus.Debugf("Failed to prefetch %s: %v", link.String(), err)
+			continue
+		}
+		if page.depth <= 1 || linkFormat(linkResp) == "" {
+			_ = linkResp.Body.Close()
+			continue
+		}
+		linkBody, err := io.ReadAll(linkResp.Body)
+		_ = linkResp.Body.Close()
+		if err == nil {
+			p.prefetchLinks(prefetchPage{req: req, resp: linkResp, body: linkBody, identity: page.identity, depth: page.depth - 1})
+		}
+	}
+}
+
+// request returns the request of a link of the page, with its cache key, and whether it is already cached
+func (p *prefetcher) request(page prefetchPage, link *url.URL) (*http.Request, string, bool) {
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        link,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     page.req.Header.Clone(),
+		Host:       link.Host,
+	}
+	req = req.WithContext(page.req.Context())
+	for _, name := range prefetchDroppedHeaders {
+		req.Header.Del(name)
+	}
+
+	s := p.server
+	key, err := s.cacheManager.GenerateKey(req, s.keyOptions(req, &ctxUserData{clientIdentity: page.identity}))
+	if err != nil {
+		return req, "", true
+	}
+	key = s.scriptKey(req, key)
+	cached, err := s.cacheManager.GetKey(key)
+	if err != nil || cached != nil {
+		if cached != nil {
+			_ = cached.Body.Close()
+		}
+		return req, key, true
+	}
+	return req, key, false
+}
+
+// filter keeps the links to the host of the page matching prefetch.patterns, up to prefetch.max_links
+func (p *prefetcher) filter(base *url.URL, links []*url.URL) []*url.URL {
+	kept := []*url.URL{}
+	for _, link := range links {
+		if len(kept) >= p.config.MaxLinks {
+			break
+		}
+		if link.Host != base.Host || link.String() == base.String() {
+			continue
+		}
+		if len(p.patterns) > 0 && !slices.ContainsFunc(p.patterns, func(g *config.URLGlob) bool { return g.Match(link) }) {
+			continue
+		}
+		kept = append(kept, link)
+	}
+	return kept
+}
+
+// start marks the link as being prefetched. Returns false if it already is
+func (p *prefetcher) start(link *url.URL) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending[link.String()] {
+		return false
+	}
+	p.pending[link.String()] = true
+	return true
+}
+
+func (p *prefetcher) done(link *url.URL) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, link.String())
+}
+
+// linkFormat returns "html" or "json" for responses links are extracted from, or ""
+func linkFormat(resp *http.Response) string {
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return "html"
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return "json"
+	}
+	return ""
+}
+
+// extractLinks returns the URLs referenced by an HTML or JSON body, resolved against base, without duplicates.
+// In HTML, these are the href and src attributes. In JSON, string values that are absolute http(s) URLs or absolute paths
+func extractLinks(format string, base *url.URL, body []byte) []*url.URL {
+	refs := []string{}
+	switch format {
+	case "html":
+		tokenizer := html.NewTokenizer(bytes.NewReader(body))
+		for {
+			tokenType := tokenizer.Next()
+			if tokenType == html.ErrorToken {
+				break
+			}
+			if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+				continue
+			}
+			_, hasAttr := tokenizer.TagName()
+			for hasAttr {
+				var name, value []byte
+				name, value, hasAttr = tokenizer.TagAttr()
+				if string(name) == "href" || string(name) == "src" {
+					refs = append(refs, string(value))
+				}
+			}
+		}
+	case "json":
+		var value any
+		if err := json.Unmarshal(body, &value); err != nil {
+			return nil
+		}
+		refs = jsonLinks(value, refs)
+	}
+
+	links := []*url.URL{}
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		u, err := base.Parse(strings.TrimSpace(ref))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		u.Fragment = ""
+		if !seen[u.String()] {
+			seen[u.String()] = true
+			links = append(links, u)
+		}
+	}
+	return links
+}
+
+// jsonLinks appends the string values of a decoded JSON value that look like links to refs
+func jsonLinks(value any, refs []string) []string {
+	switch v := value.(type) {
+	case string:
+		if strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") || (strings.HasPrefix(v, "/") && !strings.HasPrefix(v, "//")) {
+			refs = append(refs, v)
+		}
+	case []any:
+		for _, item := range v {
+			refs = jsonLinks(item, refs)
+		}
+	case map[string]any:
+		for _, item := range v {
+			refs = jsonLinks(item, refs)
+		}
+	}
+	return refs
+}
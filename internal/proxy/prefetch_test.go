@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestExtractLinks(t *testing.T) {
+	base, _ := url.Parse("http://example.com/docs/index.html")
+
+	tests := []struct {
+		name   string
+		format string
+		body   string
+		want   []string
+	}{
+		{
+			name:   "html",
+			format: "html",
+			body: `<html><head><link rel="stylesheet" href="style.css"><script src="/js/app.js"></script></head>
+<body><a href="#top">top</a><a href="page.html#part">page</a><img src="img.png"/><a href="mailto:me@example.com">mail</a>
+<a href="https://cdn.example.org/lib.js">cdn</a><a href="style.css">again</a></body></html>`,
+			want: []string{
+				"http://example.com/docs/style.css",
+				"http://example.com/js/app.js",
+				"http://example.com/docs/index.html",
+				"http://example.com/docs/page.html",
+				"http://example.com/docs/img.png",
+				"https://cdn.example.org/lib.js",
+			},
+		},
+		{
+			name:   "json",
+			format: "json",
+			body:   `{"self": "/api/items", "items": [{"image": "http://example.com/a.png"}, {"name": "not a link"}], "cdn": "//cdn.example.org/x", "count": 2}`,
+			want:   []string{"http://example.com/api/items", "http://example.com/a.png"},
+		},
+		{
+			name:   "invalid json",
+			format: "json",
+			body:   `{"self": "/api/items"`,
+			want:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, link := range extractLinks(tt.format, base, []byte(tt.body)) {
+				got = append(got, link.String())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("extractLinks() = %v, want %v", got, tt.want)
+			}
+			// JSON objects are walked in random order
+			for _, want := range tt.want {
+				found := false
+				for _, link := range got {
+					found = found || link == want
+				}
+				if !found {
+					t.Errorf("extractLinks() = %v, missing %s", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPrefetcherFilter(t *testing.T) {
+	base, _ := url.Parse("http://example.com/index.html")
+	links := []*url.URL{}
+	for _, link := range []string{
+		"http://example.com/index.html",
+		"http://example.com/style.css",
+		"http://other.com/style.css",
+		"http://example.com/app.js",
+		"http://example.com/img.png",
+	} {
+		u, _ := url.Parse(link)
+		links = append(links, u)
+	}
+
+	filter := func(cfg config.PrefetchConfig) []string {
+		p, err := newPrefetcher(&Server{}, &cfg)
+		if err != nil {
+			t.Fatalf("newPrefetcher() error = %v", err)
+		}
+		defer p.close()
+		got := []string{}
+		for _, link := range p.filter(base, links) {
+			got = append(got, link.Path)
+		}
+		return got
+	}
+
+	if got := filter(config.PrefetchConfig{MaxLinks: 10}); len(got) != 3 {
+		t.Errorf("filter() = %v, want the 3 other same-host links", got)
+	}
+	if got := filter(config.PrefetchConfig{MaxLinks: 2}); len(got) != 2 {
+		t.Errorf("filter() with max links 2 = %v", got)
+	}
+	if got := filter(config.PrefetchConfig{MaxLinks: 10, Patterns: []string{"http://example.com/**.css", "http://**/**.js"}}); len(got) != 2 || got[0] != "/style.css" || got[1] != "/app.js" {
+		t.Errorf("filter() with patterns = %v, want [/style.css /app.js]", got)
+	}
+}
+
+func TestPrefetcherVisitAfterClose(t *testing.T) {
+	p, err := newPrefetcher(&Server{}, &config.PrefetchConfig{Workers: 1, Depth: 1, MaxLinks: 10})
+	if err != nil {
+		t.Fatalf("newPrefetcher() error = %v", err)
+	}
+	p.close()
+
+	// A request still being handled at shutdown must not panic
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/index.html", nil)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/html"}},
+		Body:       io.NopCloser(strings.NewReader(`<a href="/page.html">page</a>`)),
+	}
+	p.visit(req, resp, &ctxUserData{})
+	if body, _ := io.ReadAll(resp.Body); string(body) != `<a href="/page.html">page</a>` {
+		t.Errorf("body after visit() = %q", body)
+	}
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fullBodyDigestHeaders hold digests of the body they are sent with, so are removed from partial responses
+var fullBodyDigestHeaders = []string{digestHeader, "Content-Digest", "Content-MD5", "Digest"}
+
+// rangeResponse answers a Range request out of a complete cached response: 206 with the requested byte range, or 416 if it
+// cannot be satisfied. The full response is returned if the range is not served from cache (multiple ranges, If-Range not matching)
+func rangeResponse(req *http.Request, resp *http.Response) *http.Response {
+	rangeHeader := req.Header.Get("Range")
+	if req.Method != http.MethodGet || rangeHeader == "" || resp.StatusCode != http.StatusOK || !ifRangeMatches(req, resp) {
+		return resp
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		logrus.Errorf("OnRequest(url=%s): Failed to read cached response to serve a range: %v", req.URL.String(), err)
+		return resp
+	}
+	size := int64(len(body))
+	start, end, ok := parseRange(rangeHeader, size)
+	if !ok {
+		return resp
+	}
+
+	ranged := *resp
+	ranged.Header = resp.Header.Clone()
+	// Digests of the full body do not match the partial one
+	for _, name := range fullBodyDigestHeaders {
+		ranged.Header.Del(name)
+	}
+	if start < 0 {
+		ranged.StatusCode = http.StatusRequestedRangeNotSatisfiable
+		ranged.Status = fmt.Sprintf("%d %s", ranged.StatusCode, http.StatusText(ranged.StatusCode))
+		ranged.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		ranged.Body = http.NoBody
+		ranged.ContentLength = 0
+		ranged.Header.Set("Content-Length", "0")
+		return &ranged
+	}
+	ranged.StatusCode = http.StatusPartialContent
+	ranged.Status = fmt.Sprintf("%d %s", ranged.StatusCode, http.StatusText(ranged.StatusCode))
+	ranged.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	ranged.Body = io.NopCloser(bytes.NewReader(body[start : end+1]))
+	ranged.ContentLength = end - start + 1
+	ranged.Header.Set("Content-Length", strconv.FormatInt(ranged.ContentLength, 10))
+	return &ranged
+}
+
+// ifRangeMatches reports whether the If-Range condition of the request, if any, holds for the response (see RFC 9110 13.1.5)
+func ifRangeMatches(req *http.Request, resp *http.Response) bool {
+	ifRange := req.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		// Weak validators never match
+		return !strings.HasPrefix(ifRange, "W/") && ifRange == resp.Header.Get("ETag")
+	}
+	return ifRange == resp.Header.Get("Last-Modified")
+}
+
+// parseRange parses a single range "bytes=start-end", "bytes=start-" or "bytes=-suffix" of a body of size bytes.
+// start is -1 if the range cannot be satisfied. ok is false if the header is invalid or holds several ranges, so it is ignored
+func parseRange(value string, size int64) (start int64, end int64, ok bool) {
+	spec, found := strings.CutPrefix(value, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	startStr, endStr, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	if startStr == "" {
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, 0, false
+		}
+		if suffix == 0 || size == 0 {
+			return -1, 0, true
+		}
+		return max(size-suffix, 0), size - 1, true
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	end = size - 1
+	if endStr != "" {
+		if end, err = strconv.ParseInt(endStr, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return -1, 0, true
+	}
+	return start, end, true
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end int64
+		ok         bool
+	}{
+		{"bytes=0-9", 0, 9, true},
+		{"bytes=5-", 5, 99, true},
+		{"bytes=-10", 90, 99, true},
+		{"bytes=-500", 0, 99, true},
+		{"bytes=50-500", 50, 99, true},
+		{"bytes=100-", -1, 0, true},
+		{"bytes=0-1,5-6", 0, 0, false},
+		{"bytes=9-5", 0, 0, false},
+		{"items=0-9", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, end, ok := parseRange(tt.value, 100)
+		if ok != tt.ok || (ok && (start != tt.start || (start >= 0 && end != tt.end))) {
+			t.Errorf("parseRange(%q) = %d, %d, %v, want %d, %d, %v", tt.value, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	content := "0123456789abcdefghij"
+	var upstreamHits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(content))
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	// Go clients only ask for gzip without Range, which would change the cache key
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableCompression: true}}
+
+	get := func(header http.Header) (int, string, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/file.txt", nil)
+		req.Header = header
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET error = %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("X-Cache"), string(body)
+	}
+
+	// Not cached yet: passed through, and the partial body is not stored
+	if status, cacheStatus, body := get(http.Header{"Range": {"bytes=0-3"}}); status != http.StatusPartialContent || cacheStatus == "MISS" || body != "0123" {
+		t.Errorf("uncached range: status = %d, X-Cache = %s, body = %q, want an uncached 206 with 0123", status, cacheStatus, body)
+	}
+	if status, cacheStatus, body := get(http.Header{}); status != http.StatusOK || cacheStatus != "MISS" || body != content {
+		t.Errorf("full request: status = %d, X-Cache = %s, body = %q, want the full content fetched from upstream", status, cacheStatus, body)
+	}
+
+	// Served out of the complete entry
+	if status, cacheStatus, body := get(http.Header{"Range": {"bytes=-5"}}); status != http.StatusPartialContent || cacheStatus != "HIT" || body != "fghij" {
+		t.Errorf("cached range: status = %d, X-Cache = %s, body = %q, want a cached 206 with fghij", status, cacheStatus, body)
+	}
+	if status, _, _ := get(http.Header{"Range": {"bytes=50-"}}); status != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("unsatisfiable range: status = %d, want %d", status, http.StatusRequestedRangeNotSatisfiable)
+	}
+	if status, _, body := get(http.Header{"Range": {"bytes=0-3"}, "If-Range": {`"v0"`}}); status != http.StatusOK || body != content {
+		t.Errorf("range with outdated If-Range: status = %d, body = %q, want the full content", status, body)
+	}
+	if upstreamHits.Load() != 2 {
+		t.Errorf("upstream was hit %d times, want 2", upstreamHits.Load())
+	}
+}
+
+func TestRangeResponseDigest(t *testing.T) {
+	body := "0123456789"
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	resp.Header.Set("ETag", `"v1"`)
+	resp.Header.Set("Content-MD5", "eB5eJF1ptWaXm4bijSPyxw==")
+	resp.Header.Set("Content-Digest", "sha-256=:hash:")
+	resp.Header.Set(digestHeader, bodyDigest([]byte(body)))
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/file.txt", nil)
+	req.Header.Set("Range", "bytes=0-3")
+
+	ranged := rangeResponse(req, resp)
+	if ranged.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", ranged.StatusCode, http.StatusPartialContent)
+	}
+	for _, name := range []string{digestHeader, "Content-MD5", "Content-Digest"} {
+		if value := ranged.Header.Get(name); value != "" {
+			t.Errorf("206 response has %s = %q, a digest of the full body", name, value)
+		}
+	}
+	if ranged.Header.Get("ETag") != `"v1"` {
+		t.Errorf("206 response ETag = %q, want the one of the full response", ranged.Header.Get("ETag"))
+	}
+	if resp.Header.Get(digestHeader) == "" {
+		t.Error("the digest of the full response was removed")
+	}
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elazarl/goproxy"
+	"github.com/sirupsen/logrus"
+)
+
+// rateLimiter remembers endpoints that answered 429 Too Many Requests, so further requests are not sent
+// upstream until their Retry-After passed. Protects shared API quotas from retry storms of local tools
+type rateLimiter struct {
+	defaultRetryAfter time.Duration
+	maxRetryAfter     time.Duration
+
+	mu    sync.Mutex
+	until map[string]time.Time // by endpoint
+}
+
+func newRateLimiter(defaultRetryAfter time.Duration, maxRetryAfter time.Duration) *rateLimiter {
+	return &rateLimiter{
+		defaultRetryAfter: defaultRetryAfter,
+		maxRetryAfter:     maxRetryAfter,
+		until:             make(map[string]time.Time),
+	}
+}
+
+// endpointOf returns the endpoint of a URL: the URL without its query string
+func endpointOf(u *url.URL) string {
+	return u.Scheme + "://" + u.Host + "/" + strings.TrimPrefix(u.Path, "/")
+}
+
+// parseRetryAfter parses a Retry-After header value, either delay seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// Observe records the rate limit window of a response freshly received from upstream, if it is a 429
+func (r *rateLimiter) Observe(requ *http.Request, resp *http.Response, now time.Time) {
+	if r == nil || resp.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+
+	retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if !ok {
+		retryAfter = r.defaultRetryAfter
+	}
+	if r.maxRetryAfter != 0 {
+		retryAfter = min(retryAfter, r.maxRetryAfter)
+	}
+	if retryAfter == 0 {
+		return
+	}
+
+	endpoint := endpointOf(requ.URL)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Forget passed windows
+	for e, until := range r.until {
+		if !now.Before(until) {
+			delete(r.until, e)
+		}
+	}
+	r.until[endpoint] = now.Add(retryAfter)
+	logrus.Warnf("Upstream rate limited %s, not sending requests to it for %v", endpoint, retryAfter)
+}
+
+// Limited returns the time until which requests to the endpoint of requ must not be sent upstream.
+// returns false if the endpoint is not rate limited
+func (r *rateLimiter) Limited(requ *http.Request, now time.Time) (time.Time, bool) {
+	if r == nil {
+		return time.Time{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	until, ok := r.until[endpointOf(requ.URL)]
+	if !ok || !now.Before(until) {
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+// rateLimitedResponse answers a request to a rate limited endpoint: the stale entry if any, or a 429
+func (s *Server) rateLimitedResponse(requ *http.Request, userData *ctxUserData, until time.Time) *http.Response {
+	staleResp, err := s.cacheManager.GetStaleKey(userData.key)
+	if err != nil {
+		logrus.Errorf("OnRequest(url=%s): Failed to get stale response: %v", requ.URL.String(), err)
+	} else if staleResp != nil {
+		s.fromStore(staleResp, time.Now())
+		staleResp.Request = requ
+		staleResp.Header.Set("X-Cache", "STALE")
+		userData.status = "STALE"
+		return staleResp
+	}
+
+	resp := goproxy.NewResponse(requ, goproxy.ContentTypeText, http.StatusTooManyRequests, "Upstream rate limited this endpoint, retry later.\n")
+	// Round up, so clients do not retry too early
+	resp.Header.Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+	resp.Header.Set("X-Cache", "RATE-LIMITED")
+	userData.status = "RATE-LIMITED"
+	return resp
+}
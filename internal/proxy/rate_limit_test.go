@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{"Wed, 15 Jan 2025 10:00:30 GMT", 30 * time.Second, true},
+		{"Wed, 15 Jan 2025 09:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"-5", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if ok != tt.wantOk || got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestRateLimiterWindow(t *testing.T) {
+	limiter := newRateLimiter(10*time.Second, time.Minute)
+	now := time.Now()
+	requ, _ := http.NewRequest("GET", "http://example.com/api?page=1", nil)
+
+	limiter.Observe(requ, &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"3600"}}}, now)
+	until, limited := limiter.Limited(requ, now)
+	if !limited || !until.Equal(now.Add(time.Minute)) {
+		t.Errorf("Limited() = %v, %v, want the window capped to max_retry_after", until, limited)
+	}
+	if _, limited := limiter.Limited(requ, now.Add(2*time.Minute)); limited {
+		t.Errorf("Limited() should be false once the window passed")
+	}
+
+	other, _ := http.NewRequest("GET", "http://example.com/other", nil)
+	limiter.Observe(other, &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}, now)
+	if until, _ := limiter.Limited(other, now); !until.Equal(now.Add(10 * time.Second)) {
+		t.Errorf("Limited() = %v, want the default window without Retry-After", until)
+	}
+}
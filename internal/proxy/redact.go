@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// redactedValue replaces the values of the headers of cache.redact.headers in stored entries
+const redactedValue = "[REDACTED]"
+
+// redactedRequestHeader lists the headers redacted from a stored request. Such a request is not sent upstream again,
+// as it lacks the credentials of the client
+const redactedRequestHeader = "X-Cache-Redacted"
+
+// redactEntry removes or redacts the headers of cache.redact.headers from a response about to be stored, and from its request.
+// The response headers must be a copy of the ones sent to the client, which keeps the original values. The request is copied
+func (s *Server) redactEntry(resp *http.Response) {
+	if len(s.config.Cache.Redact.Headers) == 0 {
+		return
+	}
+	s.redactHeaders(resp.Header)
+	if resp.Request != nil {
+		req := *resp.Request
+		req.Header = req.Header.Clone()
+		if redacted := s.redactHeaders(req.Header); len(redacted) > 0 {
+			req.Header.Set(redactedRequestHeader, strings.Join(redacted, ", "))
+		}
+		resp.Request = &req
+	}
+}
+
+// redactHeaders removes or redacts the headers of cache.redact.headers, in place. Returns the names of the headers found
+func (s *Server) redactHeaders(header http.Header) []string {
+	found := []string{}
+	for _, name := range s.config.Cache.Redact.Headers {
+		values := header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		found = append(found, http.CanonicalHeaderKey(name))
+		if s.config.Cache.Redact.Remove {
+			header.Del(name)
+			continue
+		}
+		redacted := make([]string, len(values))
+		for i := range redacted {
+			redacted[i] = redactedValue
+		}
+		header[http.CanonicalHeaderKey(name)] = redacted
+	}
+	return found
+}
+
+// replayable tells why a stored request cannot be sent upstream again, or returns "" if it can
+func replayable(req *http.Request) string {
+	if req == nil {
+		return "entry was stored without its request"
+	}
+	if redacted := req.Header.Get(redactedRequestHeader); redacted != "" {
+		return fmt.Sprintf("request was stored with redacted headers (%s)", redacted)
+	}
+	return ""
+}
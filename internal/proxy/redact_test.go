@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestRedactHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "session=secret")
+		w.Header().Add("Set-Cookie", "theme=dark")
+		w.Header().Set("X-Request-Id", "42")
+		_, _ = io.WriteString(w, "data")
+	}))
+	defer upstream.Close()
+
+	for _, remove := range []bool{false, true} {
+		s, err := New(&config.Config{
+			Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir(), Redact: config.RedactConfig{
+				Headers: []string{"set-cookie", "Authorization"},
+				Remove:  remove,
+			}},
+			Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+		})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		proxyServer := httptest.NewServer(s.GetProxy())
+		proxyURL, _ := url.Parse(proxyServer.URL)
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+		get := func() *http.Response {
+			req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/me", nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("GET error = %v", err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			return resp
+		}
+
+		if resp := get(); len(resp.Header.Values("Set-Cookie")) != 2 || resp.Header.Values("Set-Cookie")[0] != "session=secret" {
+			t.Errorf("remove=%v: live Set-Cookie = %q, want the original values", remove, resp.Header.Values("Set-Cookie"))
+		}
+
+		u, _ := url.Parse(upstream.URL + "/me")
+		keys, err := s.cacheManager.FindURL("", u)
+		if err != nil || len(keys) != 1 {
+			t.Fatalf("remove=%v: FindURL() = %v, %v, want the entry", remove, keys, err)
+		}
+		stored, err := s.cacheManager.GetStaleKey(keys[0])
+		if err != nil || stored == nil {
+			t.Fatalf("remove=%v: GetStaleKey() = %v, %v", remove, stored, err)
+		}
+		_ = stored.Body.Close()
+		wantCookies, wantAuthorization := []string{redactedValue, redactedValue}, redactedValue
+		if remove {
+			wantCookies, wantAuthorization = nil, ""
+		}
+		if cookies := stored.Header.Values("Set-Cookie"); len(cookies) != len(wantCookies) || (len(cookies) > 0 && cookies[0] != redactedValue) {
+			t.Errorf("remove=%v: stored Set-Cookie = %q, want %q", remove, cookies, wantCookies)
+		}
+		if stored.Header.Get("X-Request-Id") != "42" {
+			t.Errorf("remove=%v: stored X-Request-Id = %q, want it kept", remove, stored.Header.Get("X-Request-Id"))
+		}
+		if authorization := stored.Request.Header.Get("Authorization"); authorization != wantAuthorization {
+			t.Errorf("remove=%v: stored Authorization = %q, want %q", remove, authorization, wantAuthorization)
+		}
+
+		if resp := get(); resp.Header.Get("X-Cache") != "HIT" || len(resp.Header.Values("Set-Cookie")) != len(wantCookies) {
+			t.Errorf("remove=%v: X-Cache = %s, Set-Cookie = %q, want a hit with the stored values", remove, resp.Header.Get("X-Cache"), resp.Header.Values("Set-Cookie"))
+		}
+		proxyServer.Close()
+	}
+}
+
+// Requests stored with redacted credentials are not replayed: upstream would answer them with an error, replacing the entry
+func TestRefreshRedactedRequest(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.WriteString(w, "data")
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir(), Redact: config.RedactConfig{Headers: []string{"Authorization"}}},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/me", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	u, _ := url.Parse(upstream.URL + "/me")
+	keys, err := s.cacheManager.FindURL("", u)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("FindURL() = %v, %v, want the entry", keys, err)
+	}
+	if err := s.refreshEntry(keys[0]); err == nil {
+		t.Errorf("refreshEntry() of a redacted request succeeded, want an error")
+	}
+	if hits.Load() != 1 {
+		t.Errorf("upstream hits = %d, want 1: the redacted request was replayed", hits.Load())
+	}
+	stored, err := s.cacheManager.GetStaleKey(keys[0])
+	if err != nil || stored == nil {
+		t.Fatalf("GetStaleKey() = %v, %v", stored, err)
+	}
+	_ = stored.Body.Close()
+	if stored.StatusCode != http.StatusOK {
+		t.Errorf("stored status = %d, want the original 200", stored.StatusCode)
+	}
+	if diff := s.diffEntry(keys[0], stored, false); diff.Error == "" || hits.Load() != 1 {
+		t.Errorf("diffEntry() = %+v with %d upstream hits, want an error without replay", diff, hits.Load())
+	}
+}
@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// remapOrigin sends the request to the origin configured in its place (see remap.origins), if any.
+// The first matching remap applies
+func (s *Server) remapOrigin(req *http.Request) {
+	for _, remap := range s.config.Remap.Origins {
+		original := req.URL.String()
+		if !strings.HasPrefix(original, remap.From) {
+			continue
+		}
+		target, err := url.Parse(remap.To + strings.TrimPrefix(original, remap.From))
+		if err != nil {
+			logrus.Errorf("OnRequest(url=%s): Invalid remapped URL: %v", original, err)
+			return
+		}
+		logrus.Debugf("OnRequest(url=%s): Remapped to %s", original, target.String())
+		req.URL = target
+		req.Host = target.Host
+		return
+	}
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestRemapOrigin(t *testing.T) {
+	var requested []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.Host+r.URL.Path)
+		_, _ = w.Write([]byte("local"))
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir(), TTL: "1h"},
+		Rules: config.RulesConfig{Mode: config.RulesModeWhitelist, Rules: []config.CacheRule{
+			{BaseURI: upstream.URL + "/v2", Methods: []string{"GET"}},
+		}},
+		Remap: config.RemapConfig{Origins: []config.OriginRemap{
+			{From: "http://api.prod.example.com/v1", To: upstream.URL + "/v2"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	for i, wantCache := range []string{"MISS", "HIT"} {
+		resp, err := client.Get("http://api.prod.example.com/v1/users")
+		if err != nil {
+			t.Fatalf("GET %d error = %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != "local" || resp.Header.Get("X-Cache") != wantCache {
+			t.Errorf("GET %d = %q (X-Cache %s), want %q (X-Cache %s)", i, body, resp.Header.Get("X-Cache"), "local", wantCache)
+		}
+	}
+	upstreamURL, _ := url.Parse(upstream.URL)
+	if len(requested) != 1 || requested[0] != upstreamURL.Host+"/v2/users" {
+		t.Errorf("upstream requests = %v, want [%s/v2/users]", requested, upstreamURL.Host)
+	}
+}
@@ -0,0 +1,27 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// largeBody reports whether the body of a request is larger than cache.max_request_body.
+// Bodies of unknown length are read up to the limit to find out, and the part read is put back, so the body can be streamed upstream
+func (s *Server) largeBody(req *http.Request) (bool, error) {
+	if s.maxRequestBody == 0 || req.Body == nil || req.Body == http.NoBody {
+		return false, nil
+	}
+	if req.ContentLength >= 0 {
+		return req.ContentLength > s.maxRequestBody, nil
+	}
+	head, err := io.ReadAll(io.LimitReader(req.Body, s.maxRequestBody+1))
+	if err != nil {
+		return false, err
+	}
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), req.Body), req.Body}
+	return int64(len(head)) > s.maxRequestBody, nil
+}
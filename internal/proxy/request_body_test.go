@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestMaxRequestBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, strconv.Itoa(len(body)))
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir(), MaxRequestBody: "1KB"},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	tests := []struct {
+		name        string
+		size        int
+		chunked     bool
+		cacheStatus string
+	}{
+		{"small body", 1000, false, "MISS"},
+		{"small body again", 1000, false, "HIT"},
+		{"large body", 5000, false, "BYPASS"},
+		{"large body of unknown length", 5000, true, "BYPASS"},
+		{"small body of unknown length", 10, true, "MISS"},
+	}
+	for _, tt := range tests {
+		var body io.Reader = strings.NewReader(strings.Repeat("a", tt.size))
+		if tt.chunked {
+			body = io.MultiReader(body) // hides the length
+		}
+		req, _ := http.NewRequest(http.MethodPost, upstream.URL+"/upload", body)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s: POST error = %v", tt.name, err)
+		}
+		received, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.Header.Get("X-Cache") != tt.cacheStatus || string(received) != strconv.Itoa(tt.size) {
+			t.Errorf("%s: X-Cache = %s, upstream received %s bytes, want %s, %d", tt.name, resp.Header.Get("X-Cache"), received, tt.cacheStatus, tt.size)
+		}
+	}
+}
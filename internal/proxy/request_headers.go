@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// injectRequestHeaders sets the request headers of the rules matching the request, expanding environment variables.
+// The first matching rule setting a header wins. Headers of upstream.remove_request_headers and of the remove_request_headers
+// of matching rules are removed first, once the rules are matched, so rules can still match on them
+func (s *Server) injectRequestHeaders(requ *http.Request) {
+	rules := s.matchingConfigRules(requ)
+	removeRequestHeaders(requ, s.config.Upstream.RemoveRequestHeaders)
+	for _, rule := range rules {
+		removeRequestHeaders(requ, rule.RemoveRequestHeaders)
+	}
+
+	injected := map[string]bool{}
+	for _, rule := range rules {
+		for name, value := range rule.RequestHeaders {
+			name = http.CanonicalHeaderKey(name)
+			if injected[name] {
+				continue
+			}
+			injected[name] = true
+			requ.Header.Set(name, os.ExpandEnv(value))
+			logrus.Debugf("OnRequest(url=%s): Injected request header %s", requ.URL.String(), name)
+		}
+	}
+}
+
+// removeRequestHeaders removes headers from a request, so they do not leak upstream
+func removeRequestHeaders(requ *http.Request, names []string) {
+	for _, name := range names {
+		if len(requ.Header.Values(name)) == 0 {
+			continue
+		}
+		requ.Header.Del(name)
+		logrus.Debugf("OnRequest(url=%s): Removed request header %s", requ.URL.String(), http.CanonicalHeaderKey(name))
+	}
+}
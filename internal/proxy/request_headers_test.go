@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestInjectRequestHeaders(t *testing.T) {
+	var received http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	t.Setenv("TEST_API_TOKEN", "s3cret")
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist, Rules: []config.CacheRule{
+			{BaseURI: upstream.URL + "/api", Methods: []string{"GET"}, RequestHeaders: map[string]string{"authorization": "Bearer ${TEST_API_TOKEN}"}},
+			{BaseURI: upstream.URL, Methods: []string{"GET"}, RequestHeaders: map[string]string{"Authorization": "Bearer other", "X-Env": "staging"}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/api/users", nil)
+	req.Header.Set("X-Env", "production")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	_ = resp.Body.Close()
+	if received.Get("Authorization") != "Bearer s3cret" || received.Get("X-Env") != "staging" {
+		t.Errorf("upstream received Authorization = %q, X-Env = %q", received.Get("Authorization"), received.Get("X-Env"))
+	}
+}
+
+func TestRemoveRequestHeaders(t *testing.T) {
+	var received http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache:    config.CacheConfig{Folder: t.TempDir()},
+		Upstream: config.UpstreamConfig{RemoveRequestHeaders: []string{"traceparent"}},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist, Rules: []config.CacheRule{
+			{
+				BaseURI: upstream.URL + "/partner", Methods: []string{"GET"}, MatchHeaders: map[string]string{"X-Internal-Auth": ""},
+				RemoveRequestHeaders: []string{"X-Internal-Auth", "Authorization"}, RequestHeaders: map[string]string{"Authorization": "Bearer partner"},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	get := func(path string) {
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+path, nil)
+		req.Header.Set("Traceparent", "00-trace-span-01")
+		req.Header.Set("X-Internal-Auth", "local")
+		req.Header.Set("Authorization", "Bearer local")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	get("/partner/orders")
+	if _, ok := received["Traceparent"]; ok || received.Get("X-Internal-Auth") != "" || received.Get("Authorization") != "Bearer partner" {
+		t.Errorf("upstream received %v, want tracing and internal headers removed, and Authorization injected", received)
+	}
+	get("/other")
+	if _, ok := received["Traceparent"]; ok || received.Get("X-Internal-Auth") != "local" || received.Get("Authorization") != "Bearer local" {
+		t.Errorf("upstream received %v, want only the global headers removed", received)
+	}
+}
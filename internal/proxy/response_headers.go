@@ -0,0 +1,21 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+// overrideResponseHeaders removes and sets the headers of response_headers.overrides matching the host of the request, in order.
+// Applied to responses sent to clients, after caching, so stored entries keep the headers of upstream
+func (s *Server) overrideResponseHeaders(requ *http.Request, resp *http.Response) {
+	if len(s.config.ResponseHeaders.Overrides) == 0 {
+		return
+	}
+	for _, override := range s.config.ResponseHeaders.Matching(requ.URL.Hostname()) {
+		for _, name := range override.Remove {
+			resp.Header.Del(name)
+		}
+		for name, value := range override.Set {
+			resp.Header.Set(name, value)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestOverrideResponseHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Strict-Transport-Security", "max-age=31536000")
+		_, _ = io.WriteString(w, "page")
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+		ResponseHeaders: config.ResponseHeadersConfig{Overrides: []config.ResponseHeaderOverride{
+			{Match: []string{"127.0.0.1"}, Remove: []string{"content-security-policy", "X-Frame-Options"}},
+			{Match: []string{"127.0.0.0/8"}, Set: map[string]string{"Strict-Transport-Security": "max-age=0"}},
+			{Match: []string{"other.example.com"}, Remove: []string{"Strict-Transport-Security"}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	for _, cacheStatus := range []string{"MISS", "HIT"} {
+		resp, err := client.Get(upstream.URL + "/page")
+		if err != nil {
+			t.Fatalf("GET error = %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.Header.Get("X-Cache") != cacheStatus {
+			t.Errorf("X-Cache = %s, want %s", resp.Header.Get("X-Cache"), cacheStatus)
+		}
+		if resp.Header.Get("Content-Security-Policy") != "" || resp.Header.Get("X-Frame-Options") != "" || resp.Header.Get("Strict-Transport-Security") != "max-age=0" {
+			t.Errorf("%s headers = %v, want security headers removed or overridden", cacheStatus, resp.Header)
+		}
+	}
+
+	u, _ := url.Parse(upstream.URL + "/page")
+	keys, err := s.cacheManager.FindURL("", u)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("FindURL() = %v, %v, want the entry", keys, err)
+	}
+	stored, err := s.cacheManager.GetStaleKey(keys[0])
+	if err != nil || stored == nil {
+		t.Fatalf("GetStaleKey() = %v, %v", stored, err)
+	}
+	_ = stored.Body.Close()
+	if stored.Header.Get("Content-Security-Policy") == "" {
+		t.Errorf("stored headers = %v, want the headers of upstream", stored.Header)
+	}
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// retryTransport retries idempotent upstream requests failing with a connection error or a retryable status (see upstream.retry)
+type retryTransport struct {
+	next     http.RoundTripper
+	attempts int
+	// wait before the first retry, doubled before each next one up to maxBackoff (0 if unlimited)
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	statusCodes []string
+}
+
+func newRetryTransport(next http.RoundTripper, cfg *config.Config) (*retryTransport, error) {
+	backoff, maxBackoff, err := cfg.GetRetryBackoff()
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream retry: %w", err)
+	}
+	return &retryTransport{
+		next:        next,
+		attempts:    cfg.Upstream.Retry.Attempts,
+		backoff:     backoff,
+		maxBackoff:  maxBackoff,
+		statusCodes: cfg.Upstream.Retry.StatusCodes,
+	}, nil
+}
+
+// retryable reports whether the request can be sent again: GET and HEAD requests, whose body (if any) can be read again
+func retryable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// failure describes why an attempt must be retried, or returns "" if it must not
+func (t *retryTransport) failure(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	if slices.ContainsFunc(t.statusCodes, func(pattern string) bool { return config.MatchesStatusCode(resp.StatusCode, pattern) }) {
+		return resp.Status
+	}
+	return ""
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !retryable(req) {
+		return t.next.RoundTrip(req)
+	}
+	wait := t.backoff
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		failure := t.failure(resp, err)
+		if failure == "" || attempt > t.attempts || req.Context().Err() != nil {
+			return resp, err
+		}
+		logrus.Warnf("Upstream request to %s failed (%s), retrying in %s (%d/%d)", req.URL.String(), failure, wait, attempt, t.attempts)
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, fmt.Errorf("failed to read the request body again: %w", err)
+			}
+		}
+		wait *= 2
+		if t.maxBackoff > 0 && wait > t.maxBackoff {
+			wait = t.maxBackoff
+		}
+	}
+}
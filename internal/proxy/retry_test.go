@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+// sequenceTransport answers each request with the next of its results
+type sequenceTransport struct {
+	results []any // status codes (int) or errors
+	calls   int
+}
+
+func (t *sequenceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	result := t.results[min(t.calls, len(t.results)-1)]
+	t.calls++
+	if err, ok := result.(error); ok {
+		return nil, err
+	}
+	status := result.(int)
+	return &http.Response{StatusCode: status, Status: http.StatusText(status), Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func TestRetryTransport(t *testing.T) {
+	cfg := &config.Config{Upstream: config.UpstreamConfig{Retry: config.RetryConfig{
+		Attempts:    2,
+		Backoff:     "1ms",
+		MaxBackoff:  "2ms",
+		StatusCodes: []string{"502", "503"},
+	}}}
+	refused := errors.New("connection refused")
+
+	tests := []struct {
+		name       string
+		method     string
+		body       io.Reader
+		results    []any
+		wantStatus int
+		wantErr    bool
+		wantCalls  int
+	}{
+		{name: "success", method: http.MethodGet, results: []any{200}, wantStatus: 200, wantCalls: 1},
+		{name: "connection error then success", method: http.MethodGet, results: []any{refused, 200}, wantStatus: 200, wantCalls: 2},
+		{name: "retryable status then success", method: http.MethodHead, results: []any{503, 502, 200}, wantStatus: 200, wantCalls: 3},
+		{name: "retries exhausted", method: http.MethodGet, results: []any{503}, wantStatus: 503, wantCalls: 3},
+		{name: "connection errors exhausted", method: http.MethodGet, results: []any{refused}, wantErr: true, wantCalls: 3},
+		{name: "other status", method: http.MethodGet, results: []any{500, 200}, wantStatus: 500, wantCalls: 1},
+		{name: "not idempotent", method: http.MethodPost, results: []any{503, 200}, wantStatus: 503, wantCalls: 1},
+		{name: "body read again", method: http.MethodGet, body: strings.NewReader("query"), results: []any{refused, 200}, wantStatus: 200, wantCalls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &sequenceTransport{results: tt.results}
+			transport, err := newRetryTransport(next, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, _ := http.NewRequest(tt.method, "http://example.com/", tt.body)
+			resp, err := transport.RoundTrip(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RoundTrip() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && resp.StatusCode != tt.wantStatus {
+				t.Errorf("RoundTrip() status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if next.calls != tt.wantCalls {
+				t.Errorf("upstream called %d times, want %d", next.calls, tt.wantCalls)
+			}
+		})
+	}
+}
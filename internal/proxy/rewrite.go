@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/elazarl/goproxy"
+	"github.com/sirupsen/logrus"
+)
+
+// rewriteResponse applies the rewrites of the rules matching the request to an upstream response, in rule order
+func (s *Server) rewriteResponse(requ *http.Request, resp *http.Response) *http.Response {
+	for _, rule := range s.matchingConfigRules(requ) {
+		rewrite := rule.Rewrite
+		if rewrite == nil {
+			continue
+		}
+		if rewrite.Status != 0 {
+			resp.StatusCode = rewrite.Status
+			resp.Status = fmt.Sprintf("%d %s", rewrite.Status, http.StatusText(rewrite.Status))
+		}
+		for _, name := range rewrite.RemoveHeaders {
+			resp.Header.Del(name)
+		}
+		for name, value := range rewrite.SetHeaders {
+			resp.Header.Set(name, value)
+		}
+		for name, value := range rewrite.AddHeaders {
+			resp.Header.Add(name, value)
+		}
+		if len(rule.bodyRewrites) == 0 {
+			continue
+		}
+
+		body, err := rewritableBody(resp)
+		if err != nil {
+			logrus.Errorf("OnResponse(url=%s): Failed to read body to rewrite: %v", requ.URL.String(), err)
+			return goproxy.NewResponse(requ, goproxy.ContentTypeText, http.StatusBadGateway, fmt.Sprintf("failed to read upstream body: %v", err))
+		}
+		if body == nil {
+			logrus.Warnf("OnResponse(url=%s): Cannot rewrite body with Content-Encoding %s", requ.URL.String(), resp.Header.Get("Content-Encoding"))
+			continue
+		}
+		for i, re := range rule.bodyRewrites {
+			body = re.ReplaceAll(body, []byte(rewrite.Body[i].Replace))
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		resp.TransferEncoding = nil
+	}
+	return resp
+}
+
+// rewritableBody reads the body of a response, decompressing it if gzip-encoded (the rewritten body is then sent uncompressed).
+// Returns nil without reading it if it uses another encoding
+func rewritableBody(resp *http.Response) ([]byte, error) {
+	encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+	if encoding != "" && encoding != "identity" && encoding != "gzip" {
+		return nil, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if encoding != "gzip" {
+		return body, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip body: %w", err)
+	}
+	if body, err = io.ReadAll(reader); err != nil {
+		return nil, fmt.Errorf("invalid gzip body: %w", err)
+	}
+	resp.Header.Del("Content-Encoding")
+	return body, nil
+}
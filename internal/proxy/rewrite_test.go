@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestRewriteResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Internal", "secret")
+		w.Header().Set("Content-Type", "application/json")
+		body := []byte(`{"next": "https://prod.example.com/page/2", "beta": false}`)
+		if r.URL.Path == "/gzip" {
+			var compressed bytes.Buffer
+			gz := gzip.NewWriter(&compressed)
+			_, _ = gz.Write(body)
+			_ = gz.Close()
+			w.Header().Set("Content-Encoding", "gzip")
+			body = compressed.Bytes()
+		}
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write(body)
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeWhitelist, Rules: []config.CacheRule{
+			{BaseURI: upstream.URL, Methods: []string{"GET"}, Rewrite: &config.RewriteConfig{
+				Status:        http.StatusOK,
+				SetHeaders:    map[string]string{"Content-Type": "application/json; charset=utf-8"},
+				AddHeaders:    map[string]string{"X-Rewritten": "1"},
+				RemoveHeaders: []string{"X-Internal"},
+				Body: []config.BodyReplacement{
+					{Find: `https://prod\.example\.com(/\S*?)"`, Replace: `http://localhost:3000${1}"`},
+					{Find: `"beta": false`, Replace: `"beta": true`},
+				},
+			}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL), DisableCompression: true}}
+
+	// Second requests are cache hits: the rewritten response is the cached one
+	for i, path := range []string{"/plain", "/gzip", "/plain", "/gzip"} {
+		resp, err := client.Get(upstream.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if wantHit := i >= 2; (resp.Header.Get("X-Cache") == "HIT") != wantHit {
+			t.Errorf("GET %s X-Cache = %s, want a hit: %v", path, resp.Header.Get("X-Cache"), wantHit)
+		}
+		if string(body) != `{"next": "http://localhost:3000/page/2", "beta": true}` {
+			t.Errorf("GET %s body = %q", path, body)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Internal") != "" || resp.Header.Get("X-Rewritten") != "1" ||
+			resp.Header.Get("Content-Type") != "application/json; charset=utf-8" || resp.Header.Get("Content-Encoding") != "" {
+			t.Errorf("GET %s (X-Cache %s) = %d %v", path, resp.Header.Get("X-Cache"), resp.StatusCode, resp.Header)
+		}
+	}
+}
@@ -1,26 +1,84 @@
 package proxy
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
 	"net/http"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/iTrooz/caching-dev-proxy/internal/condition"
 	"github.com/iTrooz/caching-dev-proxy/internal/config"
+
+	"github.com/sirupsen/logrus"
 )
 
 // Rule interface for matching requests against caching rules
 type Rule interface {
+	// Match checks if a request and its response match the rule
 	Match(requ *http.Request, resp *http.Response) bool
+	// MatchRequest checks if a request matches the rule, ignoring response conditions
+	MatchRequest(requ *http.Request) bool
 }
 
 // ConfigRule implements Rule interface for config-based rules
 type ConfigRule struct {
 	config.CacheRule
+	// compiled expressions of Rewrite.Body, in order
+	bodyRewrites []*regexp.Regexp
+	// compiled BaseURI, if it is a glob pattern
+	glob *config.URLGlob
+	// compiled When expression
+	when *condition.Condition
+	// compiled Except patterns
+	except []*regexp.Regexp
+	// parsed MinSize and MaxSize. 0 means unbounded
+	minSize, maxSize int64
+	// parsed Placeholder durations
+	placeholderAfter, placeholderRetryAfter time.Duration
+	// parsed Latency delays
+	latencyDelay, latencyMaxDelay time.Duration
+	// parsed Timeouts, overriding upstream.timeouts. nil if the rule defines none
+	timeouts *config.Timeouts
 }
 
 // Match checks if a request matches this rule
 func (r *ConfigRule) Match(requ *http.Request, resp *http.Response) bool {
-	// Check if URL starts with base URI
-	if !strings.HasPrefix(requ.URL.String(), r.BaseURI) {
+	if !r.MatchRequest(requ) {
+		return false
+	}
+
+	// Check if status code matches (if specified)
+	if len(r.StatusCodes) > 0 {
+		statusMatches := false
+		for _, statusPattern := range r.StatusCodes {
+			if config.MatchesStatusCode(resp.StatusCode, statusPattern) {
+				statusMatches = true
+				break
+			}
+		}
+		if !statusMatches {
+			return false
+		}
+	}
+
+	if r.sizeMismatch(resp) != "" {
+		return false
+	}
+	return r.when == nil || !r.when.UsesResponse() || r.evalWhen(requ, resp)
+}
+
+// MatchRequest checks if a request matches the URL and method of this rule
+func (r *ConfigRule) MatchRequest(requ *http.Request) bool {
+	if !r.matchesURL(requ) || r.exception(requ) != "" || r.headerMismatch(requ) != "" || r.queryMismatch(requ) != "" {
+		return false
+	}
+	// Expressions using the response are evaluated by Match
+	if r.when != nil && !r.when.UsesResponse() && !r.evalWhen(requ, nil) {
 		return false
 	}
 
@@ -32,23 +90,223 @@ func (r *ConfigRule) Match(requ *http.Request, resp *http.Response) bool {
 			break
 		}
 	}
-	if !methodMatches {
+	return methodMatches
+}
+
+// matchesURL checks if the URL of a request starts with the base URI, or matches it if it is a glob pattern
+func (r *ConfigRule) matchesURL(requ *http.Request) bool {
+	if r.glob != nil {
+		return r.glob.Match(requ.URL)
+	}
+	return strings.HasPrefix(requ.URL.String(), r.BaseURI)
+}
+
+// evalWhen evaluates the When expression. Evaluation errors (e.g. a missing header) count as no match
+func (r *ConfigRule) evalWhen(requ *http.Request, resp *http.Response) bool {
+	matched, err := r.when.Match(requ, resp)
+	if err != nil {
+		logrus.Debugf("Rule(%s): when expression failed on %s: %v", r.BaseURI, requ.URL.String(), err)
 		return false
 	}
+	return matched
+}
 
-	// Check if status code matches (if specified)
-	if len(r.StatusCodes) > 0 {
-		statusMatches := false
-		for _, statusPattern := range r.StatusCodes {
-			if config.MatchesStatusCode(resp.StatusCode, statusPattern) {
-				statusMatches = true
-				break
+// exception returns the Except pattern excluding the request from the rule, or ""
+func (r *ConfigRule) exception(requ *http.Request) string {
+	for i, re := range r.except {
+		target := requ.URL.Path
+		if strings.Contains(r.Except[i], "://") {
+			target = requ.URL.String()
+		}
+		if re.MatchString(target) {
+			return r.Except[i]
+		}
+	}
+	return ""
+}
+
+// exceptRegexp compiles an Except pattern
+func exceptRegexp(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if len(parts) > 1 {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// headerMismatch tells why the headers of a request do not meet the header conditions of the rule, or returns "" if they do
+func (r *ConfigRule) headerMismatch(requ *http.Request) string {
+	for _, name := range slices.Sorted(maps.Keys(r.MatchHeaders)) {
+		values, ok := requ.Header[http.CanonicalHeaderKey(name)]
+		if !ok {
+			return fmt.Sprintf("header %s missing", name)
+		}
+		if want := r.MatchHeaders[name]; want != "" && !slices.Contains(values, want) {
+			return fmt.Sprintf("header %s is not '%s'", name, want)
+		}
+	}
+	for _, name := range r.AbsentHeaders {
+		if _, ok := requ.Header[http.CanonicalHeaderKey(name)]; ok {
+			return fmt.Sprintf("header %s present", name)
+		}
+	}
+	return ""
+}
+
+// queryMismatch tells why the query parameters of a request do not meet the query conditions of the rule, or returns "" if they do
+func (r *ConfigRule) queryMismatch(requ *http.Request) string {
+	if len(r.MatchQuery) == 0 && len(r.AbsentQuery) == 0 {
+		return ""
+	}
+	query := requ.URL.Query()
+	for _, name := range slices.Sorted(maps.Keys(r.MatchQuery)) {
+		values, ok := query[name]
+		if !ok {
+			return fmt.Sprintf("query parameter %s missing", name)
+		}
+		if want := r.MatchQuery[name]; want != "" && !slices.Contains(values, want) {
+			return fmt.Sprintf("query parameter %s is not '%s'", name, want)
+		}
+	}
+	for _, name := range r.AbsentQuery {
+		if query.Has(name) {
+			return fmt.Sprintf("query parameter %s present", name)
+		}
+	}
+	return ""
+}
+
+// hasSizeConditions reports whether the rule bounds the response body size
+func (r *ConfigRule) hasSizeConditions() bool {
+	return r.minSize > 0 || r.maxSize > 0
+}
+
+// sizeMismatch tells why the body size of a response does not meet the size conditions of the rule, or returns "" if it does
+func (r *ConfigRule) sizeMismatch(resp *http.Response) string {
+	if !r.hasSizeConditions() {
+		return ""
+	}
+	if resp.ContentLength < 0 {
+		return "response size unknown"
+	}
+	if resp.ContentLength < r.minSize {
+		return fmt.Sprintf("response size %d below min_size %s", resp.ContentLength, r.MinSize)
+	}
+	if r.maxSize > 0 && resp.ContentLength > r.maxSize {
+		return fmt.Sprintf("response size %d above max_size %s", resp.ContentLength, r.MaxSize)
+	}
+	return ""
+}
+
+// configRules converts the config rules to Rule interfaces
+func configRules(cfg *config.Config) ([]Rule, error) {
+	rules := make([]Rule, len(cfg.Rules.Rules))
+	for i, rule := range cfg.Rules.Rules {
+		configRule, err := newConfigRule(rule, cfg.Upstream.Timeouts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %d (%s): %w", i, rule.BaseURI, err)
+		}
+		rules[i] = configRule
+	}
+	return rules, nil
+}
+
+// newConfigRule compiles the patterns and expressions of a config rule, and parses its sizes and durations.
+// timeouts are the upstream.timeouts overridden by the ones of the rule
+func newConfigRule(rule config.CacheRule, timeouts config.TimeoutsConfig) (*ConfigRule, error) {
+	configRule := &ConfigRule{CacheRule: rule}
+	var err error
+	if configRule.minSize, err = config.ParseSize(rule.MinSize); err != nil {
+		return nil, fmt.Errorf("invalid min_size: %w", err)
+	}
+	if configRule.maxSize, err = config.ParseSize(rule.MaxSize); err != nil {
+		return nil, fmt.Errorf("invalid max_size: %w", err)
+	}
+	if rule.When != "" {
+		if configRule.when, err = condition.Compile(rule.When); err != nil {
+			return nil, fmt.Errorf("invalid when: %w", err)
+		}
+	}
+	for _, pattern := range rule.Except {
+		configRule.except = append(configRule.except, exceptRegexp(pattern))
+	}
+	if config.IsURLGlob(rule.BaseURI) {
+		if configRule.glob, err = config.ParseURLGlob(rule.BaseURI); err != nil {
+			return nil, err
+		}
+	}
+	if rule.Rewrite != nil {
+		for _, replacement := range rule.Rewrite.Body {
+			find, err := regexp.Compile(replacement.Find)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rewrite body expression '%s': %w", replacement.Find, err)
 			}
+			configRule.bodyRewrites = append(configRule.bodyRewrites, find)
 		}
-		if !statusMatches {
-			return false
+	}
+	if configRule.placeholderAfter, err = config.ParseOptionalDuration(rule.Placeholder.After); err != nil {
+		return nil, fmt.Errorf("invalid placeholder delay: %w", err)
+	}
+	if configRule.placeholderRetryAfter, err = config.ParseOptionalDuration(rule.Placeholder.RetryAfter); err != nil {
+		return nil, fmt.Errorf("invalid placeholder retry_after: %w", err)
+	}
+	if rule.Latency != nil {
+		if configRule.latencyDelay, err = config.ParseOptionalDuration(rule.Latency.Delay); err != nil {
+			return nil, fmt.Errorf("invalid latency delay: %w", err)
+		}
+		if configRule.latencyMaxDelay, err = config.ParseOptionalDuration(rule.Latency.MaxDelay); err != nil {
+			return nil, fmt.Errorf("invalid latency max_delay: %w", err)
+		}
+	}
+	if rule.Timeouts != nil {
+		parsed, err := timeouts.Override(rule.Timeouts).Parse()
+		if err != nil {
+			return nil, err
+		}
+		configRule.timeouts = &parsed
+	}
+	return configRule, nil
+}
+
+// matchingConfigRules returns the config rules whose request conditions match the request, in order
+func (s *Server) matchingConfigRules(requ *http.Request) []*ConfigRule {
+	matching := []*ConfigRule{}
+	for _, rule := range s.rules {
+		if configRule, ok := rule.(*ConfigRule); ok && configRule.MatchRequest(requ) {
+			matching = append(matching, configRule)
 		}
 	}
+	return matching
+}
+
+// measureBody buffers the body of a response of unknown size if a rule matching its request has size conditions, so they can be evaluated
+func (s *Server) measureBody(requ *http.Request, resp *http.Response) {
+	if resp.ContentLength >= 0 || resp.Body == nil || !slices.ContainsFunc(s.matchingConfigRules(requ), (*ConfigRule).hasSizeConditions) {
+		return
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		// Let the error surface again when the body is read to be stored or sent
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), failingReader{err}), resp.Body}
+		return
+	}
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+}
+
+// failingReader fails all reads with err
+type failingReader struct {
+	err error
+}
 
-	return true
+func (r failingReader) Read([]byte) (int, error) {
+	return 0, r.err
 }
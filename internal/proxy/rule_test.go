@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestSizeConditions(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := 10
+		if r.URL.Path == "/large" {
+			size = 2000
+		}
+		// Flushing before writing makes the response chunked, of unknown size
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte(strings.Repeat("a", size)))
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir(), TTL: "1h"},
+		Rules: config.RulesConfig{Mode: config.RulesModeWhitelist, Rules: []config.CacheRule{
+			{BaseURI: upstream.URL, Methods: []string{"GET"}, MinSize: "1KB"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	for _, tt := range []struct {
+		path      string
+		wantCache []string
+		wantSize  int
+	}{
+		{path: "/small", wantCache: []string{"DISABLED", "DISABLED"}, wantSize: 10},
+		{path: "/large", wantCache: []string{"MISS", "HIT"}, wantSize: 2000},
+	} {
+		for i, want := range tt.wantCache {
+			resp, err := client.Get(upstream.URL + tt.path)
+			if err != nil {
+				t.Fatalf("GET %s error = %v", tt.path, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if resp.Header.Get("X-Cache") != want || len(body) != tt.wantSize {
+				t.Errorf("GET %s #%d: X-Cache = %s with %d bytes, want %s with %d bytes", tt.path, i+1, resp.Header.Get("X-Cache"), len(body), want, tt.wantSize)
+			}
+		}
+	}
+}
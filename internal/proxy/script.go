@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+
+	"github.com/elazarl/goproxy"
+	"github.com/sirupsen/logrus"
+)
+
+// scriptRequest lets the script modify the request, if a script is configured
+func (s *Server) scriptRequest(req *http.Request) {
+	if s.script == nil {
+		return
+	}
+	if err := s.script.OnRequest(req); err != nil {
+		logrus.Errorf("OnRequest(url=%s): %v", req.URL.String(), err)
+	}
+}
+
+// scriptKey returns the cache key chosen by the script for the request, or key
+func (s *Server) scriptKey(req *http.Request, key string) string {
+	if s.script == nil {
+		return key
+	}
+	scriptKey, err := s.script.CacheKey(req, key)
+	if err != nil {
+		logrus.Errorf("OnRequest(url=%s): %v", req.URL.String(), err)
+		return key
+	}
+	if !cache.ValidKey(scriptKey) {
+		logrus.Errorf("OnRequest(url=%s): cache_key returned '%s', which is not a cache key, using %s", req.URL.String(), scriptKey, key)
+		return key
+	}
+	return scriptKey
+}
+
+// scriptResponse lets the script modify an upstream response before it is cached.
+// gzip bodies are decompressed first; bodies with other encodings are passed as-is
+func (s *Server) scriptResponse(req *http.Request, resp *http.Response) *http.Response {
+	if s.script == nil || !s.script.HasOnResponse() {
+		return resp
+	}
+	body, err := rewritableBody(resp)
+	if err == nil && body == nil {
+		body, err = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		logrus.Errorf("OnResponse(url=%s): Failed to read body for the script: %v", req.URL.String(), err)
+		return goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusBadGateway, fmt.Sprintf("failed to read upstream body: %v", err))
+	}
+
+	body, err = s.script.OnResponse(req, resp, body)
+	if err != nil {
+		logrus.Errorf("OnResponse(url=%s): %v", req.URL.String(), err)
+		return goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusBadGateway, err.Error())
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.TransferEncoding = nil
+	return resp
+}
+
+// scriptDecision returns the caching decision of the script for a response, or cacheable
+func (s *Server) scriptDecision(req *http.Request, resp *http.Response, cacheable bool) bool {
+	if s.script == nil {
+		return cacheable
+	}
+	decision, err := s.script.ShouldCache(req, resp, cacheable)
+	if err != nil {
+		logrus.Errorf("OnResponse(url=%s): %v", req.URL.String(), err)
+		return cacheable
+	}
+	return decision
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestScriptHooks(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello "+r.Header.Get("X-Tenant"))
+	}))
+	defer upstream.Close()
+
+	scriptFile := filepath.Join(t.TempDir(), "hooks.lua")
+	err := os.WriteFile(scriptFile, []byte(`
+function on_request(req) req.headers["X-Tenant"] = "demo" end
+function on_response(req, resp) resp.body = string.upper(resp.body) end
+function should_cache(req, resp, cache) return resp.status == 200 end
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(&config.Config{
+		Cache:  config.CacheConfig{Folder: t.TempDir(), TTL: "1h"},
+		Rules:  config.RulesConfig{Mode: config.RulesModeWhitelist},
+		Script: config.ScriptConfig{File: scriptFile},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	for i, wantCache := range []string{"MISS", "HIT"} {
+		resp, err := client.Get(upstream.URL + "/greeting")
+		if err != nil {
+			t.Fatalf("GET %d error = %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != "HELLO DEMO" || resp.Header.Get("X-Cache") != wantCache {
+			t.Errorf("GET %d = %q (X-Cache %s), want %q (X-Cache %s)", i, body, resp.Header.Get("X-Cache"), "HELLO DEMO", wantCache)
+		}
+	}
+}
+
+func TestScriptCacheKey(t *testing.T) {
+	upstreamHits := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits++
+		_, _ = io.WriteString(w, "hello")
+	}))
+	defer upstream.Close()
+
+	scriptFile := filepath.Join(t.TempDir(), "hooks.lua")
+	err := os.WriteFile(scriptFile, []byte(`
+function cache_key(req, key) return "scripted/GET.bin" end
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(&config.Config{
+		Cache:  config.CacheConfig{Folder: t.TempDir(), TTL: "1h"},
+		Rules:  config.RulesConfig{Mode: config.RulesModeBlacklist},
+		Script: config.ScriptConfig{File: scriptFile},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	// Different URLs share the scripted key
+	for i, tt := range []struct{ path, wantCache string }{{"/a", "MISS"}, {"/a", "HIT"}, {"/b", "HIT"}} {
+		resp, err := client.Get(upstream.URL + tt.path)
+		if err != nil {
+			t.Fatalf("GET %d error = %v", i, err)
+		}
+		_ = resp.Body.Close()
+		if resp.Header.Get("X-Cache") != tt.wantCache {
+			t.Errorf("GET %d %s: X-Cache = %s, want %s", i, tt.path, resp.Header.Get("X-Cache"), tt.wantCache)
+		}
+	}
+	if upstreamHits != 1 {
+		t.Errorf("upstream hits = %d, want 1", upstreamHits)
+	}
+}
+
+// Keys of the script escaping the cache folder, hidden or empty are ignored, for the generated key
+func TestScriptInvalidCacheKey(t *testing.T) {
+	scriptFile := filepath.Join(t.TempDir(), "hooks.lua")
+	err := os.WriteFile(scriptFile, []byte(`
+function cache_key(req, key)
+  if string.find(req.url, "/escape") then return "../../escaped/GET.bin" end
+  if string.find(req.url, "/hidden") then return "example.com/.hidden/GET.bin" end
+  return ""
+end
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(&config.Config{
+		Cache:  config.CacheConfig{Folder: t.TempDir(), TTL: "1h"},
+		Rules:  config.RulesConfig{Mode: config.RulesModeBlacklist},
+		Script: config.ScriptConfig{File: scriptFile},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for _, path := range []string{"/escape", "/hidden", "/empty"} {
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		if key := s.scriptKey(req, "generated/GET.bin"); key != "generated/GET.bin" {
+			t.Errorf("scriptKey(%s) = %s, want the generated key", path, key)
+		}
+	}
+}
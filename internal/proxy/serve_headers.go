@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// serveHeadersHeader stores, along a cached response, headers replacing the stored ones when it is served (JSON object).
+// It is never sent to clients
+const serveHeadersHeader = "X-Cache-Serve-Headers"
+
+// SetServeHeaders sets the headers injected when serving the cached entries of a URL, until they are replaced.
+// Empty headers remove the injected ones. Returns the number of updated entries
+func (s *Server) SetServeHeaders(namespace string, u *url.URL, headers http.Header) (int, error) {
+	keys, err := s.cacheManager.FindURL(namespace, u)
+	if err != nil {
+		return 0, err
+	}
+	ttl, err := s.config.GetCacheTTL()
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, key := range keys {
+		resp, err := s.cacheManager.GetKey(key)
+		if err != nil {
+			return updated, err
+		}
+		if resp == nil {
+			continue // expired in the meantime
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return updated, fmt.Errorf("failed to read cache entry: %w", err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		if len(headers) == 0 {
+			resp.Header.Del(serveHeadersHeader)
+		} else {
+			encoded, err := json.Marshal(headers)
+			if err != nil {
+				return updated, err
+			}
+			resp.Header.Set(serveHeadersHeader, string(encoded))
+		}
+		// Rewriting the entry must not extend its life
+		if storedAt, err := time.Parse(time.RFC3339Nano, resp.Header.Get(storedHeader)); err == nil && ttl != 0 && resp.Header.Get(expiresHeader) == "" {
+			resp.Header.Set(expiresHeader, storedAt.Add(ttl).UTC().Format(time.RFC3339Nano))
+		}
+
+		if err := s.cacheManager.SetKey(key, resp); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// applyServeHeaders replaces the headers of a cached response with the ones set for its entry
+func applyServeHeaders(resp *http.Response) {
+	value := resp.Header.Get(serveHeadersHeader)
+	if value == "" {
+		return
+	}
+	resp.Header.Del(serveHeadersHeader)
+
+	var headers http.Header
+	if err := json.Unmarshal([]byte(value), &headers); err != nil {
+		logrus.Warnf("Ignoring invalid serve headers of cached entry: %v", err)
+		return
+	}
+	for name, values := range headers {
+		resp.Header[http.CanonicalHeaderKey(name)] = values
+	}
+}
@@ -4,16 +4,24 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/iTrooz/caching-dev-proxy/internal/cache"
 	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
 	"github.com/iTrooz/caching-dev-proxy/internal/config"
+	"github.com/iTrooz/caching-dev-proxy/internal/cron"
+	"github.com/iTrooz/caching-dev-proxy/internal/history"
+	"github.com/iTrooz/caching-dev-proxy/internal/script"
 
 	"github.com/elazarl/goproxy"
 	"github.com/sirupsen/logrus"
@@ -28,46 +36,153 @@ type ProxyResponse struct {
 
 // Server represents the caching proxy server
 type Server struct {
-	config       *config.Config
-	cacheManager *httpcache.HTTPCache
-	proxy        *goproxy.ProxyHttpServer
-	rules        []Rule
+	config         *config.Config
+	cacheManager   *httpcache.HTTPCache
+	cacheWriter    *cacheWriter
+	prefetcher     *prefetcher // nil if disabled
+	proxy          *goproxy.ProxyHttpServer
+	upstream       http.RoundTripper // transports of proxy.Tr with the settings of upstream hosts, wrapped by the HTTP/3, retry and timeout transports
+	http3          *http3Transport   // nil if disabled
+	rules          []Rule
+	timeouts       config.Timeouts     // upstream.timeouts, unless overridden by a rule
+	passthrough    config.HostPatterns // server.https.passthrough_hosts
+	corsMaxAge     time.Duration
+	cacheTTL       time.Duration // cache.ttl, 0 if entries never expire
+	staleTTL       time.Duration // cache.stale_ttl
+	stop           chan struct{} // closed by Close, stopping background loops
+	clockSkew      *clockSkewDetector
+	history        *historyRecorder
+	stats          *requestStats
+	rateLimiter    *rateLimiter    // nil if disabled
+	decisions      *decisionClient // nil if no decision service is configured
+	peers          *peerClient     // nil if no peer is configured
+	script         *script.Engine  // nil if no script is configured
+	interceptors   []Interceptor
+	idle           idleTracker
+	requestLimit   *concurrencyLimit // nil if not limited
+	handshakeLimit *concurrencyLimit // nil if not limited
+	canaryReport   *canaryReporter
+	events         *eventLog // nil if disabled
+	liveEvents     eventHub
+	storageSampler *storageSampler
+	maxRequestBody int64 // 0 if not limited
+
+	// upstream fetches running in the background, by cache key
+	pending   map[string]*pendingFetch
+	pendingMu sync.Mutex
+
+	// server of the HTTP listener, nil until started. Once stopping is set, it is not started anymore
+	httpServer   *http.Server
+	stopping     bool
+	httpServerMu sync.Mutex
 }
 
+// ctxUserDataKey is the context key of the ctxUserData of requests from transparent listeners
+type ctxUserDataKey struct{}
+
 // ctxUserData holds per-request context for cache logic
 type ctxUserData struct {
 	// start time of the request
 	start time.Time
 	// where the request comes from (e.g., HTTP explicit, HTTP transparent, etc.)
 	source string
+	// CN of the client certificate presented on an intercepted connection, if any
+	clientIdentity string
 	// cache key for the request
 	key string
+	// request body, stored along the response
+	requestBody []byte
+	// request headers the key was generated from, before goproxy drops some (e.g. Accept-Encoding). Only kept for prefetching
+	requestHeader http.Header
 	// whether the request should bypass cache
 	bypass bool
-	// whether the response was a cache hit
-	hit bool
+	// whether the client asked to replace the cached response with a fresh one from upstream
+	refresh bool
+	// TTL asked by the client for the stored entry, 0 for the effective one
+	ttl time.Duration
+	// whether the request body is larger than cache.max_request_body, so it is streamed and the cache not used
+	largeBody bool
+	// whether the client asked for the explanation of the caching decision
+	explain bool
+	// X-Cache status of a response produced by the proxy itself instead of upstream (e.g. HIT).
+	// Such responses are never stored. Empty if the response comes from upstream
+	status string
+	// whether the response was already stored in cache
+	stored bool
+	// whether the upstream response is a stream, passed through unbuffered and never stored
+	streaming bool
+	// data kept from an interrupted download, completed by a ranged request. nil if not resuming
+	partial     *http.Response
+	partialBody []byte
 }
 
 // New creates a new proxy server
 func New(cfg *config.Config) (*Server, error) {
-	cacheTTL, err := cfg.GetCacheTTL()
+	clockSkewThreshold, err := cfg.GetClockSkewThreshold()
 	if err != nil {
-		return nil, fmt.Errorf("invalid cache TTL: %w", err)
+		return nil, fmt.Errorf("invalid clock skew threshold: %w", err)
+	}
+
+	maxRequestBody, err := cfg.GetMaxRequestBody()
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache max request body: %w", err)
 	}
 
-	generic := cache.NewGenericDisk(cfg.Cache.Folder, cacheTTL)
-	if err := generic.Init(); err != nil {
-		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	if err := cfg.Upstream.Compile(); err != nil {
+		return nil, fmt.Errorf("invalid upstream hosts: %w", err)
+	}
+	if err := cfg.DNS.Compile(); err != nil {
+		return nil, fmt.Errorf("invalid dns overrides: %w", err)
+	}
+	if err := cfg.ResponseHeaders.Compile(); err != nil {
+		return nil, fmt.Errorf("invalid response headers: %w", err)
+	}
+	if err := cfg.Server.HTTPS.Transparent.Compile(); err != nil {
+		return nil, fmt.Errorf("invalid transparent https: %w", err)
+	}
+
+	cacheManager, err := NewCacheManager(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	idleConnTimeout, keepAlive, err := cfg.GetTransportDurations()
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream transport: %w", err)
+	}
+	dialer := &net.Dialer{KeepAlive: keepAlive}
+	transport := &http.Transport{
+		// Hosts may skip verification or trust more CAs with upstream.hosts
+		TLSClientConfig: &tls.Config{},
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return parentProxy(&cfg.Upstream, req)
+		},
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        cfg.Upstream.Transport.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.Upstream.Transport.MaxIdleConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+		DisableKeepAlives:   cfg.Upstream.Transport.DisableKeepAlives,
+		DisableCompression:  cfg.Upstream.Transport.DisableCompression,
+	}
+	var resolver *dnsResolver
+	if cfg.DNS.Enabled() {
+		resolver = newDNSResolver(&cfg.DNS, dialer)
+		// Also used by goproxy for tunnels, and inherited by the transports of upstream hosts
+		transport.DialContext = resolver.DialContext
 	}
-	cacheManager := httpcache.New(generic)
 
 	// Create goproxy instance
 	proxy := &goproxy.ProxyHttpServer{
 		Logger:  log.New(os.Stderr, "", log.LstdFlags),
-		Tr:      &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, Proxy: http.ProxyFromEnvironment},
+		Tr:      transport,
 		Verbose: cfg.Log.ThirdParty,
 		// Set up certificate storage for better performance during TLS interception
-		CertStore: &simpleCertStore{certs: make(map[string]*tls.Certificate)},
+		CertStore: newCertStore(cfg.Server.HTTPS.CertCacheSize),
+	}
+	// Without parent proxies configured, tunnels keep connecting directly
+	if slices.ContainsFunc(cfg.Upstream.Hosts, func(h config.UpstreamHost) bool { return h.Proxy != "" }) {
+		dialer := &tunnelDialer{proxy: proxy, config: &cfg.Upstream, dialers: make(map[string]func(network, addr string) (net.Conn, error))}
+		proxy.ConnectDialWithReq = dialer.dial
 	}
 	proxy.NonproxyHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		if req.Host == "" {
@@ -77,23 +192,154 @@ func New(cfg *config.Config) (*Server, error) {
 		req.URL.Scheme = "http"
 		req.URL.Host = req.Host
 		// Set source
-		req = req.WithContext(context.WithValue(req.Context(), ctxUserData{}, &ctxUserData{
+		req = req.WithContext(context.WithValue(req.Context(), ctxUserDataKey{}, &ctxUserData{
 			source: SrcHTTPTransparent,
 		}))
 		proxy.ServeHTTP(w, req)
 	})
 
-	// Convert config rules to Rule interfaces
-	rules := make([]Rule, len(cfg.Rules.Rules))
-	for i, rule := range cfg.Rules.Rules {
-		rules[i] = &ConfigRule{CacheRule: rule}
+	var historyRecorder *historyRecorder
+	if cfg.History.Enabled {
+		retention, err := cfg.GetHistoryRetention()
+		if err != nil {
+			return nil, fmt.Errorf("invalid history retention: %w", err)
+		}
+		db, err := history.Open(cfg.History.Path, retention, cfg.History.MaxRecords)
+		if err != nil {
+			return nil, err
+		}
+		historyRecorder = newHistoryRecorder(db)
+	}
+
+	var limiter *rateLimiter
+	if cfg.RateLimit.Enabled {
+		defaultRetryAfter, maxRetryAfter, err := cfg.GetRateLimitRetryAfter()
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit configuration: %w", err)
+		}
+		limiter = newRateLimiter(defaultRetryAfter, maxRetryAfter)
+	}
+
+	var decisions *decisionClient
+	if cfg.DecisionService.URL != "" {
+		timeout, err := cfg.GetDecisionServiceTimeout()
+		if err != nil {
+			return nil, fmt.Errorf("invalid decision service timeout: %w", err)
+		}
+		decisions = newDecisionClient(cfg.DecisionService.URL, timeout)
+	}
+
+	var peers *peerClient
+	if len(cfg.Peers.URLs) > 0 {
+		timeout, err := cfg.GetPeersTimeout()
+		if err != nil {
+			return nil, fmt.Errorf("invalid peers timeout: %w", err)
+		}
+		peers = newPeerClient(cfg.Peers.URLs, timeout, cfg.Peers.Token)
+	}
+
+	var scriptEngine *script.Engine
+	if cfg.Script.File != "" {
+		scriptEngine, err = script.Load(cfg.Script.File)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	transports, err := newUpstreamTransports(transport, &cfg.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	var upstream http.RoundTripper = transports
+	var h3 *http3Transport
+	if cfg.Upstream.ParentCache != "" {
+		// The parent cache connects to upstream hosts, with its own settings
+		if upstream, err = newParentCacheTransport(cfg.Upstream.ParentCache, transport); err != nil {
+			return nil, err
+		}
+	} else if cfg.Upstream.HTTP3.Enabled {
+		h3 = newHTTP3Transport(transports, cfg.Upstream.HTTP3.Hosts, resolver)
+		upstream = h3
+	}
+	if cfg.Upstream.Retry.Attempts > 0 {
+		if upstream, err = newRetryTransport(upstream, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	rules, err := configRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+	timeouts, err := cfg.Upstream.Timeouts.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream timeouts: %w", err)
+	}
+	passthrough, err := config.ParseHostPatterns(cfg.Server.HTTPS.PassthroughHosts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server https passthrough host: %w", err)
+	}
+	corsMaxAge, err := config.ParseOptionalDuration(cfg.CORS.MaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cors max_age: %w", err)
+	}
+	cacheTTL, err := cfg.GetCacheTTL()
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache TTL: %w", err)
+	}
+	staleTTL, err := cfg.GetStaleTTL()
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache stale TTL: %w", err)
+	}
+
+	requestLimit, err := newConcurrencyLimit("requests", cfg.Server.Limits.MaxRequests, &cfg.Server.Limits)
+	if err != nil {
+		return nil, err
+	}
+	handshakeLimit, err := newConcurrencyLimit("TLS handshakes", cfg.Server.Limits.MaxHandshakes, &cfg.Server.Limits)
+	if err != nil {
+		return nil, err
 	}
 
 	server := &Server{
-		config:       cfg,
-		cacheManager: cacheManager,
-		proxy:        proxy,
-		rules:        rules,
+		config:         cfg,
+		cacheManager:   cacheManager,
+		cacheWriter:    newCacheWriter(cacheManager, cfg.Cache.AsyncWrites.Workers, cfg.Cache.AsyncWrites.QueueSize),
+		proxy:          proxy,
+		upstream:       upstream,
+		http3:          h3,
+		rules:          rules,
+		timeouts:       timeouts,
+		passthrough:    passthrough,
+		corsMaxAge:     corsMaxAge,
+		cacheTTL:       cacheTTL,
+		staleTTL:       staleTTL,
+		stop:           make(chan struct{}),
+		clockSkew:      newClockSkewDetector(clockSkewThreshold),
+		history:        historyRecorder,
+		stats:          newRequestStats(),
+		storageSampler: &storageSampler{},
+		rateLimiter:    limiter,
+		decisions:      decisions,
+		peers:          peers,
+		script:         scriptEngine,
+		canaryReport:   &canaryReporter{path: cfg.Canary.Report},
+		requestLimit:   requestLimit,
+		handshakeLimit: handshakeLimit,
+		maxRequestBody: maxRequestBody,
+		pending:        make(map[string]*pendingFetch),
+	}
+
+	if cfg.Log.EventsFile != "" {
+		server.events = &eventLog{path: cfg.Log.EventsFile}
+	}
+
+	// Outermost, so the total timeout includes retries
+	server.upstream = &timeoutTransport{next: upstream, timeoutsFor: server.upstreamTimeouts}
+	if cfg.Prefetch.Enabled {
+		if server.prefetcher, err = newPrefetcher(server, &cfg.Prefetch); err != nil {
+			return nil, err
+		}
 	}
 
 	// Configure goproxy handlers
@@ -103,19 +349,43 @@ func New(cfg *config.Config) (*Server, error) {
 }
 
 func copyResponse(resp *http.Response) (*http.Response, error) {
-	bodyBytes, _ := io.ReadAll(resp.Body)
+	bodyBytes, readErr := io.ReadAll(resp.Body)
 	err := resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+	if readErr != nil {
+		return nil, &partialBodyError{body: bodyBytes, err: readErr}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to close response body: %w", err)
 	}
 
 	respCopy := *resp
 	respCopy.Body = io.NopCloser(bytes.NewReader(bodyBytes))
-	resp.Body = io.NopCloser(bytes.NewReader(bodyBytes))
 
 	return &respCopy, nil
 }
 
+// peekBody reads the request body and restores it
+func peekBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// withBody returns a shallow copy of the request with the given body
+func withBody(req *http.Request, body []byte) *http.Request {
+	reqCopy := req.WithContext(req.Context())
+	reqCopy.Body = io.NopCloser(bytes.NewReader(body))
+	reqCopy.ContentLength = int64(len(body))
+	return reqCopy
+}
+
 // setupProxyHandlers configures the goproxy handlers
 func (s *Server) setupProxyHandlers() {
 	// Handle CONNECT requests (HTTPS explicit proxying)
@@ -128,6 +398,7 @@ func (s *Server) setupProxyHandlers() {
 	s.proxy.OnRequest().DoFunc(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
 		// Start chrono
 		start := time.Now()
+		s.idle.touch()
 		logrus.Debugf("OnRequest(url=%s)", req.URL.String())
 
 		// Read user data
@@ -135,8 +406,7 @@ func (s *Server) setupProxyHandlers() {
 		if !ok {
 			// This is used for transparent HTTP proxying
 			// _ is to avoid error
-			userData, _ = req.Context().Value(ctxUserData{}).(*ctxUserData)
-			ctx.UserData = userData
+			userData, _ = req.Context().Value(ctxUserDataKey{}).(*ctxUserData)
 		}
 
 		// Set user data for plain HTTP request if not set
@@ -144,44 +414,194 @@ func (s *Server) setupProxyHandlers() {
 			userData = &ctxUserData{
 				source: SrcHTTPExplicit,
 			}
-			ctx.UserData = userData
+		} else {
+			// Requests of a same MITM connection share user data: do not leak per-request state
+			userData = &ctxUserData{
+				source:         userData.source,
+				clientIdentity: userData.clientIdentity,
+			}
 		}
+		ctx.UserData = userData
+		ctx.RoundTripper = goproxy.RoundTripperFunc(func(upstreamReq *http.Request, _ *goproxy.ProxyCtx) (*http.Response, error) {
+			return s.upstream.RoundTrip(upstreamReq)
+		})
+		req = forwardInterim(req)
 
 		// Set chrono
 		userData.start = start
 
+		// Released once the response is sent, or the client went away
+		release, ok := s.requestLimit.acquire(req.Context())
+		if !ok {
+			resp := goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusServiceUnavailable, "Too many concurrent requests, retry later\n")
+			resp.Header.Set("X-Cache", "OVERLOADED")
+			userData.status = "OVERLOADED"
+			return req, resp
+		}
+		context.AfterFunc(req.Context(), release)
+
+		// X-Cache-Explain: explain the caching decision in the response, without forwarding the header upstream
+		if req.Header.Get(explainHeader) != "" {
+			userData.explain = true
+			req.Header.Del(explainHeader)
+		}
+
+		// Before anything else, so rules and the cache key see the remapped URL, without the control parameter
+		s.applyControlParam(req)
+		s.remapOrigin(req)
+		s.scriptRequest(req)
+		if resp := s.interceptRequest(req); resp != nil {
+			logrus.Debugf("OnRequest(url=%s): Answered by an interceptor", req.URL.String())
+			resp.Header.Set("X-Cache", "INTERCEPTED")
+			userData.status = "INTERCEPTED"
+			return req, resp
+		}
+
+		// Browsers ask before cross-origin requests: answer for upstream, which may not support CORS
+		if isPreflight(req) && s.corsEnabled(req) {
+			logrus.Debugf("OnRequest(url=%s): Answering CORS preflight", req.URL.String())
+			resp := s.preflightResponse(req)
+			resp.Header.Set("X-Cache", "CORS")
+			userData.status = "CORS"
+			return req, resp
+		}
+
+		// Mocked and blocked endpoints never reach upstream
+		if rule := s.requestRule(req); rule != nil {
+			switch s.config.Rules.ActionOf(&rule.CacheRule) {
+			case config.RuleActionMock:
+				logrus.Debugf("OnRequest(url=%s): Serving mock response", req.URL.String())
+				resp := mockResponse(req, rule.Mock)
+				resp.Header.Set("X-Cache", "MOCK")
+				userData.status = "MOCK"
+				return req, resp
+			case config.RuleActionBlock:
+				logrus.Debugf("OnRequest(url=%s): Blocked by rule", req.URL.String())
+				resp := blockResponse(req, rule)
+				resp.Header.Set("X-Cache", "BLOCKED")
+				userData.status = "BLOCKED"
+				return req, resp
+			}
+		}
+
+		// Before the cache key is generated, so it reflects the forwarded request
+		s.injectRequestHeaders(req)
+
 		// X-Cache-Bypass: if present, skip cache entirely
 		if req.Header.Get("X-Cache-Bypass") != "" {
 			logrus.Debugf("OnRequest(url=%s): bypassing cache because of X-Cache-Bypass", req.URL.String())
 			userData.bypass = true
-			req.Header.Del("X-Cache-Bypass")
+			// The parent cache bypasses its cache too
+			if s.config.Upstream.ParentCache == "" {
+				req.Header.Del("X-Cache-Bypass")
+			}
+			return req, nil
+		}
+
+		// Cache control headers are not forwarded, except refreshes and TTLs to the parent cache, so it handles its entry the same way
+		purge := req.Header.Get(purgeHeader) != ""
+		userData.refresh = req.Header.Get(refreshHeader) != ""
+		userData.ttl = requestTTL(req)
+		req.Header.Del(purgeHeader)
+		if s.config.Upstream.ParentCache == "" {
+			req.Header.Del(refreshHeader)
+			req.Header.Del(ttlHeader)
+		}
+
+		// Larger bodies are streamed upstream rather than buffered to be hashed into the cache key
+		largeBody, err := s.largeBody(req)
+		if err != nil {
+			logrus.Errorf("OnRequest(url=%s): Failed to read request body: %v", req.URL.String(), err)
+			return req, nil
+		}
+		if largeBody {
+			logrus.Debugf("OnRequest(url=%s): Streaming request body larger than cache.max_request_body, not caching", req.URL.String())
+			userData.largeBody = true
 			return req, nil
 		}
 
 		// Generate cache key
-		key, err := s.cacheManager.GenerateKey(req)
+		key, err := s.cacheManager.GenerateKey(req, s.keyOptions(req, userData))
 		if err != nil {
 			logrus.Errorf("OnRequest(url=%s): Failed to generate cache key: %v", req.URL.String(), err)
 			return req, nil
 		}
-		userData.key = key
+		userData.key = s.scriptKey(req, key)
+		if purge {
+			return req, s.purgeResponse(req, userData)
+		}
+		if s.prefetcher != nil {
+			userData.requestHeader = req.Header.Clone()
+		}
+
+		// Keep the request body, as forwarding it upstream consumes it
+		userData.requestBody, err = peekBody(req)
+		if err != nil {
+			logrus.Errorf("OnRequest(url=%s): Failed to read request body: %v", req.URL.String(), err)
+			return req, nil
+		}
+
+		if userData.refresh {
+			logrus.Debugf("OnRequest(url=%s): Refreshing cached response because of %s", req.URL.String(), refreshHeader)
+			s.handleUpstreamErrors(req, ctx, userData)
+			return req, nil
+		}
+
+		switch s.config.Cache.Mode {
+		case config.CacheModeReplay:
+			return req, s.replayResponse(req, userData)
+		case config.CacheModeRecord:
+			logrus.Debugf("OnRequest(url=%s): Recording, querying upstream", req.URL.String())
+			s.handleUpstreamErrors(req, ctx, userData)
+			return req, nil
+		}
 
 		// Check if we have a cached response
-		cachedResp, err := s.cacheManager.GetKey(key)
+		cachedResp, err := s.cacheManager.GetKey(userData.key)
 		if err != nil {
 			logrus.Errorf("OnRequest(url=%s): Failed to get cached response: %v", req.URL.String(), err)
 			return req, nil
 		}
+		if cachedResp != nil && s.fromStore(cachedResp, time.Now()) {
+			logrus.Debugf("OnRequest(url=%s): Cached response expired", req.URL.String())
+			cachedResp = nil
+		}
 		if cachedResp != nil {
 			logrus.Debugf("OnRequest(url=%s): Serving from cache", req.URL.String())
 			cachedResp.Request = req
+			cachedResp = rangeResponse(req, cachedResp)
 			cachedResp.Header.Set("X-Cache", "HIT")
-			userData.hit = true
+			userData.status = "HIT"
 			return req, cachedResp
 		}
 
+		// Another proxy of the team may have it
+		if peerResp := s.peerResponse(req, userData.key); peerResp != nil {
+			logrus.Debugf("OnRequest(url=%s): Serving from a peer", req.URL.String())
+			peerResp.Request = req
+			peerResp = rangeResponse(req, peerResp)
+			peerResp.Header.Set("X-Cache", "PEER")
+			userData.status = "PEER"
+			return req, peerResp
+		}
+
+		// Do not hit an endpoint that asked to slow down
+		if until, limited := s.rateLimiter.Limited(req, time.Now()); limited {
+			logrus.Debugf("OnRequest(url=%s): Endpoint is rate limited until %v", req.URL.String(), until)
+			return req, s.rateLimitedResponse(req, userData, until)
+		}
+
+		// Answer with a placeholder if upstream is too slow, if configured
+		if placeholder := s.placeholderFor(req); placeholder != nil {
+			return req, s.fetchWithPlaceholder(req, ctx, userData, placeholder)
+		}
+
+		// Complete an interrupted download instead of starting from zero
+		s.prepareResume(req, userData)
+
 		// Continue with the request (will be handled by OnResponse)
 		logrus.Debugf("OnRequest(url=%s): Querying upstream", req.URL.String())
+		s.handleUpstreamErrors(req, ctx, userData)
 		return req, nil
 	})
 
@@ -198,31 +618,95 @@ func (s *Server) setupProxyHandlers() {
 			return nil
 		}
 
-		// If X-Cache-Bypass was set, mark header and skip cache logic
-		if userData.bypass {
+		if userData.partial != nil {
+			resp = s.resumedResponse(ctx.Req, resp, userData)
+		}
+
+		// Responses served from cache carry an old Date header
+		if userData.status == "" {
+			s.clockSkew.Check(resp, time.Now())
+			s.rateLimiter.Observe(ctx.Req, resp, time.Now())
+		}
+
+		userData.streaming = userData.status == "" && s.isStreaming(ctx.Req, resp)
+
+		// Transform upstream responses before they are cached
+		if userData.status == "" && !userData.streaming {
+			resp = s.rewriteResponse(ctx.Req, resp)
+			resp = s.scriptResponse(ctx.Req, resp)
+		}
+
+		// If X-Cache-Bypass was set, or the request body was too large, mark header and skip cache logic
+		if userData.bypass || userData.largeBody {
 			resp.Header.Set("X-Cache", "BYPASS")
+		} else if userData.streaming {
+			passStream(ctx.Req, resp)
 		} else {
 			// Cache the response if it should be cached and it's not already a cache hit
-			isCacheHit := resp.Header.Get("X-Cache") == "HIT"
-			if !isCacheHit && s.shouldBeCached(ctx.Req, resp) {
-				respCopy, err := copyResponse(resp)
-				if err != nil {
-					logrus.Errorf("Onresponse(url=%s): Failed to copy response for caching: %v", ctx.Req.URL.String(), err)
-				} else {
-					if err := s.cacheManager.SetKey(userData.key, respCopy); err != nil {
-						logrus.Errorf("OnResponse(url=%s): Failed to cache response: %v", ctx.Req.URL.String(), err)
+			cacheable := false
+			if userData.status == "" {
+				var ttl time.Duration
+				cacheable, ttl = s.cacheDecision(ctx.Req, resp)
+				ttl = overrideTTL(ttl, userData.ttl)
+				if s.config.Cache.Mode == config.CacheModeRecord && resp.StatusCode != http.StatusPartialContent {
+					cacheable = true // store everything, regardless of the rules
+				}
+				if cacheable && !userData.stored {
+					respCopy, err := copyResponse(resp)
+					if err != nil {
+						logrus.Errorf("Onresponse(url=%s): Failed to copy response for caching: %v", ctx.Req.URL.String(), err)
+						var partialErr *partialBodyError
+						if errors.As(err, &partialErr) {
+							s.savePartial(userData.key, ctx.Req, resp, partialErr.body)
+							// Do not let the client take the truncated body for the full one
+							resp = goproxy.NewResponse(ctx.Req, goproxy.ContentTypeText, http.StatusBadGateway, partialErr.Error())
+							resp.Header.Set("X-Cache", "ERROR")
+							userData.status = "ERROR"
+						}
+					} else {
+						respCopy.Request = withBody(ctx.Req, userData.requestBody)
+						stored, err := s.toStore(respCopy, ttl)
+						if err == nil {
+							err = s.cacheWriter.store(userData.key, stored)
+						}
+						if err != nil {
+							logrus.Errorf("OnResponse(url=%s): Failed to cache response: %v", ctx.Req.URL.String(), err)
+						} else {
+							if digest := stored.Header.Get(digestHeader); digest != "" {
+								resp.Header.Set(digestHeader, digest)
+							}
+							s.prefetcher.visit(ctx.Req, resp, userData)
+						}
 					}
 				}
 			}
 
 			// Add cache information header, only if not already set (to avoid overwriting cache hits)
-			if !userData.hit {
-				if s.shouldBeCached(ctx.Req, resp) {
+			if userData.status == "" {
+				if cacheable && userData.refresh {
+					resp.Header.Set("X-Cache", "REFRESH")
+				} else if cacheable {
 					resp.Header.Set("X-Cache", "MISS")
 				} else {
 					resp.Header.Set("X-Cache", "DISABLED")
 				}
 			}
+
+			// Compare with the candidate upstream, if configured
+			if userData.status == "" {
+				resp = s.compareCanary(ctx.Req, resp, userData)
+			}
+		}
+
+		if userData.explain {
+			resp.Header.Set(explainHeader, s.explainResponse(ctx.Req, resp, userData))
+		}
+
+		if s.config.Cache.DebugHeaders && userData.key != "" {
+			resp.Header.Set("X-Cache-Key", userData.key)
+			if userData.status == "" {
+				resp.Header.Set("X-Cache-Age", "0") // fresh from upstream
+			}
 		}
 
 		// See https://github.com/elazarl/goproxy/issues/696
@@ -230,13 +714,51 @@ func (s *Server) setupProxyHandlers() {
 			logrus.Errorf("Failed to close request body: %v", err)
 		}
 
+		resp = s.interceptResponse(ctx.Req, resp)
+
+		// After caching, so stored entries keep the headers of upstream
+		if userData.status != "CORS" && s.corsEnabled(ctx.Req) {
+			setCORSHeaders(ctx.Req, resp, &s.config.CORS)
+		}
+		s.overrideResponseHeaders(ctx.Req, resp)
+
+		// Simulated slow network, counted in the logged duration
+		s.injectLatency(ctx.Req, userData)
+
 		// Last thing to do: check time taken
 		end := time.Now()
 		duration := end.Sub(userData.start)
-		logrus.Infof("%s %v %v <- %v %v (%v)", userData.source, resp.StatusCode, resp.Header.Get("X-Cache"), ctx.Req.Method, ctx.Req.URL.String(), roundDuration(duration))
+		s.logRequest(ctx.Req, resp, userData, duration)
+		s.history.Record(ctx.Req, resp, userData, duration)
+		s.recordEvent(ctx.Req, resp, userData, duration)
+		s.stats.Record(ctx.Req.URL.Hostname(), resp.Header.Get("X-Cache"), resp.ContentLength, duration)
 
 		return resp
 	})
+	// Last, as it must see the final response
+	s.proxy.OnResponse().DoFunc(sendTrailers)
+}
+
+// logRequest logs a handled request: a human readable line, or a record with one field per value in JSON format
+func (s *Server) logRequest(requ *http.Request, resp *http.Response, userData *ctxUserData, duration time.Duration) {
+	if s.config.Log.Format != "json" {
+		logrus.Infof("%s %v %v <- %v %v (%v)", userData.source, resp.StatusCode, resp.Header.Get("X-Cache"), requ.Method, requ.URL.String(), roundDuration(duration))
+		return
+	}
+
+	clientIP := requ.RemoteAddr
+	if host, _, err := net.SplitHostPort(requ.RemoteAddr); err == nil {
+		clientIP = host
+	}
+	logrus.WithFields(logrus.Fields{
+		"source":       strings.TrimSpace(userData.source),
+		"method":       requ.Method,
+		"url":          requ.URL.String(),
+		"status":       resp.StatusCode,
+		"cache_status": resp.Header.Get("X-Cache"),
+		"duration_ms":  duration.Milliseconds(),
+		"client_ip":    clientIP,
+	}).Info("request")
 }
 
 func roundDuration(d time.Duration) string {
@@ -249,7 +771,7 @@ func roundDuration(d time.Duration) string {
 	}
 }
 
-// Start starts the proxy server
+// Start starts the proxy server. Returns nil when it stopped after server.exit_when_idle
 func (s *Server) Start() error {
 	logrus.Infof("Starting caching proxy at %v", s.config.Server.HTTP.Address)
 	logrus.Debugf("Cache directory: %s", s.config.Cache.Folder)
@@ -265,8 +787,113 @@ func (s *Server) Start() error {
 	} else {
 		logrus.Debugf("TLS interception: disabled")
 	}
+	if s.config.Server.HTTP.Transparent.Address != "" {
+		go s.StartTransparentHTTP(s.config.Server.HTTP.Transparent.Address)
+		logrus.Infof("Transparent HTTP proxying enabled at %s", s.config.Server.HTTP.Transparent.Address)
+	}
+	if len(s.config.Maintenance.Windows) > 0 {
+		scheduler, err := s.newMaintenanceScheduler()
+		if err != nil {
+			return err
+		}
+		go scheduler.Run(s.stop)
+		logrus.Infof("Maintenance windows: %v", s.config.Maintenance.Windows)
+	}
+	for _, pinned := range s.config.Pinned.URLs {
+		schedule, err := cron.Parse(pinned.Schedule)
+		if err != nil {
+			return fmt.Errorf("invalid schedule of pinned URL %s: %w", pinned.URL, err)
+		}
+		go s.refreshPinned(pinned, schedule)
+	}
+	if interval, err := s.config.GetStatsInterval(); err != nil {
+		return err
+	} else if interval > 0 {
+		go s.stats.logSummaries(interval)
+	}
+	if interval, err := s.config.GetStorageSamplingInterval(); err != nil {
+		return err
+	} else if interval > 0 {
+		go s.sampleStorageEvery(interval)
+	}
+	if s.config.Admin.Address != "" {
+		if err := s.StartAdmin(s.config.Admin.Address); err != nil {
+			return fmt.Errorf("failed to start admin API: %w", err)
+		}
+		logrus.Infof("Admin API enabled at %s", s.config.Admin.Address)
+	}
+	if s.config.Peers.Address != "" {
+		if err := s.StartPeers(s.config.Peers.Address); err != nil {
+			return fmt.Errorf("failed to start peer endpoint: %w", err)
+		}
+		logrus.Infof("Peer endpoint enabled at %s", s.config.Peers.Address)
+		if s.config.Peers.Token == "" {
+			logrus.Warnf("Peer endpoint has no token: anyone reaching %s can read the cache", s.config.Peers.Address)
+		}
+	}
+
+	server, err := s.newHTTPServer()
+	if err != nil {
+		return err
+	}
+	ln, err := listen("http", 0, s.config.Server.HTTP.Address)
+	if err != nil {
+		return err
+	}
+	exitWhenIdle, err := config.ParseOptionalDuration(s.config.Server.ExitWhenIdle)
+	if err != nil {
+		return fmt.Errorf("invalid server exit_when_idle: %w", err)
+	}
+	if exitWhenIdle > 0 {
+		s.idle.touch()
+		go s.shutdownWhenIdle(server, exitWhenIdle)
+	}
+	s.httpServerMu.Lock()
+	if s.stopping {
+		s.httpServerMu.Unlock()
+		_ = ln.Close()
+		return nil
+	}
+	s.httpServer = server
+	s.httpServerMu.Unlock()
+	err = server.Serve(newLimitListener(ln, s.config.Server.Limits.MaxConnections))
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
 
-	return http.ListenAndServe(s.config.Server.HTTP.Address, s.proxy)
+// Shutdown stops the HTTP listener, making Start return, and waits for the requests it is handling to be answered, until ctx is done.
+// Requests of intercepted HTTPS connections and of the transparent listeners may still be handled afterwards
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.httpServerMu.Lock()
+	s.stopping = true
+	server := s.httpServer
+	s.httpServerMu.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
+
+// Close releases the resources of the server, e.g. saves the memory cache snapshot.
+// Call it once Start returned: responses cached by requests still being handled are then stored synchronously, and not prefetched
+func (s *Server) Close() error {
+	close(s.stop)
+	s.prefetcher.close()
+	s.cacheWriter.close()
+	if err := s.cacheManager.Close(); err != nil {
+		return fmt.Errorf("failed to close cache: %w", err)
+	}
+	if err := s.history.close(); err != nil {
+		return fmt.Errorf("failed to close request history: %w", err)
+	}
+	if s.http3 != nil {
+		if err := s.http3.Close(); err != nil {
+			return fmt.Errorf("failed to close HTTP/3 connections: %w", err)
+		}
+	}
+	return nil
 }
 
 // GetProxy returns the underlying goproxy instance for testing
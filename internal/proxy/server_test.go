@@ -1,11 +1,18 @@
 package proxy
 
 import (
+	"bytes"
+	"encoding/json"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/iTrooz/caching-dev-proxy/internal/config"
+
+	"github.com/sirupsen/logrus"
 )
 
 func TestNew(t *testing.T) {
@@ -20,6 +27,71 @@ func TestNew(t *testing.T) {
 	}
 }
 
+// A busy admin address is an error returned by StartAdmin, not a fatal exit bypassing Close
+func TestStartAdminListenError(t *testing.T) {
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeBlacklist},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	if err := s.StartAdmin(ln.Addr().String()); err == nil {
+		t.Error("StartAdmin() on a busy address error = nil, want an error")
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+// New does not require a validated config: invalid patterns and durations are errors, not panics
+func TestNewInvalidConfig(t *testing.T) {
+	tests := map[string]func(cfg *config.Config){
+		"rewrite expression": func(cfg *config.Config) {
+			cfg.Rules.Rules = []config.CacheRule{{BaseURI: "http://example.com", Rewrite: &config.RewriteConfig{Body: []config.BodyReplacement{{Find: "("}}}}}
+		},
+		"rule glob": func(cfg *config.Config) {
+			cfg.Rules.Rules = []config.CacheRule{{BaseURI: "https:///**"}}
+		},
+		"latency": func(cfg *config.Config) {
+			cfg.Rules.Rules = []config.CacheRule{{BaseURI: "http://example.com", Latency: &config.LatencyConfig{Delay: "soon"}}}
+		},
+		"upstream host": func(cfg *config.Config) {
+			cfg.Upstream.Hosts = []config.UpstreamHost{{Match: []string{"example.com:443"}}}
+		},
+		"dns override": func(cfg *config.Config) {
+			cfg.DNS.Overrides = []config.DNSOverride{{Match: []string{"10.0.0.0/33"}, Address: "127.0.0.1"}}
+		},
+		"passthrough host": func(cfg *config.Config) {
+			cfg.Server.HTTPS.PassthroughHosts = []string{""}
+		},
+		"cors max age": func(cfg *config.Config) {
+			cfg.CORS.MaxAge = "forever"
+		},
+		"response header": func(cfg *config.Config) {
+			cfg.ResponseHeaders.Overrides = []config.ResponseHeaderOverride{{Match: []string{}}}
+		},
+		"prefetch pattern": func(cfg *config.Config) {
+			cfg.Prefetch = config.PrefetchConfig{Enabled: true, Patterns: []string{"example.com"}}
+		},
+	}
+	for name, invalidate := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := &config.Config{Cache: config.CacheConfig{TTL: "1h", Folder: t.TempDir()}}
+			invalidate(cfg)
+			if _, err := New(cfg); err == nil {
+				t.Errorf("New() succeeded, want an error")
+			}
+		})
+	}
+}
+
 func TestConfigRuleMatchWithStatusCodes(t *testing.T) {
 	rule := &ConfigRule{
 		CacheRule: config.CacheRule{
@@ -88,3 +160,227 @@ func TestConfigRuleMatchWithStatusCodes(t *testing.T) {
 		})
 	}
 }
+
+func TestClockSkew(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		date   string
+		want   time.Duration
+		wantOk bool
+	}{
+		{name: "no date header", date: "", wantOk: false},
+		{name: "invalid date header", date: "yesterday", wantOk: false},
+		{name: "in sync", date: now.Format(http.TimeFormat), want: 0, wantOk: true},
+		{name: "local clock ahead", date: now.Add(-time.Hour).Format(http.TimeFormat), want: time.Hour, wantOk: true},
+		{name: "local clock behind", date: now.Add(time.Hour).Format(http.TimeFormat), want: -time.Hour, wantOk: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.date != "" {
+				resp.Header.Set("Date", tt.date)
+			}
+
+			got, ok := clockSkew(resp, now)
+			if ok != tt.wantOk {
+				t.Fatalf("clockSkew() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if got != tt.want {
+				t.Errorf("clockSkew() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogRequestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logrus.SetOutput(&buf)
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+	defer func() {
+		logrus.SetOutput(os.Stderr)
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	}()
+
+	s := &Server{config: &config.Config{Log: config.LogConfig{Format: "json"}}}
+	requ, _ := http.NewRequest("GET", "http://example.com/path", nil)
+	requ.RemoteAddr = "192.0.2.1:54321"
+	resp := &http.Response{StatusCode: 200, Header: http.Header{"X-Cache": []string{"HIT"}}}
+	s.logRequest(requ, resp, &ctxUserData{source: SrcHTTPExplicit}, 1500*time.Millisecond)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("log line is not JSON: %q", buf.String())
+	}
+	want := map[string]any{
+		"method":       "GET",
+		"url":          "http://example.com/path",
+		"status":       float64(200),
+		"cache_status": "HIT",
+		"duration_ms":  float64(1500),
+		"client_ip":    "192.0.2.1",
+	}
+	for k, v := range want {
+		if record[k] != v {
+			t.Errorf("log field %s = %v, want %v", k, record[k], v)
+		}
+	}
+}
+
+func TestGlobRuleMatchRequest(t *testing.T) {
+	rules, err := configRules(&config.Config{Rules: config.RulesConfig{Rules: []config.CacheRule{
+		{BaseURI: "https://*.googleapis.com/storage/**", Methods: []string{"GET"}},
+	}}})
+	if err != nil {
+		t.Fatalf("configRules() error = %v", err)
+	}
+
+	for target, want := range map[string]bool{
+		"https://www.googleapis.com/storage/v1/b": true,
+		"https://www.googleapis.com/drive/v3":     false,
+		"https://googleapis.com/storage/v1/b":     false,
+	} {
+		requ, _ := http.NewRequest(http.MethodGet, target, nil)
+		if got := rules[0].MatchRequest(requ); got != want {
+			t.Errorf("MatchRequest(%s) = %v, want %v", target, got, want)
+		}
+	}
+}
+
+func TestConfigRuleMatchWithHeaders(t *testing.T) {
+	rule := &ConfigRule{
+		CacheRule: config.CacheRule{
+			BaseURI:       "https://api.example.com",
+			Methods:       []string{"GET"},
+			MatchHeaders:  map[string]string{"x-requested-with": "fetch", "Accept": ""},
+			AbsentHeaders: []string{"Authorization"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   bool
+	}{
+		{name: "all conditions met", header: http.Header{"X-Requested-With": {"fetch"}, "Accept": {"*/*"}}, want: true},
+		{name: "wrong value", header: http.Header{"X-Requested-With": {"xhr"}, "Accept": {"*/*"}}, want: false},
+		{name: "missing header", header: http.Header{"X-Requested-With": {"fetch"}}, want: false},
+		{name: "absent header present", header: http.Header{"X-Requested-With": {"fetch"}, "Accept": {"*/*"}, "Authorization": {"Bearer x"}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requ, _ := http.NewRequest(http.MethodGet, "https://api.example.com/users", nil)
+			requ.Header = tt.header
+			if got := rule.MatchRequest(requ); got != tt.want {
+				t.Errorf("ConfigRule.MatchRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigRuleMatchWithQuery(t *testing.T) {
+	rule := &ConfigRule{
+		CacheRule: config.CacheRule{
+			BaseURI:     "https://api.example.com",
+			Methods:     []string{"GET"},
+			MatchQuery:  map[string]string{"page": "", "format": "json"},
+			AbsentQuery: []string{"live"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   bool
+	}{
+		{name: "all conditions met", target: "https://api.example.com/items?page=2&format=json", want: true},
+		{name: "wrong value", target: "https://api.example.com/items?page=2&format=xml", want: false},
+		{name: "missing parameter", target: "https://api.example.com/items?format=json", want: false},
+		{name: "absent parameter present", target: "https://api.example.com/items?page=2&format=json&live=1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requ, _ := http.NewRequest(http.MethodGet, tt.target, nil)
+			if got := rule.MatchRequest(requ); got != tt.want {
+				t.Errorf("ConfigRule.MatchRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigRuleMatchWithExcept(t *testing.T) {
+	rules, err := configRules(&config.Config{Rules: config.RulesConfig{Rules: []config.CacheRule{{
+		BaseURI: "https://api.example.com",
+		Methods: []string{"GET"},
+		Except:  []string{"/auth/", "/realtime/*", "https://api.example.com/v1/*/private"},
+	}}}})
+	if err != nil {
+		t.Fatalf("configRules() error = %v", err)
+	}
+
+	for target, want := range map[string]bool{
+		"https://api.example.com/users":             true,
+		"https://api.example.com/auth/token":        false,
+		"https://api.example.com/realtime/feed/1":   false,
+		"https://api.example.com/v1/users/private":  false,
+		"https://api.example.com/v1/users/public":   true,
+		"https://api.example.com/users?next=/auth/": true,
+	} {
+		requ, _ := http.NewRequest(http.MethodGet, target, nil)
+		if got := rules[0].MatchRequest(requ); got != want {
+			t.Errorf("MatchRequest(%s) = %v, want %v", target, got, want)
+		}
+	}
+}
+
+func TestConfigRuleMatchWithWhen(t *testing.T) {
+	rules, err := configRules(&config.Config{Rules: config.RulesConfig{Rules: []config.CacheRule{
+		{BaseURI: "https://api.example.com", Methods: []string{"GET"}, When: `req.header["X-Tenant"] == "demo"`},
+		{BaseURI: "https://api.example.com", Methods: []string{"GET"}, When: `resp.status < 300 && !("Set-Cookie" in resp.header)`},
+	}}})
+	if err != nil {
+		t.Fatalf("configRules() error = %v", err)
+	}
+
+	requ, _ := http.NewRequest(http.MethodGet, "https://api.example.com/users", nil)
+	if rules[0].MatchRequest(requ) {
+		t.Errorf("request without X-Tenant header matched")
+	}
+	requ.Header.Set("X-Tenant", "demo")
+	if !rules[0].MatchRequest(requ) {
+		t.Errorf("request with X-Tenant header did not match")
+	}
+
+	if !rules[1].MatchRequest(requ) {
+		t.Errorf("expression on the response must not be evaluated on the request alone")
+	}
+	if !rules[1].Match(requ, &http.Response{StatusCode: 200, Header: http.Header{}}) {
+		t.Errorf("200 response without cookie did not match")
+	}
+	if rules[1].Match(requ, &http.Response{StatusCode: 200, Header: http.Header{"Set-Cookie": {"a=b"}}}) {
+		t.Errorf("response with cookie matched")
+	}
+}
+
+func TestUpstreamTransportSettings(t *testing.T) {
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeWhitelist},
+		Upstream: config.UpstreamConfig{Transport: config.TransportConfig{
+			MaxIdleConns:        500,
+			MaxIdleConnsPerHost: 64,
+			IdleConnTimeout:     "30s",
+			DisableCompression:  true,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	tr := s.proxy.Tr
+	if tr.MaxIdleConns != 500 || tr.MaxIdleConnsPerHost != 64 || tr.IdleConnTimeout != 30*time.Second || !tr.DisableCompression || tr.DisableKeepAlives {
+		t.Errorf("transport settings not applied: MaxIdleConns=%d MaxIdleConnsPerHost=%d IdleConnTimeout=%s DisableCompression=%v DisableKeepAlives=%v",
+			tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout, tr.DisableCompression, tr.DisableKeepAlives)
+	}
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/admin"
+
+	"github.com/sirupsen/logrus"
+)
+
+// number of hosts listed in summary logs
+const summaryTopHosts = 5
+
+// requestStats counts the requests handled since startup, by X-Cache status and by host
+type requestStats struct {
+	start time.Time
+
+	mu            sync.Mutex
+	requests      int64
+	byCacheStatus map[string]int64
+	byHost        map[string]*hostCounters
+	// requests at the last summary log, to skip idle periods
+	summarized int64
+}
+
+type hostCounters struct {
+	admin.HostStats
+	// to estimate the time saved by hits
+	missDuration time.Duration
+}
+
+func newRequestStats() *requestStats {
+	return &requestStats{
+		start:         time.Now(),
+		byCacheStatus: make(map[string]int64),
+		byHost:        make(map[string]*hostCounters),
+	}
+}
+
+// Record counts a handled request. size is the response body size, -1 if unknown
+func (s *requestStats) Record(host string, cacheStatus string, size int64, duration time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.byCacheStatus[cacheStatus]++
+
+	counters, ok := s.byHost[host]
+	if !ok {
+		counters = &hostCounters{}
+		s.byHost[host] = counters
+	}
+	counters.Requests++
+	switch cacheStatus {
+	case "HIT", "PEER", "STALE":
+		if cacheStatus != "STALE" {
+			counters.Hits++
+		}
+		if size > 0 {
+			counters.BytesSaved += size
+		}
+	case "MISS", "REFRESH":
+		counters.Misses++
+		counters.missDuration += duration
+	case "BYPASS":
+		counters.Bypasses++
+	}
+}
+
+// timeSaved estimates the upstream time saved by hits, from the average duration of misses
+func (c *hostCounters) timeSaved() time.Duration {
+	if c.Misses == 0 {
+		return 0
+	}
+	return time.Duration(int64(c.missDuration) / c.Misses * c.Hits)
+}
+
+// Snapshot returns the current counters
+func (s *requestStats) Snapshot() admin.RequestStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byCacheStatus := make(map[string]int64, len(s.byCacheStatus))
+	for status, count := range s.byCacheStatus {
+		byCacheStatus[status] = count
+	}
+	byHost := make(map[string]admin.HostStats, len(s.byHost))
+	for host, counters := range s.byHost {
+		stats := counters.HostStats
+		stats.TimeSaved = counters.timeSaved()
+		byHost[host] = stats
+	}
+	return admin.RequestStats{
+		Since:         s.start,
+		Requests:      s.requests,
+		ByCacheStatus: byCacheStatus,
+		ByHost:        byHost,
+	}
+}
+
+// Summary returns a one line summary of the counters, or "" if no request was handled since the last summary
+func (s *requestStats) Summary() string {
+	s.mu.Lock()
+	idle := s.requests == s.summarized
+	s.summarized = s.requests
+	s.mu.Unlock()
+	if idle {
+		return ""
+	}
+
+	stats := s.Snapshot()
+	var hits, bytesSaved int64
+	var timeSaved time.Duration
+	hosts := make([]string, 0, len(stats.ByHost))
+	for host, hostStats := range stats.ByHost {
+		hits += hostStats.Hits
+		bytesSaved += hostStats.BytesSaved
+		timeSaved += hostStats.TimeSaved
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		a, b := stats.ByHost[hosts[i]], stats.ByHost[hosts[j]]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return hosts[i] < hosts[j]
+	})
+
+	top := []string{}
+	for _, host := range hosts[:min(len(hosts), summaryTopHosts)] {
+		hostStats := stats.ByHost[host]
+		top = append(top, fmt.Sprintf("%s (%d requests, %d hits)", host, hostStats.Requests, hostStats.Hits))
+	}
+	return fmt.Sprintf("%d requests since %s, %.1f%% hits, saved %s and ~%v of upstream time. Top hosts: %s",
+		stats.Requests, stats.Since.Format(time.DateTime), 100*float64(hits)/float64(stats.Requests),
+		formatBytes(bytesSaved), timeSaved.Round(time.Second), strings.Join(top, ", "))
+}
+
+// logSummaries logs a summary of the counters at each interval
+func (s *requestStats) logSummaries(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if summary := s.Summary(); summary != "" {
+			logrus.Infof("Stats: %s", summary)
+		}
+	}
+}
+
+// formatBytes formats a size with a binary unit, e.g. "1.5 MiB"
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
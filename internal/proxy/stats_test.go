@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestStats(t *testing.T) {
+	stats := newRequestStats()
+	if summary := stats.Summary(); summary != "" {
+		t.Errorf("summary without requests = %q, want empty", summary)
+	}
+
+	stats.Record("a.example.com", "MISS", 100, 2*time.Second)
+	stats.Record("a.example.com", "HIT", 100, time.Millisecond)
+	stats.Record("a.example.com", "HIT", -1, time.Millisecond)
+	stats.Record("a.example.com", "STALE", 50, time.Millisecond)
+	stats.Record("b.example.com", "BYPASS", 10, time.Second)
+
+	snapshot := stats.Snapshot()
+	a := snapshot.ByHost["a.example.com"]
+	if a.Requests != 4 || a.Hits != 2 || a.Misses != 1 || a.BytesSaved != 150 || a.TimeSaved != 4*time.Second {
+		t.Errorf("unexpected stats for a.example.com: %+v", a)
+	}
+	if b := snapshot.ByHost["b.example.com"]; b.Requests != 1 || b.Bypasses != 1 || b.BytesSaved != 0 {
+		t.Errorf("unexpected stats for b.example.com: %+v", b)
+	}
+
+	summary := stats.Summary()
+	if !strings.Contains(summary, "5 requests") || !strings.Contains(summary, "40.0% hits") || !strings.Contains(summary, "Top hosts: a.example.com (4 requests, 2 hits), b.example.com") {
+		t.Errorf("unexpected summary: %q", summary)
+	}
+	if summary := stats.Summary(); summary != "" {
+		t.Errorf("summary without new requests = %q, want empty", summary)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 30:         "3.0 GiB",
+	}
+	for size, expected := range tests {
+		if got := formatBytes(size); got != expected {
+			t.Errorf("formatBytes(%d) = %q, want %q", size, got, expected)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/admin"
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Thresholds above which storage settings are recommended for a host
+const (
+	// compressed bodies are at most this fraction of their size
+	compressionRecommendationRatio = 0.6
+	// sampled bodies weigh at least this many bytes, so savings are worth it
+	compressionRecommendationBytes = 64 * 1024
+	// at least this fraction of sampled bodies are duplicates
+	dedupRecommendationRate = 0.25
+	// at least this many entries are sampled, so the rate is meaningful
+	dedupRecommendationEntries = 4
+)
+
+// storageSampler keeps the last sample of stored entries
+type storageSampler struct {
+	mu   sync.Mutex
+	last *admin.StorageSample
+}
+
+// Last returns the last sample, nil if none was taken
+func (s *storageSampler) Last() *admin.StorageSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// sampleStorageEvery samples stored entries now, then at each interval, and logs the recommendations
+func (s *Server) sampleStorageEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ; ; <-ticker.C {
+		sample, err := s.sampleStorage(s.config.StorageSampling.Entries, rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())))
+		if err != nil {
+			logrus.Errorf("Failed to sample stored entries: %v", err)
+			continue
+		}
+		s.storageSampler.mu.Lock()
+		s.storageSampler.last = sample
+		s.storageSampler.mu.Unlock()
+
+		logrus.Infof("Storage sampling: analyzed %d of %d stored entries", sample.Entries, sample.Total)
+		for _, recommendation := range sample.Recommendations {
+			logrus.Infof("Storage sampling: %s", recommendation)
+		}
+	}
+}
+
+// sampleStorage draws up to n stored entries at random, and measures how well their bodies compress and how often they are duplicated
+func (s *Server) sampleStorage(n int, rng *rand.Rand) (*admin.StorageSample, error) {
+	sample := &admin.StorageSample{Time: time.Now(), ByHost: map[string]admin.HostStorageSample{}, Recommendations: []string{}}
+
+	keys := make([]string, 0, n)
+	err := s.cacheManager.Walk("", func(info cache.EntryInfo) error {
+		if strings.HasSuffix(info.Key, partialSuffix) {
+			return nil
+		}
+		sample.Total++
+		if len(keys) < n {
+			keys = append(keys, info.Key)
+		} else if i := rng.Int64N(sample.Total); i < int64(n) {
+			keys[i] = info.Key
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list entries: %w", err)
+	}
+	// Duplicates are detected in a stable order
+	sort.Strings(keys)
+
+	seen := map[[sha256.Size]byte]bool{}
+	for _, key := range keys {
+		resp, err := s.cacheManager.GetStaleKey(key)
+		if err != nil || resp == nil {
+			continue // removed or unreadable meanwhile
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			continue
+		}
+		host := "unknown"
+		if resp.Request != nil && resp.Request.URL != nil {
+			host = resp.Request.URL.Hostname()
+		}
+
+		compressed, err := gzipSize(body)
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256(body)
+
+		hostSample := sample.ByHost[host]
+		hostSample.Entries++
+		hostSample.Bytes += int64(len(body))
+		hostSample.CompressedBytes += compressed
+		if seen[digest] && len(body) > 0 {
+			hostSample.DuplicateBodies++
+		}
+		seen[digest] = true
+		sample.ByHost[host] = hostSample
+		sample.Entries++
+	}
+
+	hosts := make([]string, 0, len(sample.ByHost))
+	for host, hostSample := range sample.ByHost {
+		if hostSample.Bytes > 0 {
+			hostSample.CompressionRatio = float64(hostSample.CompressedBytes) / float64(hostSample.Bytes)
+		}
+		hostSample.DuplicateRate = float64(hostSample.DuplicateBodies) / float64(hostSample.Entries)
+		sample.ByHost[host] = hostSample
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		hostSample := sample.ByHost[host]
+		if hostSample.Bytes >= compressionRecommendationBytes && hostSample.CompressionRatio <= compressionRecommendationRatio {
+			sample.Recommendations = append(sample.Recommendations, fmt.Sprintf(
+				"enable compression for %s: its bodies compress to %.0f%% of their size (%s sampled)",
+				host, 100*hostSample.CompressionRatio, formatBytes(hostSample.Bytes)))
+		}
+		if hostSample.Entries >= dedupRecommendationEntries && hostSample.DuplicateRate >= dedupRecommendationRate {
+			sample.Recommendations = append(sample.Recommendations, fmt.Sprintf(
+				"enable deduplication for %s: %.0f%% of its sampled bodies are duplicates",
+				host, 100*hostSample.DuplicateRate))
+		}
+	}
+	return sample, nil
+}
+
+// gzipSize returns the size of data once gzip-compressed
+func gzipSize(data []byte) (int64, error) {
+	counter := &countingWriter{}
+	writer := gzip.NewWriter(counter)
+	if _, err := writer.Write(data); err != nil {
+		return 0, fmt.Errorf("failed to compress body: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return 0, fmt.Errorf("failed to compress body: %w", err)
+	}
+	return counter.n, nil
+}
+
+// countingWriter discards written data, counting its size
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
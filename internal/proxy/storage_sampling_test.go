@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"bytes"
+	cryptorand "crypto/rand"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/cache"
+	"github.com/iTrooz/caching-dev-proxy/internal/cache/httpcache"
+)
+
+func TestSampleStorage(t *testing.T) {
+	s := &Server{cacheManager: httpcache.NewHTTP(cache.NewGenericMemory(cache.MemoryOptions{}))}
+	store := func(key string, rawURL string, body []byte) {
+		req, _ := http.NewRequest("GET", rawURL, nil)
+		resp := &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(body)), Request: req}
+		if err := s.cacheManager.SetKey(key, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	text := []byte(strings.Repeat("compressible text ", 10000))
+	for i, key := range []string{"a/1", "a/2", "a/3", "a/4"} {
+		body := text
+		if i%2 == 1 {
+			body = append([]byte("other "), text...)
+		}
+		store(key, "http://a.example.com/", body)
+	}
+	random := make([]byte, 100*1024)
+	_, _ = cryptorand.Read(random)
+	store("b/1", "http://b.example.com/", random)
+	store("b/1"+partialSuffix, "http://b.example.com/", random)
+
+	sample, err := s.sampleStorage(10, rand.New(rand.NewPCG(1, 1)))
+	if err != nil {
+		t.Fatalf("sampleStorage() error = %v", err)
+	}
+	if sample.Entries != 5 || sample.Total != 5 {
+		t.Errorf("sampled %d of %d entries, want 5 of 5", sample.Entries, sample.Total)
+	}
+	a, b := sample.ByHost["a.example.com"], sample.ByHost["b.example.com"]
+	if a.Entries != 4 || a.DuplicateBodies != 2 || a.CompressionRatio > 0.1 {
+		t.Errorf("unexpected sample of a.example.com: %+v", a)
+	}
+	if b.Entries != 1 || b.DuplicateBodies != 0 || b.CompressionRatio < 0.9 {
+		t.Errorf("unexpected sample of b.example.com: %+v", b)
+	}
+	if len(sample.Recommendations) != 2 ||
+		!strings.HasPrefix(sample.Recommendations[0], "enable compression for a.example.com") ||
+		!strings.HasPrefix(sample.Recommendations[1], "enable deduplication for a.example.com") {
+		t.Errorf("unexpected recommendations: %v", sample.Recommendations)
+	}
+
+	// Sampling fewer entries than stored
+	sample, err = s.sampleStorage(2, rand.New(rand.NewPCG(1, 1)))
+	if err != nil {
+		t.Fatalf("sampleStorage() error = %v", err)
+	}
+	if sample.Entries != 2 || sample.Total != 5 {
+		t.Errorf("sampled %d of %d entries, want 2 of 5", sample.Entries, sample.Total)
+	}
+}
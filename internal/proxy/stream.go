@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"mime"
+	"net/http"
+	"slices"
+)
+
+// streamingContentTypes are the media types of responses that may never end, e.g. Server-Sent Events
+var streamingContentTypes = []string{"text/event-stream", "application/x-ndjson", "multipart/x-mixed-replace"}
+
+// isStreaming reports whether an upstream response is a stream: a response of unknown length with a streaming content type,
+// or matching a rule with stream: true. Streams may never end, so they cannot be read whole to be transformed or stored
+func (s *Server) isStreaming(requ *http.Request, resp *http.Response) bool {
+	if resp.ContentLength >= 0 || resp.Body == nil || resp.Body == http.NoBody {
+		return false
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && slices.Contains(streamingContentTypes, mediaType) {
+		return true
+	}
+	return slices.ContainsFunc(s.matchingConfigRules(requ), func(rule *ConfigRule) bool { return rule.Stream })
+}
+
+// passStream makes goproxy flush the stream to the client after each read, instead of buffering it
+func passStream(requ *http.Request, resp *http.Response) {
+	resp.Header.Set("X-Cache", "STREAM")
+	// goproxy flushes chunked responses. The MITM path always sends chunked responses
+	if requ.ProtoAtLeast(1, 1) {
+		resp.Header.Set("Transfer-Encoding", "chunked")
+	}
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestStreamPassthrough(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" {
+			w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		}
+		_, _ = io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		// The stream stays open until the client got the first event
+		<-release
+	}))
+	defer upstream.Close()
+
+	// Record mode stores everything: streams must still not be read whole
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir(), Mode: config.CacheModeRecord},
+		Rules: config.RulesConfig{Mode: config.RulesModeWhitelist, Rules: []config.CacheRule{
+			{BaseURI: upstream.URL + "/poll", Methods: []string{"GET"}, Stream: true},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	// Before closing the servers, which wait for the stream to end
+	defer close(release)
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	for _, path := range []string{"/events", "/poll"} {
+		done := make(chan string, 1)
+		go func() {
+			resp, err := client.Get(upstream.URL + path)
+			if err != nil {
+				done <- err.Error()
+				return
+			}
+			defer func() { _ = resp.Body.Close() }()
+			if cacheStatus := resp.Header.Get("X-Cache"); cacheStatus != "STREAM" {
+				done <- "X-Cache = " + cacheStatus
+				return
+			}
+			line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+			done <- line
+		}()
+
+		select {
+		case got := <-done:
+			if got != "data: first\n" {
+				t.Errorf("GET %s: got %q, want the first event", path, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("GET %s: first event not received before the end of the stream", path)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+// upstreamTimeoutsKey is the context key of the timeouts of an upstream request, read by upstreamTransports
+type upstreamTimeoutsKey struct{}
+
+// timeoutTransport applies upstream.timeouts, or the timeouts of the rule matching requests
+type timeoutTransport struct {
+	next        http.RoundTripper
+	timeoutsFor func(req *http.Request) config.Timeouts
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timeouts := t.timeoutsFor(req)
+	ctx := context.WithValue(req.Context(), upstreamTimeoutsKey{}, timeouts)
+	// Upgraded connections (e.g. WebSockets) last as long as they are used
+	if timeouts.Total <= 0 || req.Header.Get("Upgrade") != "" {
+		return t.next.RoundTrip(req.WithContext(ctx))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Total)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The timeout also covers reading the body
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody cancels the context of its request when closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// upstreamTimeouts returns the timeouts of the upstream request: upstream.timeouts, overridden by the first matching rule defining some
+func (s *Server) upstreamTimeouts(req *http.Request) config.Timeouts {
+	for _, rule := range s.matchingConfigRules(req) {
+		if rule.timeouts != nil {
+			return *rule.timeouts
+		}
+	}
+	return s.timeouts
+}
+
+// dialWithTimeout returns dial, failing after timeout (0 for no limit)
+func dialWithTimeout(dial func(ctx context.Context, network, addr string) (net.Conn, error), timeout time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return dial(ctx, network, addr)
+	}
+}
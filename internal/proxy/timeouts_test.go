@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestUpstreamTimeouts(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow-headers", "/patient/slow-headers", "/very-patient/slow-headers":
+			time.Sleep(200 * time.Millisecond)
+		case "/slow-body":
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(200 * time.Millisecond)
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeWhitelist, Rules: []config.CacheRule{
+			{BaseURI: upstream.URL + "/patient/", Methods: []string{"GET"}, Timeouts: &config.TimeoutsConfig{ResponseHeader: "0"}},
+			{BaseURI: upstream.URL + "/very-patient/", Methods: []string{"GET"}, Timeouts: &config.TimeoutsConfig{ResponseHeader: "0", Total: "1s"}},
+		}},
+		Upstream: config.UpstreamConfig{Timeouts: config.TimeoutsConfig{ResponseHeader: "50ms", Total: "100ms"}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	get := func(path string) error {
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+path, nil)
+		resp, err := s.upstream.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = resp.Body.Close() }()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+
+	if err := get("/fast"); err != nil {
+		t.Errorf("fast request failed: %v", err)
+	}
+	if err := get("/slow-headers"); err == nil {
+		t.Errorf("request with slow headers did not time out")
+	}
+	if err := get("/slow-body"); err == nil {
+		t.Errorf("request with slow body did not time out")
+	}
+	// The rule removes the response header timeout, and keeps the total one
+	if err := get("/patient/slow-headers"); err == nil {
+		t.Errorf("request of the rule did not time out")
+	}
+	if err := get("/very-patient/slow-headers"); err != nil {
+		t.Errorf("request of the rule failed: %v", err)
+	}
+}
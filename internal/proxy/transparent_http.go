@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// originalDstKey is the context key of the original destination of connections to the transparent HTTP listener
+type originalDstKey struct{}
+
+// StartTransparentHTTP serves HTTP connections redirected to the proxy by the firewall (iptables REDIRECT or TPROXY rules)
+func (s *Server) StartTransparentHTTP(addr string) {
+	tproxy := s.config.Server.HTTP.Transparent.TPROXY
+	lc := &net.ListenConfig{}
+	if tproxy {
+		lc.Control = setTransparent
+	}
+	ln, err := listenWith(lc, "http-transparent", 2, addr)
+	if err != nil {
+		logrus.Fatalf("Error listening for transparent HTTP connections: %v", err)
+	}
+	server, err := s.newHTTPServer()
+	if err != nil {
+		logrus.Fatalf("Error starting transparent HTTP listener: %v", err)
+	}
+	server.Handler = http.HandlerFunc(s.serveTransparentHTTP)
+	server.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+		dst, err := originalDestination(conn, tproxy)
+		if err != nil {
+			logrus.Debugf("Transparent HTTP connection from %s: %v, using the Host header only", conn.RemoteAddr(), err)
+			return ctx
+		}
+		return context.WithValue(ctx, originalDstKey{}, dst)
+	}
+	if err := server.Serve(newLimitListener(ln, s.config.Server.Limits.MaxConnections)); err != nil {
+		logrus.Fatalf("Error serving transparent HTTP connections: %v", err)
+	}
+}
+
+// originalDestination returns the address a redirected connection was sent to.
+// Connections of TPROXY rules keep it as local address, the ones of REDIRECT rules are looked up in the connection tracking of the kernel
+func originalDestination(conn net.Conn, tproxy bool) (*net.TCPAddr, error) {
+	if limited, ok := conn.(*limitConn); ok {
+		conn = limited.Conn
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, fmt.Errorf("not a TCP connection")
+	}
+	if tproxy {
+		return tcpConn.LocalAddr().(*net.TCPAddr), nil
+	}
+	return redirectedDestination(tcpConn)
+}
+
+// serveTransparentHTTP proxies a redirected request to the host of its Host header, completed by the original destination.
+// Requests without Host header (e.g. HTTP/1.0) go to the original destination
+func (s *Server) serveTransparentHTTP(w http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	dst, _ := req.Context().Value(originalDstKey{}).(*net.TCPAddr)
+	switch {
+	case host == "" && dst == nil:
+		http.Error(w, "Cannot determine the destination of requests without Host header", http.StatusBadRequest)
+		return
+	case host == "":
+		host = dst.String()
+	case dst != nil && dst.Port != 80:
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, strconv.Itoa(dst.Port))
+		}
+	}
+	req.URL.Scheme = "http"
+	req.URL.Host = host
+	req = req.WithContext(context.WithValue(req.Context(), ctxUserDataKey{}, &ctxUserData{
+		source: SrcHTTPTransparent,
+	}))
+	s.serveProxy(w, req)
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestServeTransparentHTTP(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "upstream "+r.URL.Path)
+	}))
+	defer upstream.Close()
+	upstreamAddr := upstream.Listener.Addr().(*net.TCPAddr)
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeWhitelist},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		host       string
+		dst        *net.TCPAddr
+		wantStatus int
+	}{
+		{name: "host header with port", host: upstreamAddr.String(), wantStatus: http.StatusOK},
+		{name: "port of the original destination", host: "127.0.0.1", dst: upstreamAddr, wantStatus: http.StatusOK},
+		{name: "original destination without host header", host: "", dst: upstreamAddr, wantStatus: http.StatusOK},
+		{name: "no destination", host: "", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/path", nil)
+			req.Host = tt.host
+			if tt.dst != nil {
+				req = req.WithContext(context.WithValue(req.Context(), originalDstKey{}, tt.dst))
+			}
+			w := httptest.NewRecorder()
+			s.serveTransparentHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK && w.Body.String() != "upstream /path" {
+				t.Errorf("body = %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestOriginalDestination(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	dst, err := originalDestination(conn, true)
+	if err != nil || dst.String() != ln.Addr().String() {
+		t.Errorf("originalDestination() with TPROXY = %v, %v, want %s", dst, err, ln.Addr())
+	}
+	// Not redirected by the firewall
+	if dst, err := originalDestination(conn, false); err == nil {
+		t.Errorf("originalDestination() of a connection that was not redirected = %v, want an error", dst)
+	}
+}
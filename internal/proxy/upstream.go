@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+
+	"github.com/elazarl/goproxy"
+	netproxy "golang.org/x/net/proxy"
+)
+
+// parentProxy returns the parent proxy of requests to host: the one of the first upstream.hosts entry matching it and defining one,
+// or else the one of the http_proxy, https_proxy and no_proxy environment variables. nil means a direct connection
+func parentProxy(cfg *config.UpstreamConfig, req *http.Request) (*url.URL, error) {
+	entry := cfg.For(req.URL.Hostname(), func(h *config.UpstreamHost) bool { return h.Proxy != "" })
+	if entry == nil {
+		return http.ProxyFromEnvironment(req)
+	}
+	if entry.Proxy == config.ProxyDirect {
+		return nil, nil
+	}
+	return url.Parse(entry.Proxy)
+}
+
+// tunnelDialer opens the connections of CONNECT tunnels that are not intercepted, through the parent proxy of their host
+type tunnelDialer struct {
+	proxy  *goproxy.ProxyHttpServer
+	config *config.UpstreamConfig
+
+	mu sync.Mutex
+	// dial functions through each parent proxy, by URL
+	dialers map[string]func(network, addr string) (net.Conn, error)
+}
+
+func (d *tunnelDialer) dial(req *http.Request, network, addr string) (net.Conn, error) {
+	// CONNECT requests have no scheme, and tunnels mostly carry HTTPS
+	parent, err := parentProxy(d.config, &http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		if d.proxy.Tr.DialContext != nil {
+			return d.proxy.Tr.DialContext(req.Context(), network, addr)
+		}
+		return net.Dial(network, addr)
+	}
+
+	d.mu.Lock()
+	dial, ok := d.dialers[parent.String()]
+	if !ok {
+		dial, err = d.dialerFor(parent)
+		if err != nil {
+			d.mu.Unlock()
+			return nil, err
+		}
+		d.dialers[parent.String()] = dial
+	}
+	d.mu.Unlock()
+	return dial(network, addr)
+}
+
+// dialerFor returns the dial function opening tunnels through a parent proxy
+func (d *tunnelDialer) dialerFor(parent *url.URL) (func(network, addr string) (net.Conn, error), error) {
+	if parent.Scheme != "socks5" && parent.Scheme != "socks5h" {
+		return d.proxy.NewConnectDialToProxy(parent.String()), nil
+	}
+	dialer, err := netproxy.FromURL(parent, netproxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOCKS proxy %s: %w", parent.Redacted(), err)
+	}
+	return dialer.Dial, nil
+}
+
+// upstreamTransports sends requests with the TLS settings of the upstream.hosts entries matching their host, and their timeouts.
+// Each combination of settings gets its own transport, cloned from the base one
+type upstreamTransports struct {
+	base   *http.Transport
+	config *config.UpstreamConfig
+	// dial function of the base transport, without timeout
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// timeouts of upstream.timeouts, applied to the base transport. The total timeout is applied by timeoutTransport
+	timeouts config.Timeouts
+	// client certificates and trusted CAs, by upstream.hosts entry
+	certs map[*config.UpstreamHost]tls.Certificate
+	cas   map[*config.UpstreamHost]*x509.CertPool
+
+	mu         sync.Mutex
+	transports map[transportKey]*http.Transport
+}
+
+// transportKey identifies the upstream.hosts entries providing the TLS settings of a transport, and its timeouts
+type transportKey struct {
+	cert, ca, insecure *config.UpstreamHost
+	timeouts           config.Timeouts
+}
+
+// newUpstreamTransports creates the transports of upstream requests. The timeouts of cfg are applied to base, so they also apply to tunnels
+func newUpstreamTransports(base *http.Transport, cfg *config.UpstreamConfig) (*upstreamTransports, error) {
+	timeouts, err := cfg.Timeouts.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream timeouts: %w", err)
+	}
+	timeouts.Total = 0
+	t := &upstreamTransports{
+		base:       base,
+		config:     cfg,
+		dial:       base.DialContext,
+		timeouts:   timeouts,
+		certs:      make(map[*config.UpstreamHost]tls.Certificate),
+		cas:        make(map[*config.UpstreamHost]*x509.CertPool),
+		transports: make(map[transportKey]*http.Transport),
+	}
+	if t.dial == nil {
+		// Same as http.DefaultTransport
+		t.dial = (&net.Dialer{KeepAlive: 30 * time.Second}).DialContext
+	}
+	t.setTimeouts(base, timeouts)
+	for i := range cfg.Hosts {
+		entry := &cfg.Hosts[i]
+		if entry.ClientCert != "" {
+			cert, err := tls.LoadX509KeyPair(entry.ClientCert, entry.ClientKey)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client certificate of upstream host %d: %w", i, err)
+			}
+			t.certs[entry] = cert
+		}
+		if entry.CABundle != "" {
+			pool, err := loadCABundle(entry.CABundle)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CA bundle of upstream host %d: %w", i, err)
+			}
+			t.cas[entry] = pool
+		}
+	}
+	return t, nil
+}
+
+// setTimeouts applies timeouts (but the total one) to a transport
+func (t *upstreamTransports) setTimeouts(transport *http.Transport, timeouts config.Timeouts) {
+	transport.DialContext = dialWithTimeout(t.dial, timeouts.Dial)
+	transport.TLSHandshakeTimeout = timeouts.TLSHandshake
+	transport.ResponseHeaderTimeout = timeouts.ResponseHeader
+}
+
+// forRequest returns the transport of the request, with the settings of its host and the timeouts of its context (see timeoutTransport)
+func (t *upstreamTransports) forRequest(req *http.Request) *http.Transport {
+	host := req.URL.Hostname()
+	timeouts, ok := req.Context().Value(upstreamTimeoutsKey{}).(config.Timeouts)
+	if !ok {
+		timeouts = t.timeouts
+	}
+	timeouts.Total = 0
+	key := transportKey{
+		cert:     t.config.For(host, func(h *config.UpstreamHost) bool { return h.ClientCert != "" }),
+		ca:       t.config.For(host, func(h *config.UpstreamHost) bool { return h.CABundle != "" }),
+		insecure: t.config.For(host, func(h *config.UpstreamHost) bool { return h.InsecureSkipVerify }),
+		timeouts: timeouts,
+	}
+	if key == (transportKey{timeouts: t.timeouts}) {
+		return t.base
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	transport, ok := t.transports[key]
+	if !ok {
+		transport = t.base.Clone()
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		if key.cert != nil {
+			transport.TLSClientConfig.Certificates = []tls.Certificate{t.certs[key.cert]}
+		}
+		if key.ca != nil {
+			transport.TLSClientConfig.RootCAs = t.cas[key.ca]
+		}
+		transport.TLSClientConfig.InsecureSkipVerify = key.insecure != nil
+		t.setTimeouts(transport, key.timeouts)
+		t.transports[key] = transport
+	}
+	return transport
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *upstreamTransports) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.forRequest(req).RoundTrip(req)
+}
+
+// loadCABundle returns the system CAs along with the ones of a PEM bundle
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return pool, nil
+}
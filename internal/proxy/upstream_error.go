@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/elazarl/goproxy"
+	"github.com/sirupsen/logrus"
+)
+
+// upstreamErrorEnvelope is the body of the 503 answered by rules with on_upstream_error: error
+type upstreamErrorEnvelope struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	URL     string `json:"url"`
+}
+
+// upstreamErrorPolicy returns the on_upstream_error setting of the first matching rule defining one, or "pass"
+func (s *Server) upstreamErrorPolicy(requ *http.Request) string {
+	for _, rule := range s.matchingConfigRules(requ) {
+		if rule.OnUpstreamError != "" {
+			return rule.OnUpstreamError
+		}
+	}
+	return "pass"
+}
+
+// upstreamDown reports whether upstream failed to answer: a transport error, or a gateway error status
+func upstreamDown(resp *http.Response, err error) (string, bool) {
+	if err != nil {
+		return err.Error(), true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return resp.Status, true
+	}
+	return "", false
+}
+
+// handleUpstreamErrors makes the upstream request of ctx answer according to the on_upstream_error setting of its rule
+// when upstream is down. It also applies to intercepted HTTPS requests, whose transport errors never reach OnResponse
+func (s *Server) handleUpstreamErrors(requ *http.Request, ctx *goproxy.ProxyCtx, userData *ctxUserData) {
+	policy := s.upstreamErrorPolicy(requ)
+	if policy == "pass" {
+		return
+	}
+	ctx.RoundTripper = goproxy.RoundTripperFunc(func(upstreamReq *http.Request, ctx *goproxy.ProxyCtx) (*http.Response, error) {
+		resp, err := s.upstream.RoundTrip(upstreamReq)
+		failure, down := upstreamDown(resp, err)
+		if !down {
+			return resp, err
+		}
+
+		if policy == "stale" {
+			staleResp := s.staleResponse(requ, userData)
+			if staleResp == nil {
+				logrus.Warnf("OnRequest(url=%s): Upstream is down (%s) and no stale entry is kept, passing the error through", requ.URL.String(), failure)
+				return resp, err
+			}
+			logrus.Warnf("OnRequest(url=%s): Upstream is down (%s), serving the stale entry", requ.URL.String(), failure)
+			if resp != nil {
+				_ = resp.Body.Close()
+			}
+			return staleResp, nil
+		}
+
+		logrus.Warnf("OnRequest(url=%s): Upstream is down (%s), answering 503", requ.URL.String(), failure)
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		return upstreamErrorResponse(requ, userData, failure), nil
+	})
+}
+
+// upstreamErrorResponse returns a 503 with a JSON error envelope describing the upstream failure
+func upstreamErrorResponse(requ *http.Request, userData *ctxUserData, failure string) *http.Response {
+	body, _ := json.Marshal(upstreamErrorEnvelope{
+		Error:   "upstream_unavailable",
+		Message: fmt.Sprintf("upstream is unavailable: %s", failure),
+		URL:     requ.URL.String(),
+	})
+	resp := goproxy.NewResponse(requ, "application/json", http.StatusServiceUnavailable, string(body))
+	resp.Header.Set("X-Cache", "ERROR")
+	userData.status = "ERROR"
+	return resp
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestOnUpstreamError(t *testing.T) {
+	var down atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "fresh")
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		// Entries expire right away, but are kept to be served stale
+		Cache: config.CacheConfig{Folder: t.TempDir(), TTL: "1ms", StaleTTL: "1h"},
+		Rules: config.RulesConfig{Mode: config.RulesModeWhitelist, Rules: []config.CacheRule{
+			{BaseURI: upstream.URL + "/stale", Methods: []string{"GET"}, OnUpstreamError: "stale"},
+			{BaseURI: upstream.URL + "/error", Methods: []string{"GET"}, OnUpstreamError: "error"},
+			{BaseURI: upstream.URL + "/pass", Methods: []string{"GET"}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	get := func(path string) (*http.Response, string) {
+		resp, err := client.Get(upstream.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	for _, path := range []string{"/stale", "/error", "/pass"} {
+		get(path)
+	}
+	time.Sleep(5 * time.Millisecond)
+	down.Store(true)
+
+	if resp, body := get("/stale"); resp.StatusCode != http.StatusOK || resp.Header.Get("X-Cache") != "STALE" || body != "fresh" {
+		t.Errorf("stale: status = %d, X-Cache = %s, body = %q, want the stale entry", resp.StatusCode, resp.Header.Get("X-Cache"), body)
+	}
+
+	resp, body := get("/error")
+	var envelope upstreamErrorEnvelope
+	if resp.StatusCode != http.StatusServiceUnavailable || json.Unmarshal([]byte(body), &envelope) != nil || envelope.Error != "upstream_unavailable" {
+		t.Errorf("error: status = %d, body = %q, want a 503 JSON envelope", resp.StatusCode, body)
+	}
+
+	if resp, body := get("/pass"); resp.StatusCode != http.StatusServiceUnavailable || body != "maintenance\n" {
+		t.Errorf("pass: status = %d, body = %q, want the upstream error", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/iTrooz/caching-dev-proxy/internal/config"
+)
+
+func TestParentProxyPerHost(t *testing.T) {
+	parent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "via parent "+r.URL.String())
+	}))
+	defer parent.Close()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "direct")
+	}))
+	defer upstream.Close()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeWhitelist},
+		Upstream: config.UpstreamConfig{Hosts: []config.UpstreamHost{
+			{Match: []string{"127.0.0.0/8"}, Proxy: config.ProxyDirect},
+			{Match: []string{"**.test"}, Proxy: parent.URL},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	for target, want := range map[string]string{
+		"http://external.test/path": "via parent http://external.test/path",
+		upstream.URL:                "direct",
+	} {
+		resp, err := client.Get(target)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", target, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("GET %s = %q, want %q", target, body, want)
+		}
+	}
+}
+
+func TestSOCKS5Upstream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "through socks")
+	}))
+	defer upstream.Close()
+
+	// Minimal SOCKS5 server: no authentication, CONNECT to an IPv4 address only
+	socks, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = socks.Close() }()
+	var tunnels atomic.Int32
+	go func() {
+		for {
+			conn, err := socks.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				greeting := make([]byte, 3)
+				if _, err := io.ReadFull(conn, greeting); err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte{5, 0})
+				request := make([]byte, 10)
+				if _, err := io.ReadFull(conn, request); err != nil || request[3] != 1 {
+					return
+				}
+				target, err := net.Dial("tcp", net.JoinHostPort(net.IP(request[4:8]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(request[8:])))))
+				if err != nil {
+					return
+				}
+				defer func() { _ = target.Close() }()
+				tunnels.Add(1)
+				_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go func() { _, _ = io.Copy(target, conn) }()
+				_, _ = io.Copy(conn, target)
+			}()
+		}
+	}()
+
+	s, err := New(&config.Config{
+		Cache: config.CacheConfig{Folder: t.TempDir()},
+		Rules: config.RulesConfig{Mode: config.RulesModeWhitelist},
+		Upstream: config.UpstreamConfig{Hosts: []config.UpstreamHost{
+			{Match: []string{"127.0.0.0/8"}, Proxy: "socks5://" + socks.Addr().String()},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	proxyServer := httptest.NewServer(s.GetProxy())
+	defer proxyServer.Close()
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "through socks" || tunnels.Load() != 1 {
+		t.Errorf("GET = %q with %d SOCKS tunnels, want the upstream response through 1 tunnel", body, tunnels.Load())
+	}
+}
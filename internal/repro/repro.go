@@ -0,0 +1,114 @@
+// Builds shell commands reproducing stored HTTP requests, to share them outside of the proxy
+package repro
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// headers not worth reproducing, because the client computes them or they only concern the proxy
+var skippedHeaders = []string{"Content-Length", "Connection", "Proxy-Connection", "Proxy-Authorization", "Transfer-Encoding"}
+
+// reproducedHeaders returns the request headers to reproduce, sorted by name
+func reproducedHeaders(req *http.Request) [][2]string {
+	headers := [][2]string{}
+	if req.Host != "" && req.Host != req.URL.Host {
+		headers = append(headers, [2]string{"Host", req.Host})
+	}
+
+	names := []string{}
+	for name := range req.Header {
+		if !slices.Contains(skippedHeaders, name) && name != "Host" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			headers = append(headers, [2]string{name, value})
+		}
+	}
+	return headers
+}
+
+// Curl returns a curl command reproducing the request
+func Curl(req *http.Request, body []byte) string {
+	command := "curl"
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodHead:
+		command += " --head"
+	default:
+		command += " -X " + req.Method
+	}
+	args := []string{command + " " + shellQuote(req.URL.String())}
+
+	for _, header := range reproducedHeaders(req) {
+		if header[1] == "" {
+			// curl syntax to send a header with an empty value
+			args = append(args, "-H "+shellQuote(header[0]+";"))
+		} else {
+			args = append(args, "-H "+shellQuote(header[0]+": "+header[1]))
+		}
+	}
+
+	if len(body) > 0 {
+		args = append(args, "--data-binary "+shellQuote(string(body)))
+	}
+	return strings.Join(args, " \\\n  ")
+}
+
+// HTTPie returns an HTTPie command reproducing the request
+func HTTPie(req *http.Request, body []byte) string {
+	args := []string{"http --ignore-stdin " + req.Method + " " + shellQuote(req.URL.String())}
+
+	for _, header := range reproducedHeaders(req) {
+		if header[1] == "" {
+			// HTTPie syntax to send a header with an empty value
+			args = append(args, shellQuote(header[0]+";"))
+		} else {
+			args = append(args, shellQuote(header[0]+":"+header[1]))
+		}
+	}
+
+	if len(body) > 0 {
+		args = append(args, "--raw "+shellQuote(string(body)))
+	}
+	return strings.Join(args, " \\\n  ")
+}
+
+// shellQuote quotes a string for POSIX shells.
+// Strings with non-printable characters use ANSI-C quoting, supported by bash and zsh
+func shellQuote(s string) string {
+	printable := utf8.ValidString(s)
+	for _, r := range s {
+		if r < 0x20 && r != '\n' && r != '\t' || r == 0x7f {
+			printable = false
+			break
+		}
+	}
+
+	if printable {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+
+	var b strings.Builder
+	b.WriteString("$'")
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
@@ -0,0 +1,60 @@
+package repro
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCurl(t *testing.T) {
+	req, err := http.NewRequest("POST", "https://api.example.com/users?page=2", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Length", "13")
+	req.Header.Set("X-Empty", "")
+
+	got := Curl(req, []byte(`{"name":"o'k"}`))
+	want := strings.Join([]string{
+		"curl -X POST 'https://api.example.com/users?page=2'",
+		"-H 'Content-Type: application/json'",
+		"-H 'X-Empty;'",
+		`--data-binary '{"name":"o'\''k"}'`,
+	}, " \\\n  ")
+	if got != want {
+		t.Errorf("Curl() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHTTPie(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://api.example.com/users", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	got := HTTPie(req, nil)
+	want := "http --ignore-stdin GET 'https://api.example.com/users' \\\n  'Accept:application/json'"
+	if got != want {
+		t.Errorf("HTTPie() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "simple", want: "'simple'"},
+		{in: "it's", want: `'it'\''s'`},
+		{in: "multi\nline", want: "'multi\nline'"},
+		{in: "bin\x00ary'", want: `$'bin\x00ary\''`},
+	}
+
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Runs user-provided Lua scripts hooking into request handling
+package script
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Hooks are global functions the script may define:
+//
+//	on_request(req)                  -- modify req.method, req.url and req.headers before the request is handled
+//	cache_key(req, key)              -- return the cache key to use instead of key, or nil to keep it
+//	on_response(req, resp)           -- modify resp.status, resp.headers and resp.body of upstream responses, before they are cached
+//	should_cache(req, resp, cache)   -- return whether to cache the response, overriding the decision of the rules
+//
+// Header values are strings, or lists of strings for repeated headers
+const (
+	hookOnRequest   = "on_request"
+	hookCacheKey    = "cache_key"
+	hookOnResponse  = "on_response"
+	hookShouldCache = "should_cache"
+)
+
+// Engine runs the hooks of a script. Hooks run one at a time, as Lua states are not safe for concurrent use
+type Engine struct {
+	mu    sync.Mutex
+	state *lua.LState
+}
+
+// Load runs a script file, defining its hooks
+func Load(path string) (*Engine, error) {
+	state := lua.NewState()
+	if err := state.DoFile(path); err != nil {
+		state.Close()
+		return nil, fmt.Errorf("failed to load script %s: %w", path, err)
+	}
+	return &Engine{state: state}, nil
+}
+
+// Close releases the Lua state
+func (e *Engine) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.state.Close()
+}
+
+// has reports whether the script defines a hook. Must be called with e.mu held
+func (e *Engine) has(hook string) bool {
+	return e.state.GetGlobal(hook).Type() == lua.LTFunction
+}
+
+// HasOnResponse reports whether the script defines on_response, so callers only read response bodies when needed
+func (e *Engine) HasOnResponse() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.has(hookOnResponse)
+}
+
+// call calls a hook with the given arguments, returning its result. Must be called with e.mu held
+func (e *Engine) call(hook string, args ...lua.LValue) (lua.LValue, error) {
+	err := e.state.CallByParam(lua.P{Fn: e.state.GetGlobal(hook), NRet: 1, Protect: true}, args...)
+	if err != nil {
+		return nil, fmt.Errorf("script %s failed: %w", hook, err)
+	}
+	result := e.state.Get(-1)
+	e.state.Pop(1)
+	return result, nil
+}
+
+// OnRequest runs on_request, applying its changes to the request
+func (e *Engine) OnRequest(req *http.Request) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.has(hookOnRequest) {
+		return nil
+	}
+	table := e.requestTable(req)
+	if _, err := e.call(hookOnRequest, table); err != nil {
+		return err
+	}
+
+	req.Method = lua.LVAsString(table.RawGetString("method"))
+	if target := lua.LVAsString(table.RawGetString("url")); target != req.URL.String() {
+		u, err := url.Parse(target)
+		if err != nil {
+			return fmt.Errorf("script on_request set an invalid URL '%s': %w", target, err)
+		}
+		req.URL = u
+		req.Host = u.Host
+	}
+	req.Header = headersFromTable(table.RawGetString("headers"))
+	return nil
+}
+
+// CacheKey runs cache_key, returning the key to use
+func (e *Engine) CacheKey(req *http.Request, key string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.has(hookCacheKey) {
+		return key, nil
+	}
+	result, err := e.call(hookCacheKey, e.requestTable(req), lua.LString(key))
+	if err != nil {
+		return "", err
+	}
+	if result == lua.LNil {
+		return key, nil
+	}
+	if result.Type() != lua.LTString || result.String() == "" {
+		return "", fmt.Errorf("script cache_key must return a non-empty string or nil, got: %s", result.Type())
+	}
+	return result.String(), nil
+}
+
+// OnResponse runs on_response, applying its changes to the response. Returns the body, changed or not
+func (e *Engine) OnResponse(req *http.Request, resp *http.Response, body []byte) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.has(hookOnResponse) {
+		return body, nil
+	}
+	table := e.responseTable(resp)
+	table.RawSetString("body", lua.LString(body))
+	if _, err := e.call(hookOnResponse, e.requestTable(req), table); err != nil {
+		return nil, err
+	}
+
+	if status := int(lua.LVAsNumber(table.RawGetString("status"))); status != resp.StatusCode {
+		if status < 100 || status > 599 {
+			return nil, fmt.Errorf("script on_response set an invalid status: %d", status)
+		}
+		resp.StatusCode = status
+		resp.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	}
+	resp.Header = headersFromTable(table.RawGetString("headers"))
+	return []byte(lua.LVAsString(table.RawGetString("body"))), nil
+}
+
+// ShouldCache runs should_cache, returning the caching decision
+func (e *Engine) ShouldCache(req *http.Request, resp *http.Response, cache bool) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.has(hookShouldCache) {
+		return cache, nil
+	}
+	result, err := e.call(hookShouldCache, e.requestTable(req), e.responseTable(resp), lua.LBool(cache))
+	if err != nil {
+		return false, err
+	}
+	if result.Type() != lua.LTBool {
+		return false, fmt.Errorf("script should_cache must return a boolean, got: %s", result.Type())
+	}
+	return lua.LVAsBool(result), nil
+}
+
+func (e *Engine) requestTable(req *http.Request) *lua.LTable {
+	table := e.state.NewTable()
+	table.RawSetString("method", lua.LString(req.Method))
+	table.RawSetString("url", lua.LString(req.URL.String()))
+	table.RawSetString("headers", e.headersTable(req.Header))
+	return table
+}
+
+func (e *Engine) responseTable(resp *http.Response) *lua.LTable {
+	table := e.state.NewTable()
+	table.RawSetString("status", lua.LNumber(resp.StatusCode))
+	table.RawSetString("headers", e.headersTable(resp.Header))
+	return table
+}
+
+func (e *Engine) headersTable(header http.Header) *lua.LTable {
+	table := e.state.NewTable()
+	for name, values := range header {
+		if len(values) == 1 {
+			table.RawSetString(name, lua.LString(values[0]))
+			continue
+		}
+		list := e.state.NewTable()
+		for _, value := range values {
+			list.Append(lua.LString(value))
+		}
+		table.RawSetString(name, list)
+	}
+	return table
+}
+
+func headersFromTable(value lua.LValue) http.Header {
+	header := http.Header{}
+	table, ok := value.(*lua.LTable)
+	if !ok {
+		return header
+	}
+	table.ForEach(func(name, value lua.LValue) {
+		if list, ok := value.(*lua.LTable); ok {
+			list.ForEach(func(_, item lua.LValue) {
+				header.Add(name.String(), item.String())
+			})
+		} else {
+			header.Add(name.String(), value.String())
+		}
+	})
+	return header
+}
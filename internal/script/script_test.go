@@ -0,0 +1,115 @@
+package script
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func loadScript(t *testing.T, source string) *Engine {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hooks.lua")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	t.Cleanup(engine.Close)
+	return engine
+}
+
+func TestHooks(t *testing.T) {
+	engine := loadScript(t, `
+function on_request(req)
+  req.headers["X-Debug"] = nil
+  req.headers["X-Tenant"] = "demo"
+  req.url = string.gsub(req.url, "^http://prod", "http://staging")
+end
+
+function cache_key(req, key)
+  if req.headers["X-Tenant"] then
+    return req.headers["X-Tenant"] .. "/" .. key
+  end
+end
+
+function on_response(req, resp)
+  resp.status = 200
+  resp.headers["Set-Cookie"] = nil
+  resp.body = string.upper(resp.body)
+end
+
+function should_cache(req, resp, cache)
+  return cache and resp.headers["Cache-Control"] ~= "no-store"
+end
+`)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://prod.example.com/users", nil)
+	req.Header.Set("X-Debug", "1")
+	if err := engine.OnRequest(req); err != nil {
+		t.Fatalf("OnRequest() error = %v", err)
+	}
+	if req.URL.String() != "http://staging.example.com/users" || req.Host != "staging.example.com" {
+		t.Errorf("OnRequest() URL = %s, Host = %s", req.URL.String(), req.Host)
+	}
+	if req.Header.Get("X-Debug") != "" || req.Header.Get("X-Tenant") != "demo" {
+		t.Errorf("OnRequest() headers = %v", req.Header)
+	}
+
+	if key, err := engine.CacheKey(req, "GET_abc"); err != nil || key != "demo/GET_abc" {
+		t.Errorf("CacheKey() = %q, %v, want demo/GET_abc", key, err)
+	}
+	req.Header.Del("X-Tenant")
+	if key, err := engine.CacheKey(req, "GET_abc"); err != nil || key != "GET_abc" {
+		t.Errorf("CacheKey() without tenant = %q, %v, want GET_abc", key, err)
+	}
+
+	resp := &http.Response{StatusCode: 203, Header: http.Header{"Set-Cookie": {"a=1", "b=2"}, "Vary": {"Accept", "Origin"}}}
+	body, err := engine.OnResponse(req, resp, []byte("hello"))
+	if err != nil {
+		t.Fatalf("OnResponse() error = %v", err)
+	}
+	if resp.StatusCode != 200 || string(body) != "HELLO" || resp.Header.Get("Set-Cookie") != "" || len(resp.Header.Values("Vary")) != 2 {
+		t.Errorf("OnResponse() = %d %v %q", resp.StatusCode, resp.Header, body)
+	}
+
+	for _, tt := range []struct {
+		cacheControl string
+		cache, want  bool
+	}{{"", true, true}, {"no-store", true, false}, {"", false, false}} {
+		resp := &http.Response{StatusCode: 200, Header: http.Header{}}
+		if tt.cacheControl != "" {
+			resp.Header.Set("Cache-Control", tt.cacheControl)
+		}
+		if got, err := engine.ShouldCache(req, resp, tt.cache); err != nil || got != tt.want {
+			t.Errorf("ShouldCache(%q, %v) = %v, %v, want %v", tt.cacheControl, tt.cache, got, err, tt.want)
+		}
+	}
+}
+
+func TestMissingHooksAndErrors(t *testing.T) {
+	engine := loadScript(t, `
+function should_cache(req, resp, cache)
+  error("boom")
+end
+`)
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if err := engine.OnRequest(req); err != nil || req.URL.String() != "http://example.com/" {
+		t.Errorf("OnRequest() without hook changed the request or failed: %v", err)
+	}
+	if key, err := engine.CacheKey(req, "k"); err != nil || key != "k" {
+		t.Errorf("CacheKey() without hook = %q, %v", key, err)
+	}
+	if engine.HasOnResponse() {
+		t.Errorf("HasOnResponse() = true without hook")
+	}
+	if _, err := engine.ShouldCache(req, &http.Response{Header: http.Header{}}, true); err == nil {
+		t.Errorf("ShouldCache() error = nil, want the script error")
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.lua")); err == nil {
+		t.Errorf("Load() of a missing file succeeded")
+	}
+}
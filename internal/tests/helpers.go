@@ -1,10 +1,16 @@
 package tests
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -99,6 +105,10 @@ func fixture_config(tempDir string, rules *config.RulesConfig) *config.Config {
 			TTL:    "1h",
 			Folder: tempDir,
 		},
+		// Upstream test servers have self-signed certificates
+		Upstream: config.UpstreamConfig{
+			Hosts: []config.UpstreamHost{{Match: []string{"127.0.0.0/8"}, InsecureSkipVerify: true}},
+		},
 	}
 
 	if rules != nil {
@@ -115,8 +125,8 @@ func fixture_proxy(cfg *config.Config) (*proxy.Server, *httptest.Server, *http.C
 		panic(fmt.Errorf("failed to create proxy server: %w", err))
 	}
 
-	// Create test proxy HTTP server using goproxy
-	proxyTestServer := httptest.NewServer(proxyServer.GetProxy())
+	// Create test proxy HTTP server
+	proxyTestServer := httptest.NewServer(proxyServer.Handler())
 
 	// Create HTTP client that uses our proxy
 	proxyURL, _ := url.Parse(proxyTestServer.URL)
@@ -130,3 +140,23 @@ func fixture_proxy(cfg *config.Config) (*proxy.Server, *httptest.Server, *http.C
 
 	return proxyServer, proxyTestServer, client
 }
+
+// helper_clientCert generates a self-signed client certificate with the given CN
+func helper_clientCert(t *testing.T, cn string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -1,14 +1,32 @@
 package tests
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/iTrooz/caching-dev-proxy/internal/ca"
 	"github.com/iTrooz/caching-dev-proxy/internal/config"
+	"github.com/iTrooz/caching-dev-proxy/internal/proxy"
 )
 
 func init() {
@@ -325,3 +343,644 @@ func TestNoUpstreamConnectionOnCacheHitHTTPS(t *testing.T) {
 		}
 	})
 }
+
+// Slow upstream answers are replaced by a placeholder, and stored in cache in the background
+func TestPlaceholderOnSlowMiss(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, requ *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		_, _ = w.Write([]byte("slow response"))
+	}))
+	defer upstream.Close()
+
+	rule := config.NewCacheRule(upstream.URL, "GET")
+	rule.Placeholder = config.PlaceholderConfig{After: "50ms", RetryAfter: "2s"}
+	cfg := fixture_config(t.TempDir(), config.NewRulesConfig(config.RulesModeWhitelist, rule))
+	_, proxyTestServer, client := fixture_proxy(cfg)
+	defer proxyTestServer.Close()
+
+	t.Run("first request - placeholder", func(t *testing.T) {
+		resp, err := client.Get(upstream.URL + "/slow")
+		if err != nil {
+			panic(err)
+		}
+		helper_readBodyAndClose(resp)
+
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+		assert.Equal(t, "PLACEHOLDER", resp.Header.Get("X-Cache"))
+		assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+	})
+
+	// Let the background fetch finish
+	time.Sleep(500 * time.Millisecond)
+
+	t.Run("second request - cache hit", func(t *testing.T) {
+		resp, err := client.Get(upstream.URL + "/slow")
+		if err != nil {
+			panic(err)
+		}
+		body := helper_readBodyAndClose(resp)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+		assert.Equal(t, "slow response", body)
+	})
+}
+
+// Clients presenting different certificates get separate caches
+func TestClientCertNamespace(t *testing.T) {
+	upstream := fixture_upstream_tls()
+	defer upstream.Close()
+
+	cfg := fixture_config(t.TempDir(), nil)
+	cfg.Server.HTTPS.ClientCertNamespace = true
+	_, proxyTestServer, client := fixture_proxy(cfg)
+	defer proxyTestServer.Close()
+
+	clientAs := func(cn string) *http.Client {
+		transport := client.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = []tls.Certificate{helper_clientCert(t, cn)}
+		return &http.Client{Transport: transport, Timeout: client.Timeout}
+	}
+	alice := clientAs("alice")
+	bob := clientAs("bob")
+
+	get := func(c *http.Client) string {
+		resp, err := c.Get(upstream.URL + "/test")
+		if err != nil {
+			panic(err)
+		}
+		helper_readBodyAndClose(resp)
+		return resp.Header.Get("X-Cache")
+	}
+
+	assert.Equal(t, "MISS", get(alice))
+	assert.Equal(t, "MISS", get(bob), "bob should not see alice's cache")
+	assert.Equal(t, "HIT", get(alice))
+	assert.Equal(t, "HIT", get(bob))
+}
+
+// After a 429, requests to the same endpoint are not sent upstream until Retry-After passed
+func TestRateLimitedEndpoint(t *testing.T) {
+	var upstreamHits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, requ *http.Request) {
+		upstreamHits.Add(1)
+		if requ.URL.Path == "/limited" {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	cfg := fixture_config(t.TempDir(), nil)
+	cfg.RateLimit = config.RateLimitConfig{Enabled: true}
+	_, proxyTestServer, client := fixture_proxy(cfg)
+	defer proxyTestServer.Close()
+
+	get := func(path string) *http.Response {
+		resp, err := client.Get(upstream.URL + path)
+		if err != nil {
+			panic(err)
+		}
+		helper_readBodyAndClose(resp)
+		return resp
+	}
+
+	resp := get("/limited")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "DISABLED", resp.Header.Get("X-Cache"), "429 responses should not be cached")
+
+	resp = get("/limited?page=2")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "RATE-LIMITED", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+	assert.Equal(t, int32(1), upstreamHits.Load(), "rate limited endpoint should not be requested again")
+
+	resp = get("/other")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "other endpoints should not be rate limited")
+}
+
+// The decision service overrides local rules and TTLs, and local rules apply when it does not answer in time
+func TestDecisionService(t *testing.T) {
+	upstream := fixture_upstream()
+	defer upstream.Close()
+
+	decisionService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, requ *http.Request) {
+		var decision struct {
+			URL   string `json:"url"`
+			Cache bool   `json:"cache"`
+		}
+		if err := json.NewDecoder(requ.Body).Decode(&decision); err != nil {
+			panic(err)
+		}
+		assert.True(t, decision.Cache, "local decision should be sent")
+
+		u, _ := url.Parse(decision.URL)
+		switch u.Path {
+		case "/nocache":
+			_, _ = w.Write([]byte(`{"cache": false}`))
+		case "/short":
+			_, _ = w.Write([]byte(`{"ttl": "100ms"}`))
+		case "/slow":
+			time.Sleep(500 * time.Millisecond)
+			_, _ = w.Write([]byte(`{"cache": false}`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer decisionService.Close()
+
+	cfg := fixture_config(t.TempDir(), nil)
+	cfg.DecisionService = config.DecisionServiceConfig{URL: decisionService.URL, Timeout: "100ms"}
+	_, proxyTestServer, client := fixture_proxy(cfg)
+	defer proxyTestServer.Close()
+
+	get := func(path string) *http.Response {
+		resp, err := client.Get(upstream.URL + path)
+		if err != nil {
+			panic(err)
+		}
+		helper_readBodyAndClose(resp)
+		return resp
+	}
+
+	assert.Equal(t, "DISABLED", get("/nocache").Header.Get("X-Cache"))
+	assert.Equal(t, "DISABLED", get("/nocache").Header.Get("X-Cache"))
+
+	assert.Equal(t, "MISS", get("/default").Header.Get("X-Cache"))
+	assert.Equal(t, "HIT", get("/default").Header.Get("X-Cache"))
+
+	assert.Equal(t, "MISS", get("/short").Header.Get("X-Cache"))
+	resp := get("/short")
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Empty(t, resp.Header.Get("X-Cache-Expires"), "internal expiry header should not be served")
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "MISS", get("/short").Header.Get("X-Cache"), "entry should expire after the decided TTL")
+
+	assert.Equal(t, "MISS", get("/slow").Header.Get("X-Cache"), "local rules should apply on timeout")
+	assert.Equal(t, "HIT", get("/slow").Header.Get("X-Cache"))
+}
+
+// The digest header matches the served body, on misses and hits
+func TestDigestHeader(t *testing.T) {
+	upstream := fixture_upstream()
+	defer upstream.Close()
+
+	cfg := fixture_config(t.TempDir(), nil)
+	cfg.Cache.DigestHeader = true
+	_, proxyTestServer, client := fixture_proxy(cfg)
+	defer proxyTestServer.Close()
+
+	for _, expected := range []string{"MISS", "HIT"} {
+		resp, err := client.Get(upstream.URL + "/test")
+		if err != nil {
+			panic(err)
+		}
+		body := helper_readBodyAndClose(resp)
+		hash := sha256.Sum256([]byte(body))
+
+		assert.Equal(t, expected, resp.Header.Get("X-Cache"))
+		assert.Equal(t, "sha256="+hex.EncodeToString(hash[:]), resp.Header.Get("X-Cache-Digest"))
+	}
+}
+
+// Debug headers expose the cache key and the age of the entry
+func TestDebugHeaders(t *testing.T) {
+	upstream := fixture_upstream()
+	defer upstream.Close()
+
+	cfg := fixture_config(t.TempDir(), nil)
+	cfg.Cache.DebugHeaders = true
+	_, proxyTestServer, client := fixture_proxy(cfg)
+	defer proxyTestServer.Close()
+
+	var keys []string
+	for _, expected := range []string{"MISS", "HIT"} {
+		resp, err := client.Get(upstream.URL + "/test")
+		if err != nil {
+			panic(err)
+		}
+		helper_readBodyAndClose(resp)
+
+		assert.Equal(t, expected, resp.Header.Get("X-Cache"))
+		assert.Equal(t, "0", resp.Header.Get("X-Cache-Age"))
+		assert.Empty(t, resp.Header.Get("X-Cache-Stored"), "internal header should not be served")
+		keys = append(keys, resp.Header.Get("X-Cache-Key"))
+	}
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1], "hit should be served from the key of the miss")
+	assert.FileExists(t, filepath.Join(cfg.Cache.Folder, keys[0]))
+}
+
+// An interrupted download is completed with a ranged request on the next attempt
+func TestResumeInterruptedDownload(t *testing.T) {
+	body := strings.Repeat("0123456789", 10000)
+	var ranges []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, requ *http.Request) {
+		ranges = append(ranges, requ.Header.Get("Range"))
+		if len(ranges) == 1 {
+			// Send half of the body, then cut the connection
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				panic(err)
+			}
+			_, _ = fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\nETag: \"v1\"\r\n\r\n%s", len(body), body[:len(body)/2])
+			_ = buf.Flush()
+			_ = conn.Close()
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, requ, "", time.Time{}, strings.NewReader(body))
+	}))
+	defer upstream.Close()
+
+	_, proxyTestServer, client := fixture_proxy(fixture_config(t.TempDir(), nil))
+	defer proxyTestServer.Close()
+
+	resp, err := client.Get(upstream.URL + "/big")
+	if err != nil {
+		panic(err)
+	}
+	helper_readBodyAndClose(resp)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode, "client should not receive the truncated body as a success")
+	assert.Equal(t, "ERROR", resp.Header.Get("X-Cache"))
+
+	resp, err = client.Get(upstream.URL + "/big")
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	assert.Equal(t, body, helper_readBodyAndClose(resp))
+	assert.Equal(t, []string{"", fmt.Sprintf("bytes=%d-", len(body)/2)}, ranges, "download should resume where it was interrupted")
+
+	resp, err = client.Get(upstream.URL + "/big")
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, body, helper_readBodyAndClose(resp))
+}
+
+// Headers set for an entry replace the stored ones when serving it
+func TestServeHeaders(t *testing.T) {
+	upstream := fixture_upstream()
+	defer upstream.Close()
+
+	proxyServer, proxyTestServer, client := fixture_proxy(fixture_config(t.TempDir(), nil))
+	defer proxyTestServer.Close()
+
+	get := func() *http.Response {
+		resp, err := client.Get(upstream.URL + "/file.pdf")
+		if err != nil {
+			panic(err)
+		}
+		helper_readBodyAndClose(resp)
+		return resp
+	}
+	assert.Equal(t, "MISS", get().Header.Get("X-Cache"))
+
+	u, _ := url.Parse(upstream.URL + "/file.pdf")
+	updated, err := proxyServer.SetServeHeaders("", u, http.Header{"Content-Type": {"application/pdf"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, updated)
+
+	resp := get()
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "application/pdf", resp.Header.Get("Content-Type"))
+	assert.Empty(t, resp.Header.Get("X-Cache-Serve-Headers"), "internal header should not be served")
+
+	_, err = proxyServer.SetServeHeaders("", u, http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", get().Header.Get("Content-Type"))
+}
+
+// Canary comparisons store the responses of both upstreams and report their differences
+func TestCanaryComparison(t *testing.T) {
+	primary := fixture_upstream()
+	defer primary.Close()
+	candidate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, requ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"message": "Hello from candidate", "path": "` + requ.URL.Path + `"}`))
+	}))
+	defer candidate.Close()
+
+	tempDir := t.TempDir()
+	cfg := fixture_config(tempDir, nil)
+	cfg.Canary = config.CanaryConfig{
+		Report: filepath.Join(tempDir, "canary.jsonl"),
+		Comparisons: []config.CanaryComparison{
+			{BaseURI: primary.URL + "/v1/", Candidate: candidate.URL + "/v2/", Serve: "candidate"},
+		},
+	}
+	_, proxyTestServer, client := fixture_proxy(cfg)
+	defer proxyTestServer.Close()
+
+	resp, err := client.Get(primary.URL + "/v1/users")
+	if err != nil {
+		panic(err)
+	}
+	assert.Contains(t, helper_readBodyAndClose(resp), "Hello from candidate")
+	assert.Equal(t, "candidate", resp.Header.Get("X-Cache-Canary"))
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+
+	report, err := os.ReadFile(cfg.Canary.Report)
+	assert.NoError(t, err)
+	var record struct {
+		CandidateURL string   `json:"candidate_url"`
+		Equal        bool     `json:"equal"`
+		Differences  []string `json:"differences"`
+	}
+	assert.NoError(t, json.Unmarshal(report, &record))
+	assert.Equal(t, candidate.URL+"/v2/users", record.CandidateURL)
+	assert.False(t, record.Equal)
+	assert.Equal(t, []string{
+		`body $.message: "Hello from upstream" -> "Hello from candidate"`,
+		`body $.path: "/v1/users" -> "/v2/users"`,
+	}, record.Differences)
+
+	// Both responses are stored
+	for _, u := range []string{primary.URL + "/v1/users", candidate.URL + "/v2/users"} {
+		resp, err := client.Get(u)
+		if err != nil {
+			panic(err)
+		}
+		helper_readBodyAndClose(resp)
+		assert.Equal(t, "HIT", resp.Header.Get("X-Cache"), u)
+	}
+}
+
+// Hosts requiring mutual TLS get the client certificate of their upstream.hosts entry
+func TestUpstreamClientCertificate(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "hello %s", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	upstream.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	tempDir := t.TempDir()
+	certFile, keyFile := filepath.Join(tempDir, "client.crt"), filepath.Join(tempDir, "client.key")
+	assert.NoError(t, ca.Generate(certFile, keyFile, "staging-client"))
+
+	// Without certificate, the handshake fails: the intercepted connection is closed
+	_, proxyTestServer, client := fixture_proxy(fixture_config(filepath.Join(tempDir, "cache1"), nil))
+	resp, err := client.Get(upstream.URL + "/test")
+	if err == nil {
+		helper_readBodyAndClose(resp)
+		assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+	}
+	proxyTestServer.Close()
+
+	cfg := fixture_config(filepath.Join(tempDir, "cache2"), nil)
+	cfg.Upstream.Hosts = append(cfg.Upstream.Hosts, config.UpstreamHost{Match: []string{"127.0.0.0/8"}, ClientCert: certFile, ClientKey: keyFile})
+	_, proxyTestServer, client = fixture_proxy(cfg)
+	defer proxyTestServer.Close()
+	resp, err = client.Get(upstream.URL + "/test")
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, "hello staging-client", helper_readBodyAndClose(resp))
+}
+
+// Upstream certificates are verified, unless trusted with ca_bundle or skipped with insecure_skip_verify
+func TestUpstreamCertificateVerification(t *testing.T) {
+	upstream := fixture_upstream_tls()
+	defer upstream.Close()
+
+	get := func(hosts []config.UpstreamHost) int {
+		cfg := fixture_config(t.TempDir(), nil)
+		cfg.Upstream.Hosts = hosts
+		_, proxyTestServer, client := fixture_proxy(cfg)
+		defer proxyTestServer.Close()
+		resp, err := client.Get(upstream.URL + "/test")
+		if err != nil {
+			return 0
+		}
+		helper_readBodyAndClose(resp)
+		return resp.StatusCode
+	}
+
+	assert.NotEqual(t, http.StatusOK, get(nil), "self-signed certificate should be rejected")
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw}), 0o600))
+	assert.Equal(t, http.StatusOK, get([]config.UpstreamHost{{Match: []string{"127.0.0.0/8"}, CABundle: bundle}}))
+	assert.NotEqual(t, http.StatusOK, get([]config.UpstreamHost{{Match: []string{"10.0.0.0/8"}, InsecureSkipVerify: true}}), "other hosts should still be verified")
+}
+
+// Connections to passthrough hosts are tunneled: clients get the certificate of upstream, and responses are not cached
+func TestTLSPassthrough(t *testing.T) {
+	upstream := fixture_upstream_tls()
+	defer upstream.Close()
+
+	get := func(passthrough []string) *http.Response {
+		cfg := fixture_config(t.TempDir(), nil)
+		cfg.Server.HTTPS.PassthroughHosts = passthrough
+		_, proxyTestServer, client := fixture_proxy(cfg)
+		defer proxyTestServer.Close()
+		resp, err := client.Get(upstream.URL + "/test")
+		if err != nil {
+			t.Fatalf("GET error = %v", err)
+		}
+		helper_readBodyAndClose(resp)
+		return resp
+	}
+
+	resp := get([]string{"127.0.0.0/8"})
+	assert.True(t, resp.TLS.PeerCertificates[0].Equal(upstream.Certificate()), "client should get the upstream certificate")
+	assert.Empty(t, resp.Header.Get("X-Cache"))
+
+	resp = get([]string{"*.example.com"})
+	assert.False(t, resp.TLS.PeerCertificates[0].Equal(upstream.Certificate()), "other hosts should be intercepted")
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+}
+
+// Transparent HTTPS connections are intercepted or blocked according to their SNI hostname, before any interception
+func TestTransparentSNIRules(t *testing.T) {
+	cfg := fixture_config(t.TempDir(), nil)
+	cfg.Server.HTTPS.Transparent.SNIRules = []config.SNIRule{{Match: []string{"*.blocked.test"}, Action: config.SNIActionBlock}}
+	proxyServer, err := proxy.New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	_ = ln.Close()
+	go proxyServer.StartTransparentHTTPS(address)
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	handshake := func(sni string) (*tls.Conn, error) {
+		return tls.DialWithDialer(&net.Dialer{Timeout: 2 * time.Second}, "tcp", address, &tls.Config{ServerName: sni, InsecureSkipVerify: true})
+	}
+
+	conn, err := handshake("api.intercepted.test")
+	if assert.NoError(t, err) {
+		assert.Contains(t, conn.ConnectionState().PeerCertificates[0].DNSNames, "api.intercepted.test", "proxy should present its own certificate")
+		_ = conn.Close()
+	}
+	_, err = handshake("api.blocked.test")
+	assert.Error(t, err, "blocked connection should be closed")
+}
+
+// Requests over server.limits.max_requests are rejected, and slots are released once responses are sent
+func TestMaxRequests(t *testing.T) {
+	unblock := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-unblock
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	cfg := fixture_config(t.TempDir(), nil)
+	cfg.Server.Limits.MaxRequests = 1
+	cfg.Server.Limits.OnOverload = config.OverloadReject
+	_, proxyTestServer, client := fixture_proxy(cfg)
+	defer proxyTestServer.Close()
+
+	slow := make(chan *http.Response)
+	go func() {
+		resp, err := client.Get(upstream.URL + "/slow")
+		if err != nil {
+			t.Errorf("GET /slow error = %v", err)
+		}
+		slow <- resp
+	}()
+
+	// The slow request takes the only slot
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get(upstream.URL + "/fast")
+		if err != nil {
+			return false
+		}
+		helper_readBodyAndClose(resp)
+		return resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("X-Cache") == "OVERLOADED"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	close(unblock)
+	if resp := <-slow; resp != nil {
+		assert.Equal(t, "ok", helper_readBodyAndClose(resp))
+	}
+
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get(upstream.URL + "/fast")
+		if err != nil {
+			return false
+		}
+		return helper_readBodyAndClose(resp) == "ok"
+	}, 5*time.Second, 10*time.Millisecond, "slot should be released once the response is sent")
+}
+
+// The same-host links of cached pages are fetched into the cache in the background
+func TestPrefetch(t *testing.T) {
+	var assetHits, otherHits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, requ *http.Request) {
+		switch requ.URL.Path {
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(`<html><head><link rel="stylesheet" href="/assets/style.css"></head><body><a href="/other">other</a></body></html>`))
+		case "/assets/style.css":
+			assetHits.Add(1)
+			w.Header().Set("Content-Type", "text/css")
+			_, _ = w.Write([]byte("body {}"))
+		default:
+			otherHits.Add(1)
+			_, _ = w.Write([]byte("ok"))
+		}
+	}))
+	defer upstream.Close()
+
+	tempDir := t.TempDir()
+	cfg := fixture_config(tempDir, nil)
+	cfg.Prefetch = config.PrefetchConfig{Enabled: true, Depth: 1, MaxLinks: 10, Workers: 1, Patterns: []string{"http://**/assets/**"}}
+	_, proxyTestServer, client := fixture_proxy(cfg)
+	defer proxyTestServer.Close()
+
+	get := func(path string) string {
+		resp, err := client.Get(upstream.URL + path)
+		if err != nil {
+			panic(err)
+		}
+		helper_readBodyAndClose(resp)
+		return resp.Header.Get("X-Cache")
+	}
+
+	assert.Equal(t, "MISS", get("/page.html"))
+	assert.Eventually(t, func() bool {
+		entries, _ := filepath.Glob(filepath.Join(tempDir, "*", "assets", "style.css", "GET*.bin"))
+		for _, entry := range entries {
+			if info, err := os.Stat(entry); err == nil && info.Size() > 0 {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond, "linked asset should be prefetched")
+
+	assert.Equal(t, "HIT", get("/assets/style.css"))
+	assert.Equal(t, int32(1), assetHits.Load())
+	assert.Equal(t, int32(0), otherHits.Load(), "links not matching the patterns should not be prefetched")
+}
+
+// 103 Early Hints are forwarded, and trailers are forwarded and cached, over HTTP and intercepted HTTPS
+func TestTrailersAndEarlyHints(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, requ *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		w.Header().Set("Trailer", "X-Checksum")
+		_, _ = w.Write([]byte("hello"))
+		w.Header().Set("X-Checksum", "abc")
+	})
+	upstreams := map[string]*httptest.Server{
+		"http":  httptest.NewServer(handler),
+		"https": httptest.NewTLSServer(handler),
+	}
+
+	for name, upstream := range upstreams {
+		t.Run(name, func(t *testing.T) {
+			defer upstream.Close()
+			_, proxyTestServer, client := fixture_proxy(fixture_config(t.TempDir(), nil))
+			defer proxyTestServer.Close()
+
+			get := func() (*http.Response, []string) {
+				hints := []string{}
+				trace := &httptrace.ClientTrace{
+					Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+						hints = append(hints, fmt.Sprintf("%d %s", code, header.Get("Link")))
+						return nil
+					},
+				}
+				requ, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, upstream.URL+"/page", nil)
+				resp, err := client.Do(requ)
+				if err != nil {
+					panic(err)
+				}
+				assert.Equal(t, "hello", helper_readBodyAndClose(resp))
+				return resp, hints
+			}
+
+			resp, hints := get()
+			assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+			assert.Equal(t, []string{"103 </style.css>; rel=preload"}, hints)
+			assert.Empty(t, resp.Header.Get("Link"), "early hints should not leak into the final response")
+			assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+
+			resp, hints = get()
+			assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+			assert.Empty(t, hints)
+			assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"), "trailers should be stored in cache")
+		})
+	}
+}
@@ -1,7 +1,15 @@
 package main
 
-import procycmd "github.com/iTrooz/caching-dev-proxy/cmd/proxy"
+import (
+	_ "embed"
+
+	procycmd "github.com/iTrooz/caching-dev-proxy/cmd/proxy"
+)
+
+//go:embed config.yaml
+var defaultConfig []byte
 
 func main() {
+	procycmd.DefaultConfigFile = defaultConfig
 	procycmd.Main()
 }